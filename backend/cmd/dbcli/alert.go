@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+)
+
+type AlertCmd struct {
+	Type  string `arg:"positional,required" help:"kind of the alert, e.g. 'failed migration'"`
+	Name  string `arg:"positional" help:"name of the affected file or record"`
+	Notes string `arg:"--notes" help:"details, e.g. the error message"`
+}
+
+// newAlerter sets up the admin alerts from the environment variables of the API server (ALERT_NOTIFIERS,
+// TELEGRAM_TOKEN/TELEGRAM_CHAT_ID, SLACK_WEBHOOK_URL and ALERT_EMAIL)
+func newAlerter() (alerts.Notifier, error) {
+	return alerts.New(os.Getenv("ALERT_NOTIFIERS"), alerts.Config{
+		TelegramToken:   os.Getenv("TELEGRAM_TOKEN"),
+		TelegramChatID:  os.Getenv("TELEGRAM_CHAT_ID"),
+		SlackWebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+		Mailer:          mailer,
+		EmailTo:         strings.FieldsFunc(os.Getenv("ALERT_EMAIL"), func(r rune) bool { return r == ',' || r == ' ' }),
+	})
+}
+
+// sendAlert notifies the admins, e.g. about a migration that couldn't be applied by the cron job
+func sendAlert(ctx context.Context, n alerts.Notifier, cmd *AlertCmd) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return n.Notify(ctx, alerts.Alert{
+		Type:  cmd.Type,
+		Name:  cmd.Name,
+		Notes: cmd.Notes,
+		Time:  time.Now().UTC(),
+	})
+}
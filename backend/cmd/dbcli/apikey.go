@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/apikeys"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+)
+
+type ApiKeyCreateCmd struct {
+	Name      string `arg:"positional,required" help:"name of the consumer (e.g. the site or bot using the key)"`
+	RateLimit int    `arg:"--rate-limit" default:"600" help:"requests per minute"`
+}
+
+type ApiKeyRevokeCmd struct {
+	Id int `arg:"positional,required" help:"ID of the key"`
+}
+
+type ApiKeyListCmd struct{}
+
+type ApiKeyCmd struct {
+	Create *ApiKeyCreateCmd `arg:"subcommand:create" help:"create an API key (the key is only shown once)"`
+	Revoke *ApiKeyRevokeCmd `arg:"subcommand:revoke" help:"revoke an API key (takes up to a minute to take effect)"`
+	List   *ApiKeyListCmd   `arg:"subcommand:list" help:"list all API keys and their usage"`
+}
+
+// createApiKey stores a new key and returns it, together with its ID
+func createApiKey(ctx context.Context, name string, rateLimit int) (string, int32, error) {
+	if rateLimit <= 0 {
+		return "", 0, fmt.Errorf("the rate limit must be a positive number of requests per minute, got %v", rateLimit)
+	}
+	key, err := apikeys.Generate()
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := queries.InsertApiKey(ctx, dbutils.InsertApiKeyParams{Name: name, KeyPrefix: apikeys.DisplayPrefix(key), KeyHash: apikeys.Hash(key), RateLimit: int32(rateLimit)})
+	if err != nil {
+		return "", 0, err
+	}
+	return key, id, nil
+}
+
+func revokeApiKey(ctx context.Context, id int32) error {
+	n, err := queries.RevokeApiKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("there is no active API key with ID %v", id)
+	}
+	return nil
+}
+
+// listApiKeys writes a table of all keys and their usage (the server writes the request counts every minute)
+func listApiKeys(ctx context.Context, out io.Writer) error {
+	keys, err := queries.ListApiKeys(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY_ID\tNAME\tPREFIX\tRATE_LIMIT\tREQUESTS_TODAY\tREQUESTS\tLAST_USED\tCREATED\tREVOKED")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v/min\t%v\t%v\t%v\t%v\t%v\n", k.KeyID, k.Name, k.KeyPrefix, k.RateLimit, k.RequestsToday, k.RequestCount,
+			formatTimestamp(k.DtLastUsed.Time, k.DtLastUsed.Valid), formatTimestamp(k.DtCreated.Time, k.DtCreated.Valid), formatTimestamp(k.DtRevoked.Time, k.DtRevoked.Valid))
+	}
+	return w.Flush()
+}
+
+func formatTimestamp(t time.Time, valid bool) string {
+	if !valid {
+		return "-"
+	}
+	return t.UTC().Format(time.DateTime)
+}
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
 	"github.com/felix-schott/jamsessions/backend/internal/geocoding"
+	"github.com/felix-schott/jamsessions/backend/internal/webhooks"
 
 	"github.com/alexflint/go-arg"
 )
@@ -27,9 +32,23 @@ type DeleteCmd struct {
 }
 
 type args struct {
-	Update *UpdateCmd `arg:"subcommand:update"`
-	Insert *InsertCmd `arg:"subcommand:insert"`
-	Delete *DeleteCmd `arg:"subcommand:delete"`
+	Update      *UpdateCmd      `arg:"subcommand:update"`
+	Insert      *InsertCmd      `arg:"subcommand:insert"`
+	Delete      *DeleteCmd      `arg:"subcommand:delete"`
+	Geocode     *GeocodeCmd     `arg:"subcommand:geocode"`
+	Venue       *VenueCmd       `arg:"subcommand:venue"`
+	Occurrences *OccurrencesCmd `arg:"subcommand:occurrences"`
+	Comment     *CommentCmd     `arg:"subcommand:comment"`
+	User        *UserCmd        `arg:"subcommand:user"`
+	ApiKey      *ApiKeyCmd      `arg:"subcommand:apikey"`
+	Favourite   *FavouriteCmd   `arg:"subcommand:favourite"`
+	Follow      *FollowCmd      `arg:"subcommand:follow"`
+	Channel     *ChannelCmd     `arg:"subcommand:channel"`
+	Search      *SearchCmd      `arg:"subcommand:search"`
+	Digest      *DigestCmd      `arg:"subcommand:digest"`
+	Webhook     *WebhookCmd     `arg:"subcommand:webhook"`
+	Events      *EventsCmd      `arg:"subcommand:events"`
+	Alert       *AlertCmd       `arg:"subcommand:alert"`
 }
 
 func (args) Description() string {
@@ -39,8 +58,16 @@ func (args) Description() string {
 var ctx = context.Background()
 var queries *dbutils.Queries
 
+// Nominatim allows at most one request per second (https://operations.osmfoundation.org/policies/nominatim/)
+const nominatimRequestSpacing = 1100 * time.Millisecond
+
 func main() {
 
+	// cancel running queries and requests on ctrl+c
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	pool, err := dbutils.CreatePool(ctx)
 	if err != nil {
 		log.Fatal(err)
@@ -49,9 +76,21 @@ func main() {
 
 	queries = dbutils.New(pool)
 
+	// share the Nominatim rate limit with other dbcli processes
+	geocoding.UseRateLimiter(dbutils.NewRateLimiter(pool, "nominatim", nominatimRequestSpacing))
+
 	var args args
 	p := arg.MustParse(&args)
 
+	// notify followers about the changes
+	publicUrl = os.Getenv("PUBLIC_URL")
+	if mailer, err = newMailer(); err != nil {
+		log.Fatalf("could not set up the mailer: %v", err)
+	}
+	if dispatcher, err = newDispatcher(mailer); err != nil {
+		log.Fatalf("could not set up notifications: %v", err)
+	}
+
 	switch {
 	case args.Update != nil:
 		switch args.Update.Table {
@@ -66,21 +105,22 @@ func main() {
 			// geocode address
 			if payload.AddressFirstLine != nil || payload.AddressSecondLine != nil || payload.City != nil || payload.Postcode != nil {
 				log.Println("Obtaining coordinates from address")
-				var s string
-				if payload.AddressSecondLine != nil {
-					s = *payload.AddressFirstLine + "+" + *payload.AddressSecondLine
-				} else {
-					s = *payload.AddressFirstLine
-				}
-				loc, err := geocoding.Geocode(s, *payload.City, *payload.Postcode)
+				result, err := geocoding.Search(ctx, streetAddress(*payload.AddressFirstLine, payload.AddressSecondLine), *payload.City, *payload.Postcode)
 				if err != nil {
 					log.Fatalf("failed to obtain coordinates from provided address: %v", err)
 				}
-				payload.Geom = loc
+				checkGeocodingResult(result)
+				payload.Geom = result.Point
 			}
+			before, beforeErr := queries.GetVenueById(ctx, payload.VenueID)
 			if err := queries.UpdateVenueById(ctx, payload); err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			recordContribution(ctx, args.Update.Payload, nil, &payload.VenueID, "update")
+			if beforeErr == nil {
+				notifyVenueUpdate(ctx, before)
+			}
+			emitVenueEvent(ctx, webhooks.VenueUpdated, payload.VenueID)
 		case "session":
 			fmt.Printf("Updating record %v of table session\n", args.Update.Id)
 			var payload dbutils.UpdateJamSessionByIdParams
@@ -88,9 +128,27 @@ func main() {
 				p.Fail(fmt.Sprintf("couldn't parse payload with the following error: %v", err))
 			}
 			payload.SessionID = int32(args.Update.Id)
+			before, beforeErr := queries.GetSessionById(ctx, payload.SessionID)
 			if err := queries.UpdateJamSessionById(ctx, payload); err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			recordContribution(ctx, args.Update.Payload, &payload.SessionID, nil, "update")
+			if beforeErr == nil {
+				notifySessionUpdate(ctx, before)
+			}
+			emitSessionEvent(ctx, webhooks.SessionUpdated, payload.SessionID)
+		case "comment":
+			log.Printf("Updating record %v of table comments\n", args.Update.Id)
+			var payload dbutils.UpdateCommentByIdParams
+			if err := json.Unmarshal([]byte(args.Update.Payload), &payload); err != nil {
+				p.Fail(fmt.Sprintf("couldn't parse payload with the following error: %v", err))
+			}
+			payload.CommentID = int32(args.Update.Id)
+			if n, err := queries.UpdateCommentById(ctx, payload); err != nil {
+				log.Fatalf("failed to run query: %v", err)
+			} else if n == 0 {
+				log.Fatalf("comment %v does not exist", args.Update.Id)
+			}
 		default:
 			p.Fail(fmt.Sprintf("available tables: 'venue' or 'session', got %v", args.Update.Table))
 		}
@@ -106,23 +164,20 @@ func main() {
 			// geocode address
 			if payload.AddressFirstLine != "" || payload.AddressSecondLine != nil || payload.City != "" || payload.Postcode != "" {
 				log.Println("Obtaining coordinates from address")
-				var s string
-				if payload.AddressSecondLine != nil {
-					s = payload.AddressFirstLine + " " + *payload.AddressSecondLine
-				} else {
-					s = payload.AddressFirstLine
-				}
-				loc, err := geocoding.Geocode(s, payload.City, payload.Postcode)
+				result, err := geocoding.Search(ctx, streetAddress(payload.AddressFirstLine, payload.AddressSecondLine), payload.City, payload.Postcode)
 				if err != nil {
 					log.Fatalf("failed to obtain coordinates from provided address: %v", err)
 				}
-				payload.Geom = loc
+				checkGeocodingResult(result)
+				payload.Geom = result.Point
 			}
 
 			newId, err := queries.InsertVenue(ctx, payload)
 			if err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			recordContribution(ctx, args.Insert.Payload, nil, &newId, "insert")
+			emitVenueEvent(ctx, webhooks.VenueCreated, newId)
 			log.Printf("Inserted record with ID %v into table venue\n", newId)
 			fmt.Print(newId) // write new id to stdout
 		case "session":
@@ -135,6 +190,8 @@ func main() {
 			if err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			recordContribution(ctx, args.Insert.Payload, &newId, nil, "insert")
+			emitSessionEvent(ctx, webhooks.SessionCreated, newId)
 			log.Printf("Inserted record with ID %v into table session\n", newId)
 			fmt.Print(newId) // write new id to stdout
 		case "comment":
@@ -161,6 +218,32 @@ func main() {
 			}
 			log.Printf("Inserted record with ID %v into table ratings\n", newId)
 			fmt.Print(newId) // write new id to stdout
+		case "user":
+			log.Println("Inserting record into table users")
+			var payload struct {
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal([]byte(args.Insert.Payload), &payload); err != nil || payload.Email == "" {
+				p.Fail(fmt.Sprintf("couldn't parse payload (an email address is required): %v", err))
+			}
+			newId, err := queries.InsertUser(ctx, payload.Email)
+			if err != nil {
+				log.Fatalf("failed to run query: %v", err)
+			}
+			log.Printf("Registered user with ID %v\n", newId)
+			fmt.Print(newId) // write new id to stdout
+		case "report":
+			log.Println("Inserting record into table comment_reports")
+			var payload dbutils.InsertCommentReportParams
+			if err := json.Unmarshal([]byte(args.Insert.Payload), &payload); err != nil {
+				p.Fail(fmt.Sprintf("couldn't parse payload with the following error: %v", err))
+			}
+			newId, err := queries.InsertCommentReport(ctx, payload)
+			if err != nil {
+				log.Fatalf("failed to run query: %v", err)
+			}
+			log.Printf("Inserted record with ID %v into table comment_reports\n", newId)
+			fmt.Print(newId) // write new id to stdout
 		default:
 			p.Fail(fmt.Sprintf("available tables: 'venue' or 'session', got %v", args.Insert.Table))
 		}
@@ -168,16 +251,238 @@ func main() {
 		switch args.Delete.Table {
 		case "venue":
 			log.Printf("Deleting record %v from table venue\n", args.Delete.Id)
+			notifyDeletion := prepareVenueDeletion(ctx, int32(args.Delete.Id))
+			emitDeleted := prepareVenueDeletedEvent(ctx, int32(args.Delete.Id), nil)
 			if err := queries.DeleteVenueById(ctx, int32(args.Delete.Id)); err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			notifyDeletion()
+			emitDeleted()
 		case "session":
 			log.Printf("Deleting record %v from table session\n", args.Delete.Id)
+			notifyCancellation := prepareSessionCancellation(ctx, int32(args.Delete.Id))
+			emitDeleted := prepareSessionDeletedEvent(ctx, int32(args.Delete.Id))
 			if err := queries.DeleteJamSessionById(ctx, int32(args.Delete.Id)); err != nil {
 				log.Fatalf("failed to run query: %v", err)
 			}
+			notifyCancellation()
+			emitDeleted()
+		case "comment":
+			log.Printf("Deleting record %v from table comments\n", args.Delete.Id)
+			ratings, err := deleteComment(ctx, pool, int32(args.Delete.Id))
+			if err != nil {
+				log.Fatalf("failed to delete comment: %v", err)
+			}
+			log.Printf("Deleted comment %v and %v ratings\n", args.Delete.Id, ratings)
 		default:
 			p.Fail(fmt.Sprintf("available tables: 'venue' or 'session', got %v", args.Delete.Table))
 		}
+	case args.Geocode != nil:
+		switch {
+		case args.Geocode.Verify != nil:
+			if _, err := verifyGeocoding(args.Geocode.Verify); err != nil {
+				log.Fatalf("failed to verify venue locations: %v", err)
+			}
+		default:
+			p.Fail("available subcommands: 'verify'")
+		}
+	case args.Venue != nil:
+		switch {
+		case args.Venue.Merge != nil:
+			log.Printf("Merging venue %v into venue %v\n", args.Venue.Merge.Drop, args.Venue.Merge.Keep)
+			keep := int32(args.Venue.Merge.Keep)
+			emitDeleted := prepareVenueDeletedEvent(ctx, int32(args.Venue.Merge.Drop), &keep)
+			result, err := mergeVenues(ctx, pool, keep, int32(args.Venue.Merge.Drop))
+			if err != nil {
+				log.Fatalf("failed to merge venues: %v", err)
+			}
+			emitDeleted()
+			log.Printf("Moved %v sessions and merged %v duplicate sessions (re-pointed %v comments and %v ratings), deleted venue %v\n", result.MovedSessions, result.MergedSessions, result.Comments, result.Ratings, args.Venue.Merge.Drop)
+		default:
+			p.Fail("available subcommands: 'merge'")
+		}
+	case args.Occurrences != nil:
+		switch {
+		case args.Occurrences.Refresh != nil:
+			start := time.Now().UTC()
+			if args.Occurrences.Refresh.Start != "" {
+				if start, err = time.Parse(time.DateOnly, args.Occurrences.Refresh.Start); err != nil {
+					p.Fail(fmt.Sprintf("couldn't parse --start as a date (YYYY-MM-DD): %v", err))
+				}
+			}
+			result, err := refreshOccurrences(ctx, pool, start, args.Occurrences.Refresh.Months)
+			if err != nil {
+				log.Fatalf("failed to refresh session occurrences: %v", err)
+			}
+			log.Printf("Materialised %v session occurrences from %v to %v\n", result.Occurrences, result.StartDate.Time.Format(time.DateOnly), result.EndDate.Time.Format(time.DateOnly))
+		default:
+			p.Fail("available subcommands: 'refresh'")
+		}
+	case args.Comment != nil:
+		switch {
+		case args.Comment.Status != nil:
+			resolved, err := setCommentStatus(ctx, pool, int32(args.Comment.Status.Id), args.Comment.Status.Status)
+			if err != nil {
+				log.Fatalf("failed to set comment status: %v", err)
+			}
+			log.Printf("Set status of comment %v to '%v' (resolved %v reports)\n", args.Comment.Status.Id, args.Comment.Status.Status, resolved)
+		default:
+			p.Fail("available subcommands: 'status'")
+		}
+	case args.User != nil:
+		switch {
+		case args.User.Role != nil:
+			if err := setUserRole(ctx, args.User.Role.User, args.User.Role.Role); err != nil {
+				log.Fatalf("failed to set the role of the user: %v", err)
+			}
+			log.Printf("Set role of user %v to '%v'\n", args.User.Role.User, args.User.Role.Role)
+		case args.User.Owner != nil:
+			if err := setVenueOwner(ctx, args.User.Owner.User, int32(args.User.Owner.Venue), args.User.Owner.Remove); err != nil {
+				log.Fatalf("failed to update the owners of venue %v: %v", args.User.Owner.Venue, err)
+			}
+			if args.User.Owner.Remove {
+				log.Printf("Removed user %v from the owners of venue %v\n", args.User.Owner.User, args.User.Owner.Venue)
+			} else {
+				log.Printf("Added user %v to the owners of venue %v\n", args.User.Owner.User, args.User.Owner.Venue)
+			}
+		default:
+			p.Fail("available subcommands: 'role', 'owner'")
+		}
+	case args.ApiKey != nil:
+		switch {
+		case args.ApiKey.Create != nil:
+			key, id, err := createApiKey(ctx, args.ApiKey.Create.Name, args.ApiKey.Create.RateLimit)
+			if err != nil {
+				log.Fatalf("failed to create API key: %v", err)
+			}
+			log.Printf("Created API key %v for %v (%v requests per minute), it won't be shown again\n", id, args.ApiKey.Create.Name, args.ApiKey.Create.RateLimit)
+			fmt.Println(key) // write key to stdout
+		case args.ApiKey.Revoke != nil:
+			if err := revokeApiKey(ctx, int32(args.ApiKey.Revoke.Id)); err != nil {
+				log.Fatalf("failed to revoke API key: %v", err)
+			}
+			log.Printf("Revoked API key %v\n", args.ApiKey.Revoke.Id)
+		case args.ApiKey.List != nil:
+			if err := listApiKeys(ctx, os.Stdout); err != nil {
+				log.Fatalf("failed to list API keys: %v", err)
+			}
+		default:
+			p.Fail("available subcommands: 'create', 'revoke', 'list'")
+		}
+	case args.Favourite != nil:
+		switch {
+		case args.Favourite.Add != nil:
+			if err := setFavourite(ctx, args.Favourite.Add.Payload, false); err != nil {
+				log.Fatalf("failed to add favourite: %v", err)
+			}
+			log.Println("Added favourite")
+		case args.Favourite.Remove != nil:
+			if err := setFavourite(ctx, args.Favourite.Remove.Payload, true); err != nil {
+				log.Fatalf("failed to remove favourite: %v", err)
+			}
+			log.Println("Removed favourite")
+		default:
+			p.Fail("available subcommands: 'add', 'remove'")
+		}
+	case args.Follow != nil:
+		switch {
+		case args.Follow.Add != nil:
+			if err := setFollow(ctx, args.Follow.Add.Payload, false); err != nil {
+				log.Fatalf("failed to add follow: %v", err)
+			}
+			log.Println("Added follow")
+		case args.Follow.Remove != nil:
+			if err := setFollow(ctx, args.Follow.Remove.Payload, true); err != nil {
+				log.Fatalf("failed to remove follow: %v", err)
+			}
+			log.Println("Removed follow")
+		default:
+			p.Fail("available subcommands: 'add', 'remove'")
+		}
+	case args.Channel != nil:
+		switch {
+		case args.Channel.Add != nil:
+			id, err := addChannel(ctx, args.Channel.Add.Payload)
+			if err != nil {
+				log.Fatalf("failed to add notification channel: %v", err)
+			}
+			log.Printf("Added notification channel %v\n", id)
+			fmt.Print(id) // write id to stdout
+		case args.Channel.Remove != nil:
+			if err := removeChannel(ctx, args.Channel.Remove.Payload); err != nil {
+				log.Fatalf("failed to remove notification channel: %v", err)
+			}
+			log.Println("Removed notification channel")
+		default:
+			p.Fail("available subcommands: 'add', 'remove'")
+		}
+	case args.Search != nil:
+		switch {
+		case args.Search.Add != nil:
+			id, err := addSavedSearch(ctx, args.Search.Add.Payload)
+			if err != nil {
+				log.Fatalf("failed to save search: %v", err)
+			}
+			log.Printf("Saved search %v\n", id)
+			fmt.Print(id) // write id to stdout
+		case args.Search.Remove != nil:
+			if err := removeSavedSearch(ctx, args.Search.Remove.Payload); err != nil {
+				log.Fatalf("failed to delete saved search: %v", err)
+			}
+			log.Println("Deleted saved search")
+		default:
+			p.Fail("available subcommands: 'add', 'remove'")
+		}
+	case args.Digest != nil:
+		if args.Digest.Send == nil {
+			p.Fail("available subcommands: 'send'")
+		}
+		sent, err := sendDigests(ctx, time.Now().UTC(), args.Digest.Send.Days, args.Digest.Send.Force)
+		log.Printf("Sent %v digests\n", sent)
+		if err != nil {
+			log.Fatalf("failed to send some digests: %v", err)
+		}
+	case args.Webhook != nil:
+		switch {
+		case args.Webhook.Add != nil:
+			id, err := addWebhook(ctx, args.Webhook.Add.Payload)
+			if err != nil {
+				log.Fatalf("failed to add webhook subscription: %v", err)
+			}
+			log.Printf("Added webhook subscription %v\n", id)
+			fmt.Print(id) // write id to stdout
+		case args.Webhook.Remove != nil:
+			if err := removeWebhook(ctx, int32(args.Webhook.Remove.Id)); err != nil {
+				log.Fatalf("failed to remove webhook subscription: %v", err)
+			}
+			log.Printf("Removed webhook subscription %v\n", args.Webhook.Remove.Id)
+		case args.Webhook.Deliver != nil:
+			delivered, failed, err := deliverWebhooks(ctx, args.Webhook.Deliver.Batch)
+			log.Printf("Delivered %v webhook events (%v failed attempts)\n", delivered, failed)
+			if err != nil {
+				log.Fatalf("failed to deliver webhook events: %v", err)
+			}
+		default:
+			p.Fail("available subcommands: 'add', 'remove', 'deliver'")
+		}
+	case args.Events != nil:
+		switch {
+		case args.Events.Prune != nil:
+			n, err := pruneEvents(ctx, time.Now(), args.Events.Prune.Days)
+			if err != nil {
+				log.Fatalf("failed to prune events: %v", err)
+			}
+			log.Printf("Deleted %v events older than %v days\n", n, args.Events.Prune.Days)
+		default:
+			p.Fail("available subcommands: 'prune'")
+		}
+	case args.Alert != nil:
+		alerter, err := newAlerter()
+		if err != nil {
+			log.Fatalf("could not set up alerts: %v", err)
+		}
+		if err := sendAlert(ctx, alerter, args.Alert); err != nil {
+			log.Fatalf("failed to send the alert: %v", err)
+		}
 	}
 }
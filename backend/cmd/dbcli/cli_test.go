@@ -4,19 +4,32 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/apikeys"
 	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/geocoding"
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
 	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
 	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/felix-schott/jamsessions/backend/internal/webhooks"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	geom "github.com/twpayne/go-geom"
 )
@@ -274,7 +287,7 @@ func TestCli(t *testing.T) {
 		if err != nil {
 			t.Error("error when retrieving inserted session record:", err)
 		}
-		if rec.Rating != 3 {
+		if rec.Rating == nil || *rec.Rating != 3 {
 			t.Errorf("rating in DB (%v) doesn't match 3", rec.Rating)
 		}
 	})
@@ -364,4 +377,877 @@ func TestCli(t *testing.T) {
 			t.Errorf("rating ID %v in DB (%v) doesn't match 2", *commentRecs[0].RatingID, *commentRecs[0].Rating)
 		}
 	})
+	t.Run("MergeVenues", func(t *testing.T) {
+		keepVenueId, err := queries.InsertVenue(ctx, dbutils.InsertVenueParams{
+			VenueName:        "The Merge Arms",
+			AddressFirstLine: "1 Merge Street",
+			City:             "London",
+			Postcode:         "E1 6AN",
+			Geom:             geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.072, 51.517}),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert venue: %v", err)
+		}
+		dropVenueId, err := queries.InsertVenue(ctx, dbutils.InsertVenueParams{
+			VenueName:        "Merge Arms",
+			AddressFirstLine: "1 Merge St",
+			City:             "London",
+			Postcode:         "E16AN",
+			Geom:             geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.072, 51.517}),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert venue: %v", err)
+		}
+
+		startTime := pgtype.Timestamptz{Time: time.Date(2024, 6, 3, 19, 0, 0, 0, time.UTC), Valid: true}
+		keepSessionId, err := queries.InsertJamSession(ctx, dbutils.InsertJamSessionParams{SessionName: "Merge Jam", Venue: keepVenueId, StartTimeUtc: startTime, Interval: "Weekly", DurationMinutes: 120})
+		if err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+		duplicateSessionId, err := queries.InsertJamSession(ctx, dbutils.InsertJamSessionParams{SessionName: "Merge Jam (duplicate)", Venue: dropVenueId, StartTimeUtc: startTime, Interval: "Weekly", DurationMinutes: 120})
+		if err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+		otherSessionId, err := queries.InsertJamSession(ctx, dbutils.InsertJamSessionParams{SessionName: "Merge Blues", Venue: dropVenueId, StartTimeUtc: startTime, Interval: "Fortnightly", DurationMinutes: 120})
+		if err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+		commentId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: duplicateSessionId, Author: "merge", Content: "comment on the duplicate"})
+		if err != nil {
+			t.Fatalf("failed to insert comment: %v", err)
+		}
+		if _, err := queries.InsertSessionRating(ctx, dbutils.InsertSessionRatingParams{Session: duplicateSessionId, Rating: ptr(int16(4)), Comment: &commentId}); err != nil {
+			t.Fatalf("failed to insert rating: %v", err)
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("dbcli", "venue", "merge", fmt.Sprint(keepVenueId), fmt.Sprint(dropVenueId))
+		cmd.Env = os.Environ()
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("an error occured when merging venues: %v: %v", err, stderr.String())
+		}
+
+		if _, err := queries.GetVenueById(ctx, dropVenueId); err == nil {
+			t.Errorf("expected venue %v to be deleted", dropVenueId)
+		}
+		if _, err := queries.GetSessionById(ctx, duplicateSessionId); err == nil {
+			t.Errorf("expected duplicate session %v to be deleted", duplicateSessionId)
+		}
+		other, err := queries.GetSessionById(ctx, otherSessionId)
+		if err != nil {
+			t.Fatalf("expected session %v to still exist: %v", otherSessionId, err)
+		}
+		if other.Venue != keepVenueId {
+			t.Errorf("expected session %v to be moved to venue %v, got %v", otherSessionId, keepVenueId, other.Venue)
+		}
+		comments, err := queries.GetCommentsBySessionId(ctx, keepSessionId)
+		if err != nil {
+			t.Fatalf("error when retrieving comments: %v", err)
+		}
+		if len(comments) != 1 || comments[0].CommentID != commentId || comments[0].Rating == nil || *comments[0].Rating != 4 {
+			t.Errorf("expected the comment and rating of the duplicate session to be moved to session %v, got %+v", keepSessionId, comments)
+		}
+
+		// merging into a venue that doesn't exist anymore should fail
+		cmd = exec.Command("dbcli", "venue", "merge", fmt.Sprint(dropVenueId), fmt.Sprint(keepVenueId))
+		cmd.Env = os.Environ()
+		if err := cmd.Run(); err == nil {
+			t.Error("expected an error when merging into a deleted venue")
+		}
+		if _, err := queries.GetVenueById(ctx, keepVenueId); err != nil {
+			t.Errorf("expected venue %v to still exist after the failed merge: %v", keepVenueId, err)
+		}
+	})
+
+	t.Run("RefreshOccurrences", func(t *testing.T) {
+		migrationsDirectory := t.TempDir()
+		migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+
+		if fp, err := migrationutils.WriteMigration("dbcli occurrences refresh --start 2024-05-01 --months 1", "test_refresh_occurrences", migrationsDirectory); err != nil {
+			t.Errorf("could not write to file %v: %v", fp, err)
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("bash", migrationsScript, "-y")
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+		cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+		cmd.Stderr = &stderr
+		cmd.Stdout = os.Stdout
+
+		if err := cmd.Run(); err != nil {
+			t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+		}
+
+		horizon, err := queries.GetOccurrencesHorizon(ctx)
+		if err != nil {
+			t.Fatalf("error when retrieving the occurrences horizon: %v", err)
+		}
+		if !horizon.StartDate.Time.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) || !horizon.EndDate.Time.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected the horizon to be 2024-05-01 to 2024-06-01, got %v to %v", horizon.StartDate.Time, horizon.EndDate.Time)
+		}
+		// the two weekly test sessions take place on 4 mondays in may 2024 (6th - 27th)
+		result, err := queries.GetSessionIdsByDate(ctx, pgtype.Date{Time: time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), Valid: true})
+		if err != nil {
+			t.Fatalf("error when retrieving sessions by date: %v", err)
+		}
+		var found int
+		for _, i := range result {
+			if id := i.([]any)[0].(int32); id == testSessionId || id == testSessionId2 {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("expected both test sessions to take place on 2024-05-13, got %v", result)
+		}
+	})
+
+	t.Run("ReportAndModerateComment", func(t *testing.T) {
+		commentId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSessionId, Author: "spammer", Content: "cheap loans"})
+		if err != nil {
+			t.Fatalf("failed to insert comment: %v", err)
+		}
+
+		runMigrations := func(cmds ...string) {
+			migrationsDirectory := t.TempDir()
+			migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+			for i, c := range cmds {
+				if fp, err := migrationutils.WriteMigration(c, fmt.Sprintf("test_moderation_%v", i), migrationsDirectory); err != nil {
+					t.Errorf("could not write to file %v: %v", fp, err)
+				}
+			}
+			var stderr bytes.Buffer
+			cmd := exec.Command("bash", migrationsScript, "-y")
+			cmd.Env = os.Environ()
+			cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+			cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+			cmd.Stderr = &stderr
+			cmd.Stdout = os.Stdout
+			if err := cmd.Run(); err != nil {
+				t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+			}
+		}
+		status := func() string {
+			comment, err := queries.GetCommentById(ctx, commentId)
+			if err != nil {
+				t.Fatalf("error when retrieving the comment: %v", err)
+			}
+			return comment.Status
+		}
+
+		// reports of three different users hold the comment back
+		runMigrations(
+			fmt.Sprintf(`dbcli insert report "{"comment":%v,"reason":"spam","reporter_hash":"a"}";`, commentId),
+			fmt.Sprintf(`dbcli insert report "{"comment":%v,"reason":"spam","reporter_hash":"a"}";`, commentId),
+			fmt.Sprintf(`dbcli insert report "{"comment":%v,"reason":"offensive","details":"...","reporter_hash":"b"}";`, commentId),
+		)
+		if s := status(); s != "visible" {
+			t.Errorf("expected the comment to remain visible after reports of two users, got %v", s)
+		}
+		runMigrations(fmt.Sprintf(`dbcli insert report "{"comment":%v,"reason":"spam","reporter_hash":"c"}";`, commentId))
+		if s := status(); s != "pending" {
+			t.Errorf("expected the comment to be held after reports of three users, got %v", s)
+		}
+		queue, err := queries.GetModeratedComments(ctx, dbutils.GetModeratedCommentsParams{Reported: true})
+		if err != nil {
+			t.Fatalf("error when retrieving the moderation queue: %v", err)
+		}
+		var found bool
+		for _, c := range queue {
+			if c.CommentID == commentId {
+				found = true
+				if c.ReportCount != 4 || strings.Join(c.ReportReasons, ",") != "offensive,spam" {
+					t.Errorf("expected 4 reports (offensive, spam), got %v (%v)", c.ReportCount, c.ReportReasons)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected the comment to be in the moderation queue, got %+v", queue)
+		}
+
+		// moderating the comment resolves its reports
+		runMigrations(fmt.Sprintf("dbcli comment status %v hidden", commentId))
+		if s := status(); s != "hidden" {
+			t.Errorf("expected the comment to be hidden, got %v", s)
+		}
+		queue, err = queries.GetModeratedComments(ctx, dbutils.GetModeratedCommentsParams{Reported: true})
+		if err != nil {
+			t.Fatalf("error when retrieving the moderation queue: %v", err)
+		}
+		for _, c := range queue {
+			if c.CommentID == commentId {
+				t.Errorf("expected the reports of the comment to be resolved, got %+v", c)
+			}
+		}
+	})
+
+	t.Run("UpdateAndDeleteComment", func(t *testing.T) {
+		commentId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSessionId, Author: "typo", Content: "Grate session"})
+		if err != nil {
+			t.Fatalf("failed to insert comment: %v", err)
+		}
+		if _, err := queries.InsertSessionRating(ctx, dbutils.InsertSessionRatingParams{Session: testSessionId, Rating: ptr(int16(5)), Comment: &commentId}); err != nil {
+			t.Fatalf("failed to insert rating: %v", err)
+		}
+		replyId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSessionId, Author: "reply", Content: "Agreed", ParentComment: &commentId})
+		if err != nil {
+			t.Fatalf("failed to insert comment: %v", err)
+		}
+
+		runMigration := func(c string) {
+			migrationsDirectory := t.TempDir()
+			migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+			if fp, err := migrationutils.WriteMigration(c, "test_comment", migrationsDirectory); err != nil {
+				t.Errorf("could not write to file %v: %v", fp, err)
+			}
+			var stderr bytes.Buffer
+			cmd := exec.Command("bash", migrationsScript, "-y")
+			cmd.Env = os.Environ()
+			cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+			cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+			cmd.Stderr = &stderr
+			cmd.Stdout = os.Stdout
+			if err := cmd.Run(); err != nil {
+				t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+			}
+		}
+
+		// edits keep the previous version
+		runMigration(fmt.Sprintf(`dbcli update comment %v "{"content":"Great session"}"`, commentId))
+		comment, err := queries.GetCommentById(ctx, commentId)
+		if err != nil {
+			t.Fatalf("error when retrieving the comment: %v", err)
+		}
+		if comment.Content != "Great session" || comment.Author != "typo" || !comment.DtEdited.Valid {
+			t.Errorf("expected the content to be edited, got %+v", comment)
+		}
+		edits, err := queries.GetCommentEdits(ctx, commentId)
+		if err != nil {
+			t.Fatalf("error when retrieving the comment edits: %v", err)
+		}
+		if len(edits) != 1 || edits[0].Content != "Grate session" {
+			t.Errorf("expected the previous version to be kept, got %+v", edits)
+		}
+
+		// deleting a comment deletes its rating, replies become top-level comments
+		runMigration(fmt.Sprintf("dbcli delete comment %v", commentId))
+		if _, err := queries.GetCommentById(ctx, commentId); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("expected the comment to be deleted, got %v", err)
+		}
+		ratings, err := queries.GetRatingsBySessionId(ctx, testSessionId)
+		if err != nil {
+			t.Fatalf("error when retrieving ratings: %v", err)
+		}
+		for _, r := range ratings {
+			if r.Comment != nil && *r.Comment == commentId {
+				t.Errorf("expected the rating of the comment to be deleted, got %+v", r)
+			}
+		}
+		reply, err := queries.GetCommentById(ctx, replyId)
+		if err != nil {
+			t.Fatalf("error when retrieving the reply: %v", err)
+		}
+		if reply.ParentComment != nil || reply.Session != testSessionId {
+			t.Errorf("expected the reply to become a top-level comment, got %+v", reply)
+		}
+	})
+
+	t.Run("InsertUserAndAttribution", func(t *testing.T) {
+		migrationsDirectory := t.TempDir()
+		migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+
+		sessionJson, err := json.Marshal(struct {
+			dbutils.InsertJamSessionParams
+			UserEmail string `json:"user_email"`
+		}{dbutils.InsertJamSessionParams{
+			SessionName:     "Attributed Jam",
+			Venue:           testVenueId,
+			Description:     "...",
+			StartTimeUtc:    pgtype.Timestamptz{Time: time.Date(2024, 5, 8, 19, 0, 0, 0, time.UTC), Valid: true},
+			DurationMinutes: 120,
+			Interval:        "Weekly",
+		}, "CLI.User@example.org"})
+		if err != nil {
+			t.Fatal("Couldn't marshal json", err)
+		}
+		for i, c := range []string{
+			// registering twice is a no-op
+			`dbcli insert user "{"email":"cli.user@example.org"}"`,
+			`dbcli insert user "{"email":"cli.user@example.org"}"`,
+			fmt.Sprintf(`dbcli insert session "%s"`, sessionJson),
+			fmt.Sprintf(`dbcli insert comment "{"session":%v,"author":"","content":"attributed","user_email":"cli.user@example.org"}"`, testSessionId),
+		} {
+			if fp, err := migrationutils.WriteMigration(c, fmt.Sprintf("test_attribution_%v", i), migrationsDirectory); err != nil {
+				t.Errorf("could not write to file %v: %v", fp, err)
+			}
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("bash", migrationsScript, "-y")
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+		cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+		cmd.Stderr = &stderr
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+		}
+
+		user, err := queries.GetUserByEmail(ctx, "cli.user@example.org")
+		if err != nil {
+			t.Fatalf("error when retrieving the user: %v", err)
+		}
+		contributions, err := queries.GetUserContributions(ctx, user.UserID)
+		if err != nil {
+			t.Fatalf("error when retrieving the contributions: %v", err)
+		}
+		if len(contributions) != 1 || contributions[0].Session == nil || contributions[0].Action != "insert" {
+			t.Errorf("expected the session to be attributed to the user, got %+v", contributions)
+		}
+		comments, err := queries.GetCommentsBySessionId(ctx, testSessionId)
+		if err != nil {
+			t.Fatalf("error when retrieving comments: %v", err)
+		}
+		var found bool
+		for _, c := range comments {
+			if c.Content == "attributed" {
+				found = true
+				if c.UserID == nil || *c.UserID != user.UserID {
+					t.Errorf("expected the comment to be attributed to user %v, got %v", user.UserID, c.UserID)
+				}
+			}
+		}
+		if !found {
+			t.Error("expected the comment to be inserted")
+		}
+	})
+
+	t.Run("UserRoleAndOwner", func(t *testing.T) {
+		migrationsDirectory := t.TempDir()
+		migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+
+		for i, c := range []string{
+			`dbcli insert user "{"email":"owner@example.org"}"`,
+			"dbcli user role owner@example.org trusted_contributor",
+			fmt.Sprintf("dbcli user owner owner@example.org %v", testVenueId),
+		} {
+			if fp, err := migrationutils.WriteMigration(c, fmt.Sprintf("test_user_role_%v", i), migrationsDirectory); err != nil {
+				t.Errorf("could not write to file %v: %v", fp, err)
+			}
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("bash", migrationsScript, "-y")
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+		cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+		cmd.Stderr = &stderr
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+		}
+
+		permissions, err := queries.GetUserPermissions(ctx, "owner@example.org")
+		if err != nil {
+			t.Fatalf("error when retrieving the permissions: %v", err)
+		}
+		if permissions.Role != "trusted_contributor" {
+			t.Errorf("expected the role trusted_contributor, got %v", permissions.Role)
+		}
+		if len(permissions.OwnedVenues) != 1 || permissions.OwnedVenues[0] != testVenueId {
+			t.Errorf("expected the user to own venue %v, got %v", testVenueId, permissions.OwnedVenues)
+		}
+
+		if err := setUserRole(ctx, "owner@example.org", "venue_owner"); err == nil {
+			t.Error("expected venue_owner not to be assignable to accounts")
+		}
+		if err := setUserRole(ctx, "nobody@example.org", "admin"); err == nil {
+			t.Error("expected an error for a user that doesn't exist")
+		}
+		if err := setVenueOwner(ctx, "owner@example.org", testVenueId, true); err != nil {
+			t.Errorf("expected error to be nil, got %v", err)
+		}
+		if permissions, err := queries.GetUserPermissions(ctx, "owner@example.org"); err != nil || len(permissions.OwnedVenues) != 0 {
+			t.Errorf("expected the user not to own any venues, got %v (err: %v)", permissions.OwnedVenues, err)
+		}
+	})
+
+	t.Run("Favourites", func(t *testing.T) {
+		migrationsDirectory := t.TempDir()
+		migrationsArchive := filepath.Join(migrationsDirectory, "/archive")
+
+		for i, c := range []string{
+			`dbcli insert user "{"email":"fan@example.org"}"`,
+			fmt.Sprintf(`dbcli favourite add "{"user_email":"fan@example.org","session":%v}"`, testSessionId),
+			fmt.Sprintf(`dbcli favourite add "{"user_email":"fan@example.org","session":%v}"`, testSessionId), // idempotent
+			fmt.Sprintf(`dbcli favourite add "{"user_email":"fan@example.org","session":%v}"`, testSessionId2),
+			fmt.Sprintf(`dbcli favourite remove "{"user_email":"fan@example.org","session":%v}"`, testSessionId2),
+		} {
+			if fp, err := migrationutils.WriteMigration(c, fmt.Sprintf("test_favourite_%v", i), migrationsDirectory); err != nil {
+				t.Errorf("could not write to file %v: %v", fp, err)
+			}
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("bash", migrationsScript, "-y")
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, "MIGRATIONS_DIRECTORY="+migrationsDirectory)
+		cmd.Env = append(cmd.Env, "MIGRATIONS_ARCHIVE="+migrationsArchive)
+		cmd.Stderr = &stderr
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			t.Errorf("an error occured when running migrations: %v: %v", err, stderr.String())
+		}
+
+		user, err := queries.GetUserByEmail(ctx, "fan@example.org")
+		if err != nil {
+			t.Fatalf("error when retrieving the user: %v", err)
+		}
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		rows, err := queries.GetFavouriteOccurrences(ctx, dbutils.GetFavouriteOccurrencesParams{
+			UserID:    user.UserID,
+			StartDate: pgtype.Date{Time: today, Valid: true},
+			EndDate:   pgtype.Date{Time: today.AddDate(0, 0, 60), Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("error when retrieving the favourites: %v", err)
+		}
+		if len(rows) != 1 || rows[0].SessionID != testSessionId {
+			t.Fatalf("expected only session %v to be a favourite, got %+v", testSessionId, rows)
+		}
+		if len(rows[0].Dates) == 0 {
+			t.Errorf("expected the session to take place in the next 60 days, got no dates")
+		}
+
+		if err := setFavourite(ctx, fmt.Sprintf(`{"user_email":"nobody@example.org","session":%v}`, testSessionId), false); err == nil {
+			t.Error("expected an error for an unknown user")
+		}
+		if err := setFavourite(ctx, `{"user_email":"fan@example.org"}`, false); err == nil {
+			t.Error("expected an error for a missing session")
+		}
+	})
+
+	t.Run("FollowsAndNotifications", func(t *testing.T) {
+		var err error
+		if dispatcher, err = notify.New("memory", "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		publicUrl = "https://example.org"
+		defer func() { dispatcher, publicUrl = nil, "" }()
+		memory := dispatcher.Transports[notify.Email].(*notify.MemoryTransport)
+
+		for _, email := range []string{"session.follower@example.org", "venue.follower@example.org"} {
+			if _, err := queries.InsertUser(ctx, email); err != nil {
+				t.Fatalf("could not insert user: %v", err)
+			}
+		}
+		if err := setFollow(ctx, fmt.Sprintf(`{"user_email":"session.follower@example.org","session":%v}`, testSessionId), false); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if err := setFollow(ctx, fmt.Sprintf(`{"user_email":"venue.follower@example.org","venue":%v}`, testVenueId), false); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if err := setFollow(ctx, fmt.Sprintf(`{"user_email":"nobody@example.org","venue":%v}`, testVenueId), false); err == nil {
+			t.Error("expected an error for an unknown user")
+		}
+		if err := setFollow(ctx, fmt.Sprintf(`{"user_email":"venue.follower@example.org","session":%v,"venue":%v}`, testSessionId, testVenueId), false); err == nil {
+			t.Error("expected an error for a follow of both a session and a venue")
+		}
+		channelId, err := addChannel(ctx, `{"user_email":"venue.follower@example.org","kind":"webhook","endpoint":"https://example.org/hook"}`)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if _, err := addChannel(ctx, `{"user_email":"venue.follower@example.org","kind":"webhook","endpoint":"http://example.org/hook"}`); err == nil {
+			t.Error("expected an error for an endpoint that isn't https")
+		}
+
+		// renaming the session notifies the followers of the session and of its venue
+		before, err := queries.GetSessionById(ctx, testSessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renamed := before
+		renamed.SessionName = "Old Name"
+		notifySessionUpdate(ctx, renamed)
+		notifications := memory.Notifications()
+		if len(notifications) != 2 {
+			t.Fatalf("expected 2 notifications, got %+v", notifications)
+		}
+		for _, n := range notifications {
+			if n.Change.Type != "session_updated" || !strings.Contains(n.Change.Text, "renamed to "+before.SessionName) || n.Change.URL != sessionLink(before) {
+				t.Errorf("unexpected notification %+v", n)
+			}
+			if n.Recipient == "venue.follower@example.org" && (n.Channel.Kind != notify.Webhook || n.Channel.ID != channelId) {
+				t.Errorf("expected the venue follower to be notified through the webhook, got %+v", n.Channel)
+			}
+		}
+
+		// updates that don't change anything relevant are not announced
+		notifySessionUpdate(ctx, before)
+		if n := memory.Notifications(); len(n) != 2 {
+			t.Errorf("expected no further notifications, got %+v", n[2:])
+		}
+
+		// cancellations
+		cancelledId, err := queries.InsertJamSession(ctx, dbutils.InsertJamSessionParams{
+			SessionName:     "TEST_SESSION_CANCELLED",
+			Venue:           testVenueId,
+			Description:     "...",
+			StartTimeUtc:    pgtype.Timestamptz{Time: time.Date(2024, 5, 6, 7, 6, 5, 4, time.UTC), Valid: true},
+			DurationMinutes: 30,
+			Interval:        "Weekly",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		notifyCancellation := prepareSessionCancellation(ctx, cancelledId)
+		if err := queries.DeleteJamSessionById(ctx, cancelledId); err != nil {
+			t.Fatal(err)
+		}
+		notifyCancellation()
+		if n := memory.Notifications(); len(n) != 3 || n[2].Recipient != "venue.follower@example.org" || n[2].Change.Type != "session_cancelled" {
+			t.Errorf("expected the venue follower to be notified about the cancellation, got %+v", n)
+		}
+
+		if err := setFollow(ctx, fmt.Sprintf(`{"user_email":"session.follower@example.org","session":%v}`, testSessionId), true); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if err := removeChannel(ctx, fmt.Sprintf(`{"user_email":"session.follower@example.org","channel_id":%v}`, channelId)); err == nil {
+			t.Error("expected an error when removing the channel of another user")
+		}
+		if err := removeChannel(ctx, fmt.Sprintf(`{"user_email":"venue.follower@example.org","channel_id":%v}`, channelId)); err != nil {
+			t.Errorf("expected error to be nil, got %v", err)
+		}
+	})
+
+	t.Run("SavedSearchDigests", func(t *testing.T) {
+		var outbox bytes.Buffer
+		mailer, publicUrl = &mail.WriterMailer{W: &outbox}, "https://example.org"
+		defer func() { mailer, publicUrl = nil, "" }()
+
+		if _, err := queries.InsertUser(ctx, "digest@example.org"); err != nil {
+			t.Fatalf("could not insert user: %v", err)
+		}
+		// area around the test venue, Mondays only
+		searchId, err := addSavedSearch(ctx, `{"user_email":"digest@example.org","search_name":"Mondays in Westminster","weekdays":[1],"lon":-0.13,"lat":51.51,"radius_metres":2000}`)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if _, err := addSavedSearch(ctx, `{"user_email":"digest@example.org","search_name":"Far away","lon":-2.0,"lat":53.0,"radius_metres":1000}`); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if _, err := addSavedSearch(ctx, `{"user_email":"nobody@example.org","search_name":"Nothing"}`); err == nil {
+			t.Error("expected an error for an unknown user")
+		}
+		// sessions added after the search was saved are new
+		newSessionId, err := queries.InsertJamSession(ctx, dbutils.InsertJamSessionParams{
+			SessionName:     "TEST_SESSION_DIGEST",
+			Venue:           testVenueId,
+			Description:     "...",
+			StartTimeUtc:    pgtype.Timestamptz{Time: time.Date(2030, 6, 3, 19, 0, 0, 0, time.UTC), Valid: true}, // a Monday
+			DurationMinutes: 90,
+			Interval:        "Once",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now().UTC()
+		sent, err := sendDigests(ctx, now, 7, false)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		// the weekly test sessions take place on Mondays, the other search has no matches
+		if sent != 1 {
+			t.Errorf("expected 1 digest to be sent, got %v", sent)
+		}
+		for _, expected := range []string{
+			"To: digest@example.org",
+			"Subject: Mondays in Westminster: ",
+			" and 1 new session\r\n",
+			fmt.Sprintf("https://example.org/john-does-jazz-hole-%v/testsession-%v", testVenueId, testSessionId),
+			fmt.Sprintf("TEST_SESSION_DIGEST at John Doe's Jazz Hole, starting Mon 3 Jun 19:00 (UTC)\r\n  https://example.org/john-does-jazz-hole-%v/testsessiondigest-%v", testVenueId, newSessionId),
+			"Content-Type: text/html; charset=utf-8",
+		} {
+			if !strings.Contains(outbox.String(), expected) {
+				t.Errorf("expected the digest to contain %q, got %v", expected, outbox.String())
+			}
+		}
+
+		// the next digest is due in 6 days, sessions are only new once
+		outbox.Reset()
+		if sent, err := sendDigests(ctx, now.Add(time.Hour), 7, false); err != nil || sent != 0 {
+			t.Errorf("expected no digests to be due, got %v (err: %v)", sent, err)
+		}
+		if sent, err := sendDigests(ctx, now.Add(time.Hour), 7, true); err != nil || sent != 1 {
+			t.Errorf("expected the digest to be sent again with force, got %v (err: %v)", sent, err)
+		}
+		if strings.Contains(outbox.String(), "New sessions") {
+			t.Errorf("expected no new sessions in the second digest, got %v", outbox.String())
+		}
+
+		if err := removeSavedSearch(ctx, fmt.Sprintf(`{"user_email":"nobody@example.org","search_id":%v}`, searchId)); err == nil {
+			t.Error("expected an error when deleting the search of another user")
+		}
+		if err := removeSavedSearch(ctx, fmt.Sprintf(`{"user_email":"digest@example.org","search_id":%v}`, searchId)); err != nil {
+			t.Errorf("expected error to be nil, got %v", err)
+		}
+	})
+
+	t.Run("ApiKeys", func(t *testing.T) {
+		key, id, err := createApiKey(ctx, "Test bot", 60)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if _, _, err := createApiKey(ctx, "Test bot", 0); err == nil {
+			t.Error("expected an error for a rate limit of 0")
+		}
+		stored, err := queries.GetApiKeyByHash(ctx, apikeys.Hash(key))
+		if err != nil || stored.KeyID != id || stored.RateLimit != 60 || stored.KeyPrefix != apikeys.DisplayPrefix(key) {
+			t.Fatalf("expected the key to be stored, got %+v (err: %v)", stored, err)
+		}
+		now := time.Now().UTC()
+		for i := 0; i < 2; i++ {
+			if err := queries.RecordApiKeyUsage(ctx, dbutils.RecordApiKeyUsageParams{KeyID: id, RequestCount: 5, LastUsed: pgtype.Timestamptz{Time: now, Valid: true}}); err != nil {
+				t.Fatalf("expected error to be nil, got %v", err)
+			}
+		}
+		var out bytes.Buffer
+		if err := listApiKeys(ctx, &out); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if !regexp.MustCompile(fmt.Sprintf(`(?m)^%v\s+Test bot\s+%v\s+60/min\s+10\s+10\s`, id, apikeys.DisplayPrefix(key))).Match(out.Bytes()) {
+			t.Errorf("expected the key to be listed with 10 requests, got:\n%v", out.String())
+		}
+
+		if err := revokeApiKey(ctx, id); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if err := revokeApiKey(ctx, id); err == nil {
+			t.Error("expected an error when revoking a key twice")
+		}
+		if _, err := queries.GetApiKeyByHash(ctx, apikeys.Hash(key)); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("expected revoked keys not to be returned, got %v", err)
+		}
+	})
+
+	t.Run("Webhooks", func(t *testing.T) {
+		status := http.StatusNoContent
+		var received []webhooks.Event
+		receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			ts, _ := strconv.ParseInt(r.Header.Get(webhooks.TimestampHeader), 10, 64)
+			if !webhooks.Verify("whsec_test", ts, body, r.Header.Get(webhooks.SignatureHeader)) {
+				t.Errorf("expected a valid signature, got %v", r.Header)
+			}
+			var e webhooks.Event
+			if err := json.Unmarshal(body, &e); err != nil {
+				t.Errorf("could not decode event: %v", err)
+			}
+			received = append(received, e)
+			w.WriteHeader(status)
+		}))
+		defer receiver.Close()
+		// subscriptions can't point to internal addresses, the receiver is registered as example.com (covered by its
+		// certificate) and the client connects to the receiver instead
+		client := receiver.Client()
+		client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, receiver.Listener.Addr().String())
+		}
+		webhookClient.HTTP, publicUrl = client, "https://example.org"
+		defer func() { webhookClient.HTTP, publicUrl = nil, "" }()
+
+		if _, err := addWebhook(ctx, `{"url":"http://example.org/hook","secret":"whsec_test","event_types":["session.updated"]}`); err == nil {
+			t.Error("expected an error for a URL that isn't https")
+		}
+		if _, err := addWebhook(ctx, `{"url":"https://example.org/hook","secret":"whsec_test","event_types":["session.rated"]}`); err == nil {
+			t.Error("expected an error for an unknown event type")
+		}
+		if _, err := addWebhook(ctx, fmt.Sprintf(`{"url":%q,"secret":"whsec_test","event_types":["session.updated"]}`, receiver.URL)); err == nil {
+			t.Error("expected an error for a loopback address")
+		}
+		id, err := addWebhook(ctx, `{"url":"https://example.com/hook","secret":"whsec_test","event_types":["session.updated"]}`)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+
+		emitSessionEvent(ctx, webhooks.SessionUpdated, testSessionId)
+		emitVenueEvent(ctx, webhooks.VenueUpdated, testVenueId) // not subscribed
+		delivered, failed, err := deliverWebhooks(ctx, 10)
+		if err != nil || delivered != 1 || failed != 0 {
+			t.Fatalf("expected 1 delivery, got %v delivered and %v failed (err: %v)", delivered, failed, err)
+		}
+		var data webhooks.SessionData
+		if len(received) != 1 || received[0].Type != webhooks.SessionUpdated || json.Unmarshal(received[0].Data, &data) != nil || data.SessionID != testSessionId ||
+			data.URL != fmt.Sprintf("https://example.org/john-does-jazz-hole-%v/testsession-%v", testVenueId, testSessionId) {
+			t.Errorf("expected the session.updated event, got %+v", received)
+		}
+
+		// failed deliveries are retried later
+		status = http.StatusServiceUnavailable
+		emitSessionEvent(ctx, webhooks.SessionUpdated, testSessionId)
+		if delivered, failed, err := deliverWebhooks(ctx, 10); err != nil || delivered != 0 || failed != 1 {
+			t.Errorf("expected 1 failed attempt, got %v delivered and %v failed (err: %v)", delivered, failed, err)
+		}
+		if delivered, failed, err := deliverWebhooks(ctx, 10); err != nil || delivered+failed != 0 {
+			t.Errorf("expected the retry not to be due yet, got %v delivered and %v failed (err: %v)", delivered, failed, err)
+		}
+		entries, err := queries.GetWebhookDeliveries(ctx, dbutils.GetWebhookDeliveriesParams{SubscriptionID: id, MaxResults: 10})
+		if err != nil || len(entries) != 2 {
+			t.Fatalf("expected 2 deliveries, got %+v (err: %v)", entries, err)
+		}
+		if entries[0].Status != webhooks.Pending || entries[0].Attempts != 1 || entries[0].ResponseStatus == nil || *entries[0].ResponseStatus != 503 || !entries[0].NextAttemptAt.Time.After(time.Now()) {
+			t.Errorf("expected the failed delivery to be retried later, got %+v", entries[0])
+		}
+		if entries[1].Status != webhooks.Delivered || !entries[1].DtDelivered.Valid {
+			t.Errorf("expected the first delivery to be delivered, got %+v", entries[1])
+		}
+
+		if err := removeWebhook(ctx, id); err != nil {
+			t.Errorf("expected error to be nil, got %v", err)
+		}
+		if err := removeWebhook(ctx, id); err == nil {
+			t.Error("expected an error when removing a subscription twice")
+		}
+	})
+
+	t.Run("PruneEvents", func(t *testing.T) {
+		if _, err := pruneEvents(ctx, time.Now(), 0); err == nil {
+			t.Error("expected an error for 0 days")
+		}
+		latest, err := queries.GetLatestEventId(ctx)
+		if err != nil || latest == 0 {
+			t.Fatalf("expected the inserts of the tests to be recorded as events, got %v (err: %v)", latest, err)
+		}
+		if n, err := pruneEvents(ctx, time.Now(), 30); err != nil || n != 0 {
+			t.Errorf("expected recent events to be kept, got %v deleted (err: %v)", n, err)
+		}
+		if n, err := pruneEvents(ctx, time.Now().AddDate(0, 0, 31), 30); err != nil || n == 0 {
+			t.Errorf("expected the events to be deleted 31 days later, got %v deleted (err: %v)", n, err)
+		}
+		if rows, err := queries.GetEventsAfter(ctx, dbutils.GetEventsAfterParams{AfterID: 0, MaxResults: 1}); err != nil || len(rows) != 0 {
+			t.Errorf("expected no events to be left, got %+v (err: %v)", rows, err)
+		}
+	})
+}
+
+func TestSessionChanges(t *testing.T) {
+	publicUrl = "https://example.org/"
+	defer func() { publicUrl = "" }()
+	before := dbutils.GetSessionByIdRow{
+		SessionID:       5,
+		SessionName:     "Monday Blues Jam",
+		Venue:           2,
+		VenueID:         2,
+		VenueName:       "The Pub",
+		StartTimeUtc:    pgtype.Timestamptz{Time: time.Date(2024, 5, 6, 19, 30, 0, 0, time.UTC), Valid: true},
+		DurationMinutes: 120,
+		Interval:        "Weekly",
+	}
+	if c := sessionChange(before, before); c != nil {
+		t.Errorf("expected no change, got %+v", c)
+	}
+	after := before
+	after.SessionName = "Tuesday Blues Jam (New!)"
+	after.StartTimeUtc.Time = before.StartTimeUtc.Time.AddDate(0, 0, 1)
+	c := sessionChange(before, after)
+	if c == nil {
+		t.Fatal("expected a change")
+	}
+	if c.Title != "Monday Blues Jam has changed" || c.URL != "https://example.org/the-pub-2/tuesday-blues-jam-new-5" {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if expected := "The schedule has changed from Weekly, Mon 6 May 2024 19:30 (UTC) for 120 minutes to Weekly, Tue 7 May 2024 19:30 (UTC) for 120 minutes."; !strings.Contains(c.Text, expected) {
+		t.Errorf("expected the text to contain %q, got %q", expected, c.Text)
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	n := &alerts.MemoryNotifier{}
+	if err := sendAlert(context.Background(), n, &AlertCmd{Type: "failed migration", Name: "20240601_new_session.sh", Notes: "exit status 1"}); err != nil {
+		t.Fatal(err)
+	}
+	sent := n.Alerts()
+	if len(sent) != 1 || sent[0].Title() != "Pending failed migration: 20240601_new_session.sh" || sent[0].Notes != "exit status 1" {
+		t.Errorf("unexpected alerts %+v", sent)
+	}
+}
+
+func TestGeocodingWarnings(t *testing.T) {
+	soho := geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.132, 51.513}).SetSRID(4326)
+	paris := geom.NewPoint(geom.XY).MustSetCoords([]float64{2.352, 48.857}).SetSRID(4326)
+	cases := map[string]struct {
+		result   geocoding.Result
+		expected []string
+	}{
+		"building in london": {geocoding.Result{Point: soho, Confidence: 1, MatchType: "building"}, nil},
+		"road in london":     {geocoding.Result{Point: soho, Confidence: 26.0 / 30, MatchType: "road"}, []string{"matched to a road (confidence 0.87)"}},
+		"building in paris":  {geocoding.Result{Point: paris, Confidence: 1, MatchType: "building"}, []string{"(2.352, 48.857) lies outside of the bounding box"}},
+		"postcode in paris":  {geocoding.Result{Point: paris, Confidence: 0.5, MatchType: "postcode"}, []string{"outside of the bounding box", "matched to a postcode (confidence 0.50)"}},
+	}
+	for name, tc := range cases {
+		warnings := geocodingWarnings(&tc.result)
+		if len(warnings) != len(tc.expected) {
+			t.Errorf("%v: expected %v warnings, got %q", name, len(tc.expected), warnings)
+			continue
+		}
+		for i, exp := range tc.expected {
+			if !strings.Contains(warnings[i], exp) {
+				t.Errorf("%v: expected warning %q to contain %q", name, warnings[i], exp)
+			}
+		}
+	}
+}
+
+func TestGeocodingCorrection(t *testing.T) {
+	// stand-in for dbcli that records the arguments the correction script calls it with
+	bin := t.TempDir()
+	argsFile := filepath.Join(t.TempDir(), "args")
+	if err := os.WriteFile(filepath.Join(bin, "dbcli"), []byte("#!/usr/bin/env bash\nprintf '%s\\0' \"$@\" > "+argsFile+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	secondLine := `"Back" Room; $(touch pwned)`
+	result := &geocoding.Result{Point: geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.132, 51.513}), Confidence: 1, MatchType: "building"}
+	fp, err := writeGeocodingCorrection(t.TempDir(), 7, "47 Frith Street", &secondLine, "London", "W1D 4HT", 1234.4, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(fp, "_geocoding_correction_venue_7.sh") {
+		t.Errorf("unexpected file name %v", fp)
+	}
+	script, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# geocoding correction: stored location is 1234m away from the fresh result (-0.132, 51.513), confidence 1.00, match type building"; !strings.Contains(string(script), expected) {
+		t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+	}
+
+	cmd := exec.Command("bash", fp)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to run the correction script: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(cmd.Dir, "pwned")); err == nil {
+		t.Error("expected the address not to be executed")
+	}
+	b, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(strings.TrimSuffix(string(b), "\x00"), "\x00")
+	if len(args) != 4 || args[0] != "update" || args[1] != "venue" || args[2] != "7" {
+		t.Fatalf("unexpected arguments %q", args)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(args[3]), &payload); err != nil {
+		t.Fatalf("failed to parse the payload %q: %v", args[3], err)
+	}
+	expected := map[string]string{"address_first_line": "47 Frith Street", "address_second_line": secondLine, "city": "London", "postcode": "W1D 4HT"}
+	if fmt.Sprint(payload) != fmt.Sprint(expected) {
+		t.Errorf("expected the payload %v, got %v", expected, payload)
+	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// moderation states of a comment, only 'visible' comments are served by the API
+var commentStatuses = []string{"visible", "hidden", "pending"}
+
+type CommentStatusCmd struct {
+	Id     int    `arg:"positional,required" help:"ID of the comment"`
+	Status string `arg:"positional,required" help:"new status: 'visible', 'hidden' or 'pending'"`
+}
+
+type CommentCmd struct {
+	Status *CommentStatusCmd `arg:"subcommand:status" help:"set the moderation status of a comment (resolves its open reports)"`
+}
+
+// setCommentStatus sets the moderation status of a comment and resolves all of its open reports, in one transaction.
+// It returns the number of resolved reports.
+func setCommentStatus(ctx context.Context, pool *pgxpool.Pool, id int32, status string) (int64, error) {
+	if !slices.Contains(commentStatuses, status) {
+		return 0, fmt.Errorf("unknown status %q (available: %v)", status, commentStatuses)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // no-op if the transaction has been committed
+	qtx := queries.WithTx(tx)
+
+	n, err := qtx.UpdateCommentStatus(ctx, dbutils.UpdateCommentStatusParams{Status: status, CommentID: id})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("comment %v does not exist", id)
+	}
+	resolved, err := qtx.ResolveCommentReports(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return resolved, tx.Commit(ctx)
+}
+
+// deleteComment deletes a comment together with the rating that was submitted with it, in one transaction.
+// Replies to the comment become top-level comments. It returns the number of deleted ratings.
+func deleteComment(ctx context.Context, pool *pgxpool.Pool, id int32) (int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // no-op if the transaction has been committed
+	qtx := queries.WithTx(tx)
+
+	ratings, err := qtx.DeleteRatingsByCommentId(ctx, &id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := qtx.DeleteCommentById(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("comment %v does not exist", id)
+	}
+	return ratings, tx.Commit(ctx)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/digest"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type SearchAddCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email', 'search_name' and optionally 'genres', 'backline', 'weekdays' (ISO, 1 = Monday), 'lon', 'lat' and 'radius_metres'"`
+}
+
+type SearchRemoveCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and 'search_id'"`
+}
+
+type SearchCmd struct {
+	Add    *SearchAddCmd    `arg:"subcommand:add" help:"save a search of a user (a search with the same name is replaced)"`
+	Remove *SearchRemoveCmd `arg:"subcommand:remove" help:"delete a saved search of a user"`
+}
+
+type DigestSendCmd struct {
+	Days  int  `arg:"--days" default:"7" help:"number of days (starting today) the upcoming sessions are listed for"`
+	Force bool `arg:"--force" help:"send the digests of all saved searches, not only of those whose last digest is older than 6 days"`
+}
+
+type DigestCmd struct {
+	Send *DigestSendCmd `arg:"subcommand:send" help:"email the weekly digests of the saved searches (run weekly by cron)"`
+}
+
+// digests are due 6 days after the last one so that a weekly cron job doesn't skip a week if it runs a bit early
+const digestInterval = 6 * 24 * time.Hour
+
+// addSavedSearch stores the saved search of the payload (written by the server) and returns its ID
+func addSavedSearch(ctx context.Context, payload string) (int32, error) {
+	var p dbutils.InsertSavedSearchParams
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return 0, err
+	}
+	if p.UserEmail == "" || strings.TrimSpace(p.SearchName) == "" {
+		return 0, fmt.Errorf("the keys 'user_email' and 'search_name' are required, got %v", payload)
+	}
+	id, err := queries.InsertSavedSearch(ctx, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("there is no user with the email address %v", p.UserEmail)
+	}
+	return id, err
+}
+
+func removeSavedSearch(ctx context.Context, payload string) error {
+	var p dbutils.DeleteSavedSearchParams
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return err
+	}
+	n, err := queries.DeleteSavedSearch(ctx, p)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("user %v has no saved search with ID %v", p.UserEmail, p.SearchID)
+	}
+	return nil
+}
+
+// buildDigest collects the sessions matching the saved search
+func buildDigest(ctx context.Context, search dbutils.GetDueSavedSearchesRow, from time.Time, days int) (digest.Digest, error) {
+	to := from.AddDate(0, 0, days-1)
+	d := digest.Digest{
+		SearchName: search.SearchName,
+		Filters:    digest.Filters(search.Genres, search.Backline, search.Weekdays, search.RadiusMetres),
+		From:       from,
+		To:         to,
+		URL:        strings.TrimSuffix(publicUrl, "/"),
+	}
+	matches, err := queries.GetSavedSearchMatches(ctx, dbutils.GetSavedSearchMatchesParams{
+		SearchID:  search.SearchID,
+		StartDate: pgtype.Date{Time: from, Valid: true},
+		EndDate:   pgtype.Date{Time: to, Valid: true},
+	})
+	if err != nil {
+		return d, err
+	}
+	for _, m := range matches {
+		s := digest.Session{
+			Name:            m.SessionName,
+			VenueName:       m.VenueName,
+			URL:             sessionURL(m.VenueName, m.VenueID, m.SessionName, m.SessionID),
+			Start:           m.StartTimeUtc.Time,
+			DurationMinutes: m.DurationMinutes,
+		}
+		for _, date := range m.Dates {
+			s.Dates = append(s.Dates, date.Time)
+		}
+		if m.IsNew {
+			d.New = append(d.New, s)
+		}
+		if len(s.Dates) > 0 {
+			d.Upcoming = append(d.Upcoming, s)
+		}
+	}
+	return d, nil
+}
+
+// sendDigests emails the digests of the saved searches that are due (all if force is set) and returns the number
+// of digests sent. Searches without matching sessions are skipped (their new sessions are reported next time).
+func sendDigests(ctx context.Context, now time.Time, days int, force bool) (int, error) {
+	if days < 1 {
+		return 0, fmt.Errorf("--days has to be at least 1, got %v", days)
+	}
+	dueBefore := now.Add(-digestInterval)
+	if force {
+		dueBefore = now
+	}
+	searches, err := queries.GetDueSavedSearches(ctx, pgtype.Timestamptz{Time: dueBefore, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	sent := 0
+	var errs []error
+	for _, search := range searches {
+		d, err := buildDigest(ctx, search, today, days)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("saved search %v: %w", search.SearchID, err))
+			continue
+		}
+		if d.Empty() {
+			log.Printf("Nothing to send for saved search %v\n", search.SearchID)
+			continue
+		}
+		msg, err := d.Message(search.Email)
+		if err == nil {
+			err = mailer.Send(ctx, msg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("saved search %v: %w", search.SearchID, err))
+			continue
+		}
+		if err := queries.SetSavedSearchDigestSent(ctx, dbutils.SetSavedSearchDigestSentParams{SearchID: search.SearchID, DtLastDigest: pgtype.Timestamptz{Time: now, Valid: true}}); err != nil {
+			errs = append(errs, fmt.Errorf("saved search %v: %w", search.SearchID, err))
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type EventsPruneCmd struct {
+	Days int `arg:"--days" default:"30" help:"keep the events of this many days"`
+}
+
+type EventsCmd struct {
+	Prune *EventsPruneCmd `arg:"subcommand:prune" help:"delete old events of the event stream (run daily), clients can't resume from pruned events"`
+}
+
+// pruneEvents deletes the events recorded more than the given number of days before now, returns the number of deleted events
+func pruneEvents(ctx context.Context, now time.Time, days int) (int64, error) {
+	if days < 1 {
+		return 0, errors.New("events must be kept for at least one day")
+	}
+	return queries.DeleteEventsBefore(ctx, pgtype.Timestamptz{Time: now.AddDate(0, 0, -days), Valid: true})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+)
+
+type FavouriteAddCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and 'session'"`
+}
+
+type FavouriteRemoveCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and 'session'"`
+}
+
+type FavouriteCmd struct {
+	Add    *FavouriteAddCmd    `arg:"subcommand:add" help:"add a session to the favourites of a user"`
+	Remove *FavouriteRemoveCmd `arg:"subcommand:remove" help:"remove a session from the favourites of a user"`
+}
+
+// parseFavourite parses the payload of the favourite subcommands (written by the server)
+func parseFavourite(payload string) (dbutils.InsertFavouriteParams, error) {
+	var p struct {
+		UserEmail string `json:"user_email"`
+		Session   int32  `json:"session"`
+	}
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return dbutils.InsertFavouriteParams{}, err
+	}
+	if p.UserEmail == "" || p.Session == 0 {
+		return dbutils.InsertFavouriteParams{}, fmt.Errorf("the keys 'user_email' and 'session' are required, got %v", payload)
+	}
+	return dbutils.InsertFavouriteParams{UserEmail: p.UserEmail, Session: p.Session}, nil
+}
+
+// setFavourite adds the session to (or removes it from) the favourites of the user. Adding an existing
+// favourite or removing a missing one is not an error.
+func setFavourite(ctx context.Context, payload string, remove bool) error {
+	params, err := parseFavourite(payload)
+	if err != nil {
+		return err
+	}
+	if remove {
+		_, err = queries.DeleteFavourite(ctx, dbutils.DeleteFavouriteParams{UserEmail: params.UserEmail, Session: params.Session})
+		return err
+	}
+	if _, err := queries.GetUserByEmail(ctx, params.UserEmail); err != nil {
+		return fmt.Errorf("there is no user with the email address %v: %w", params.UserEmail, err)
+	}
+	_, err = queries.InsertFavourite(ctx, params)
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+type FollowAddCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and either 'session' or 'venue'"`
+}
+
+type FollowRemoveCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and either 'session' or 'venue'"`
+}
+
+type FollowCmd struct {
+	Add    *FollowAddCmd    `arg:"subcommand:add" help:"follow a session or venue (the user is notified about changes)"`
+	Remove *FollowRemoveCmd `arg:"subcommand:remove" help:"unfollow a session or venue"`
+}
+
+type ChannelAddCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email', 'kind' ('email', 'webpush' or 'webhook'), 'endpoint', 'p256dh' and 'auth'"`
+}
+
+type ChannelRemoveCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'user_email' and 'channel_id'"`
+}
+
+type ChannelCmd struct {
+	Add    *ChannelAddCmd    `arg:"subcommand:add" help:"add a notification channel of a user (users without channels are notified by email)"`
+	Remove *ChannelRemoveCmd `arg:"subcommand:remove" help:"remove a notification channel of a user"`
+}
+
+// setFollow adds (or removes) the follow of the payload (written by the server). Following a session twice or
+// unfollowing a session that isn't followed is not an error.
+func setFollow(ctx context.Context, payload string, remove bool) error {
+	var p dbutils.InsertFollowParams
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return err
+	}
+	if p.UserEmail == "" || (p.Session == nil) == (p.Venue == nil) {
+		return fmt.Errorf("the key 'user_email' and either 'session' or 'venue' are required, got %v", payload)
+	}
+	if remove {
+		_, err := queries.DeleteFollow(ctx, dbutils.DeleteFollowParams{UserEmail: p.UserEmail, Session: p.Session, Venue: p.Venue})
+		return err
+	}
+	if _, err := queries.GetUserByEmail(ctx, p.UserEmail); err != nil {
+		return fmt.Errorf("there is no user with the email address %v: %w", p.UserEmail, err)
+	}
+	_, err := queries.InsertFollow(ctx, p)
+	return err
+}
+
+// addChannel validates and stores the notification channel of the payload and returns its ID. Adding an
+// existing channel updates its keys.
+func addChannel(ctx context.Context, payload string) (int32, error) {
+	var p struct {
+		UserEmail string `json:"user_email"`
+		notify.Channel
+	}
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return 0, err
+	}
+	if err := p.Channel.Validate(); err != nil {
+		return 0, err
+	}
+	id, err := queries.InsertNotificationChannel(ctx, dbutils.InsertNotificationChannelParams{
+		UserEmail:  p.UserEmail,
+		Kind:       string(p.Kind),
+		Endpoint:   optional(p.Endpoint),
+		P256dh:     optional(p.P256dh),
+		AuthSecret: optional(p.Auth),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("there is no user with the email address %v", p.UserEmail)
+	}
+	return id, err
+}
+
+func removeChannel(ctx context.Context, payload string) error {
+	var p dbutils.DeleteNotificationChannelParams
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return err
+	}
+	n, err := queries.DeleteNotificationChannel(ctx, p)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("user %v has no notification channel with ID %v", p.UserEmail, p.ChannelID)
+	}
+	return nil
+}
+
+// optional returns nil for empty strings
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/felix-schott/jamsessions/backend/internal/geocoding"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	geom "github.com/twpayne/go-geom"
+)
+
+type GeocodeVerifyCmd struct {
+	Threshold           float64 `arg:"--threshold" default:"100" help:"report venues whose stored location is further than this many metres away from the fresh geocoding result"`
+	Bounds              string  `arg:"--bounds" help:"bounding box as 'minLon,minLat,maxLon,maxLat', defaults to Greater London"`
+	WriteCorrections    bool    `arg:"--write-corrections" help:"write a migration for every reported venue that re-geocodes its address when applied"`
+	MigrationsDirectory string  `arg:"--migrations-directory,env:MIGRATIONS_DIRECTORY" help:"directory to write corrections to"`
+}
+
+type GeocodeCmd struct {
+	Verify *GeocodeVerifyCmd `arg:"subcommand:verify" help:"re-geocode all venues and report inconsistencies"`
+}
+
+// helper func - formats the address fields of a venue the same way the insert and update commands do
+func streetAddress(firstLine string, secondLine *string) string {
+	if secondLine != nil && *secondLine != "" {
+		return firstLine + " " + *secondLine
+	}
+	return firstLine
+}
+
+// helper func - logs a warning if a geocoding result looks unreliable
+func checkGeocodingResult(result *geocoding.Result) {
+	for _, w := range geocodingWarnings(result) {
+		log.Printf("WARNING: %v\n", w)
+	}
+}
+
+// helper func - returns the reasons why a geocoding result looks unreliable, if any
+func geocodingWarnings(result *geocoding.Result) []string {
+	var warnings []string
+	if !geocoding.LondonBounds.Contains(result.Point) {
+		warnings = append(warnings, fmt.Sprintf("the geocoded location (%v, %v) lies outside of the bounding box %v", result.Point.X(), result.Point.Y(), geocoding.LondonBounds))
+	}
+	if result.Confidence < 1 {
+		warnings = append(warnings, fmt.Sprintf("the address was matched to a %v (confidence %.2f), please check the location manually", result.MatchType, result.Confidence))
+	}
+	return warnings
+}
+
+// verifyGeocoding re-geocodes every venue and prints a report of the venues whose stored location
+// differs from the fresh result by more than the threshold or lies outside of the bounding box.
+// Returns the number of reported venues.
+func verifyGeocoding(cmd *GeocodeVerifyCmd) (int, error) {
+	bounds := geocoding.LondonBounds
+	if cmd.Bounds != "" {
+		b, err := geocoding.ParseBounds(cmd.Bounds)
+		if err != nil {
+			return 0, err
+		}
+		bounds = b
+	}
+	if cmd.WriteCorrections && cmd.MigrationsDirectory == "" {
+		return 0, fmt.Errorf("please provide a migrations directory (--migrations-directory or MIGRATIONS_DIRECTORY) to write corrections to")
+	}
+
+	venues, err := queries.GetAllVenueLocations(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run query: %v", err)
+	}
+	log.Printf("Verifying the locations of %v venues (threshold: %vm, bounds: %v)\n", len(venues), cmd.Threshold, bounds)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VENUE_ID\tVENUE_NAME\tDISTANCE_M\tIN_BOUNDS\tCONFIDENCE\tMATCH_TYPE\tMIGRATION")
+
+	reported := 0
+	for _, v := range venues {
+		stored := geom.NewPoint(geom.XY).MustSetCoords([]float64{v.Lon, v.Lat}).SetSRID(4326)
+		result, err := geocoding.Search(ctx, streetAddress(v.AddressFirstLine, v.AddressSecondLine), v.City, v.Postcode)
+		if err != nil {
+			if _, ok := err.(geocoding.NominatimDownError); ok || ctx.Err() != nil {
+				return reported, err
+			}
+			log.Printf("failed to geocode venue %v: %v\n", v.VenueID, err)
+			fmt.Fprintf(w, "%v\t%v\t-\t%v\t-\tno_match\t-\n", v.VenueID, v.VenueName, bounds.Contains(stored))
+			reported++
+			continue
+		}
+
+		distance := geocoding.Distance(stored, result.Point)
+		inBounds := bounds.Contains(stored)
+		if distance <= cmd.Threshold && inBounds {
+			continue
+		}
+		reported++
+
+		migration := "-"
+		if cmd.WriteCorrections && bounds.Contains(result.Point) {
+			fp, err := writeGeocodingCorrection(cmd.MigrationsDirectory, v.VenueID, v.AddressFirstLine, v.AddressSecondLine, v.City, v.Postcode, distance, result)
+			if err != nil {
+				return reported, err
+			}
+			migration = fp
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.0f\t%v\t%.2f\t%v\t%v\n", v.VenueID, v.VenueName, distance, inBounds, result.Confidence, result.MatchType, migration)
+	}
+	if err := w.Flush(); err != nil {
+		return reported, err
+	}
+	log.Printf("Reported %v of %v venues\n", reported, len(venues))
+	return reported, nil
+}
+
+// writeGeocodingCorrection writes a migration that re-submits the address of a venue - applying it
+// runs the update command which geocodes the address again and stores the fresh location.
+func writeGeocodingCorrection(migrationsDirectory string, venueId int32, firstLine string, secondLine *string, city string, postcode string, distance float64, result *geocoding.Result) (string, error) {
+	payload := map[string]any{
+		"address_first_line": firstLine,
+		"city":               city,
+		"postcode":           postcode,
+	}
+	if secondLine != nil {
+		payload["address_second_line"] = *secondLine
+	}
+	j, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	cmd := fmt.Sprintf(`dbcli update venue %v "%s"`, venueId, j)
+	cmd += fmt.Sprintf("\n# geocoding correction: stored location is %.0fm away from the fresh result (%v, %v), confidence %.2f, match type %v", distance, result.Point.X(), result.Point.Y(), result.Confidence, result.MatchType)
+	return migrationutils.WriteMigration(cmd, fmt.Sprintf("geocoding_correction_venue_%v", venueId), migrationsDirectory)
+}
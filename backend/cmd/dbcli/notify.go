@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
+)
+
+// NOTIFICATIONS - followers of sessions and venues are notified when an approved change (i.e. a migration
+// applied with dbcli) touches them
+
+// dispatcher delivers the notifications, nil disables notifications (set up in main)
+var dispatcher *notify.Dispatcher
+
+// URL of the website, used for links in notifications (environment variable PUBLIC_URL)
+var publicUrl string
+
+// mailer sends the email notifications and digests (set up in main)
+var mailer mail.Mailer
+
+// newMailer sets up the mailer from the environment variables of the API server (MAILER, MAIL_DIRECTORY, MAIL_FROM
+// and SMTP_ADDRESS/SMTP_USERNAME/SMTP_PASSWORD)
+func newMailer() (mail.Mailer, error) {
+	m, err := mail.New(os.Getenv("MAILER"), os.Getenv("MAIL_DIRECTORY"), os.Getenv("MAIL_FROM"), mail.SMTPServer{
+		Addr:     os.Getenv("SMTP_ADDRESS"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if w, ok := m.(*mail.WriterMailer); ok {
+		w.W = os.Stderr // stdout is reserved for the output of the commands
+	}
+	return m, nil
+}
+
+// newDispatcher sets up the dispatcher from the environment variables NOTIFIER ('deliver' (default), 'file' or
+// 'memory'), NOTIFICATION_DIRECTORY and VAPID_PRIVATE_KEY/VAPID_SUBJECT
+func newDispatcher(mailer mail.Mailer) (*notify.Dispatcher, error) {
+	var vapid *notify.VAPID
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		var err error
+		if vapid, err = notify.ParseVAPID(key, os.Getenv("VAPID_SUBJECT")); err != nil {
+			return nil, err
+		}
+	}
+	return notify.New(os.Getenv("NOTIFIER"), os.Getenv("NOTIFICATION_DIRECTORY"), mailer, vapid)
+}
+
+// pathElement turns a name into a URL path element like the frontend does (see sanitisePathElement)
+func pathElement(s string) string {
+	return strings.ToLower(regexp.MustCompile(`[^a-zA-Z\-]`).ReplaceAllString(strings.ReplaceAll(s, " ", "-"), ""))
+}
+
+func venueLink(name string, id int32) string {
+	return fmt.Sprintf("%v/%v-%v", strings.TrimSuffix(publicUrl, "/"), pathElement(name), id)
+}
+
+func sessionURL(venueName string, venueID int32, sessionName string, sessionID int32) string {
+	return fmt.Sprintf("%v/%v-%v", venueLink(venueName, venueID), pathElement(sessionName), sessionID)
+}
+
+func sessionLink(s dbutils.GetSessionByIdRow) string {
+	return sessionURL(s.VenueName, s.VenueID, s.SessionName, s.SessionID)
+}
+
+func formatSchedule(s dbutils.GetSessionByIdRow) string {
+	return fmt.Sprintf("%v, %v (UTC) for %v minutes", s.Interval, s.StartTimeUtc.Time.UTC().Format("Mon 2 Jan 2006 15:04"), s.DurationMinutes)
+}
+
+// sessionChanges lists the changes of a session that matter to followers (name, schedule and venue)
+func sessionChanges(before dbutils.GetSessionByIdRow, after dbutils.GetSessionByIdRow) []string {
+	var changes []string
+	if before.SessionName != after.SessionName {
+		changes = append(changes, fmt.Sprintf("The session has been renamed to %v.", after.SessionName))
+	}
+	if !before.StartTimeUtc.Time.Equal(after.StartTimeUtc.Time) || before.Interval != after.Interval || before.DurationMinutes != after.DurationMinutes {
+		changes = append(changes, fmt.Sprintf("The schedule has changed from %v to %v.", formatSchedule(before), formatSchedule(after)))
+	}
+	if before.Venue != after.Venue {
+		changes = append(changes, fmt.Sprintf("The session has moved from %v to %v.", before.VenueName, after.VenueName))
+	}
+	return changes
+}
+
+// venueChanges lists the changes of a venue that matter to followers (name and address)
+func venueChanges(before dbutils.LondonJamSessionsVenue, after dbutils.LondonJamSessionsVenue) []string {
+	var changes []string
+	if before.VenueName != after.VenueName {
+		changes = append(changes, fmt.Sprintf("The venue has been renamed to %v.", after.VenueName))
+	}
+	address := func(v dbutils.LondonJamSessionsVenue) string {
+		return strings.Join([]string{streetAddress(v.AddressFirstLine, v.AddressSecondLine), v.City, v.Postcode}, ", ")
+	}
+	if address(before) != address(after) {
+		changes = append(changes, fmt.Sprintf("The address has changed from %v to %v.", address(before), address(after)))
+	}
+	return changes
+}
+
+// sessionChange describes the update of a session, nil if nothing relevant has changed
+func sessionChange(before dbutils.GetSessionByIdRow, after dbutils.GetSessionByIdRow) *notify.Change {
+	changes := sessionChanges(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	return &notify.Change{
+		Type:      "session_updated",
+		SessionID: &after.SessionID,
+		VenueID:   &after.VenueID,
+		Title:     fmt.Sprintf("%v has changed", before.SessionName),
+		Text:      strings.Join(changes, "\n"),
+		URL:       sessionLink(after),
+		Time:      time.Now().UTC(),
+	}
+}
+
+func sessionCancellation(s dbutils.GetSessionByIdRow) notify.Change {
+	return notify.Change{
+		Type:      "session_cancelled",
+		SessionID: &s.SessionID,
+		VenueID:   &s.VenueID,
+		Title:     fmt.Sprintf("%v has been cancelled", s.SessionName),
+		Text:      fmt.Sprintf("%v at %v (%v) has been cancelled and removed from the site.", s.SessionName, s.VenueName, formatSchedule(s)),
+		URL:       venueLink(s.VenueName, s.VenueID),
+		Time:      time.Now().UTC(),
+	}
+}
+
+func venueChange(before dbutils.LondonJamSessionsVenue, after dbutils.LondonJamSessionsVenue) *notify.Change {
+	changes := venueChanges(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	return &notify.Change{
+		Type:    "venue_updated",
+		VenueID: &after.VenueID,
+		Title:   fmt.Sprintf("%v has changed", before.VenueName),
+		Text:    strings.Join(changes, "\n"),
+		URL:     venueLink(after.VenueName, after.VenueID),
+		Time:    time.Now().UTC(),
+	}
+}
+
+func venueDeletion(v dbutils.LondonJamSessionsVenue) notify.Change {
+	return notify.Change{
+		Type:    "venue_deleted",
+		VenueID: &v.VenueID,
+		Title:   fmt.Sprintf("%v has been removed", v.VenueName),
+		Text:    fmt.Sprintf("%v and all of its sessions have been removed from the site.", v.VenueName),
+		Time:    time.Now().UTC(),
+	}
+}
+
+// notifyFollowers delivers the change to the followers through their notification channels. Failures are only
+// logged, the change itself has been applied at this point. Expired push subscriptions and webhooks are removed.
+func notifyFollowers(ctx context.Context, change notify.Change, followers []dbutils.GetSessionFollowersRow) {
+	if dispatcher == nil || len(followers) == 0 {
+		return
+	}
+	ids := make([]int32, len(followers))
+	for i, f := range followers {
+		ids[i] = f.UserID
+	}
+	channels, err := queries.GetNotificationChannelsByUserIds(ctx, ids)
+	if err != nil {
+		log.Printf("WARNING: failed to notify the followers: %v\n", err)
+		return
+	}
+	byUser := make(map[int32][]notify.Channel)
+	for _, c := range channels {
+		byUser[c.UserID] = append(byUser[c.UserID], notify.Channel{ID: c.ChannelID, Kind: notify.Kind(c.Kind), Endpoint: orEmpty(c.Endpoint), P256dh: orEmpty(c.P256dh), Auth: orEmpty(c.AuthSecret)})
+	}
+	recipients := make([]notify.Recipient, len(followers))
+	for i, f := range followers {
+		recipients[i] = notify.Recipient{Email: f.Email, Channels: byUser[f.UserID]}
+	}
+
+	sent, err := dispatcher.Dispatch(ctx, change, recipients)
+	log.Printf("Sent %v notifications to %v followers\n", sent, len(followers))
+	if err == nil {
+		return
+	}
+	log.Printf("WARNING: some notifications could not be delivered: %v\n", err)
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return
+	}
+	for _, e := range joined.Unwrap() {
+		var delivery *notify.DeliveryError
+		if errors.As(e, &delivery) && errors.Is(e, notify.ErrExpired) && delivery.Channel.ID != 0 {
+			if err := queries.DeleteNotificationChannelById(ctx, delivery.Channel.ID); err != nil {
+				log.Printf("WARNING: failed to remove expired notification channel %v: %v\n", delivery.Channel.ID, err)
+			} else {
+				log.Printf("Removed expired notification channel %v of %v\n", delivery.Channel.ID, delivery.Recipient)
+			}
+		}
+	}
+}
+
+func orEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// venueFollowers returns the followers of the venue and its sessions in the same shape as GetSessionFollowers
+func venueFollowers(ctx context.Context, venue int32) ([]dbutils.GetSessionFollowersRow, error) {
+	rows, err := queries.GetVenueFollowers(ctx, venue)
+	if err != nil {
+		return nil, err
+	}
+	followers := make([]dbutils.GetSessionFollowersRow, len(rows))
+	for i, r := range rows {
+		followers[i] = dbutils.GetSessionFollowersRow(r)
+	}
+	return followers, nil
+}
+
+// notifySessionUpdate notifies the followers of the session (or its venue) if the update changed its name,
+// schedule or venue
+func notifySessionUpdate(ctx context.Context, before dbutils.GetSessionByIdRow) {
+	after, err := queries.GetSessionById(ctx, before.SessionID)
+	if err != nil {
+		log.Printf("WARNING: failed to notify the followers of session %v: %v\n", before.SessionID, err)
+		return
+	}
+	change := sessionChange(before, after)
+	if change == nil {
+		return
+	}
+	followers, err := queries.GetSessionFollowers(ctx, after.SessionID)
+	if err != nil {
+		log.Printf("WARNING: failed to notify the followers of session %v: %v\n", after.SessionID, err)
+		return
+	}
+	notifyFollowers(ctx, *change, followers)
+}
+
+// notifyVenueUpdate notifies the followers of the venue (or its sessions) if the update changed its name or address
+func notifyVenueUpdate(ctx context.Context, before dbutils.LondonJamSessionsVenue) {
+	after, err := queries.GetVenueById(ctx, before.VenueID)
+	if err != nil {
+		log.Printf("WARNING: failed to notify the followers of venue %v: %v\n", before.VenueID, err)
+		return
+	}
+	change := venueChange(before, after)
+	if change == nil {
+		return
+	}
+	followers, err := venueFollowers(ctx, after.VenueID)
+	if err != nil {
+		log.Printf("WARNING: failed to notify the followers of venue %v: %v\n", after.VenueID, err)
+		return
+	}
+	notifyFollowers(ctx, *change, followers)
+}
+
+// prepareSessionCancellation looks up the followers of the session before it is deleted (the follows are deleted
+// with it) and returns the function that notifies them once the session has been deleted
+func prepareSessionCancellation(ctx context.Context, id int32) func() {
+	session, err := queries.GetSessionById(ctx, id)
+	if err != nil {
+		return func() {}
+	}
+	followers, err := queries.GetSessionFollowers(ctx, id)
+	if err != nil {
+		log.Printf("WARNING: failed to look up the followers of session %v: %v\n", id, err)
+		return func() {}
+	}
+	return func() { notifyFollowers(ctx, sessionCancellation(session), followers) }
+}
+
+// prepareVenueDeletion is prepareSessionCancellation for venues
+func prepareVenueDeletion(ctx context.Context, id int32) func() {
+	venue, err := queries.GetVenueById(ctx, id)
+	if err != nil {
+		return func() {}
+	}
+	followers, err := venueFollowers(ctx, id)
+	if err != nil {
+		log.Printf("WARNING: failed to look up the followers of venue %v: %v\n", id, err)
+		return func() {}
+	}
+	return func() { notifyFollowers(ctx, venueDeletion(venue), followers) }
+}
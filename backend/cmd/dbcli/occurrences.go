@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OccurrencesRefreshCmd struct {
+	Start  string `arg:"--start" help:"first day of the horizon (YYYY-MM-DD), defaults to today"`
+	Months int    `arg:"--months" default:"12" help:"length of the horizon in months"`
+}
+
+type OccurrencesCmd struct {
+	Refresh *OccurrencesRefreshCmd `arg:"subcommand:refresh" help:"recompute the materialised session occurrences (run daily to move the horizon forward)"`
+}
+
+// refreshOccurrences moves the horizon of the materialised session occurrences to [start, start + months]
+// and recomputes the occurrences of all sessions, in a single transaction
+func refreshOccurrences(ctx context.Context, pool *pgxpool.Pool, start time.Time, months int) (dbutils.GetOccurrencesHorizonRow, error) {
+	var result dbutils.GetOccurrencesHorizonRow
+	if months < 1 {
+		return result, errors.New("the horizon must be at least one month long")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx) // no-op if the transaction has been committed
+	qtx := queries.WithTx(tx)
+
+	if err := qtx.SetOccurrencesHorizon(ctx, dbutils.SetOccurrencesHorizonParams{
+		StartDate: pgtype.Date{Time: start, Valid: true},
+		EndDate:   pgtype.Date{Time: start.AddDate(0, months, 0), Valid: true},
+	}); err != nil {
+		return result, err
+	}
+	if err := qtx.RefreshSessionOccurrences(ctx, nil); err != nil {
+		return result, err
+	}
+	if result, err = qtx.GetOccurrencesHorizon(ctx); err != nil {
+		return result, err
+	}
+	return result, tx.Commit(ctx)
+}
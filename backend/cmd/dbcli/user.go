@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/jackc/pgx/v5"
+)
+
+type UserRoleCmd struct {
+	User string `arg:"positional,required" help:"email address or ID of the user"`
+	Role string `arg:"positional,required" help:"new role: 'contributor', 'trusted_contributor', 'moderator' or 'admin'"`
+}
+
+type UserOwnerCmd struct {
+	User   string `arg:"positional,required" help:"email address or ID of the user"`
+	Venue  int    `arg:"positional,required" help:"ID of the venue"`
+	Remove bool   `arg:"--remove" help:"remove the user from the owners of the venue"`
+}
+
+type UserCmd struct {
+	Role  *UserRoleCmd  `arg:"subcommand:role" help:"set the role of a user (edits of trusted contributors are applied without review)"`
+	Owner *UserOwnerCmd `arg:"subcommand:owner" help:"make a user owner of a venue (their edits of the venue and its sessions are applied without review)"`
+}
+
+// userEmail resolves the user argument of the user subcommands (email address or user ID) to the email address
+func userEmail(ctx context.Context, user string) (string, error) {
+	id, err := strconv.Atoi(user)
+	if err != nil {
+		return auth.NormaliseEmail(user)
+	}
+	row, err := queries.GetUserById(ctx, int32(id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("user %v does not exist", id)
+	}
+	return row.Email, err
+}
+
+func setUserRole(ctx context.Context, user string, role string) error {
+	if _, err := auth.ParseRole(role); err != nil {
+		return err
+	}
+	email, err := userEmail(ctx, user)
+	if err != nil {
+		return err
+	}
+	n, err := queries.UpdateUserRole(ctx, dbutils.UpdateUserRoleParams{Role: role, Email: email})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("there is no user with the email address %v", email)
+	}
+	return nil
+}
+
+func setVenueOwner(ctx context.Context, user string, venue int32, remove bool) error {
+	email, err := userEmail(ctx, user)
+	if err != nil {
+		return err
+	}
+	if remove {
+		n, err := queries.DeleteVenueOwner(ctx, dbutils.DeleteVenueOwnerParams{Email: email, Venue: venue})
+		if err == nil && n == 0 {
+			err = fmt.Errorf("%v is not an owner of venue %v", email, venue)
+		}
+		return err
+	}
+	if _, err := queries.GetUserByEmail(ctx, email); errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("there is no user with the email address %v", email)
+	} else if err != nil {
+		return err
+	}
+	_, err = queries.InsertVenueOwner(ctx, dbutils.InsertVenueOwnerParams{Email: email, Venue: venue})
+	return err
+}
+
+// attribution is part of the insert/update payloads of sessions and venues submitted by logged in users
+type attribution struct {
+	UserEmail *string `json:"user_email"`
+}
+
+// recordContribution attributes the session or venue to the user of the payload (if any). Failures are only logged,
+// the record itself has been written at this point.
+func recordContribution(ctx context.Context, payload string, session *int32, venue *int32, action string) {
+	var a attribution
+	if err := json.Unmarshal([]byte(payload), &a); err != nil || a.UserEmail == nil {
+		return
+	}
+	n, err := queries.InsertUserContribution(ctx, dbutils.InsertUserContributionParams{Session: session, Venue: venue, Action: action, UserEmail: *a.UserEmail})
+	if err != nil {
+		log.Printf("WARNING: failed to attribute the %v to %v: %v\n", action, *a.UserEmail, err)
+	} else if n == 0 {
+		log.Printf("WARNING: there is no user with the email address %v\n", *a.UserEmail)
+	}
+}
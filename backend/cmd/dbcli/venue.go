@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type VenueMergeCmd struct {
+	Keep int `arg:"positional,required" help:"ID of the venue to keep"`
+	Drop int `arg:"positional,required" help:"ID of the duplicate venue that is deleted"`
+}
+
+type VenueCmd struct {
+	Merge *VenueMergeCmd `arg:"subcommand:merge" help:"merge a duplicate venue into another venue"`
+}
+
+type mergeResult struct {
+	MovedSessions  int64
+	MergedSessions int64
+	Comments       int64
+	Ratings        int64
+}
+
+// mergeVenues moves all sessions of the venue 'drop' to the venue 'keep' and deletes 'drop', all in one transaction.
+// Sessions that exist at both venues (same start time and interval) are merged - their comments and
+// ratings are re-pointed to the session of the kept venue before the duplicate session is deleted.
+func mergeVenues(ctx context.Context, pool *pgxpool.Pool, keep int32, drop int32) (mergeResult, error) {
+	var result mergeResult
+	if keep == drop {
+		return result, errors.New("can't merge a venue into itself")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx) // no-op if the transaction has been committed
+	qtx := queries.WithTx(tx)
+
+	for _, id := range []int32{keep, drop} {
+		if _, err := qtx.GetVenueById(ctx, id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return result, fmt.Errorf("venue %v does not exist", id)
+			}
+			return result, err
+		}
+	}
+
+	if result.Comments, err = qtx.RepointCommentsOfDuplicateSessions(ctx, dbutils.RepointCommentsOfDuplicateSessionsParams{KeepVenue: keep, DropVenue: drop}); err != nil {
+		return result, fmt.Errorf("failed to re-point comments: %v", err)
+	}
+	if result.Ratings, err = qtx.RepointRatingsOfDuplicateSessions(ctx, dbutils.RepointRatingsOfDuplicateSessionsParams{KeepVenue: keep, DropVenue: drop}); err != nil {
+		return result, fmt.Errorf("failed to re-point ratings: %v", err)
+	}
+	if result.MergedSessions, err = qtx.DeleteDuplicateSessions(ctx, dbutils.DeleteDuplicateSessionsParams{KeepVenue: keep, DropVenue: drop}); err != nil {
+		return result, fmt.Errorf("failed to delete duplicate sessions: %v", err)
+	}
+	if result.MovedSessions, err = qtx.MoveSessionsToVenue(ctx, dbutils.MoveSessionsToVenueParams{KeepVenue: keep, DropVenue: drop}); err != nil {
+		return result, fmt.Errorf("failed to move sessions: %v", err)
+	}
+	if _, err := qtx.CopyVenueFollows(ctx, dbutils.CopyVenueFollowsParams{KeepVenue: keep, DropVenue: drop}); err != nil {
+		return result, fmt.Errorf("failed to move followers: %v", err)
+	}
+	if err := qtx.DeleteVenueById(ctx, drop); err != nil {
+		return result, fmt.Errorf("failed to delete venue %v: %v", drop, err)
+	}
+	return result, tx.Commit(ctx)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
+	"github.com/felix-schott/jamsessions/backend/internal/webhooks"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type WebhookAddCmd struct {
+	Payload string `arg:"positional,required" help:"JSON object with the keys 'url', 'secret', 'event_types' and optionally 'description'"`
+}
+
+type WebhookRemoveCmd struct {
+	Id int `arg:"positional,required" help:"ID of the subscription"`
+}
+
+type WebhookDeliverCmd struct {
+	Batch int `arg:"--batch" default:"50" help:"number of deliveries claimed at a time"`
+}
+
+type WebhookCmd struct {
+	Add     *WebhookAddCmd     `arg:"subcommand:add" help:"add a webhook subscription"`
+	Remove  *WebhookRemoveCmd  `arg:"subcommand:remove" help:"remove a webhook subscription and its delivery log"`
+	Deliver *WebhookDeliverCmd `arg:"subcommand:deliver" help:"send the pending webhook deliveries (run every minute by cron)"`
+}
+
+// webhookClient posts the deliveries
+var webhookClient = &webhooks.Client{}
+
+// deliveries that haven't been completed within the lease (e.g. because the deliverer crashed) are picked up again
+const webhookLease = 5 * time.Minute
+
+// addWebhook stores the subscription of the payload (written by the server) and returns its ID
+func addWebhook(ctx context.Context, payload string) (int32, error) {
+	var p dbutils.InsertWebhookSubscriptionParams
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return 0, err
+	}
+	if err := (notify.Channel{Kind: notify.Webhook, Endpoint: p.Url}).Validate(); err != nil {
+		return 0, fmt.Errorf("invalid url: %w", err)
+	}
+	if strings.TrimSpace(p.Secret) == "" {
+		return 0, fmt.Errorf("the key 'secret' is required, got %v", payload)
+	}
+	if err := webhooks.ValidateEventTypes(p.EventTypes); err != nil {
+		return 0, err
+	}
+	return queries.InsertWebhookSubscription(ctx, p)
+}
+
+func removeWebhook(ctx context.Context, id int32) error {
+	n, err := queries.DeleteWebhookSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("webhook subscription %v does not exist", id)
+	}
+	return nil
+}
+
+// emitEvent queues the event for the subscriptions to its type. Failures are only logged, the change itself has
+// been applied at this point.
+func emitEvent(ctx context.Context, eventType string, data any) {
+	event, err := webhooks.NewEvent(eventType, data, time.Now())
+	if err == nil {
+		var payload []byte
+		if payload, err = json.Marshal(event); err == nil {
+			var n int64
+			if n, err = queries.EnqueueWebhookDeliveries(ctx, dbutils.EnqueueWebhookDeliveriesParams{EventID: event.ID, EventType: event.Type, Payload: payload}); err == nil {
+				if n > 0 {
+					log.Printf("Queued %v event %v for %v webhook subscriptions\n", event.Type, event.ID, n)
+				}
+				return
+			}
+		}
+	}
+	log.Printf("WARNING: failed to queue the %v webhook event: %v\n", eventType, err)
+}
+
+func sessionEventData(s dbutils.GetSessionByIdRow) webhooks.SessionData {
+	return webhooks.SessionData{SessionID: s.SessionID, SessionName: s.SessionName, VenueID: s.VenueID, VenueName: s.VenueName, URL: sessionLink(s)}
+}
+
+// emitSessionEvent emits the event with the current data of the session
+func emitSessionEvent(ctx context.Context, eventType string, id int32) {
+	s, err := queries.GetSessionById(ctx, id)
+	if err != nil {
+		log.Printf("WARNING: failed to queue the %v webhook event of session %v: %v\n", eventType, id, err)
+		return
+	}
+	emitEvent(ctx, eventType, sessionEventData(s))
+}
+
+// emitVenueEvent emits the event with the current data of the venue
+func emitVenueEvent(ctx context.Context, eventType string, id int32) {
+	v, err := queries.GetVenueById(ctx, id)
+	if err != nil {
+		log.Printf("WARNING: failed to queue the %v webhook event of venue %v: %v\n", eventType, id, err)
+		return
+	}
+	emitEvent(ctx, eventType, webhooks.VenueData{VenueID: v.VenueID, VenueName: v.VenueName})
+}
+
+// prepareSessionDeletedEvent looks up the session before it is deleted and returns the function that emits
+// the event once it has been deleted
+func prepareSessionDeletedEvent(ctx context.Context, id int32) func() {
+	s, err := queries.GetSessionById(ctx, id)
+	if err != nil {
+		return func() {}
+	}
+	data := sessionEventData(s)
+	data.URL = ""
+	return func() { emitEvent(ctx, webhooks.SessionDeleted, data) }
+}
+
+// prepareVenueDeletedEvent is prepareSessionDeletedEvent for venues, mergedInto is the venue that replaces it (if any)
+func prepareVenueDeletedEvent(ctx context.Context, id int32, mergedInto *int32) func() {
+	v, err := queries.GetVenueById(ctx, id)
+	if err != nil {
+		return func() {}
+	}
+	return func() {
+		emitEvent(ctx, webhooks.VenueDeleted, webhooks.VenueData{VenueID: v.VenueID, VenueName: v.VenueName, MergedInto: mergedInto})
+	}
+}
+
+// deliverWebhooks sends the due deliveries until there are none left and returns the number of successful and
+// failed attempts. Failed attempts are retried with an exponential backoff until webhooks.MaxAttempts is reached.
+func deliverWebhooks(ctx context.Context, batch int) (int, int, error) {
+	if batch <= 0 {
+		return 0, 0, fmt.Errorf("the batch size must be positive, got %v", batch)
+	}
+	delivered, failed := 0, 0
+	for {
+		now := time.Now().UTC()
+		claimed, err := queries.ClaimWebhookDeliveries(ctx, dbutils.ClaimWebhookDeliveriesParams{
+			LeaseUntil: pgtype.Timestamptz{Time: now.Add(webhookLease), Valid: true},
+			Now:        pgtype.Timestamptz{Time: now, Valid: true},
+			BatchSize:  int32(batch),
+		})
+		if err != nil || len(claimed) == 0 {
+			return delivered, failed, err
+		}
+		for _, d := range claimed {
+			code, err := webhookClient.Deliver(ctx, webhooks.Delivery{ID: d.DeliveryID, URL: d.Url, Secret: d.Secret, EventID: d.EventID, EventType: d.EventType, Payload: d.Payload})
+			finished := time.Now().UTC()
+			status, next := webhooks.Next(int(d.Attempts), err, finished)
+			result := dbutils.SetWebhookDeliveryResultParams{
+				Status:        status,
+				NextAttemptAt: pgtype.Timestamptz{Time: next, Valid: true},
+				DeliveryID:    d.DeliveryID,
+			}
+			if code != 0 {
+				c := int16(code)
+				result.ResponseStatus = &c
+			}
+			if err != nil {
+				msg := err.Error()
+				result.LastError = &msg
+				failed++
+				log.Printf("WARNING: delivery %v (attempt %v) to %v failed: %v\n", d.DeliveryID, d.Attempts, d.Url, err)
+			} else {
+				result.DtDelivered = pgtype.Timestamptz{Time: finished, Valid: true}
+				delivered++
+			}
+			if err := queries.SetWebhookDeliveryResult(ctx, result); err != nil {
+				return delivered, failed, err
+			}
+		}
+	}
+}
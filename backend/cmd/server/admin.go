@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+const defaultDuplicateNameSimilarity = 0.6
+const defaultDuplicateDistanceMetres = 25
+
+func GetDuplicateVenues(c *fuego.ContextNoBody) ([]dbutils.GetDuplicateVenuesRow, error) {
+	params := dbutils.GetDuplicateVenuesParams{
+		MinNameSimilarity: defaultDuplicateNameSimilarity,
+		MaxDistanceMetres: defaultDuplicateDistanceMetres,
+	}
+	if v := c.QueryParam("name_similarity"); v != "" {
+		similarity, err := strconv.ParseFloat(v, 32)
+		if err != nil || similarity < 0 || similarity > 1 {
+			return []dbutils.GetDuplicateVenuesRow{}, invalidParam("name_similarity", fmt.Sprintf("'name_similarity' must be a number between 0 and 1, got: %v", v))
+		}
+		params.MinNameSimilarity = float32(similarity)
+	}
+	if v := c.QueryParam("distance"); v != "" {
+		distance, err := strconv.ParseFloat(v, 64)
+		if err != nil || distance < 0 {
+			return []dbutils.GetDuplicateVenuesRow{}, invalidParam("distance", fmt.Sprintf("'distance' must be a positive number of metres, got: %v", v))
+		}
+		params.MaxDistanceMetres = distance
+	}
+	slog.Info("GetDuplicateVenues", "params", params)
+	res, err := queries.GetDuplicateVenues(ctx, params)
+	if err != nil {
+		slog.Error("GetDuplicateVenues", "err", err)
+		return []dbutils.GetDuplicateVenuesRow{}, problem(err)
+	}
+	if res == nil {
+		res = []dbutils.GetDuplicateVenuesRow{}
+	}
+	return res, nil
+}
+
+type UserRoleBody struct {
+	// one of 'contributor', 'trusted_contributor', 'moderator' or 'admin'
+	Role string `json:"role"`
+}
+
+func PutUserRoleById(c *fuego.ContextWithBody[UserRoleBody]) (any, error) {
+	slog.Info("PutUserRoleById", "id", c.PathParam("id"))
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/admin/users/{id}/role'), got: %v", c.PathParam("id")))
+	}
+	payload, err := c.Body()
+	if err != nil {
+		return nil, problem(err)
+	}
+	role, err := auth.ParseRole(payload.Role)
+	if err != nil {
+		return nil, invalidField("role", fmt.Sprintf("'role' must be one of %v, got: %q", auth.AssignableRoles, payload.Role), err)
+	}
+	if _, err := queries.GetUserById(ctx, int32(id)); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFound("user", id, err)
+	} else if err != nil {
+		return nil, problem(err)
+	}
+	cmd := fmt.Sprintf(`dbcli user role %v %v`, id, role)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("user_role_%v", id), migrationDirectory(requestRole(c.Request()))); err != nil {
+		slog.Error("PutUserRoleById", "id", id, "msg", err)
+		return nil, problem(err)
+	}
+	return nil, nil
+}
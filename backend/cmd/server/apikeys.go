@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/apikeys"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RATE LIMITS - requests with an API key are limited per key, all other requests per client IP (anonymousRateLimit)
+
+const defaultAnonymousRateLimit = 120 // requests per minute
+
+// requests per minute and client IP without an API key, 0 disables the limit (environment variable ANONYMOUS_RATE_LIMIT)
+var anonymousRateLimit = defaultAnonymousRateLimit
+var rateLimiter = apikeys.NewLimiter(time.Minute)
+var apiKeyUsage = apikeys.NewUsage()
+
+// lookupApiKey returns the (not revoked) key with the hash (set to queries.GetApiKeyByHash in main)
+var lookupApiKey func(ctx context.Context, hash string) (dbutils.LondonJamSessionsApiKey, error)
+
+// keys are cached for a minute, so revoking a key takes up to a minute to take effect
+const apiKeyCacheTTL = time.Minute
+
+type cachedApiKey struct {
+	key     *dbutils.LondonJamSessionsApiKey // nil if there is no valid key with the hash
+	expires time.Time
+}
+
+var apiKeyCache = struct {
+	sync.Mutex
+	keys map[string]cachedApiKey
+}{keys: make(map[string]cachedApiKey)}
+
+var errInvalidApiKey = errors.New("invalid or revoked API key")
+
+// apiKey returns the key with the hash, using the cache if possible
+func apiKey(ctx context.Context, hash string) (*dbutils.LondonJamSessionsApiKey, error) {
+	now := time.Now()
+	apiKeyCache.Lock()
+	cached, ok := apiKeyCache.keys[hash]
+	apiKeyCache.Unlock()
+	if ok && now.Before(cached.expires) {
+		if cached.key == nil {
+			return nil, errInvalidApiKey
+		}
+		return cached.key, nil
+	}
+	if lookupApiKey == nil {
+		return nil, errInvalidApiKey
+	}
+	key, err := lookupApiKey(ctx, hash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	cached = cachedApiKey{expires: now.Add(apiKeyCacheTTL)}
+	if err == nil {
+		cached.key = &key
+	}
+	apiKeyCache.Lock()
+	for h, c := range apiKeyCache.keys {
+		if now.After(c.expires) {
+			delete(apiKeyCache.keys, h)
+		}
+	}
+	apiKeyCache.keys[hash] = cached
+	apiKeyCache.Unlock()
+	if cached.key == nil {
+		return nil, errInvalidApiKey
+	}
+	return cached.key, nil
+}
+
+// RateLimitMiddleware authenticates API keys ('Authorization: Bearer jsk_...') and limits the number of requests
+// per key (rate_limit of the key) or client IP (anonymousRateLimit). Requests with the admin token aren't limited.
+// The current limits are reported in the X-RateLimit-* headers, requests over the limit are rejected with 429.
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, limit := "ip:"+clientIP(r), anonymousRateLimit
+		var keyID *int32
+		if token := fuego.TokenFromHeader(r); apikeys.IsKey(token) {
+			key, err := apiKey(r.Context(), apikeys.Hash(token))
+			if errors.Is(err, errInvalidApiKey) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				sendProblem(w, http.StatusUnauthorized, "The API key is invalid or has been revoked")
+				return
+			} else if err != nil {
+				slog.Error("RateLimitMiddleware", "msg", "failed to look up the API key", "err", err)
+				sendProblem(w, http.StatusInternalServerError, "An unknown error occurred")
+				return
+			}
+			client, limit, keyID = "key:"+strconv.Itoa(int(key.KeyID)), int(key.RateLimit), &key.KeyID
+		} else if limit <= 0 || hasAdminToken(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, remaining, reset := rateLimiter.Allow(client, limit)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			sendProblem(w, http.StatusTooManyRequests, "Rate limit exceeded, please try again later (request an API key for a higher limit)")
+			return
+		}
+		if keyID != nil {
+			apiKeyUsage.Add(*keyID, 1, time.Now())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// flushApiKeyUsage writes the request counts of the API keys to the database every interval, until the context is cancelled
+func flushApiKeyUsage(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		usage := apiKeyUsage.Take()
+		if len(usage) == 0 {
+			continue
+		}
+		if err := recordApiKeyUsage(ctx, pool, usage); err != nil {
+			slog.Error("flushApiKeyUsage", "err", err)
+			for key, count := range usage {
+				apiKeyUsage.Add(key, count.Requests, count.LastUsed) // try again next time
+			}
+		}
+	}
+}
+
+// recordApiKeyUsage adds the request counts to the usage table in a single transaction. The server usually
+// connects as read_only, so the transaction switches to the api_usage_writer role, which may write to this
+// table and nothing else (see internal/db/scripts/add-api-usage-writer.sh).
+func recordApiKeyUsage(ctx context.Context, pool *pgxpool.Pool, usage map[int32]apikeys.Count) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "SET LOCAL ROLE api_usage_writer"); err != nil {
+		return err
+	}
+	qtx := queries.WithTx(tx)
+	for key, count := range usage {
+		if err := qtx.RecordApiKeyUsage(ctx, dbutils.RecordApiKeyUsageParams{KeyID: key, RequestCount: count.Requests, LastUsed: pgtype.Timestamptz{Time: count.LastUsed, Valid: true}}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
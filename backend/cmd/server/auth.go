@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/felix-schott/jamsessions/backend/internal/moderation"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+const sessionCookieName = "session"
+
+// at most 3 login links per email address and hour
+var loginLimit = moderation.NewVelocityCheck(3, time.Hour)
+
+type userContextKey struct{}
+type principalContextKey struct{}
+
+// AuthMiddleware adds the user of a valid session cookie or bearer token to the request context (see currentUser
+// and currentPrincipal). Requests without (valid) credentials are passed on as anonymous requests.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := verifyCredentials(r)
+		if claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, claims))
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, resolvePrincipal(r, claims)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyCredentials returns the claims of the session token (bearer token or cookie) or nil
+func verifyCredentials(r *http.Request) *auth.Claims {
+	if authIssuer == nil {
+		return nil
+	}
+	token := fuego.TokenFromHeader(r)
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && token == "" {
+		token = cookie.Value
+	}
+	if token == "" {
+		return nil
+	}
+	claims, err := authIssuer.VerifySessionToken(token)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// currentUser returns the logged in user or nil for anonymous requests
+func currentUser(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(userContextKey{}).(*auth.Claims)
+	return claims
+}
+
+// currentPrincipal returns the role and owned venues of the user making the request
+func currentPrincipal(r *http.Request) auth.Principal {
+	if principal, ok := r.Context().Value(principalContextKey{}).(auth.Principal); ok {
+		return principal
+	}
+	return resolvePrincipal(r, verifyCredentials(r))
+}
+
+// userPermissions looks up the role and owned venues of an account (set to queries.GetUserPermissions in main)
+var userPermissions func(ctx context.Context, email string) (dbutils.GetUserPermissionsRow, error)
+
+// resolvePrincipal determines the role of the user - requests with the admin token are made by an admin, logged in users
+// are contributors until their account has been registered (or if the role can't be looked up)
+func resolvePrincipal(r *http.Request, claims *auth.Claims) auth.Principal {
+	if hasAdminToken(r) {
+		return auth.Principal{Role: auth.RoleAdmin}
+	}
+	if claims == nil {
+		return auth.Anonymous
+	}
+	principal := auth.Principal{Email: claims.Email(), Role: auth.RoleContributor}
+	if userPermissions == nil {
+		return principal
+	}
+	row, err := userPermissions(r.Context(), principal.Email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("resolvePrincipal", "msg", "failed to look up the role of the user", "email", principal.Email, "err", err)
+		}
+		return principal
+	}
+	principal.Role = auth.Role(row.Role)
+	principal.OwnedVenues = row.OwnedVenues
+	return principal
+}
+
+// userEmail returns the email address of the logged in user (used to attribute submissions) or nil
+func userEmail(r *http.Request) *string {
+	if user := currentUser(r); user != nil {
+		return ptr(user.Email())
+	}
+	return nil
+}
+
+// payloads of the session/venue migrations, dbcli attributes the record to the user with the email address
+type attributedSession struct {
+	types.SessionProperties
+	UserEmail *string `json:"user_email,omitempty"`
+}
+
+type attributedVenue struct {
+	types.VenueProperties
+	UserEmail *string `json:"user_email,omitempty"`
+}
+
+type LoginBody struct {
+	Email string `json:"email"`
+}
+
+func PostLogin(c *fuego.ContextWithBody[LoginBody]) (any, error) {
+	payload, err := c.Body()
+	if err != nil {
+		return nil, problem(err)
+	}
+	email, err := auth.NormaliseEmail(payload.Email)
+	if err != nil {
+		return nil, invalidField("email", "Please provide a valid email address", err)
+	}
+	if authIssuer == nil || mailer == nil {
+		return nil, fuego.HTTPError{Err: errors.New("accounts are disabled"), Status: http.StatusServiceUnavailable, Title: "Service Unavailable", Detail: "Logging in is currently not possible"}
+	}
+	// the response doesn't reveal whether the address is rate limited or has an account
+	c.SetStatus(http.StatusAccepted)
+	if score, _, _ := loginLimit.Check(ctx, moderation.Submission{Source: email, Time: time.Now()}); score > 0 {
+		slog.Warn("PostLogin", "msg", "too many login links requested", "email", email)
+		return nil, nil
+	}
+	token, err := authIssuer.LoginToken(email)
+	if err != nil {
+		return nil, problem(err)
+	}
+	link := fmt.Sprintf("%v/v1/auth/verify?token=%v", strings.TrimSuffix(publicUrl, "/"), url.QueryEscape(token))
+	if err := mailer.Send(c.Request().Context(), mail.Message{
+		To:      email,
+		Subject: "Your login link for Jam Sessions",
+		Text:    fmt.Sprintf("Please follow the link below to log in, it is valid for %v minutes and can only be used once.\n\n%v\n\nIf you didn't request this email, you can ignore it.", auth.LoginTokenTTL.Minutes(), link),
+	}); err != nil {
+		slog.Error("PostLogin", "msg", "failed to send login link", "err", err)
+		return nil, problem(err)
+	}
+	return nil, nil
+}
+
+type LoginResponse struct {
+	// session token, can be used as bearer token instead of the session cookie
+	Token string     `json:"token"`
+	User  types.User `json:"user"`
+}
+
+// lookupUser returns the account of the user - the user ID is only set once the registration has been applied
+func lookupUser(email string) (types.User, error) {
+	user := types.User{Email: email, Role: string(auth.RoleContributor)}
+	row, err := queries.GetUserByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, nil
+	} else if err != nil {
+		return user, err
+	}
+	user.UserID = &row.UserID
+	user.Role = row.Role
+	if row.DtCreated.Valid {
+		user.DtCreated = &row.DtCreated.Time
+	}
+	return user, nil
+}
+
+// registerUser writes the migration that registers the user, unless an earlier login has written it already
+func registerUser(email string) error {
+	if pending, err := migrationutils.HasPendingUser(migrationsDirectory, email); err != nil || pending {
+		return err
+	}
+	j, err := json.Marshal(LoginBody{Email: email})
+	if err != nil {
+		return err
+	}
+	if _, err := migrationutils.WriteMigration(fmt.Sprintf(`dbcli insert user "%s"`, j), "insert_user", migrationsDirectory); err != nil {
+		return err
+	}
+	alertSubmission(alerts.Alert{Type: "new user", Name: email}, migrationsDirectory)
+	return nil
+}
+
+func GetVerifyLogin(c *fuego.ContextNoBody) (LoginResponse, error) {
+	if authIssuer == nil {
+		return LoginResponse{}, fuego.HTTPError{Err: errors.New("accounts are disabled"), Status: http.StatusServiceUnavailable, Title: "Service Unavailable", Detail: "Logging in is currently not possible"}
+	}
+	claims, err := authIssuer.RedeemLoginToken(c.QueryParam("token"))
+	if err != nil {
+		return LoginResponse{}, fuego.UnauthorizedError{Err: err, Title: "Unauthorized", Detail: "The login link is invalid, has expired or has already been used. Please request a new one."}
+	}
+	user, err := lookupUser(claims.Email())
+	if err != nil {
+		slog.Error("GetVerifyLogin", "err", err)
+		return LoginResponse{}, problem(err)
+	}
+	if user.UserID == nil { // first login
+		if err := registerUser(user.Email); err != nil {
+			slog.Error("GetVerifyLogin", "msg", err)
+			return LoginResponse{}, problem(err)
+		}
+	}
+	token, err := authIssuer.SessionToken(user.Email)
+	if err != nil {
+		return LoginResponse{}, problem(err)
+	}
+	http.SetCookie(c.Response(), &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(auth.SessionTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(publicUrl, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return LoginResponse{Token: token, User: user}, nil
+}
+
+func PostLogout(c *fuego.ContextNoBody) (any, error) {
+	http.SetCookie(c.Response(), &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	return nil, nil
+}
+
+func GetMe(c *fuego.ContextNoBody) (types.User, error) {
+	claims, err := loggedInUser(c.Request())
+	if err != nil {
+		return types.User{}, err
+	}
+	user, err := lookupUser(claims.Email())
+	if err != nil {
+		slog.Error("GetMe", "err", err)
+		return types.User{}, problem(err)
+	}
+	user.OwnedVenues = currentPrincipal(c.Request()).OwnedVenues
+	return user, nil
+}
+
+// loggedInUser returns the logged in user or a 401 problem
+func loggedInUser(r *http.Request) (*auth.Claims, error) {
+	claims := currentUser(r)
+	if claims == nil {
+		return nil, fuego.UnauthorizedError{Err: errors.New("not logged in"), Title: "Unauthorized", Detail: "Please log in"}
+	}
+	return claims, nil
+}
+
+// registeredUser returns the account of the logged in user, or a 409 problem if the registration of the account
+// (a reviewed migration) hasn't been applied yet
+func registeredUser(r *http.Request) (types.User, error) {
+	claims, err := loggedInUser(r)
+	if err != nil {
+		return types.User{}, err
+	}
+	user, err := lookupUser(claims.Email())
+	if err != nil {
+		slog.Error("registeredUser", "err", err)
+		return types.User{}, problem(err)
+	}
+	if user.UserID == nil {
+		return types.User{}, fuego.ConflictError{Err: errors.New("account not registered yet"), Title: "Conflict", Detail: "Your account is still being set up, please try again later"}
+	}
+	return user, nil
+}
+
+// writePersonalMigration writes the migration 'dbcli <subCmd> "<payload as JSON>"'. Changes that only concern the
+// logged in user (favourites, follows, notification channels) are applied without review.
+func writePersonalMigration(subCmd string, payload any, title string) error {
+	dir := autoApplyDirectory
+	if dir == "" {
+		dir = migrationsDirectory
+	}
+	return writeJSONMigration(subCmd, payload, title, dir)
+}
+
+// writeJSONMigration writes the migration 'dbcli <subCmd> "<payload as JSON>"' to dir
+func writeJSONMigration(subCmd string, payload any, title string, dir string) error {
+	j, err := json.Marshal(payload)
+	if err != nil {
+		return problem(err)
+	}
+	if _, err := migrationutils.WriteMigration(fmt.Sprintf(`dbcli %v "%s"`, subCmd, j), title, dir); err != nil {
+		slog.Error("writeJSONMigration", "cmd", subCmd, "msg", err)
+		return problem(err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+)
+
+// HTTP CACHING
+// the data only changes when migrations are applied, so all read endpoints share a single validator
+// derived from the latest modification time and the row counts of the exposed tables (see GetDataVersion).
+
+const cacheControl = "public, max-age=60, must-revalidate"
+
+type dataVersion struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// newDataVersion derives the validators from the data version at the given time. The current date is part
+// of the ETag and the Last-Modified time is never earlier than midnight, because some responses depend on
+// it (e.g. sort=next_occurrence).
+func newDataVersion(v dbutils.GetDataVersionRow, now time.Time) dataVersion {
+	today := now.UTC().Truncate(24 * time.Hour)
+	lastModified := today
+	if v.LastModified.Valid && v.LastModified.Time.After(today) {
+		lastModified = v.LastModified.Time.UTC()
+	}
+	h := sha256.Sum256([]byte(fmt.Sprintf("%v|%v|%v|%v|%v|%v", v.LastModified.Time.UnixNano(), v.SessionCount, v.VenueCount, v.CommentCount, v.RatingCount, today.Format(time.DateOnly))))
+	return dataVersion{
+		ETag:         `"` + hex.EncodeToString(h[:12]) + `"`,
+		LastModified: lastModified.Truncate(time.Second), // http dates have a resolution of seconds
+	}
+}
+
+// notModified reports whether the conditional headers of the request match the validators.
+// If-Modified-Since is only evaluated in the absence of If-None-Match (RFC 9110, section 13.1.3).
+func (v dataVersion) notModified(r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/") // weak comparison
+			if tag == "*" || tag == v.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !v.LastModified.After(t)
+	}
+	return false
+}
+
+// RESPONSE CACHE
+// successful responses of the read endpoints are kept in memory, keyed by path and normalised query
+// parameters. The cache is only active while the server LISTENs for the notifications sent by the
+// notify_data_changed triggers (see dbutils.Listener) - any notification clears it.
+
+const maxCachedResponses = 1000
+
+type cachedResponse struct {
+	day    string // responses may depend on the current date (e.g. sort=next_occurrence)
+	status int
+	header http.Header
+	body   []byte
+}
+
+type responseCache struct {
+	mu         sync.RWMutex
+	enabled    bool
+	generation uint64 // incremented on every invalidation, responses computed from older data are discarded
+	version    *dbutils.GetDataVersionRow
+	entries    map[string]cachedResponse
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+// setEnabled is called when the listening connection is established or lost, the cache is cleared in both cases
+// because notifications may have been missed
+func (rc *responseCache) setEnabled(enabled bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.enabled = enabled
+	rc.clear()
+}
+
+// invalidate clears the cache, called for every notification
+func (rc *responseCache) invalidate() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.clear()
+}
+
+// helper func - the caller must hold the write lock
+func (rc *responseCache) clear() {
+	rc.generation++
+	rc.version = nil
+	clear(rc.entries)
+}
+
+// dataVersion returns the cached data version or queries it (caching the result if the cache is enabled)
+func (rc *responseCache) dataVersion(ctx context.Context) (dbutils.GetDataVersionRow, error) {
+	rc.mu.RLock()
+	version, generation := rc.version, rc.generation
+	rc.mu.RUnlock()
+	if version != nil {
+		return *version, nil
+	}
+	row, err := queries.GetDataVersion(ctx)
+	if err != nil {
+		return row, err
+	}
+	rc.mu.Lock()
+	if rc.enabled && rc.generation == generation {
+		rc.version = &row
+	}
+	rc.mu.Unlock()
+	return row, nil
+}
+
+// get returns the cached response for the key and the current generation (to be passed to put)
+func (rc *responseCache) get(key string, day string) (cachedResponse, bool, uint64) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	res, ok := rc.entries[key]
+	return res, rc.enabled && ok && res.day == day, rc.generation
+}
+
+// put stores a response unless the data changed since it was computed
+func (rc *responseCache) put(key string, generation uint64, res cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !rc.enabled || rc.generation != generation {
+		return
+	}
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= maxCachedResponses {
+		return
+	}
+	rc.entries[key] = res
+}
+
+// helper func - url.Values.Encode sorts the query parameters by key
+func cacheKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// response writer that keeps a copy of the response
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// CacheMiddleware sets the ETag, Last-Modified and Cache-Control headers of GET requests, answers conditional
+// requests with 304 Not Modified if the data hasn't changed and serves responses from the response cache
+func CacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		row, err := cache.dataVersion(r.Context())
+		if err != nil { // serve the request without validators
+			slog.Error("CacheMiddleware", "msg", "could not determine data version", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		v := newDataVersion(row, now)
+		w.Header().Set("ETag", v.ETag)
+		w.Header().Set("Last-Modified", v.LastModified.Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", cacheControl)
+		if v.notModified(r) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		key, day := cacheKey(r), now.UTC().Format(time.DateOnly)
+		cached, ok, generation := cache.get(key, day)
+		if ok {
+			for k, values := range cached.header {
+				w.Header()[k] = values
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+		rec := &recordingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusOK {
+			header := make(http.Header)
+			for _, k := range []string{"Content-Type", "Link"} {
+				if values := w.Header().Values(k); len(values) > 0 {
+					header[k] = values
+				}
+			}
+			cache.put(key, generation, cachedResponse{day: day, status: rec.status, header: header, body: rec.body.Bytes()})
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+// editTokenHeader carries the edit token returned when a comment is posted
+const editTokenHeader = "X-Edit-Token"
+
+// newEditToken returns a random token and its hash - only the hash is stored, the token is handed out once
+func newEditToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashEditToken(token), nil
+}
+
+func hashEditToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+type PostCommentResponse struct {
+	// secret token that lets the author edit or delete the comment (PATCH/DELETE /v1/comments with the token in
+	// the X-Edit-Token header), it is only returned once. The comment becomes available once the submission has
+	// been reviewed.
+	EditToken string `json:"edit_token"`
+	// 'visible' or 'pending' (held for moderation)
+	Status string `json:"status"`
+}
+
+var errInvalidEditToken = fuego.ForbiddenError{Err: errors.New("invalid edit token"), Title: "Forbidden", Detail: fmt.Sprintf("Please provide the edit token of the comment in the %v header", editTokenHeader)}
+
+// authorisedComment returns the comment if the request carries its edit token (or is made by a moderator).
+// Hidden comments can't be edited anymore.
+func authorisedComment(r *http.Request, id int) (dbutils.LondonJamSessionsComment, error) {
+	comment, err := queries.GetCommentById(ctx, int32(id))
+	if err == nil && comment.Status == "hidden" {
+		err = pgx.ErrNoRows
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return comment, notFound("comment", id, err)
+	} else if err != nil {
+		return comment, problem(err)
+	}
+	if currentPrincipal(r).Role.AtLeast(auth.RoleModerator) {
+		return comment, nil
+	}
+	token := r.Header.Get(editTokenHeader)
+	if token == "" || comment.EditTokenHash == nil || subtle.ConstantTimeCompare([]byte(hashEditToken(token)), []byte(*comment.EditTokenHash)) != 1 {
+		return comment, errInvalidEditToken
+	}
+	return comment, nil
+}
+
+// commentByEditToken returns the comment the edit token of the request belongs to - the author doesn't know the ID of
+// the comment, it is assigned when the submission is applied
+func commentByEditToken(r *http.Request) (dbutils.LondonJamSessionsComment, error) {
+	token := r.Header.Get(editTokenHeader)
+	if token == "" {
+		return dbutils.LondonJamSessionsComment{}, errInvalidEditToken
+	}
+	comment, err := queries.GetCommentByEditTokenHash(ctx, ptr(hashEditToken(token)))
+	if err == nil && comment.Status == "hidden" {
+		err = pgx.ErrNoRows
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return comment, fuego.NotFoundError{Err: err, Title: "Not Found", Detail: "There is no comment with this edit token (comments can be edited once the submission has been reviewed)"}
+	} else if err != nil {
+		return comment, problem(err)
+	}
+	return comment, nil
+}
+
+type CommentEditBody struct {
+	Author  *string `json:"author,omitempty"`
+	Content *string `json:"content,omitempty"`
+}
+
+// helper func - returns the body of an edit request if it changes the comment
+func commentEdit(c *fuego.ContextWithBody[CommentEditBody]) (CommentEditBody, error) {
+	payload, err := c.Body()
+	if err != nil {
+		return payload, problem(err)
+	}
+	if payload.Content == nil && payload.Author == nil {
+		detail := "Please provide the new 'content' and/or 'author' of the comment"
+		return payload, invalidField("content", detail, errors.New(detail))
+	}
+	if payload.Content != nil && strings.TrimSpace(*payload.Content) == "" {
+		detail := "The content of a comment can't be empty, delete the comment instead"
+		return payload, invalidField("content", detail, errors.New(detail))
+	}
+	return payload, nil
+}
+
+func PatchCommentById(c *fuego.ContextWithBody[CommentEditBody]) (any, error) {
+	slog.Info("PatchCommentById", "id", c.PathParam("id"))
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/comments/{id}'), got: %v", c.PathParam("id")))
+	}
+	payload, err := commentEdit(c)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := authorisedComment(c.Request(), id)
+	if err != nil {
+		return nil, err
+	}
+	return nil, editComment(c.Request(), comment, payload)
+}
+
+// PatchComment edits the comment the edit token belongs to
+func PatchComment(c *fuego.ContextWithBody[CommentEditBody]) (any, error) {
+	payload, err := commentEdit(c)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := commentByEditToken(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("PatchComment", "id", comment.CommentID)
+	return nil, editComment(c.Request(), comment, payload)
+}
+
+// helper func - writes the migration that applies the edit
+func editComment(r *http.Request, comment dbutils.LondonJamSessionsComment, payload CommentEditBody) error {
+	// edits go through the same spam checks as new comments
+	edited := CommentBody{Author: comment.Author, Content: comment.Content}
+	if payload.Author != nil {
+		edited.Author = *payload.Author
+	}
+	if payload.Content != nil {
+		edited.Content = *payload.Content
+	}
+	verdict := scoreComment(r, int(comment.Session), edited)
+
+	// same keys as dbutils.UpdateCommentByIdParams
+	editPayload := struct {
+		CommentEditBody
+		Status    string   `json:"status,omitempty"`
+		SpamScore *float64 `json:"spam_score,omitempty"`
+	}{CommentEditBody: payload}
+	if verdict.Score > 0 {
+		editPayload.SpamScore = ptr(verdict.Score)
+	}
+	if verdict.Held() {
+		editPayload.Status = "pending"
+	}
+	j, err := json.Marshal(editPayload)
+	if err != nil {
+		return problem(err)
+	}
+	cmd := fmt.Sprintf(`dbcli update comment %v "%s"`, comment.CommentID, j)
+	if verdict.Held() {
+		cmd += fmt.Sprintf("\n# held for moderation (spam score %.1f): %s", verdict.Score, strings.Join(verdict.Reasons, ", "))
+	}
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("update_comment_%v", comment.CommentID), migrationsDirectory); err != nil {
+		slog.Error("editComment", "id", comment.CommentID, "msg", err)
+		return problem(err)
+	}
+	alert := alerts.Alert{Type: "comment edit", Name: fmt.Sprintf("comment %v", comment.CommentID), Notes: edited.Content, Submitter: edited.Author}
+	if verdict.Held() {
+		alert.Type, alert.Notes = "comment edit (held for moderation)", strings.Join(verdict.Reasons, ", ")
+		alert.ReviewURL = fmt.Sprintf("%v/v1/admin/comments", strings.TrimSuffix(publicUrl, "/"))
+	}
+	alertSubmission(alert, migrationsDirectory)
+	return nil
+}
+
+func DeleteCommentById(c *fuego.ContextNoBody) (any, error) {
+	slog.Info("DeleteCommentById", "id", c.PathParam("id"))
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/comments/{id}'), got: %v", c.PathParam("id")))
+	}
+	comment, err := authorisedComment(c.Request(), id)
+	if err != nil {
+		return nil, err
+	}
+	return nil, deleteComment(comment)
+}
+
+// DeleteComment deletes the comment the edit token belongs to
+func DeleteComment(c *fuego.ContextNoBody) (any, error) {
+	comment, err := commentByEditToken(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("DeleteComment", "id", comment.CommentID)
+	return nil, deleteComment(comment)
+}
+
+// helper func - writes the migration that deletes the comment
+func deleteComment(comment dbutils.LondonJamSessionsComment) error {
+	cmd := fmt.Sprintf("dbcli delete comment %v", comment.CommentID)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("delete_comment_%v", comment.CommentID), migrationsDirectory); err != nil {
+		slog.Error("deleteComment", "id", comment.CommentID, "msg", err)
+		return problem(err)
+	}
+	alertSubmission(alerts.Alert{Type: "comment deletion", Name: fmt.Sprintf("comment %v", comment.CommentID), Submitter: comment.Author}, migrationsDirectory)
+	return nil
+}
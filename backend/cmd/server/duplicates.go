@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	types "github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// sameSchedule reports whether two sessions with the same interval, starting at a and b, take place
+// on the same day(s) at the same time - mirrors the GetConflictingSessions query
+func sameSchedule(interval types.Interval, a time.Time, b time.Time) bool {
+	a, b = a.UTC(), b.UTC()
+	if a.Hour() != b.Hour() || a.Minute() != b.Minute() || a.Second() != b.Second() {
+		return false
+	}
+	dateA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dateB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case types.Daily:
+		return true
+	case types.Once:
+		return dateA.Equal(dateB)
+	case types.Fortnightly:
+		return int(dateA.Sub(dateB).Hours()/24)%14 == 0
+	default:
+		return a.Weekday() == b.Weekday()
+	}
+}
+
+// helper func - compares name and postcode of two venues that don't have an id yet
+func sameNewVenue(a *types.VenueProperties, b *types.VenueProperties) bool {
+	if a == nil || b == nil || a.VenueName == nil || b.VenueName == nil || a.Postcode == nil || b.Postcode == nil {
+		return false
+	}
+	normalise := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, " ", "")) }
+	return normalise(*a.VenueName) == normalise(*b.VenueName) && normalise(*a.Postcode) == normalise(*b.Postcode)
+}
+
+// findConflictingSession returns a ConflictError if a session with the same venue, interval and schedule already exists
+func findConflictingSession(session types.SessionProperties) error {
+	if session.Venue == nil || session.Interval == nil || session.StartTimeUtc == nil {
+		return nil
+	}
+	conflicts, err := queries.GetConflictingSessions(ctx, dbutils.GetConflictingSessionsParams{
+		Venue:        *session.Venue,
+		Interval:     session.Interval.String(),
+		StartTimeUtc: pgtype.Timestamptz{Time: *session.StartTimeUtc, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	detail := fmt.Sprintf("Session %v ('%v') already takes place at this venue at the same time", conflicts[0].SessionID, conflicts[0].SessionName)
+	return fuego.ConflictError{
+		Err:    fmt.Errorf("duplicate of session %v", conflicts[0].SessionID),
+		Title:  "Duplicate session",
+		Detail: detail,
+		Errors: []fuego.ErrorItem{{Name: "session_id", Reason: detail, More: map[string]any{"session_id": conflicts[0].SessionID}}},
+	}
+}
+
+// findPendingDuplicates returns the file names of unapplied submissions that describe the same session
+func findPendingDuplicates(session types.SessionProperties, newVenue *types.VenueProperties) ([]string, error) {
+	if session.Interval == nil || session.StartTimeUtc == nil {
+		return nil, nil
+	}
+	pending, err := migrationutils.ReadPendingSessions(migrationsDirectory)
+	if err != nil {
+		return nil, err
+	}
+	var duplicates []string
+	for _, p := range pending {
+		if p.Session.Interval == nil || p.Session.StartTimeUtc == nil || *p.Session.Interval != *session.Interval {
+			continue
+		}
+		if session.Venue != nil {
+			if p.Session.Venue == nil || *p.Session.Venue != *session.Venue {
+				continue
+			}
+		} else if !sameNewVenue(p.NewVenue, newVenue) {
+			continue
+		}
+		if sameSchedule(*session.Interval, *p.Session.StartTimeUtc, *session.StartTimeUtc) {
+			duplicates = append(duplicates, filepath.Base(p.File))
+		}
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	types "github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fuego serialises every fuego.HTTPError as an RFC 7807 problem (application/problem+json).
+// The helpers in this file make sure the errors returned by the handlers (and by fuego itself
+// when decoding request bodies) are mapped to problems with a meaningful status code.
+
+const (
+	unknownErrorDetail = "an unknown error occured"
+	conflictDetail     = "The request conflicts with an existing record"
+)
+
+// ProblemErrorHandler is passed to fuego.WithErrorHandler - maps the error to a problem and hands it to the default error handler
+func ProblemErrorHandler(err error) error {
+	return fuego.ErrorHandler(problem(err))
+}
+
+// problem maps an error to a fuego error type:
+// validation errors to 400 (with a pointer to the offending field), pgx.ErrNoRows to 404,
+// integrity constraint violations to 409 and everything else to 500
+func problem(err error) error {
+	var validationErr types.ValidationError
+	var typeErr *json.UnmarshalTypeError
+	var pgErr *pgconn.PgError
+	var statusErr fuego.ErrorWithStatus
+	switch {
+	case errors.As(err, &validationErr):
+		return invalidField(validationErr.Field, validationErr.Msg, err)
+	case errors.As(err, &typeErr):
+		return invalidField(strings.ReplaceAll(typeErr.Field, ".", "/"), fmt.Sprintf("expected a value of type %v, got %v", typeErr.Type, typeErr.Value), err)
+	case errors.As(err, &statusErr): // already mapped
+		return err
+	case errors.Is(err, pgx.ErrNoRows):
+		return fuego.NotFoundError{Err: err, Title: "Not Found", Detail: "The requested resource does not exist"}
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23"): // class 23 - integrity constraint violation
+		// the message and constraint name reveal the schema (and sometimes values), they are only logged
+		slog.Warn("problem", "msg", pgErr.Message, "constraint", pgErr.ConstraintName, "code", pgErr.Code)
+		return fuego.ConflictError{Err: err, Title: "Conflict", Detail: conflictDetail}
+	default:
+		return fuego.HTTPError{Err: err, Status: 500, Detail: unknownErrorDetail}
+	}
+}
+
+// notFound returns a 404 problem for the resource (e.g. 'session') with the given ID
+func notFound(resource string, id int, err error) error {
+	return fuego.NotFoundError{Err: err, Title: "Not Found", Detail: fmt.Sprintf("There is no %v with ID %v", resource, id)}
+}
+
+// invalidField returns a 400 problem pointing to a field of the request body
+func invalidField(field string, detail string, err error) error {
+	e := fuego.BadRequestError{Err: err, Title: "Validation Error", Detail: detail}
+	if field != "" {
+		e.Errors = []fuego.ErrorItem{{Name: field, Reason: detail, More: map[string]any{"pointer": "/" + field}}}
+	}
+	return e
+}
+
+// invalidParam returns a 400 problem pointing to a path or query parameter
+func invalidParam(param string, detail string) error {
+	return fuego.BadRequestError{
+		Err:    errors.New(detail),
+		Title:  "Invalid Parameter",
+		Detail: detail,
+		Errors: []fuego.ErrorItem{{Name: param, Reason: detail, More: map[string]any{"parameter": param}}},
+	}
+}
+
+// unknownParams returns a 400 problem pointing to every query parameter that isn't recognised
+func unknownParams(params []string) error {
+	slices.Sort(params)
+	detail := fmt.Sprintf("The following query parameters are not recognised: %v", strings.Join(params, ","))
+	e := fuego.BadRequestError{Err: errors.New(detail), Title: "Invalid Parameter", Detail: detail}
+	for _, param := range params {
+		e.Errors = append(e.Errors, fuego.ErrorItem{Name: param, Reason: fmt.Sprintf("%v is not a recognised query parameter", param), More: map[string]any{"parameter": param}})
+	}
+	return e
+}
+
+// sendProblem writes a problem response outside of fuego handlers (e.g. in middleware)
+func sendProblem(w http.ResponseWriter, status int, detail string) {
+	fuego.SendJSONError(w, fuego.HTTPError{Status: status, Title: http.StatusText(status), Detail: detail})
+}
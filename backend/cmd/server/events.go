@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// EVENTS - applied changes are streamed to clients as Server-Sent Events. The record_event triggers (see schema.sql)
+// log every change in the events table and notify the server, clients that reconnect send the ID of the last event
+// they received (Last-Event-ID) and the events they missed are replayed from the table
+
+const (
+	eventBufferSize     = 64 // events queued per client, clients that fall further behind are disconnected
+	maxEventSubscribers = 1000
+	eventReplayBatch    = 500
+	eventHeartbeat      = 30 * time.Second
+	eventRetry          = 5 * time.Second // reconnection delay suggested to the clients
+)
+
+// loadEvents returns the events recorded after an ID (set to queries.GetEventsAfter in main)
+var loadEvents func(ctx context.Context, arg dbutils.GetEventsAfterParams) ([]dbutils.LondonJamSessionsEvent, error)
+
+var eventStream = newEventBroker(0)
+
+// streamEvent is sent to the clients, it is also the payload of the notifications of the record_event trigger
+type streamEvent struct {
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+func eventFromRow(row dbutils.LondonJamSessionsEvent) streamEvent {
+	return streamEvent{ID: row.EventID, Type: row.EventType, Time: row.DtCreated.Time, Data: row.Data}
+}
+
+// write writes the event in the text/event-stream format
+func (e streamEvent) write(w io.Writer) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// eventBroker fans the events out to the connected clients
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan streamEvent]struct{}
+	lastID      int64 // highest ID published so far, the broker catches up from it after the listener reconnected
+}
+
+func newEventBroker(lastID int64) *eventBroker {
+	return &eventBroker{subscribers: map[chan streamEvent]struct{}{}, lastID: lastID}
+}
+
+// subscribe returns nil if the maximum number of clients is connected
+func (b *eventBroker) subscribe() chan streamEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) >= maxEventSubscribers {
+		return nil
+	}
+	ch := make(chan streamEvent, eventBufferSize)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish never blocks, the channels of clients that can't keep up are closed
+// (they reconnect and the missed events are replayed)
+func (b *eventBroker) publish(e streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID = max(b.lastID, e.ID)
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// handleNotification publishes the event of a notification on dbutils.EventsChannel
+func (b *eventBroker) handleNotification(n *pgconn.Notification) {
+	var e streamEvent
+	if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+		slog.Error("eventBroker", "msg", "invalid notification", "payload", n.Payload, "err", err)
+		return
+	}
+	b.publish(e)
+}
+
+// catchUp publishes the events recorded while the listener was disconnected
+func (b *eventBroker) catchUp(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		after := b.lastID
+		b.mu.Unlock()
+		rows, err := loadEvents(ctx, dbutils.GetEventsAfterParams{AfterID: after, MaxResults: eventReplayBatch})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			b.publish(eventFromRow(row))
+		}
+		if len(rows) < eventReplayBatch {
+			return nil
+		}
+	}
+}
+
+// GetEvents streams the events to the client until it disconnects
+func GetEvents(w http.ResponseWriter, r *http.Request) {
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		// EventSource can't set headers on the first connection
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	var after int64 = -1
+	if lastEventId != "" {
+		id, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || id < 0 {
+			fuego.SendJSONError(w, invalidParam("last_event_id", "'Last-Event-ID' must be the ID of an event"))
+			return
+		}
+		after = id
+	}
+
+	// subscribe before replaying so that no event is lost in between
+	ch := eventStream.subscribe()
+	if ch == nil {
+		w.Header().Set("Retry-After", "60")
+		sendProblem(w, http.StatusServiceUnavailable, "Too many clients are connected to the event stream, please try again later.")
+		return
+	}
+	defer eventStream.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Error("GetEvents", "msg", err)
+		sendProblem(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disables the response buffering of proxies
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds()); err != nil {
+		return
+	}
+
+	// events published while the missed ones are replayed are queued in ch, skip the ones replayed already
+	replayed := after
+	for after >= 0 {
+		rows, err := loadEvents(r.Context(), dbutils.GetEventsAfterParams{AfterID: replayed, MaxResults: eventReplayBatch})
+		if err != nil {
+			// the client reconnects with the ID of the last event it received
+			slog.Error("GetEvents", "msg", err)
+			return
+		}
+		for _, row := range rows {
+			if err := eventFromRow(row).write(w); err != nil {
+				return
+			}
+			replayed = row.EventID
+		}
+		if len(rows) < eventReplayBatch {
+			break
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				// the client fell behind, it reconnects with the ID of the last event it received
+				return
+			}
+			if e.ID <= replayed {
+				continue
+			}
+			if err := e.write(w); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// comment line that keeps proxies from closing the idle connection
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
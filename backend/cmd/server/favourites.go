@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/ics"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// the calendar feed contains the occurrences of the favourite sessions within this window
+const calendarDaysBefore = 30
+const calendarDaysAfter = 180
+
+// payload of 'dbcli favourite add|remove'
+type favouritePayload struct {
+	UserEmail string `json:"user_email"`
+	Session   int32  `json:"session"`
+}
+
+// writeFavouriteMigration writes the migration that adds or removes a favourite
+func writeFavouriteMigration(c *fuego.ContextNoBody, action string) error {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(c.PathParam("session_id"))
+	if err != nil {
+		return invalidParam("session_id", fmt.Sprintf("Please provide a numeric ID ('/me/favourites/{session_id}'), got: %v", c.PathParam("session_id")))
+	}
+	if _, err := sessionVenue(ctx, int32(id)); errors.Is(err, pgx.ErrNoRows) {
+		return notFound("session", id, err)
+	} else if err != nil {
+		return problem(err)
+	}
+	if err := writePersonalMigration("favourite "+action, favouritePayload{UserEmail: user.Email, Session: int32(id)}, fmt.Sprintf("favourite_%v_%v", action, id)); err != nil {
+		return err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil
+}
+
+func PutFavourite(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFavouriteMigration(c, "add")
+}
+
+func DeleteFavourite(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFavouriteMigration(c, "remove")
+}
+
+func GetFavourites(c *fuego.ContextNoBody) (types.SessionWithVenueFeatureCollection, error) {
+	claims, err := loggedInUser(c.Request())
+	if err != nil {
+		return types.SessionWithVenueFeatureCollection{}, err
+	}
+	result, err := queries.GetFavouriteSessionsAsGeoJSON(ctx, claims.Email())
+	if err != nil {
+		slog.Error("GetFavourites", "msg", err)
+		return types.SessionWithVenueFeatureCollection{}, problem(err)
+	}
+	return types.RawFeatureCollection[types.SessionFeature[types.SessionPropertiesWithVenue]](result), nil
+}
+
+type CalendarLinkResponse struct {
+	// private link to the calendar feed of the favourites (iCalendar), anyone with the link can see the favourites
+	URL string `json:"url"`
+}
+
+func GetFavouritesCalendarLink(c *fuego.ContextNoBody) (CalendarLinkResponse, error) {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return CalendarLinkResponse{}, err
+	}
+	link := fmt.Sprintf("%v/v1/calendar/%v.ics", strings.TrimSuffix(publicUrl, "/"), authIssuer.CalendarToken(*user.UserID))
+	return CalendarLinkResponse{URL: link}, nil
+}
+
+// CalendarFeed is an iCalendar document, written as text/calendar by Serialize
+type CalendarFeed []byte
+
+func GetCalendarFeed(c *fuego.ContextNoBody) (CalendarFeed, error) {
+	token := strings.TrimSuffix(c.PathParam("token"), ".ics")
+	if authIssuer == nil {
+		return nil, fuego.NotFoundError{Err: errors.New("accounts are disabled"), Title: "Not Found", Detail: "There is no calendar with this link"}
+	}
+	userID, err := authIssuer.VerifyCalendarToken(token)
+	if err != nil {
+		return nil, fuego.NotFoundError{Err: err, Title: "Not Found", Detail: "There is no calendar with this link"}
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	rows, err := queries.GetFavouriteOccurrences(ctx, dbutils.GetFavouriteOccurrencesParams{
+		UserID:    userID,
+		StartDate: pgtype.Date{Time: today.AddDate(0, 0, -calendarDaysBefore), Valid: true},
+		EndDate:   pgtype.Date{Time: today.AddDate(0, 0, calendarDaysAfter), Valid: true},
+	})
+	if err != nil {
+		slog.Error("GetCalendarFeed", "user", userID, "err", err)
+		return nil, problem(err)
+	}
+	var buf bytes.Buffer
+	if err := favouritesCalendar(rows).Write(&buf, time.Now()); err != nil {
+		return nil, problem(err)
+	}
+	return CalendarFeed(buf.Bytes()), nil
+}
+
+// favouritesCalendar turns the occurrences of the favourite sessions into calendar events. Sessions take place
+// at the time of day of their first occurrence (start_time_utc), like in the date filters.
+func favouritesCalendar(rows []dbutils.GetFavouriteOccurrencesRow) ics.Calendar {
+	domain := "jamsessions"
+	if u, err := url.Parse(publicUrl); err == nil && u.Hostname() != "" {
+		domain = u.Hostname()
+	}
+	cal := ics.Calendar{ProdID: "-//Jam Sessions//Favourites//EN", Name: "Jam Sessions - Favourites"}
+	for _, r := range rows {
+		first := r.StartTimeUtc.Time.UTC()
+		location := []string{r.VenueName, r.AddressFirstLine}
+		if r.AddressSecondLine != nil && *r.AddressSecondLine != "" {
+			location = append(location, *r.AddressSecondLine)
+		}
+		location = append(location, r.City, r.Postcode)
+		for _, d := range r.Dates {
+			if !d.Valid {
+				continue
+			}
+			start := time.Date(d.Time.Year(), d.Time.Month(), d.Time.Day(), first.Hour(), first.Minute(), 0, 0, time.UTC)
+			event := ics.Event{
+				UID:         ics.UID(r.SessionID, start, domain),
+				Start:       start,
+				End:         start.Add(time.Duration(r.DurationMinutes) * time.Minute),
+				Summary:     r.SessionName,
+				Location:    strings.Join(location, ", "),
+				Description: r.Description,
+				Modified:    r.DtUpdatedUtc.Time,
+			}
+			if r.SessionWebsite != nil {
+				event.URL = *r.SessionWebsite
+			}
+			cal.Events = append(cal.Events, event)
+		}
+	}
+	return cal
+}
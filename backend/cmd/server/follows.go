@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+// FOLLOWS - followers of sessions and venues are notified when an approved change touches them (dbcli sends the
+// notifications when it applies the change)
+
+// VAPID public key of the push notifications (environment variable VAPID_PUBLIC_KEY, the private key is only
+// needed by dbcli), empty if push notifications are disabled
+var vapidPublicKey string
+
+// payload of 'dbcli follow add|remove'
+type followPayload struct {
+	UserEmail string `json:"user_email"`
+	Session   *int32 `json:"session,omitempty"`
+	Venue     *int32 `json:"venue,omitempty"`
+}
+
+// writeFollowMigration writes the migration that follows or unfollows the session or venue ('session' or 'venue')
+func writeFollowMigration(c *fuego.ContextNoBody, resource string, action string) error {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return invalidParam("id", fmt.Sprintf("Please provide a numeric ID, got: %v", c.PathParam("id")))
+	}
+	payload := followPayload{UserEmail: user.Email}
+	ref := int32(id)
+	if resource == "session" {
+		_, err = sessionVenue(ctx, ref)
+		payload.Session = &ref
+	} else {
+		_, err = queries.GetVenueById(ctx, ref)
+		payload.Venue = &ref
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFound(resource, id, err)
+	} else if err != nil {
+		return problem(err)
+	}
+	if err := writePersonalMigration("follow "+action, payload, fmt.Sprintf("follow_%v_%v_%v", action, resource, id)); err != nil {
+		return err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil
+}
+
+func PutFollowSession(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFollowMigration(c, "session", "add")
+}
+
+func DeleteFollowSession(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFollowMigration(c, "session", "remove")
+}
+
+func PutFollowVenue(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFollowMigration(c, "venue", "add")
+}
+
+func DeleteFollowVenue(c *fuego.ContextNoBody) (any, error) {
+	return nil, writeFollowMigration(c, "venue", "remove")
+}
+
+func GetFollows(c *fuego.ContextNoBody) ([]types.Follow, error) {
+	claims, err := loggedInUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queries.GetFollows(ctx, claims.Email())
+	if err != nil {
+		slog.Error("GetFollows", "msg", err)
+		return nil, problem(err)
+	}
+	follows := make([]types.Follow, len(rows))
+	for i, r := range rows {
+		follows[i] = types.Follow{FollowID: r.FollowID, Session: r.Session, SessionName: r.SessionName, Venue: r.Venue, VenueName: r.VenueName, DtAdded: r.DtAdded.Time}
+	}
+	return follows, nil
+}
+
+// NOTIFICATION CHANNELS
+
+func GetNotificationChannels(c *fuego.ContextNoBody) ([]types.NotificationChannel, error) {
+	claims, err := loggedInUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queries.GetNotificationChannelsByEmail(ctx, claims.Email())
+	if err != nil {
+		slog.Error("GetNotificationChannels", "msg", err)
+		return nil, problem(err)
+	}
+	channels := make([]types.NotificationChannel, len(rows))
+	for i, r := range rows {
+		channels[i] = types.NotificationChannel{ChannelID: r.ChannelID, Kind: r.Kind, Endpoint: r.Endpoint, DtCreated: r.DtCreated.Time}
+	}
+	return channels, nil
+}
+
+type PushSubscriptionKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+type NotificationChannelBody struct {
+	// 'email', 'webpush' or 'webhook'. Users without channels are notified by email.
+	Kind string `json:"kind" validate:"required"`
+	// push service URL (from the PushSubscription of the browser) or https URL the notifications are posted to
+	Endpoint string `json:"endpoint,omitempty"`
+	// keys of the PushSubscription (webpush only)
+	Keys *PushSubscriptionKeys `json:"keys,omitempty"`
+}
+
+// payload of 'dbcli channel add'
+type channelPayload struct {
+	UserEmail string `json:"user_email"`
+	notify.Channel
+}
+
+func PostNotificationChannel(c *fuego.ContextWithBody[NotificationChannelBody]) (any, error) {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	channel := notify.Channel{Kind: notify.Kind(body.Kind), Endpoint: body.Endpoint}
+	if body.Keys != nil {
+		channel.P256dh, channel.Auth = body.Keys.P256dh, body.Keys.Auth
+	}
+	if err := channel.Validate(); err != nil {
+		field := "endpoint"
+		if !slices.Contains(notify.Kinds, channel.Kind) {
+			field = "kind"
+		}
+		return nil, invalidField(field, err.Error(), err)
+	}
+	if channel.Kind == notify.WebPush && vapidPublicKey == "" {
+		return nil, fuego.HTTPError{Err: errors.New("VAPID_PUBLIC_KEY is not set"), Status: http.StatusServiceUnavailable, Title: "Service Unavailable", Detail: "Push notifications are not available"}
+	}
+	if err := writePersonalMigration("channel add", channelPayload{UserEmail: user.Email, Channel: channel}, "channel_add_"+body.Kind); err != nil {
+		return nil, err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil, nil
+}
+
+func DeleteNotificationChannel(c *fuego.ContextNoBody) (any, error) {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/me/notification-channels/{id}'), got: %v", c.PathParam("id")))
+	}
+	channels, err := queries.GetNotificationChannelsByEmail(ctx, user.Email)
+	if err != nil {
+		slog.Error("DeleteNotificationChannel", "msg", err)
+		return nil, problem(err)
+	}
+	found := false
+	for _, ch := range channels {
+		found = found || ch.ChannelID == int32(id)
+	}
+	if !found {
+		return nil, notFound("notification channel", id, pgx.ErrNoRows)
+	}
+	payload := struct {
+		UserEmail string `json:"user_email"`
+		ChannelID int32  `json:"channel_id"`
+	}{user.Email, int32(id)}
+	if err := writePersonalMigration("channel remove", payload, fmt.Sprintf("channel_remove_%v", id)); err != nil {
+		return nil, err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil, nil
+}
+
+type WebPushKeyResponse struct {
+	// VAPID public key, pass it as applicationServerKey to PushManager.subscribe()
+	PublicKey string `json:"public_key"`
+}
+
+func GetWebPushKey(c *fuego.ContextNoBody) (WebPushKeyResponse, error) {
+	if vapidPublicKey == "" {
+		return WebPushKeyResponse{}, fuego.NotFoundError{Err: errors.New("VAPID_PUBLIC_KEY is not set"), Title: "Not Found", Detail: "Push notifications are not available"}
+	}
+	return WebPushKeyResponse{PublicKey: vapidPublicKey}, nil
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/kinds"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/graphql/language/visitor"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// GRAPHQL - sessions, venues, occurrences, comments and ratings in one round trip. The executor resolves the fields
+// level by level, so the relations of all objects on a level of a query are fetched with a single query by the
+// loaders of the request. Queries nested deeper than graphqlMaxDepth or whose cost exceeds graphqlMaxCost are
+// rejected by a validation rule before they are executed (see queryLimits)
+
+const (
+	graphqlMaxDepth        = 8
+	graphqlMaxCost         = 1000
+	graphqlDefaultListSize = 100
+	graphqlMaxFirst        = 100 // maximum value of the 'first' arguments
+)
+
+// Error codes of requests that are rejected before they are executed (extensions.code)
+const (
+	graphqlParseFailed      = "GRAPHQL_PARSE_FAILED"
+	graphqlValidationFailed = "GRAPHQL_VALIDATION_FAILED"
+	graphqlQueryTooComplex  = "QUERY_TOO_COMPLEX"
+)
+
+// graphqlListSizes are the lengths of the lists without a 'first' argument that are shorter than graphqlDefaultListSize
+var graphqlListSizes = map[string]int{"Rating.aspects": 5}
+
+var errGraphQLInternal = errors.New("internal error")
+
+var graphqlSchema = newGraphQLSchema()
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// occurrence is the source of the fields of the Occurrence type
+type occurrence struct {
+	date    time.Time
+	session *dbutils.LondonJamSessionsJamsession
+}
+
+// aspectRating is the source of the fields of the AspectRating type
+type aspectRating struct {
+	aspect string
+	types.RatingStats
+}
+
+// LOADERS
+
+// loader batches the loads of a request: load queues the key and returns a thunk, the first thunk that is called
+// fetches all queued keys with a single call of fetch. Results are cached for the lifetime of the loader, create
+// one per request. Keys that fetch leaves out resolve to the zero value of V (null for pointers, an empty list for
+// slices). Loaders are not safe for concurrent use, the executor calls resolvers and thunks from one goroutine.
+type loader[K comparable, V any] struct {
+	fetch   func(keys []K) (map[K]V, error)
+	queued  []K
+	pending map[K]bool
+	results map[K]V
+	errs    map[K]error
+}
+
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, pending: map[K]bool{}, results: map[K]V{}, errs: map[K]error{}}
+}
+
+// load queues the key (unless it has been loaded already) and returns a thunk that returns its value. The executor
+// only defers resolvers that return a func() (any, error), so the thunk must not have a named type.
+func (l *loader[K, V]) load(key K) func() (any, error) {
+	if _, ok := l.results[key]; !ok && !l.pending[key] && l.errs[key] == nil {
+		l.pending[key] = true
+		l.queued = append(l.queued, key)
+	}
+	return func() (any, error) {
+		v, err := l.get(key)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func (l *loader[K, V]) get(key K) (V, error) {
+	if l.pending[key] {
+		keys := l.queued
+		l.queued, l.pending = nil, map[K]bool{}
+		values, err := l.fetch(keys)
+		for _, k := range keys {
+			if err != nil {
+				l.errs[k] = err
+			} else {
+				l.results[k] = values[k]
+			}
+		}
+	}
+	return l.results[key], l.errs[key]
+}
+
+// graphqlLoaders batch the queries of a request, the occurrence and comment loaders are created per set of arguments
+type graphqlLoaders struct {
+	ctx           context.Context
+	venues        *loader[int32, *dbutils.ListVenuesRow]
+	sessions      *loader[int32, *dbutils.LondonJamSessionsJamsession]
+	venueSessions *loader[int32, []*dbutils.LondonJamSessionsJamsession]
+	ratings       *loader[int32, *dbutils.GetRatingStatsBySessionIdsRow]
+	occurrences   map[occurrenceArgs]*loader[int32, []occurrence]
+	comments      map[int]*loader[int32, []*dbutils.GetLatestCommentsRow]
+}
+
+type occurrenceArgs struct {
+	first int
+	from  time.Time
+}
+
+type graphqlLoadersKey struct{}
+
+// internalError logs the error of a query, clients only learn that the field couldn't be resolved
+func internalError(loader string, err error) error {
+	slog.Error("GraphQL", "loader", loader, "msg", err)
+	return errGraphQLInternal
+}
+
+func newGraphQLLoaders(ctx context.Context) *graphqlLoaders {
+	l := &graphqlLoaders{
+		ctx:         ctx,
+		occurrences: map[occurrenceArgs]*loader[int32, []occurrence]{},
+		comments:    map[int]*loader[int32, []*dbutils.GetLatestCommentsRow]{},
+	}
+	l.venues = newLoader(func(ids []int32) (map[int32]*dbutils.ListVenuesRow, error) {
+		rows, err := queries.ListVenues(ctx, dbutils.ListVenuesParams{Ids: ids})
+		if err != nil {
+			return nil, internalError("venues", err)
+		}
+		result := make(map[int32]*dbutils.ListVenuesRow, len(rows))
+		for i := range rows {
+			result[rows[i].VenueID] = &rows[i]
+		}
+		return result, nil
+	})
+	l.sessions = newLoader(func(ids []int32) (map[int32]*dbutils.LondonJamSessionsJamsession, error) {
+		rows, err := queries.ListSessions(ctx, dbutils.ListSessionsParams{Ids: ids})
+		if err != nil {
+			return nil, internalError("sessions", err)
+		}
+		result := make(map[int32]*dbutils.LondonJamSessionsJamsession, len(rows))
+		for i := range rows {
+			result[rows[i].SessionID] = &rows[i]
+		}
+		return result, nil
+	})
+	l.venueSessions = newLoader(func(ids []int32) (map[int32][]*dbutils.LondonJamSessionsJamsession, error) {
+		rows, err := queries.ListSessions(ctx, dbutils.ListSessionsParams{VenueIds: ids})
+		if err != nil {
+			return nil, internalError("venueSessions", err)
+		}
+		result := make(map[int32][]*dbutils.LondonJamSessionsJamsession, len(ids))
+		for i := range rows {
+			result[rows[i].Venue] = append(result[rows[i].Venue], &rows[i])
+		}
+		return result, nil
+	})
+	l.ratings = newLoader(func(ids []int32) (map[int32]*dbutils.GetRatingStatsBySessionIdsRow, error) {
+		rows, err := queries.GetRatingStatsBySessionIds(ctx, ids)
+		if err != nil {
+			return nil, internalError("ratings", err)
+		}
+		result := make(map[int32]*dbutils.GetRatingStatsBySessionIdsRow, len(rows))
+		for i := range rows {
+			result[rows[i].SessionID] = &rows[i]
+		}
+		return result, nil
+	})
+	return l
+}
+
+func (l *graphqlLoaders) occurrenceLoader(args occurrenceArgs) *loader[int32, []occurrence] {
+	if ld, ok := l.occurrences[args]; ok {
+		return ld
+	}
+	ld := newLoader(func(ids []int32) (map[int32][]occurrence, error) {
+		rows, err := queries.GetUpcomingOccurrences(l.ctx, dbutils.GetUpcomingOccurrencesParams{
+			SessionIds:    ids,
+			StartDate:     pgtype.Date{Time: args.from, Valid: true},
+			MaxPerSession: int32(args.first),
+		})
+		if err != nil {
+			return nil, internalError("occurrences", err)
+		}
+		result := make(map[int32][]occurrence, len(ids))
+		for _, row := range rows {
+			result[row.Session] = append(result[row.Session], occurrence{date: row.D.Time})
+		}
+		return result, nil
+	})
+	l.occurrences[args] = ld
+	return ld
+}
+
+func (l *graphqlLoaders) commentLoader(first int) *loader[int32, []*dbutils.GetLatestCommentsRow] {
+	if ld, ok := l.comments[first]; ok {
+		return ld
+	}
+	ld := newLoader(func(ids []int32) (map[int32][]*dbutils.GetLatestCommentsRow, error) {
+		rows, err := queries.GetLatestComments(l.ctx, dbutils.GetLatestCommentsParams{SessionIds: ids, MaxPerSession: int32(first)})
+		if err != nil {
+			return nil, internalError("comments", err)
+		}
+		result := make(map[int32][]*dbutils.GetLatestCommentsRow, len(ids))
+		for i := range rows {
+			result[rows[i].Session] = append(result[rows[i].Session], &rows[i])
+		}
+		return result, nil
+	})
+	l.comments[first] = ld
+	return ld
+}
+
+func loadersOf(p graphql.ResolveParams) *graphqlLoaders {
+	return p.Context.Value(graphqlLoadersKey{}).(*graphqlLoaders)
+}
+
+// ARGUMENTS
+
+// the arguments with a default are nullable, graphql-go requires non-null arguments even if they have a default
+func firstArg(defaultValue int) *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultValue, Description: fmt.Sprintf("Maximum number of items (at most %d)", graphqlMaxFirst)}
+}
+
+var offsetArg = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0, Description: "Number of items to skip"}
+
+var idsArg = &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int)), Description: "Only return the items with these IDs"}
+
+func first(p graphql.ResolveParams) (int, error) {
+	n, ok := p.Args["first"].(int)
+	if !ok || n < 0 || n > graphqlMaxFirst {
+		return 0, fmt.Errorf("'first' must be between 0 and %d", graphqlMaxFirst)
+	}
+	return n, nil
+}
+
+func offset(p graphql.ResolveParams) (int32, error) {
+	n, _ := p.Args["offset"].(int) // null is 0
+	if n < 0 {
+		return 0, errors.New("'offset' must not be negative")
+	}
+	return int32(n), nil
+}
+
+// idsOf returns nil if the argument is omitted or null
+func idsOf(p graphql.ResolveParams) []int32 {
+	values, ok := p.Args["ids"].([]any)
+	if !ok {
+		return nil
+	}
+	result := make([]int32, len(values))
+	for i, v := range values {
+		result[i] = int32(v.(int))
+	}
+	return result
+}
+
+// HELPERS
+
+// timestamp formats the time like the REST endpoints (RFC 3339, UTC)
+func timestamp(t pgtype.Timestamptz) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+// field returns a field that is computed from its source without loading anything
+func field[T any](typ graphql.Output, description string, value func(T) any) *graphql.Field {
+	return &graphql.Field{Type: typ, Description: description, Resolve: func(p graphql.ResolveParams) (any, error) {
+		return value(p.Source.(T)), nil
+	}}
+}
+
+// SCHEMA
+
+func newGraphQLSchema() graphql.Schema {
+	nonNull := graphql.NewNonNull
+	stringList := nonNull(graphql.NewList(nonNull(graphql.String)))
+
+	var venue, session, occurrenceType, comment, rating, aspect *graphql.Object
+
+	venue = graphql.NewObject(graphql.ObjectConfig{Name: "Venue", Description: "A venue that hosts jam sessions", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"venue_id":             field(nonNull(graphql.Int), "", func(v *dbutils.ListVenuesRow) any { return v.VenueID }),
+			"venue_name":           field(nonNull(graphql.String), "", func(v *dbutils.ListVenuesRow) any { return v.VenueName }),
+			"address_first_line":   field(nonNull(graphql.String), "", func(v *dbutils.ListVenuesRow) any { return v.AddressFirstLine }),
+			"address_second_line":  field(graphql.String, "", func(v *dbutils.ListVenuesRow) any { return v.AddressSecondLine }),
+			"city":                 field(nonNull(graphql.String), "", func(v *dbutils.ListVenuesRow) any { return v.City }),
+			"postcode":             field(nonNull(graphql.String), "", func(v *dbutils.ListVenuesRow) any { return v.Postcode }),
+			"venue_website":        field(graphql.String, "", func(v *dbutils.ListVenuesRow) any { return v.VenueWebsite }),
+			"backline":             field(stringList, "", func(v *dbutils.ListVenuesRow) any { return v.Backline }),
+			"venue_comments":       field(stringList, "", func(v *dbutils.ListVenuesRow) any { return v.VenueComments }),
+			"venue_dt_updated_utc": field(graphql.String, "", func(v *dbutils.ListVenuesRow) any { return timestamp(v.VenueDtUpdatedUtc) }),
+			"lon":                  field(nonNull(graphql.Float), "Longitude (WGS 84)", func(v *dbutils.ListVenuesRow) any { return v.Lon }),
+			"lat":                  field(nonNull(graphql.Float), "Latitude (WGS 84)", func(v *dbutils.ListVenuesRow) any { return v.Lat }),
+			"sessions": {
+				Type: nonNull(graphql.NewList(nonNull(session))), Description: "The sessions hosted by the venue",
+				Args: graphql.FieldConfigArgument{"first": firstArg(20)},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					n, err := first(p)
+					if err != nil {
+						return nil, err
+					}
+					load := loadersOf(p).venueSessions.load(p.Source.(*dbutils.ListVenuesRow).VenueID)
+					return func() (any, error) {
+						v, err := load()
+						if err != nil {
+							return nil, err
+						}
+						sessions := v.([]*dbutils.LondonJamSessionsJamsession)
+						return sessions[:min(n, len(sessions))], nil
+					}, nil
+				},
+			},
+		}
+	})})
+
+	session = graphql.NewObject(graphql.ObjectConfig{Name: "Session", Description: "A regular jam session", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"session_id":       field(nonNull(graphql.Int), "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.SessionID }),
+			"session_name":     field(nonNull(graphql.String), "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.SessionName }),
+			"description":      field(nonNull(graphql.String), "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.Description }),
+			"genres":           field(stringList, "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.Genres }),
+			"start_time_utc":   field(nonNull(graphql.String), "Start of the first session (RFC 3339)", func(s *dbutils.LondonJamSessionsJamsession) any { return timestamp(s.StartTimeUtc) }),
+			"interval":         field(nonNull(graphql.String), "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.Interval }),
+			"duration_minutes": field(nonNull(graphql.Int), "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.DurationMinutes }),
+			"session_website":  field(graphql.String, "", func(s *dbutils.LondonJamSessionsJamsession) any { return s.SessionWebsite }),
+			"dt_updated_utc":   field(graphql.String, "", func(s *dbutils.LondonJamSessionsJamsession) any { return timestamp(s.DtUpdatedUtc) }),
+			"venue": {
+				Type: nonNull(venue),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersOf(p).venues.load(p.Source.(*dbutils.LondonJamSessionsJamsession).Venue), nil
+				},
+			},
+			"occurrences": {
+				Type: nonNull(graphql.NewList(nonNull(occurrenceType))), Description: "The next dates of the session",
+				Args: graphql.FieldConfigArgument{
+					"first": firstArg(10),
+					"from":  {Type: graphql.String, Description: "List the occurrences from this date on (YYYY-MM-DD, default: today)"},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					n, err := first(p)
+					if err != nil {
+						return nil, err
+					}
+					from := time.Now().UTC().Truncate(24 * time.Hour)
+					if v, ok := p.Args["from"].(string); ok {
+						if from, err = time.Parse(time.DateOnly, v); err != nil {
+							return nil, errors.New("'from' must be a date (YYYY-MM-DD)")
+						}
+					}
+					s := p.Source.(*dbutils.LondonJamSessionsJamsession)
+					load := loadersOf(p).occurrenceLoader(occurrenceArgs{first: n, from: from}).load(s.SessionID)
+					return func() (any, error) {
+						v, err := load()
+						if err != nil {
+							return nil, err
+						}
+						occurrences := slices.Clone(v.([]occurrence))
+						for i := range occurrences {
+							occurrences[i].session = s
+						}
+						return occurrences, nil
+					}, nil
+				},
+			},
+			"comments": {
+				Type: nonNull(graphql.NewList(nonNull(comment))), Description: "The latest comments on the session (replies included, newest first)",
+				Args: graphql.FieldConfigArgument{"first": firstArg(10)},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					n, err := first(p)
+					if err != nil {
+						return nil, err
+					}
+					return loadersOf(p).commentLoader(n).load(p.Source.(*dbutils.LondonJamSessionsJamsession).SessionID), nil
+				},
+			},
+			"rating": {
+				Type: nonNull(rating),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersOf(p).ratings.load(p.Source.(*dbutils.LondonJamSessionsJamsession).SessionID), nil
+				},
+			},
+		}
+	})})
+
+	occurrenceType = graphql.NewObject(graphql.ObjectConfig{Name: "Occurrence", Description: "A date on which a session takes place (inferred from its interval)", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"date": field(nonNull(graphql.String), "YYYY-MM-DD", func(o occurrence) any { return o.date.Format(time.DateOnly) }),
+			"start_time_utc": field(nonNull(graphql.String), "Start of the session on this date (RFC 3339)", func(o occurrence) any {
+				t := o.session.StartTimeUtc.Time.UTC()
+				return time.Date(o.date.Year(), o.date.Month(), o.date.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Format(time.RFC3339)
+			}),
+			"session": field(nonNull(session), "", func(o occurrence) any { return o.session }),
+		}
+	})})
+
+	comment = graphql.NewObject(graphql.ObjectConfig{Name: "Comment", Description: "A comment on a session", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"comment_id":     field(nonNull(graphql.Int), "", func(c *dbutils.GetLatestCommentsRow) any { return c.CommentID }),
+			"author":         field(nonNull(graphql.String), "", func(c *dbutils.GetLatestCommentsRow) any { return c.Author }),
+			"content":        field(nonNull(graphql.String), "", func(c *dbutils.GetLatestCommentsRow) any { return c.Content }),
+			"dt_posted":      field(graphql.String, "", func(c *dbutils.GetLatestCommentsRow) any { return timestamp(c.DtPosted) }),
+			"dt_edited":      field(graphql.String, "", func(c *dbutils.GetLatestCommentsRow) any { return timestamp(c.DtEdited) }),
+			"parent_comment": field(graphql.Int, "The comment this comment replies to", func(c *dbutils.GetLatestCommentsRow) any { return c.ParentComment }),
+			"verified":       field(nonNull(graphql.Boolean), "", func(c *dbutils.GetLatestCommentsRow) any { return c.Verified }),
+			"rating":         field(graphql.Int, "The rating submitted with the comment", func(c *dbutils.GetLatestCommentsRow) any { return c.Rating }),
+			"session": {
+				Type: nonNull(session),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersOf(p).sessions.load(p.Source.(*dbutils.GetLatestCommentsRow).Session), nil
+				},
+			},
+		}
+	})})
+
+	rating = graphql.NewObject(graphql.ObjectConfig{Name: "Rating", Description: "The ratings of a session", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"rating": field(graphql.Float, "Average rating (null if the session hasn't been rated)", func(r *dbutils.GetRatingStatsBySessionIdsRow) any { return r.Rating }),
+			"rating_count": field(nonNull(graphql.Int), "", func(r *dbutils.GetRatingStatsBySessionIdsRow) any {
+				if r.RatingCount == nil {
+					return 0
+				}
+				return *r.RatingCount
+			}),
+			"rating_score":     field(graphql.Float, "Score used to rank sessions by rating", func(r *dbutils.GetRatingStatsBySessionIdsRow) any { return r.RatingScore }),
+			"rating_histogram": field(nonNull(graphql.NewList(nonNull(graphql.Int))), "Number of ratings per star (1 to 5)", func(r *dbutils.GetRatingStatsBySessionIdsRow) any { return r.RatingHistogram }),
+			"aspects": {
+				Type: nonNull(graphql.NewList(nonNull(aspect))), Description: "Average ratings of the aspects that have been rated",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					r := p.Source.(*dbutils.GetRatingStatsBySessionIdsRow)
+					if len(r.AspectRatings) == 0 {
+						return []aspectRating{}, nil
+					}
+					var stats types.AspectRatingStats
+					if err := json.Unmarshal(r.AspectRatings, &stats); err != nil {
+						return nil, internalError("ratings", err)
+					}
+					result := []aspectRating{}
+					for _, a := range []struct {
+						name  string
+						stats *types.RatingStats
+					}{
+						{"house_band", stats.HouseBand},
+						{"backline", stats.Backline},
+						{"beginner_friendliness", stats.BeginnerFriendliness},
+						{"sound", stats.Sound},
+						{"crowd", stats.Crowd},
+					} {
+						if a.stats != nil {
+							result = append(result, aspectRating{aspect: a.name, RatingStats: *a.stats})
+						}
+					}
+					return result, nil
+				},
+			},
+		}
+	})})
+
+	aspect = graphql.NewObject(graphql.ObjectConfig{Name: "AspectRating", Description: "The average rating of an aspect of a session", Fields: graphql.Fields{
+		"aspect":       field(nonNull(graphql.String), "", func(a aspectRating) any { return a.aspect }),
+		"rating":       field(nonNull(graphql.Float), "", func(a aspectRating) any { return a.Rating }),
+		"rating_count": field(nonNull(graphql.Int), "", func(a aspectRating) any { return a.RatingCount }),
+	}})
+
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"session": {
+			Type: session, Description: "A jam session by ID",
+			Args: graphql.FieldConfigArgument{"id": {Type: nonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return loadersOf(p).sessions.load(int32(p.Args["id"].(int))), nil
+			},
+		},
+		"sessions": {
+			Type: nonNull(graphql.NewList(nonNull(session))), Description: "Jam sessions ordered by ID",
+			Args: graphql.FieldConfigArgument{"ids": idsArg, "first": firstArg(20), "offset": offsetArg},
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				n, err := first(p)
+				if err != nil {
+					return nil, err
+				}
+				skip, err := offset(p)
+				if err != nil {
+					return nil, err
+				}
+				maxResults := int32(n)
+				rows, err := queries.ListSessions(p.Context, dbutils.ListSessionsParams{Ids: idsOf(p), Skip: skip, MaxResults: &maxResults})
+				if err != nil {
+					return nil, internalError("sessions", err)
+				}
+				result := make([]*dbutils.LondonJamSessionsJamsession, len(rows))
+				for i := range rows {
+					result[i] = &rows[i]
+				}
+				return result, nil
+			},
+		},
+		"venue": {
+			Type: venue, Description: "A venue by ID",
+			Args: graphql.FieldConfigArgument{"id": {Type: nonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return loadersOf(p).venues.load(int32(p.Args["id"].(int))), nil
+			},
+		},
+		"venues": {
+			Type: nonNull(graphql.NewList(nonNull(venue))), Description: "Venues ordered by ID",
+			Args: graphql.FieldConfigArgument{"ids": idsArg, "first": firstArg(20), "offset": offsetArg},
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				n, err := first(p)
+				if err != nil {
+					return nil, err
+				}
+				skip, err := offset(p)
+				if err != nil {
+					return nil, err
+				}
+				maxResults := int32(n)
+				rows, err := queries.ListVenues(p.Context, dbutils.ListVenuesParams{Ids: idsOf(p), Skip: skip, MaxResults: &maxResults})
+				if err != nil {
+					return nil, internalError("venues", err)
+				}
+				result := make([]*dbutils.ListVenuesRow, len(rows))
+				for i := range rows {
+					result[i] = &rows[i]
+				}
+				return result, nil
+			},
+		},
+	}})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+	}
+	return schema
+}
+
+// graphqlSDL returns the schema in the GraphQL schema definition language (fields and arguments in alphabetical order)
+func graphqlSDL(schema graphql.Schema) string {
+	var b strings.Builder
+	query := schema.QueryType()
+	b.WriteString("schema {\n  query: " + query.Name() + "\n}\n")
+	seen := map[*graphql.Object]bool{query: true}
+	for queue := []*graphql.Object{query}; len(queue) > 0; queue = queue[1:] {
+		obj := queue[0]
+		b.WriteString("\n")
+		if obj.Description() != "" {
+			b.WriteString(strconv.Quote(obj.Description()) + "\n")
+		}
+		b.WriteString("type " + obj.Name() + " {\n")
+		fields := obj.Fields()
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := fields[name]
+			if f.Description != "" {
+				b.WriteString("  " + strconv.Quote(f.Description) + "\n")
+			}
+			b.WriteString("  " + f.Name)
+			if len(f.Args) > 0 {
+				args := make([]string, len(f.Args))
+				for i, a := range f.Args {
+					args[i] = a.Name() + ": " + a.Type.String()
+					if a.DefaultValue != nil {
+						v, _ := json.Marshal(a.DefaultValue)
+						args[i] += " = " + string(v)
+					}
+				}
+				sort.Strings(args)
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.Type.String() + "\n")
+			if child, ok := graphql.GetNamed(f.Type).(*graphql.Object); ok && !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// LIMITS
+
+// queryLimits is a validation rule that rejects the operation to be executed if it is nested deeper than
+// graphqlMaxDepth (the fields of the query type are on level 1) or its cost exceeds graphqlMaxCost. Every field
+// of an object type costs one point per parent object, the number of parent objects of the fields of a list is
+// multiplied by the length of the list: its 'first' argument, graphqlListSizes or graphqlDefaultListSize.
+// Introspection fields are free.
+type queryLimits struct {
+	operationName string
+	variables     map[string]any
+	op            *ast.OperationDefinition
+	visiting      map[string]bool // fragments on the current path (cycles are reported by the specified rules)
+	tooDeep       bool
+	cost          int
+}
+
+func (l *queryLimits) rule(ctx *graphql.ValidationContext) *graphql.ValidationRuleInstance {
+	return &graphql.ValidationRuleInstance{VisitorOpts: &visitor.VisitorOptions{
+		KindFuncMap: map[string]visitor.NamedVisitFuncs{
+			kinds.OperationDefinition: {Kind: func(p visitor.VisitFuncParams) (string, any) {
+				op, ok := p.Node.(*ast.OperationDefinition)
+				if !ok || op.Operation != ast.OperationTypeQuery || (l.operationName != "" && (op.Name == nil || op.Name.Value != l.operationName)) {
+					return visitor.ActionNoChange, nil
+				}
+				l.op, l.visiting = op, map[string]bool{}
+				l.cost = l.selectionCost(ctx, ctx.Schema().QueryType(), op.SelectionSet, 1, 1)
+				if l.tooDeep {
+					ctx.ReportError(tooComplex(op, fmt.Sprintf("The query exceeds the maximum depth of %d.", graphqlMaxDepth)))
+				} else if l.cost > graphqlMaxCost {
+					ctx.ReportError(tooComplex(op, fmt.Sprintf("The query has a cost of %d, which exceeds the maximum of %d. Request fewer items.", l.cost, graphqlMaxCost)))
+				}
+				return visitor.ActionNoChange, nil
+			}},
+		},
+	}}
+}
+
+func tooComplex(op *ast.OperationDefinition, message string) gqlerrors.FormattedError {
+	err := gqlerrors.NewFormattedError(message)
+	if op.Loc != nil && op.Loc.Source != nil {
+		err.Locations = []location.SourceLocation{location.GetLocation(op.Loc.Source, op.Loc.Start)}
+	}
+	err.Extensions = map[string]any{"code": graphqlQueryTooComplex}
+	return err
+}
+
+// helper func - computes the cost of the selections, resolved for the given number of parent objects
+func (l *queryLimits) selectionCost(ctx *graphql.ValidationContext, obj *graphql.Object, set *ast.SelectionSet, depth, parents int) int {
+	if set == nil {
+		return 0
+	}
+	if depth > graphqlMaxDepth {
+		l.tooDeep = true
+		return 0
+	}
+	total := 0
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			def := obj.Fields()[sel.Name.Value] // nil for introspection and unknown fields
+			if def == nil || sel.SelectionSet == nil {
+				continue
+			}
+			child, ok := graphql.GetNamed(def.Type).(*graphql.Object)
+			if !ok {
+				continue
+			}
+			total = saturatingAdd(total, parents)
+			n := parents
+			if isList(def.Type) {
+				n = saturatingMul(n, l.listSize(obj, def, sel))
+			}
+			total = saturatingAdd(total, l.selectionCost(ctx, child, sel.SelectionSet, depth+1, n))
+		case *ast.InlineFragment:
+			total = saturatingAdd(total, l.selectionCost(ctx, obj, sel.SelectionSet, depth, parents))
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			fragment := ctx.Fragment(name)
+			if fragment == nil || l.visiting[name] {
+				continue
+			}
+			l.visiting[name] = true
+			total = saturatingAdd(total, l.selectionCost(ctx, obj, fragment.SelectionSet, depth, parents))
+			delete(l.visiting, name)
+		}
+	}
+	return total
+}
+
+func (l *queryLimits) listSize(obj *graphql.Object, def *graphql.FieldDefinition, f *ast.Field) int {
+	if size, ok := graphqlListSizes[obj.Name()+"."+def.Name]; ok {
+		return size
+	}
+	for _, arg := range def.Args {
+		if arg.Name() == "first" {
+			n, ok := l.intArgument(f, "first")
+			if !ok {
+				n, _ = arg.DefaultValue.(int)
+			}
+			return min(max(n, 0), graphqlMaxFirst)
+		}
+	}
+	return graphqlDefaultListSize
+}
+
+// intArgument returns the value of an argument given as a literal or variable (or the default of the variable)
+func (l *queryLimits) intArgument(f *ast.Field, name string) (int, bool) {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != name {
+			continue
+		}
+		value := arg.Value
+		if v, ok := value.(*ast.Variable); ok {
+			switch n := l.variables[v.Name.Value].(type) {
+			case float64:
+				return int(min(max(n, math.MinInt32), math.MaxInt32)), true
+			case int:
+				return n, true
+			}
+			value = nil
+			for _, def := range l.op.VariableDefinitions {
+				if def.Variable.Name.Value == v.Name.Value {
+					value = def.DefaultValue
+				}
+			}
+		}
+		if v, ok := value.(*ast.IntValue); ok {
+			n, err := strconv.Atoi(v.Value)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+func isList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func saturatingMul(a, b int) int {
+	if b != 0 && a > math.MaxInt32/b {
+		return math.MaxInt32
+	}
+	return a * b
+}
+
+// HANDLERS
+
+// rejected sets the code of the errors of a request that is rejected before it is executed
+func rejected(code string, errs []gqlerrors.FormattedError) *graphql.Result {
+	for i := range errs {
+		if errs[i].Extensions == nil {
+			errs[i].Extensions = map[string]any{"code": code}
+		}
+	}
+	return &graphql.Result{Errors: errs}
+}
+
+// executeGraphQL validates the request and executes it with fresh loaders, requests that are rejected before they
+// are executed (syntax, validation and cost errors) are answered with 400
+func executeGraphQL(ctx context.Context, setStatus func(int), req graphqlRequest) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		setStatus(http.StatusBadRequest)
+		return rejected(graphqlParseFailed, gqlerrors.FormatErrors(err))
+	}
+	limits := &queryLimits{operationName: req.OperationName, variables: req.Variables}
+	if validation := graphql.ValidateDocument(&graphqlSchema, doc, slices.Concat(graphql.SpecifiedRules, []graphql.ValidationRuleFn{limits.rule})); !validation.IsValid {
+		setStatus(http.StatusBadRequest)
+		return rejected(graphqlValidationFailed, validation.Errors)
+	}
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       context.WithValue(ctx, graphqlLoadersKey{}, newGraphQLLoaders(ctx)),
+	})
+	if res.Data == nil { // e.g. mutations or invalid variables
+		setStatus(http.StatusBadRequest)
+		return rejected(graphqlValidationFailed, res.Errors)
+	}
+	res.Extensions = map[string]any{"cost": map[string]int{"requested": limits.cost, "maximum": graphqlMaxCost}}
+	return res
+}
+
+func PostGraphQL(c *fuego.ContextWithBody[graphqlRequest]) (*graphql.Result, error) {
+	req, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return executeGraphQL(c.Request().Context(), c.SetStatus, req), nil
+}
+
+// GetGraphQL takes the request from the query parameters, its responses are cached like those of the REST endpoints
+func GetGraphQL(c *fuego.ContextNoBody) (*graphql.Result, error) {
+	req := graphqlRequest{Query: c.QueryParam("query"), OperationName: c.QueryParam("operationName")}
+	if v := c.QueryParam("variables"); v != "" {
+		if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+			return nil, invalidParam("variables", "'variables' must be a JSON object")
+		}
+	}
+	return executeGraphQL(c.Request().Context(), c.SetStatus, req), nil
+}
+
+func GetGraphQLSchema(c *fuego.ContextNoBody) (string, error) {
+	return graphqlSDL(graphqlSchema), nil
+}
@@ -12,23 +12,49 @@ import (
 	"strings"
 	"time"
 
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
 	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
 	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
 	types "github.com/felix-schott/jamsessions/backend/internal/types"
 	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 func GetVenues(c *fuego.ContextNoBody) (types.VenueFeatureCollection, error) {
 	var geojson types.FeatureCollection[types.VenueFeature]
-	result, err := queries.GetAllVenuesAsGeoJSON(ctx)
+	var invalidKeys []string
+	for k := range c.QueryParams() {
+		if _, ok := listQueryParams[k]; !ok {
+			invalidKeys = append(invalidKeys, k)
+		}
+	}
+	if len(invalidKeys) != 0 {
+		return geojson, unknownParams(invalidKeys)
+	}
+	list, err := parseListParams(c, venueSortOptions, venueFields)
 	if err != nil {
 		return geojson, err
 	}
-	slog.Info("foo", "result", string(result))
-	json.Unmarshal(result, &geojson)
-	slog.Info("GetVenues", "result", geojson)
-	return geojson, nil
+	params := dbutils.GetVenuesPageAsGeoJSONParams{
+		PageLimit:  list.Limit,
+		Sort:       list.Sort,
+		Descending: list.Descending,
+		Lon:        list.Lon,
+		Lat:        list.Lat,
+		Fields:     list.Fields,
+	}
+	if list.Cursor != nil {
+		params.CursorID = &list.Cursor.ID
+		params.CursorSortNum = list.Cursor.sortNum()
+		params.CursorSortText = &list.Cursor.Text
+	}
+	result, err := queries.GetVenuesPageAsGeoJSON(ctx, params)
+	if err != nil {
+		return geojson, problem(err)
+	}
+	return pageAsGeoJSON[types.VenueFeature](c, result.FeatureCollection, result.NextID, result.NextSortNum, result.NextSortText)
 }
 
 func GetSessions(c *fuego.ContextNoBody) (types.SessionWithVenueFeatureCollection, error) {
@@ -46,31 +72,31 @@ func GetSessions(c *fuego.ContextNoBody) (types.SessionWithVenueFeatureCollectio
 		if k == "date" {
 			dateRange := strings.Split(c.QueryParam("date"), "/")
 			if len(dateRange) < 1 || len(dateRange) > 2 {
-				return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date"))}
+				return geojson, invalidParam("date", fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date")))
 			}
 			if len(dateRange) == 1 || len(dateRange) == 2 {
 				dateParsed, err := time.Parse(time.DateOnly, dateRange[0])
 				if err != nil {
-					return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date"))}
+					return geojson, invalidParam("date", fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date")))
 				}
 				startDate = &dateParsed
 			}
 			if len(dateRange) == 2 {
 				dateParsed, err := time.Parse(time.DateOnly, dateRange[1])
 				if err != nil {
-					return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date"))}
+					return geojson, invalidParam("date", fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date")))
 				}
 				endDate = &dateParsed
 			}
 			if startDate == nil {
-				return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date"))}
+				return geojson, invalidParam("date", fmt.Sprintf("failed to parse %v as a date or date range, please provide dates as 'YYYY-MM-DD' or optionally as a range 'YYYY-MM-DD/YYYY-MM-DD'", c.QueryParam("date")))
 			}
 		} else if k == "backline" {
 			backlineSlice := strings.Split(c.QueryParam("backline"), ",")
 			for _, b := range backlineSlice {
 				_, ok := types.BacklineOptions[types.Backline(b)]
 				if !ok {
-					return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("%v is not a valid value for 'backline'", b)}
+					return geojson, invalidParam("backline", fmt.Sprintf("%v is not a valid value for 'backline'", b))
 				}
 			}
 			backline = &backlineSlice
@@ -78,151 +104,107 @@ func GetSessions(c *fuego.ContextNoBody) (types.SessionWithVenueFeatureCollectio
 			genreParam := c.QueryParam("genre")
 			_, ok := types.Genres[types.Genre(genreParam)]
 			if !ok {
-				return geojson, fuego.BadRequestError{Detail: fmt.Sprintf("%v is not a valid value for 'genre'", genreParam)}
+				return geojson, invalidParam("genre", fmt.Sprintf("%v is not a valid value for 'genre'", genreParam))
 			}
 			genre = &genreParam
-		} else {
+		} else if _, ok := listQueryParams[k]; !ok {
 			invalidKeys = append(invalidKeys, k)
 		}
 		i++
 	}
 	if len(invalidKeys) != 0 {
-		return types.SessionWithVenueFeatureCollection{}, fuego.BadRequestError{Detail: fmt.Sprintf("The following query parameters are not recognised: %v", strings.Join(invalidKeys, ","))}
-	}
-
-	var result json.RawMessage
-	var err error
-	if startDate == nil && backline == nil && genre == nil { // no params used, no filter
-		result, err = queries.GetAllSessionsAsGeoJSON(ctx)
-	} else if startDate != nil && endDate == nil && backline != nil && genre != nil { // all available query params are used (single day)
-		slog.Info("GetSessions", "query", "GetSessionsByDateAndGenreAndBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByDateAndGenreAndBacklineAsGeoJSON(ctx, dbutils.GetSessionsByDateAndGenreAndBacklineAsGeoJSONParams{
-			Genres:   []string{*genre},
-			Backline: *backline,
-			Date:     pgtype.Date{Time: *startDate, Valid: true},
-		})
-	} else if startDate != nil && endDate != nil && backline != nil && genre != nil { // all available query params are used (date range)
-		slog.Info("GetSessions", "query", "GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSON(ctx, dbutils.GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSONParams{
-			Genres:    []string{*genre},
-			Backline:  *backline,
-			StartDate: pgtype.Date{Time: *startDate, Valid: true},
-			EndDate:   pgtype.Date{Time: *endDate, Valid: true},
-		})
-	} else if startDate != nil && endDate == nil && backline != nil && genre == nil { // single date and backline are used
-		slog.Info("GetSessions", "query", "GetSessionsByDateAndBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByDateAndBacklineAsGeoJSON(ctx, dbutils.GetSessionsByDateAndBacklineAsGeoJSONParams{
-			Date:     pgtype.Date{Time: *startDate, Valid: true},
-			Backline: *backline,
-		})
-	} else if startDate != nil && endDate != nil && backline != nil && genre == nil { // date range and backline are used
-		slog.Info("GetSessions", "query", "GetSessionsByDateRangeAndBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByDateRangeAndBacklineAsGeoJSON(ctx, dbutils.GetSessionsByDateRangeAndBacklineAsGeoJSONParams{
-			StartDate: pgtype.Date{Time: *startDate, Valid: true},
-			EndDate:   pgtype.Date{Time: *endDate, Valid: true},
-			Backline:  *backline,
-		})
-	} else if startDate != nil && endDate == nil && genre != nil && backline == nil { // single date and genre are used
-		slog.Info("GetSessions", "query", "GetSessionsByDateAndGenreAsGeoJSON")
-		result, err = queries.GetSessionsByDateAndGenreAsGeoJSON(ctx, dbutils.GetSessionsByDateAndGenreAsGeoJSONParams{
-			Date:   pgtype.Date{Time: *startDate, Valid: true},
-			Genres: []string{*genre},
-		})
-	} else if startDate != nil && endDate != nil && genre != nil && backline == nil { // date range and genre are used
-		slog.Info("GetSessions", "query", "GetSessionsByDateRangeAndGenreAsGeoJSON")
-		result, err = queries.GetSessionsByDateRangeAndGenreAsGeoJSON(ctx, dbutils.GetSessionsByDateRangeAndGenreAsGeoJSONParams{
-			StartDate: pgtype.Date{Time: *startDate, Valid: true},
-			EndDate:   pgtype.Date{Time: *endDate, Valid: true},
-			Genres:    []string{*genre},
-		})
-	} else if genre != nil && backline != nil && startDate == nil { // genre and backline are used
-		slog.Info("GetSessions", "query", "GetSessionsByGenreAndBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByGenreAndBacklineAsGeoJSON(ctx, dbutils.GetSessionsByGenreAndBacklineAsGeoJSONParams{
-			Genres:   []string{*genre},
-			Backline: *backline,
-		})
-	} else if startDate != nil && endDate == nil {
-		slog.Info("GetSessions", "query", "GetSessionsByDateAsGeoJSON")
-		result, err = queries.GetSessionsByDateAsGeoJSON(ctx, pgtype.Date{Time: *startDate, Valid: true})
-	} else if startDate != nil && endDate != nil {
-		slog.Info("GetSessions", "query", "GetSessionsByDateRangeAsGeoJSON")
-		result, err = queries.GetSessionsByDateRangeAsGeoJSON(ctx, dbutils.GetSessionsByDateRangeAsGeoJSONParams{
-			StartDate: pgtype.Date{Time: *startDate, Valid: true},
-			EndDate:   pgtype.Date{Time: *endDate, Valid: true},
-		})
-	} else if backline != nil {
-		slog.Info("GetSessions", "query", "GetSessionsByBacklineAsGeoJSON")
-		result, err = queries.GetSessionsByBacklineAsGeoJSON(ctx, *backline)
-	} else { // genre
-		slog.Info("GetSessions", "query", "GetSessionsByGenreAsGeoJSON")
-		result, err = queries.GetSessionsByGenreAsGeoJSON(ctx, []string{*genre})
+		return types.SessionWithVenueFeatureCollection{}, unknownParams(invalidKeys)
 	}
+
+	list, err := parseListParams(c, sessionSortOptions, sessionFields)
 	if err != nil {
 		return geojson, err
 	}
-	if err := json.Unmarshal(result, &geojson); err != nil {
-		return geojson, err
+	params := dbutils.GetSessionsPageAsGeoJSONParams{
+		PageLimit:  list.Limit,
+		Sort:       list.Sort,
+		Descending: list.Descending,
+		Lon:        list.Lon,
+		Lat:        list.Lat,
+		Fields:     list.Fields,
+	}
+	if startDate != nil {
+		params.StartDate = pgtype.Date{Time: *startDate, Valid: true}
+	}
+	if endDate != nil {
+		params.EndDate = pgtype.Date{Time: *endDate, Valid: true}
 	}
-	slog.Info("GetSessions", "result", geojson)
-	return geojson, nil
+	if genre != nil {
+		params.Genres = []string{*genre}
+	}
+	if backline != nil {
+		params.Backline = *backline
+	}
+	if list.Cursor != nil {
+		params.CursorID = &list.Cursor.ID
+		params.CursorSortNum = list.Cursor.sortNum()
+		params.CursorSortText = &list.Cursor.Text
+	}
+	slog.Info("GetSessions", "params", params)
+	result, err := queries.GetSessionsPageAsGeoJSON(ctx, params)
+	if err != nil {
+		return geojson, problem(err)
+	}
+	return pageAsGeoJSON[types.SessionFeature[types.SessionPropertiesWithVenue]](c, result.FeatureCollection, result.NextID, result.NextSortNum, result.NextSortText)
 }
 
 func GetSessionById(c *fuego.ContextNoBody) (types.SessionFeature[types.SessionPropertiesWithVenue], error) {
-	var geojson types.SessionFeature[types.SessionPropertiesWithVenue]
 	slog.Info("GetSessionById", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionFeature[types.SessionPropertiesWithVenue]{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.SessionFeature[types.SessionPropertiesWithVenue]{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	result, err := queries.GetSessionByIdAsGeoJSON(ctx, int32(id))
 	if err != nil {
-		return types.SessionFeature[types.SessionPropertiesWithVenue]{}, err
-	}
-	err = json.Unmarshal([]byte(result.(string)), &geojson)
-	if err != nil {
-		return types.SessionFeature[types.SessionPropertiesWithVenue]{}, err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return types.SessionFeature[types.SessionPropertiesWithVenue]{}, notFound("session", id, err)
+		}
+		return types.SessionFeature[types.SessionPropertiesWithVenue]{}, problem(err)
 	}
-	slog.Info("GetSessionById", "result", geojson)
-	return geojson, nil
+	return types.RawSessionFeature[types.SessionPropertiesWithVenue](result), nil
 }
 
 func GetVenueById(c *fuego.ContextNoBody) (types.VenueFeature, error) {
-	var geojson types.VenueFeature
 	slog.Info("GetVenueById", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.VenueFeature{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/venues/{id}'), got: %v", c.PathParam("id"))}
+		return types.VenueFeature{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/venues/{id}'), got: %v", c.PathParam("id")))
 	}
 	result, err := queries.GetVenueByIdAsGeoJSON(ctx, int32(id))
 	if err != nil {
-		return types.VenueFeature{}, err
-	}
-	err = json.Unmarshal([]byte(result.(string)), &geojson)
-	if err != nil {
-		return types.VenueFeature{}, err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return types.VenueFeature{}, notFound("venue", id, err)
+		}
+		return types.VenueFeature{}, problem(err)
 	}
-	slog.Info("GetVenueById", "result", geojson)
-	return geojson, nil
+	return types.RawVenueFeature(result), nil
 }
 
 func GetSessionsByVenueId(c *fuego.ContextNoBody) (types.SessionWithVenueFeatureCollection, error) {
 	slog.Info("GetSessionsByVenueId", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionWithVenueFeatureCollection{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/venues/{id}'), got: %v", c.PathParam("id"))}
+		return types.SessionWithVenueFeatureCollection{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/venues/{id}'), got: %v", c.PathParam("id")))
 	}
-	result, err := queries.GetSessionsByVenueIdAsGeoJSON(ctx, int32(id))
-	if err != nil {
+	// the collection of a venue without sessions is empty too
+	if _, err := queries.GetVenueById(ctx, int32(id)); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return types.SessionWithVenueFeatureCollection{}, notFound("venue", id, err)
+		}
 		slog.Error("GetSessionsByVenueId", "msg", err)
-		return types.SessionWithVenueFeatureCollection{}, errors.New("an unknown error occured")
+		return types.SessionWithVenueFeatureCollection{}, problem(err)
 	}
-	var geojson types.SessionWithVenueFeatureCollection
-	err = json.Unmarshal(result, &geojson)
+	result, err := queries.GetSessionsByVenueIdAsGeoJSON(ctx, int32(id))
 	if err != nil {
-		return geojson, err
+		slog.Error("GetSessionsByVenueId", "msg", err)
+		return types.SessionWithVenueFeatureCollection{}, problem(err)
 	}
-	slog.Info("GetVenueById", "result", geojson)
-	return geojson, nil
+	return types.RawFeatureCollection[types.SessionFeature[types.SessionPropertiesWithVenue]](result), nil
 }
 
 // the following handlers don't directly apply changes but rather prepare commits for the admin to manually run (make migrations or scripts/run-migrations.sh)
@@ -235,59 +217,84 @@ func PostSession(c *fuego.ContextWithBody[types.SessionPropertiesWithVenuePOST])
 	payload, err := c.Body()
 	slog.Info("PostSession", "payload", payload)
 	if err != nil {
-		slog.Error("PostSession", "msg", err)
-		if errors.As(err, &types.ValidationError{}) {
-			return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: err.Error()}
-		}
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
 	var submissionNotes *string = payload.SubmissionNotes
 	var submissionEmail *string = payload.SubmissionEmail
 	payload.SubmissionNotes = nil
 	payload.SubmissionEmail = nil
 
+	var newVenue *types.VenueProperties
+	if payload.VenueName != nil {
+		newVenue = &payload.VenueProperties
+	} else if err := findConflictingSession(payload.SessionProperties); err != nil {
+		if errors.As(err, &fuego.ConflictError{}) {
+			return types.SessionFeature[types.SessionProperties]{}, err
+		}
+		slog.Error("PostSession", "msg", err)
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
+	}
+	pendingDuplicates, err := findPendingDuplicates(payload.SessionProperties, newVenue)
+	if err != nil { // not critical, the submission is reviewed manually anyway
+		slog.Warn("PostSession", "msg", "failed to check pending submissions for duplicates", "err", err)
+	}
+
 	var cmd string
 	var title string
 
 	var sessionJson []byte
 	if payload.VenueName != nil { // if venue fields are present in the payload, we create a new venue in the same transaction
-		venueJson, err := json.Marshal(payload.VenueProperties)
+		venueJson, err := json.Marshal(attributedVenue{payload.VenueProperties, userEmail(c.Request())})
 		if err != nil {
 			slog.Error("PostSession", "msg", err, "props", "venue")
-			return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+			return types.SessionFeature[types.SessionProperties]{}, problem(err)
 		}
 
 		// set venue to bash variable that will be evaluated as the real venue id during runtime
 		payload.Venue = ptr(int32(-999999))
-		sessionJson, err = json.Marshal(payload.SessionProperties)
+		sessionJson, err = json.Marshal(attributedSession{payload.SessionProperties, userEmail(c.Request())})
 		if err != nil {
 			slog.Error("PostSession", "msg", err, "props", "session")
-			return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+			return types.SessionFeature[types.SessionProperties]{}, problem(err)
 		}
 		sessionJson = []byte(strings.Replace(string(sessionJson), "-999999", "$new_id", -1))
 		cmd = fmt.Sprintf(`new_id=$(dbcli insert venue "%s");`+"\n"+`dbcli insert session "%s";`, venueJson, sessionJson)
 		title = fmt.Sprintf("insert_venue_%v_session_%v", *payload.VenueName, *payload.SessionName)
 		slog.Info("PostSession", "mode", "sessionAndVenue", "cmd", cmd)
 	} else {
-		sessionJson, err = json.Marshal(payload.SessionProperties)
+		sessionJson, err = json.Marshal(attributedSession{payload.SessionProperties, userEmail(c.Request())})
 		if err != nil {
 			slog.Error("PostSession", "msg", err, "props", "session")
-			return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+			return types.SessionFeature[types.SessionProperties]{}, problem(err)
 		}
 		cmd = fmt.Sprintf(`dbcli insert session "%s"`, sessionJson)
 		title = fmt.Sprintf("insert_session_%v", *payload.SessionName)
 		slog.Info("PostSession", "mode", "sessionOnly", "cmd", cmd)
 	}
 	if submissionNotes != nil {
-		cmd += "\n# submission notes: " + *submissionNotes
+		cmd += "\n# submission notes: " + migrationutils.Comment(*submissionNotes)
 	}
 	if submissionEmail != nil {
-		cmd += "\n# email: " + *submissionEmail
+		cmd += "\n# email: " + migrationutils.Comment(*submissionEmail)
+	}
+	for _, fn := range pendingDuplicates {
+		cmd += "\n# likely duplicate of pending submission " + migrationutils.Comment(fn)
+	}
+	// new venues are reviewed unless the user is a trusted contributor, sessions at existing venues can be added by their owners
+	role := requestRole(c.Request())
+	if payload.VenueName == nil {
+		role = currentPrincipal(c.Request()).RoleFor(payload.Venue)
 	}
-	if _, err := migrationutils.WriteMigration(cmd, title, migrationsDirectory); err != nil {
+	dir := migrationDirectory(role)
+	if _, err := migrationutils.WriteMigration(cmd, title, dir); err != nil {
 		slog.Error("PostSession", "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occurred")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
+	alert := alerts.Alert{Type: "new session", Name: *payload.SessionName, Notes: orFallback(submissionNotes, ""), Submitter: orFallback(submissionEmail, orFallback(userEmail(c.Request()), ""))}
+	if payload.VenueName != nil {
+		alert.Type, alert.Name = "new session and venue", fmt.Sprintf("%v at %v", *payload.SessionName, *payload.VenueName)
+	}
+	alertSubmission(alert, dir)
 	c.SetStatus(201)
 	return types.SessionFeature[types.SessionProperties]{}, nil
 }
@@ -296,26 +303,28 @@ func PatchSessionById(c *fuego.ContextWithBody[types.SessionProperties]) (types.
 	slog.Info("PatchSessionById", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.SessionFeature[types.SessionProperties]{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	payload, err := c.Body()
 	if err != nil {
-		if errors.As(err, &types.ValidationError{}) {
-			return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: err.Error()}
-		}
-		slog.Error("PatchSessionById", "id", id, "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
-	j, err := json.Marshal(payload)
+	j, err := json.Marshal(attributedSession{payload, userEmail(c.Request())})
 	if err != nil {
 		slog.Error("PatchSessionById", "id", id, "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occured")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
 	cmd := fmt.Sprintf(`dbcli update session %v "%s"`, id, j)
-	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("update_session_%v", id), migrationsDirectory); err != nil {
+	role := requestRole(c.Request())
+	if payload.Venue != nil { // moving the session to another venue is only applied if the user may edit both venues
+		role = lowerRole(role, currentPrincipal(c.Request()).RoleFor(payload.Venue))
+	}
+	dir := migrationDirectory(role)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("update_session_%v", id), dir); err != nil {
 		slog.Error("PatchSessionById", "id", id, "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occurred")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
+	alertSubmission(alerts.Alert{Type: "session update", Name: orFallback(payload.SessionName, fmt.Sprintf("session %v", id)), Submitter: orFallback(userEmail(c.Request()), "")}, dir)
 	return types.SessionFeature[types.SessionProperties]{}, nil
 }
 
@@ -324,125 +333,298 @@ type CommentBody struct {
 	Author  string `json:"author"`
 	Content string `json:"content"`
 	Rating  *int16 `json:"rating,omitempty"`
+	// optional ratings of individual aspects, stored alongside the overall rating
+	AspectRatings *types.AspectRatings `json:"aspect_ratings,omitempty"`
+	// ID of the comment this comment replies to (replies can't carry ratings)
+	ParentComment *int32 `json:"parent_comment,omitempty"`
+	// marks the reply of the verified organiser or venue, requires the venue owner role (or moderator)
+	Verified bool `json:"verified,omitempty"`
 }
 
-func PostCommentForSessionById(c *fuego.ContextWithBody[CommentBody]) (types.SessionFeature[types.SessionProperties], error) {
+func PostCommentForSessionById(c *fuego.ContextWithBody[CommentBody]) (PostCommentResponse, error) {
 	slog.Info("PostCommentForSessionById", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return PostCommentResponse{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	payload, err := c.Body()
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, err
+		return PostCommentResponse{}, problem(err)
 	}
 	payload.Session = ptr(id)
 
+	if payload.Verified {
+		venue, err := sessionVenue(ctx, int32(id))
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return PostCommentResponse{}, problem(err)
+		}
+		if !currentPrincipal(c.Request()).RoleFor(&venue).AtLeast(auth.RoleVenueOwner) {
+			return PostCommentResponse{}, fuego.ForbiddenError{Err: errors.New("verified comment without permission"), Title: "Forbidden", Detail: "Verified replies can only be posted by the owners of the venue and moderators"}
+		}
+	}
+	if payload.ParentComment != nil {
+		if payload.Rating != nil || payload.AspectRatings != nil {
+			return PostCommentResponse{}, invalidField("rating", "Replies can't carry a rating", errors.New("reply with rating"))
+		}
+		parent, err := queries.GetCommentById(ctx, *payload.ParentComment)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && (parent.Session != int32(id) || parent.Status != "visible")) {
+			detail := fmt.Sprintf("There is no comment with ID %v for session %v", *payload.ParentComment, id)
+			return PostCommentResponse{}, invalidField("parent_comment", detail, errors.New(detail))
+		} else if err != nil {
+			return PostCommentResponse{}, problem(err)
+		}
+	}
+
+	// comments that look like spam are stored, but held back until a moderator approves them
+	verdict := scoreComment(c.Request(), id, payload)
+	token, tokenHash, err := newEditToken()
+	if err != nil {
+		return PostCommentResponse{}, problem(err)
+	}
+	comment := commentPayload{CommentBody: payload, EditTokenHash: tokenHash, UserEmail: userEmail(c.Request())}
+	if verdict.Score > 0 {
+		comment.SpamScore = ptr(verdict.Score)
+	}
+	if verdict.Held() {
+		comment.Status = "pending"
+	}
+
 	var cmd string
-	if payload.Rating != nil {
+	if payload.Rating != nil || payload.AspectRatings != nil {
 
-		ratingPayload := dbutils.InsertSessionRatingParams{
-			Session: int32(id),
-			Rating:  payload.Rating,
-			Comment: ptr(int32(-999999)),
+		if payload.AspectRatings != nil {
+			if err := payload.AspectRatings.Validate(); err != nil {
+				return PostCommentResponse{}, problem(err)
+			}
+		}
+		// same keys as dbutils.InsertSessionRatingParams, aspects that haven't been rated are left out
+		ratingPayload := struct {
+			Session int32  `json:"session"`
+			Rating  *int16 `json:"rating"`
+			Comment *int32 `json:"comment"`
+			*types.AspectRatings
+			UserEmail *string `json:"user_email,omitempty"`
+		}{
+			Session:       int32(id),
+			Rating:        payload.Rating,
+			Comment:       ptr(int32(-999999)),
+			AspectRatings: payload.AspectRatings,
+			UserEmail:     comment.UserEmail,
 		}
 		ratingJson, err := json.Marshal(ratingPayload)
 		if err != nil {
-			return types.SessionFeature[types.SessionProperties]{}, err
+			return PostCommentResponse{}, problem(err)
 		}
 		ratingJson = []byte(strings.Replace(string(ratingJson), "-999999", "$new_comment", -1))
 
-		payload.Rating = nil
-		commentJson, err := json.Marshal(payload)
+		comment.Rating, comment.AspectRatings = nil, nil
+		commentJson, err := json.Marshal(comment)
 		if err != nil {
-			return types.SessionFeature[types.SessionProperties]{}, err
+			return PostCommentResponse{}, problem(err)
 		}
 
 		cmd = fmt.Sprintf(`new_comment=$(dbcli insert comment "%s");`+"\n"+`dbcli insert rating "%s";`, commentJson, ratingJson)
 		slog.Info("PostCommentForSessionById", "mode", "commentAndRating", "cmd", cmd)
 	} else {
-		commentJson, err := json.Marshal(payload)
+		commentJson, err := json.Marshal(comment)
 		if err != nil {
-			return types.SessionFeature[types.SessionProperties]{}, err
+			return PostCommentResponse{}, problem(err)
 		}
 		cmd = fmt.Sprintf(`dbcli insert comment "%s";`, commentJson)
 		slog.Info("PostCommentForSessionById", "mode", "commentOnly", "cmd", cmd)
 	}
+	if verdict.Held() {
+		// the note is appended as a comment after the last subcommand
+		cmd = strings.TrimSuffix(cmd, ";") + fmt.Sprintf("\n# held for moderation (spam score %.1f): %s", verdict.Score, strings.Join(verdict.Reasons, ", "))
+	}
 	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("insert_comment_session_%v", id), migrationsDirectory); err != nil {
 		slog.Error("PostCommentForSessionById", "id", id, "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occurred")
+		return PostCommentResponse{}, problem(err)
 	}
+	alert := alerts.Alert{Type: "comment", Name: fmt.Sprintf("session %v", id), Submitter: payload.Author}
+	if verdict.Held() {
+		// held comments have to be published by a moderator after the migration has been applied
+		alert.Type, alert.Notes = "comment (held for moderation)", strings.Join(verdict.Reasons, ", ")
+		alert.ReviewURL = fmt.Sprintf("%v/v1/admin/comments", strings.TrimSuffix(publicUrl, "/"))
+	}
+	alertSubmission(alert, migrationsDirectory)
 	c.SetStatus(201)
-	return types.SessionFeature[types.SessionProperties]{}, nil
+	res := PostCommentResponse{EditToken: token, Status: "visible"}
+	if verdict.Held() {
+		res.Status = "pending"
+	}
+	return res, nil
 }
 
 func PostSuggestionsForSessionById(c *fuego.ContextWithBody[CommentBody]) (types.SessionFeature[types.SessionProperties], error) {
 	slog.Info("PostSuggestionsSessionById", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.SessionFeature[types.SessionProperties]{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 
 	body, err := c.Body()
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, err
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
 
 	fp := filepath.Join(suggestionsDirectory, fmt.Sprintf("%v_session_%v", time.Now().Format(time.RFC3339), (id)))
 	slog.Info("writing suggestion", "filepath", fp)
 	os.WriteFile(fp, []byte(fmt.Sprintf("Session %v: %v", id, body)), fs.FileMode(int(0755)))
+	alertSubmission(alerts.Alert{Type: "suggestion", Name: fmt.Sprintf("session %v", id), Notes: body.Content, Submitter: body.Author}, suggestionsDirectory)
 	return types.SessionFeature[types.SessionProperties]{}, nil
 }
 
-func GetCommentsBySessionId(c *fuego.ContextNoBody) ([]dbutils.GetCommentsBySessionIdRow, error) {
+func GetCommentsBySessionId(c *fuego.ContextNoBody) ([]types.Comment, error) {
 	slog.Info("GetCommentsBySessionId", "id", c.PathParam("id"))
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return []dbutils.GetCommentsBySessionIdRow{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}/comments'), got: %v", c.PathParam("id"))}
+		return []types.Comment{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}/comments'), got: %v", c.PathParam("id")))
+	}
+	list, err := parseListParams(c, commentSortOptions, nil)
+	if err != nil {
+		return []types.Comment{}, err
+	}
+	view := c.QueryParam("view")
+	if view != "" && view != "flat" && view != "tree" {
+		return []types.Comment{}, invalidParam("view", fmt.Sprintf("'view' must be either 'flat' or 'tree', got: %v", view))
+	}
+	params := dbutils.GetCommentsPageBySessionIdParams{
+		Session:    int32(id),
+		PageLimit:  list.Limit,
+		Sort:       list.Sort,
+		Descending: list.Descending,
+	}
+	if v := c.QueryParam("parent_comment"); v != "" {
+		parent, err := strconv.Atoi(v)
+		if err != nil {
+			return []types.Comment{}, invalidParam("parent_comment", fmt.Sprintf("Please provide a numeric comment ID, got: %v", v))
+		}
+		params.ParentComment = ptr(int32(parent))
+	}
+	// a session without comments returns an empty list too
+	if _, err := queries.GetSessionById(ctx, int32(id)); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []types.Comment{}, notFound("session", id, err)
+		}
+		slog.Error("GetCommentsBySessionId", "id", id, "err", err)
+		return []types.Comment{}, problem(err)
 	}
-	res, err := queries.GetCommentsBySessionId(ctx, int32(id))
+	// in the tree view, pages consist of threads (top-level comments or the direct replies to parent_comment)
+	params.TopLevel = view == "tree" && params.ParentComment == nil
+	if list.Cursor != nil {
+		params.CursorID = &list.Cursor.ID
+		params.CursorSortNum = list.Cursor.sortNum()
+	}
+	rows, err := queries.GetCommentsPageBySessionId(ctx, params)
 	if err != nil {
 		slog.Error("GetCommentsBySessionId", "id", id, "err", err)
-		return []dbutils.GetCommentsBySessionIdRow{}, errors.New("an unknown error occured")
+		return []types.Comment{}, problem(err)
+	}
+	// the query returns one extra row if there is a next page
+	if list.Limit != nil && len(rows) > int(*list.Limit) {
+		rows = rows[:*list.Limit]
+		last := rows[len(rows)-1]
+		nextLink(c, newCursor(c.QueryParam("sort"), last.SortNum, nil, last.CommentID))
+	}
+	res := make([]types.Comment, len(rows))
+	for i, r := range rows {
+		res[i] = types.Comment{CommentID: r.CommentID, Session: r.Session, Author: r.Author, Content: r.Content, Rating: r.Rating, RatingID: r.RatingID, ParentComment: r.ParentComment, Verified: r.Verified, ReplyCount: r.ReplyCount}
+		if r.DtPosted.Valid {
+			res[i].DtPosted = &r.DtPosted.Time
+		}
+		if r.DtEdited.Valid {
+			res[i].DtEdited = &r.DtEdited.Time
+		}
+	}
+	if view == "tree" && len(res) > 0 {
+		ids := make([]int32, len(res))
+		for i, comment := range res {
+			ids[i] = comment.CommentID
+		}
+		rows, err := queries.GetCommentReplies(ctx, ids)
+		if err != nil {
+			slog.Error("GetCommentsBySessionId", "id", id, "err", err)
+			return []types.Comment{}, problem(err)
+		}
+		replies := make([]types.Comment, len(rows))
+		for i, r := range rows {
+			replies[i] = types.Comment{CommentID: r.CommentID, Session: r.Session, Author: r.Author, Content: r.Content, Rating: r.Rating, RatingID: r.RatingID, ParentComment: r.ParentComment, Verified: r.Verified, ReplyCount: r.ReplyCount}
+			if r.DtPosted.Valid {
+				replies[i].DtPosted = &r.DtPosted.Time
+			}
+			if r.DtEdited.Valid {
+				replies[i].DtEdited = &r.DtEdited.Time
+			}
+		}
+		nestReplies(res, replies)
 	}
 	return res, nil
 }
 
+// maximum number of replies nested under a comment in the tree view
+const maxNestedReplies = 10
+
+// nestReplies attaches the replies (oldest first) to the comments they respond to, recursively.
+// Only the first maxNestedReplies replies of every comment are included, the reply_count tells clients whether there are more.
+func nestReplies(comments []types.Comment, replies []types.Comment) {
+	children := make(map[int32][]types.Comment)
+	for _, r := range replies {
+		if r.ParentComment != nil {
+			children[*r.ParentComment] = append(children[*r.ParentComment], r)
+		}
+	}
+	var nest func(c *types.Comment)
+	nest = func(c *types.Comment) {
+		cs := children[c.CommentID]
+		if len(cs) == 0 {
+			return
+		}
+		c.Replies = append([]types.Comment(nil), cs[:min(len(cs), maxNestedReplies)]...)
+		for i := range c.Replies {
+			nest(&c.Replies[i])
+		}
+	}
+	for i := range comments {
+		nest(&comments[i])
+	}
+}
+
 func DeleteSessionById(c *fuego.ContextNoBody) (types.SessionFeature[types.SessionProperties], error) {
 	slog.Info("DeleteSessionById", "id", c.PathParam("id"))
 
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.SessionFeature[types.SessionProperties]{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.SessionFeature[types.SessionProperties]{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	cmd := fmt.Sprintf("dbcli delete session %v", id)
-	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("delete_session_%v", id), migrationsDirectory); err != nil {
+	dir := migrationDirectory(requestRole(c.Request()))
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("delete_session_%v", id), dir); err != nil {
 
 		slog.Error("DeleteSessionById", "id", id, "msg", err)
-		return types.SessionFeature[types.SessionProperties]{}, errors.New("an unknown error occurred")
+		return types.SessionFeature[types.SessionProperties]{}, problem(err)
 	}
+	alertSubmission(alerts.Alert{Type: "session deletion", Name: fmt.Sprintf("session %v", id), Submitter: orFallback(userEmail(c.Request()), "")}, dir)
 	return types.SessionFeature[types.SessionProperties]{}, nil
 }
 
 func PostVenue(c *fuego.ContextWithBody[types.VenueProperties]) (types.VenueFeature, error) {
 	payload, err := c.Body()
 	if err != nil {
-		slog.Error("PostVenue", "msg", err)
-		if errors.As(err, &types.ValidationError{}) {
-			return types.VenueFeature{}, fuego.BadRequestError{Detail: err.Error()}
-		}
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
-	j, err := json.Marshal(payload)
+	j, err := json.Marshal(attributedVenue{payload, userEmail(c.Request())})
 	if err != nil {
 		slog.Error("PostVenue", "msg", err)
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
 	cmd := fmt.Sprintf(`dbcli insert venue "%s"`, j)
-	if _, err := migrationutils.WriteMigration(cmd, "insert_venue_"+*payload.VenueName, migrationsDirectory); err != nil {
+	dir := migrationDirectory(requestRole(c.Request()))
+	if _, err := migrationutils.WriteMigration(cmd, "insert_venue_"+*payload.VenueName, dir); err != nil {
 		slog.Error("PostVenue", "msg", err)
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
+	alertSubmission(alerts.Alert{Type: "new venue", Name: *payload.VenueName, Submitter: orFallback(userEmail(c.Request()), "")}, dir)
 	c.SetStatus(201)
 	return types.VenueFeature{}, nil
 
@@ -453,26 +635,24 @@ func PatchVenueById(c *fuego.ContextWithBody[types.VenueProperties]) (types.Venu
 
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.VenueFeature{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.VenueFeature{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	payload, err := c.Body()
 	if err != nil {
-		slog.Error("PatchVenueById", "id", id, "msg", err)
-		if errors.As(err, &types.ValidationError{}) {
-			return types.VenueFeature{}, fuego.BadRequestError{Detail: err.Error()}
-		}
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
-	j, err := json.Marshal(payload)
+	j, err := json.Marshal(attributedVenue{payload, userEmail(c.Request())})
 	if err != nil {
 		slog.Error("PatchVenueById", "id", id, "msg", err)
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
 	cmd := fmt.Sprintf(`dbcli update venue %v "%s"`, id, j)
-	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("update_venue_%v", id), migrationsDirectory); err != nil {
+	dir := migrationDirectory(requestRole(c.Request()))
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("update_venue_%v", id), dir); err != nil {
 		slog.Error("PatchVenueById", "msg", err)
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
+	alertSubmission(alerts.Alert{Type: "venue update", Name: orFallback(payload.VenueName, fmt.Sprintf("venue %v", id)), Submitter: orFallback(userEmail(c.Request()), "")}, dir)
 	return types.VenueFeature{}, nil
 }
 
@@ -481,12 +661,14 @@ func DeleteVenueById(c *fuego.ContextNoBody) (types.VenueFeature, error) {
 
 	id, err := strconv.Atoi(c.PathParam("id"))
 	if err != nil {
-		return types.VenueFeature{}, fuego.BadRequestError{Detail: fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id"))}
+		return types.VenueFeature{}, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/jamsession/{id}'), got: %v", c.PathParam("id")))
 	}
 	cmd := fmt.Sprintf("dbcli delete venue %v", id)
-	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("delete_venue_%v", id), migrationsDirectory); err != nil {
+	dir := migrationDirectory(requestRole(c.Request()))
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("delete_venue_%v", id), dir); err != nil {
 		slog.Error("DeleteVenueById", "msg", err)
-		return types.VenueFeature{}, errors.New("an unknown error occured")
+		return types.VenueFeature{}, problem(err)
 	}
+	alertSubmission(alerts.Alert{Type: "venue deletion", Name: fmt.Sprintf("venue %v", id), Submitter: orFallback(userEmail(c.Request()), "")}, dir)
 	return types.VenueFeature{}, nil
 }
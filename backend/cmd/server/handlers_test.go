@@ -1,31 +1,79 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	geom "github.com/twpayne/go-geom"
 
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/apikeys"
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
 	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	"github.com/felix-schott/jamsessions/backend/internal/moderation"
 	"github.com/felix-schott/jamsessions/backend/internal/types"
 	"github.com/go-fuego/fuego"
 )
 
-var s *fuego.Server = fuego.NewServer(fuego.WithAddr("localhost:66666"))
+var s *fuego.Server = fuego.NewServer(fuego.WithAddr("localhost:66666"), fuego.WithErrorHandler(ProblemErrorHandler), fuego.WithSerializer(Serialize))
+
+// helper func - returns a server with all routes registered and its OpenAPI spec
+func newTestServerWithSpec() (*fuego.Server, *openapi3.T) {
+	srv := fuego.NewServer(fuego.WithoutLogger(), fuego.WithErrorHandler(ProblemErrorHandler), fuego.WithSerializer(Serialize), fuego.WithOpenAPIConfig(fuego.OpenAPIConfig{DisableSwagger: true, DisableLocalSave: true}))
+	registerRoutes(srv)
+	spec := srv.OutputOpenAPISpec()
+	return srv, &spec
+}
+
+// helper func - validates the response against the schema of the route (e.g. '/v1/venues/{id}') in the OpenAPI spec
+func checkOpenAPIConformance(t *testing.T, spec *openapi3.T, route string, req *http.Request, res *http.Response) {
+	t.Helper()
+	pathItem := spec.Paths.Find(route)
+	if pathItem == nil || pathItem.GetOperation(req.Method) == nil {
+		t.Fatalf("route %v %v is not part of the OpenAPI spec", req.Method, route)
+	}
+	err := openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request: req,
+			Route:   &routers.Route{Spec: spec, Path: route, PathItem: pathItem, Method: req.Method, Operation: pathItem.GetOperation(req.Method)},
+		},
+		Status:  res.StatusCode,
+		Header:  res.Header,
+		Body:    res.Body,
+		Options: &openapi3filter.Options{IncludeResponseStatus: true, MultiError: true},
+	})
+	if err != nil {
+		t.Errorf("the response to %v %v doesn't conform to the OpenAPI spec: %v", req.Method, req.URL, err)
+	}
+}
 
 // helper func - check if the returned feature collection contains the sessionIds provided
 func checkResultSetForSessionIds(t *testing.T, sessionIds []int32, body types.SessionFeatureCollection) {
@@ -52,6 +100,25 @@ func checkResultSetForSessionIds(t *testing.T, sessionIds []int32, body types.Se
 	}
 }
 
+// helper func - checks that the response is a problem (RFC 7807) with the expected status and returns the decoded body
+func checkProblem(t *testing.T, res *http.Response, expStatus int) fuego.HTTPError {
+	t.Helper()
+	var body fuego.HTTPError
+	if res.StatusCode != expStatus {
+		t.Errorf("expected status code %v, got %v", expStatus, res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected content type application/problem+json, got %v", ct)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode problem: %v", err)
+	}
+	if body.Status != expStatus {
+		t.Errorf("expected the problem status to be %v, got %v", expStatus, body.Status)
+	}
+	return body
+}
+
 func TestHandlers(t *testing.T) {
 
 	slog.SetLogLoggerLevel(slog.LevelError) // change this to see more log informations
@@ -480,6 +547,123 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("GetSessionByIdNotFound", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessionById)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions/999999", nil)
+		req.SetPathValue("id", "999999")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		body := checkProblem(t, res, 404)
+		if !strings.Contains(body.Detail, "999999") {
+			t.Errorf("expected the detail to contain the requested ID, got %v", body.Detail)
+		}
+	})
+
+	t.Run("GetVenueByIdNotFound", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetVenueById)
+		req := httptest.NewRequest(http.MethodGet, "/venues/999999", nil)
+		req.SetPathValue("id", "999999")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		checkProblem(t, res, 404)
+	})
+
+	t.Run("GetSessionsByVenueIdNotFound", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessionsByVenueId)
+		req := httptest.NewRequest(http.MethodGet, "/venues/999999/jamsessions", nil)
+		req.SetPathValue("id", "999999")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		body := checkProblem(t, res, 404)
+		if !strings.Contains(body.Detail, "venue with ID 999999") {
+			t.Errorf("expected the detail to contain the requested ID, got %v", body.Detail)
+		}
+	})
+
+	t.Run("GetCommentsBySessionIdNotFound", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetCommentsBySessionId)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions/999999/comments", nil)
+		req.SetPathValue("id", "999999")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		body := checkProblem(t, res, 404)
+		if !strings.Contains(body.Detail, "session with ID 999999") {
+			t.Errorf("expected the detail to contain the requested ID, got %v", body.Detail)
+		}
+	})
+
+	t.Run("GetVenueById", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetVenueById)
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/venues/%v", testVenueId), nil)
+		req.SetPathValue("id", fmt.Sprint(testVenueId))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body types.VenueFeature
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if body.Properties.VenueID == nil || *body.Properties.VenueID != testVenueId {
+			t.Errorf("expected venue %v to be returned, got %+v", testVenueId, body.Properties)
+		}
+	})
+
+	t.Run("GetSessionByIdInvalidId", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessionById)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions/abc", nil)
+		req.SetPathValue("id", "abc")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		body := checkProblem(t, res, 400)
+		if len(body.Errors) != 1 || body.Errors[0].More["parameter"] != "id" {
+			t.Errorf("expected the problem to point to the parameter 'id', got %+v", body.Errors)
+		}
+	})
+
+	t.Run("PostSessionInvalidFields", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		for body, pointer := range map[string]string{
+			`{"session_name":"TestInvalid","venue":1,"interval":"Never","start_time_utc":"2024-10-16T00:00:00.000Z","duration_minutes":60}`:      "/interval",
+			`{"session_name":"TestInvalid","venue":1,"interval":"Weekly","start_time_utc":"2024-10-16T00:00:00.000Z","duration_minutes":"long"}`: "/duration_minutes",
+			`{"session_name":"TestInvalid","venue":1,"interval":"Weekly","genres":["Polka"]}`:                                                    "/genres",
+		} {
+			handler := fuego.HTTPHandler(s, PostSession)
+			req := httptest.NewRequest(http.MethodPost, "/jamsessions", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			res := w.Result()
+			problem := checkProblem(t, res, 400)
+			res.Body.Close()
+			if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != pointer {
+				t.Errorf("expected the problem to point to %v, got %+v", pointer, problem.Errors)
+			}
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Fatalf("couldn't read directory contents: %v", err)
+		}
+		if len(dir) != 0 {
+			t.Errorf("expected no migration to be written, got %v files", len(dir))
+		}
+	})
+
 	t.Run("PostCommentForSessionById", func(t *testing.T) {
 
 		// temp directory for migrations
@@ -512,7 +696,15 @@ func TestHandlers(t *testing.T) {
 			t.Errorf("error reading file: %v", err)
 		}
 
-		matched, err := regexp.Match(fmt.Sprintf(`.*dbcli insert comment "{\\"session\\":%v,\\"author\\":\\"\\",\\"content\\":\\"%v\\"}"`, testSession1Id, testComment), f)
+		// only the hash of the edit token is stored
+		var created PostCommentResponse
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(created.EditToken) != 64 || created.Status != "visible" {
+			t.Errorf("expected an edit token and status 'visible', got %+v", created)
+		}
+		matched, err := regexp.Match(fmt.Sprintf(`.*dbcli insert comment "{\\"session\\":%v,\\"author\\":\\"\\",\\"content\\":\\"%v\\",\\"edit_token_hash\\":\\"%v\\"}"`, testSession1Id, testComment, hashEditToken(created.EditToken)), f)
 		if err != nil {
 			t.Errorf("error when trying match with regex: %v", err)
 		}
@@ -555,7 +747,7 @@ func TestHandlers(t *testing.T) {
 			t.Errorf("error reading file: %v", err)
 		}
 
-		matched, err := regexp.Match(fmt.Sprintf(`.*dbcli insert comment "{\\"session\\":%v,\\"author\\":\\"\\",\\"content\\":\\"%v\\"}".*\n.*dbcli insert rating "{\\"session\\":%v,\\"rating\\":4,\\"comment\\":\$new_comment}";`, testSession2Id, testComment, testSession2Id), f)
+		matched, err := regexp.Match(fmt.Sprintf(`.*dbcli insert comment "{\\"session\\":%v,\\"author\\":\\"\\",\\"content\\":\\"%v\\",\\"edit_token_hash\\":\\"[0-9a-f]{64}\\"}".*\n.*dbcli insert rating "{\\"session\\":%v,\\"rating\\":4,\\"comment\\":\$new_comment}";`, testSession2Id, testComment, testSession2Id), f)
 		if err != nil {
 			t.Errorf("error when trying match with regex: %v", err)
 		}
@@ -566,26 +758,12 @@ func TestHandlers(t *testing.T) {
 		// see internal/db/cli for cli tests
 	})
 
-	t.Run("PostSession", func(t *testing.T) {
-		// temp directory for migrations
+	t.Run("PostCommentWithAspectRatings", func(t *testing.T) {
 		migrationsDirectory = t.TempDir()
 
-		testBody, err := json.Marshal(types.SessionProperties{
-			SessionName:     ptr("TestInsert"),
-			Venue:           &testVenueId,
-			Description:     ptr("Description."),
-			StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
-			DurationMinutes: ptr(int16(90)),
-			Interval:        ptr(types.FirstOfMonth),
-			SessionWebsite:  ptr("https://example.org"),
-		})
-		if err != nil {
-			t.Error("could not marshal json:", err)
-			t.FailNow()
-		}
-
-		handler := fuego.HTTPHandler(s, PostSession)
-		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+		handler := fuego.HTTPHandler(s, PostCommentForSessionById)
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", testSession2Id), strings.NewReader(`{"content": "Great house band", "aspect_ratings": {"house_band": 5, "sound": 2}}`))
+		req.SetPathValue("id", fmt.Sprint(testSession2Id))
 		w := httptest.NewRecorder()
 		handler(w, req)
 		res := w.Result()
@@ -594,181 +772,2724 @@ func TestHandlers(t *testing.T) {
 		}
 
 		dir, err := os.ReadDir(migrationsDirectory)
-		if err != nil {
-			t.Errorf("couldn't read directory contents: %v", err)
-			t.FailNow()
-		}
-		if len(dir) != 1 {
-			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
-			t.FailNow()
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
 		}
-
 		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
 		if err != nil {
 			t.Errorf("error reading file: %v", err)
 		}
-
-		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*}"$`, "TestInsert"), f)
-		if err != nil {
-			t.Errorf("error when trying match with regex: %v", err)
+		expected := fmt.Sprintf(`dbcli insert rating "{\"session\":%v,\"rating\":null,\"comment\":$new_comment,\"house_band\":5,\"sound\":2}";`, testSession2Id)
+		if !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
+		}
+		if strings.Contains(string(f), "aspect_ratings") {
+			t.Errorf("expected the aspect ratings to be left out of the comment, got %s", f)
 		}
 
-		if !matched {
-			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		// aspect ratings must be between 1 and 5
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", testSession2Id), strings.NewReader(`{"content": "...", "rating": 4, "aspect_ratings": {"crowd": 6}}`))
+		req.SetPathValue("id", fmt.Sprint(testSession2Id))
+		w = httptest.NewRecorder()
+		handler(w, req)
+		problem := checkProblem(t, w.Result(), 400)
+		if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != "/aspect_ratings/crowd" {
+			t.Errorf("expected the problem to point to /aspect_ratings/crowd, got %+v", problem.Errors)
 		}
 	})
 
-	t.Run("PostSessionWithSubmissionNotes", func(t *testing.T) {
-		// temp directory for migrations
+	t.Run("PostCommentReply", func(t *testing.T) {
 		migrationsDirectory = t.TempDir()
-
-		testBody, err := json.Marshal(types.SessionPropertiesWithVenuePOST{
-			SessionProperties: types.SessionProperties{SessionName: ptr("TestInsert"),
-				Venue:           &testVenueId,
-				Description:     ptr("Description."),
-				StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
-				DurationMinutes: ptr(int16(90)),
-				Interval:        ptr(types.FirstOfMonth),
-				SessionWebsite:  ptr("https://example.org"),
-			},
-			SubmissionNotes: ptr("I run the session"),
-			SubmissionEmail: ptr("john.doe@example.com")})
+		parent, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession2Id, Content: "Is there a drum kit?"})
 		if err != nil {
-			t.Error("could not marshal json:", err)
-			t.FailNow()
+			t.Fatalf("could not insert comment: %v", err)
 		}
 
-		handler := fuego.HTTPHandler(s, PostSession)
-		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
-		w := httptest.NewRecorder()
-		handler(w, req)
-		res := w.Result()
-		if res.StatusCode != 201 {
-			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		handler := fuego.HTTPHandler(s, PostCommentForSessionById)
+		post := func(session int32, body string, token string) *http.Response {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", session), strings.NewReader(body))
+			req.SetPathValue("id", fmt.Sprint(session))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Result()
 		}
 
-		dir, err := os.ReadDir(migrationsDirectory)
-		if err != nil {
-			t.Errorf("couldn't read directory contents: %v", err)
-			t.FailNow()
+		// replies of the organiser must be posted with the admin token
+		adminToken = "secret"
+		defer func() { adminToken = "" }()
+		body := fmt.Sprintf(`{"content": "Yes, bring sticks", "parent_comment": %v, "verified": true}`, parent)
+		if res := post(testSession2Id, body, ""); res.StatusCode != 403 {
+			t.Errorf("expected status code 403 for a verified reply without token, got %v", res.StatusCode)
 		}
-		if len(dir) != 1 {
-			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
-			t.FailNow()
+		if res := post(testSession2Id, body, "secret"); res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
 		}
-
 		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
 		if err != nil {
 			t.Errorf("error reading file: %v", err)
 		}
-
-		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*\n# submission notes: I run the session\n# email: john.doe@example.com`, "TestInsert"), f)
-		if err != nil {
-			t.Errorf("error when trying match with regex: %v", err)
+		expected := fmt.Sprintf(`dbcli insert comment "{\\"session\\":%v,\\"author\\":\\"\\",\\"content\\":\\"Yes, bring sticks\\",\\"parent_comment\\":%v,\\"verified\\":true,\\"edit_token_hash\\":\\"[0-9a-f]{64}\\"}";`, testSession2Id, parent)
+		if matched, _ := regexp.Match(expected, f); !matched {
+			t.Errorf("expected the migration to match %s, got %s", expected, f)
+		}
+
+		// the parent must belong to the same session, replies can't be rated
+		for _, tc := range []struct {
+			session int32
+			body    string
+			pointer string
+		}{
+			{testSession1Id, fmt.Sprintf(`{"content": "...", "parent_comment": %v}`, parent), "/parent_comment"},
+			{testSession2Id, `{"content": "...", "parent_comment": 999999}`, "/parent_comment"},
+			{testSession2Id, fmt.Sprintf(`{"content": "...", "parent_comment": %v, "rating": 5}`, parent), "/rating"},
+		} {
+			problem := checkProblem(t, post(tc.session, tc.body, ""), 400)
+			if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != tc.pointer {
+				t.Errorf("expected the problem to point to %v, got %+v", tc.pointer, problem.Errors)
+			}
 		}
+	})
 
-		if !matched {
-			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+	t.Run("PostCommentHeldForModeration", func(t *testing.T) {
+		migrationsDirectory = t.TempDir()
+		spamScorer = moderation.NewLocalScorer(moderation.DefaultBlocklist, nil)
+		defer func() { spamScorer = nil }()
+
+		handler := fuego.HTTPHandler(s, PostCommentForSessionById)
+		for _, content := range []string{"Lovely jam, see you next week", "Casino bonus https://spam.example https://spam.example/2"} {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", testSession1Id), strings.NewReader(fmt.Sprintf(`{"content": %q}`, content)))
+			req.SetPathValue("id", fmt.Sprint(testSession1Id))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if res := w.Result(); res.StatusCode != 201 {
+				t.Errorf("expected status code 201, got %v", res.StatusCode)
+			}
+		}
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 2 {
+			t.Fatalf("expected exactly 2 files in the directory, got %v (err: %v)", len(dir), err)
+		}
+		var held, published int
+		for _, entry := range dir {
+			f, err := os.ReadFile(filepath.Join(migrationsDirectory, entry.Name()))
+			if err != nil {
+				t.Errorf("error reading file: %v", err)
+			}
+			switch {
+			case strings.Contains(string(f), `\"status\":\"pending\"`) && strings.Contains(string(f), "# held for moderation (spam score 1.1): 2 links, blocklisted words: casino"):
+				held++
+			case !strings.Contains(string(f), "status") && !strings.Contains(string(f), "spam_score"):
+				published++
+			default:
+				t.Errorf("unexpected migration %s", f)
+			}
+		}
+		if held != 1 || published != 1 {
+			t.Errorf("expected one held and one published comment, got %v held and %v published", held, published)
 		}
 	})
 
-	t.Run("PostSessionAndVenue", func(t *testing.T) {
-		// temp directory for migrations
+	t.Run("PostReportForComment", func(t *testing.T) {
 		migrationsDirectory = t.TempDir()
-
-		testBody, err := json.Marshal(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
-			SessionName:     ptr("TestInsert2"),
-			Description:     ptr("Description."),
-			StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
-			DurationMinutes: ptr(int16(90)),
-			Interval:        ptr(types.FirstOfMonth),
-			SessionWebsite:  ptr("https://example.org"),
-		},
-			VenueProperties: types.VenueProperties{
-				VenueName:        ptr("VenueInsert"),
-				AddressFirstLine: ptr("1 Random St"),
-				City:             ptr("Randomtown"),
-				Postcode:         ptr("ABC 123"),
-				VenueWebsite:     ptr("https://example.org"),
-				Backline:         &[]types.Backline{types.PA, types.Drums},
-			},
-		})
+		visible, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession1Id, Content: "Reported comment"})
 		if err != nil {
-			t.Error("could not marshal json:", err)
-			t.FailNow()
+			t.Fatalf("could not insert comment: %v", err)
 		}
-
-		handler := fuego.HTTPHandler(s, PostSession)
-		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
-		w := httptest.NewRecorder()
-		handler(w, req)
-		res := w.Result()
-		if res.StatusCode != 201 {
-			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		pending, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession1Id, Content: "Held comment", Status: ptr("pending")})
+		if err != nil {
+			t.Fatalf("could not insert comment: %v", err)
 		}
 
-		dir, err := os.ReadDir(migrationsDirectory)
-		if err != nil {
-			t.Errorf("couldn't read directory contents: %v", err)
-			t.FailNow()
+		handler := fuego.HTTPHandler(s, PostReportForCommentById)
+		post := func(id int32, body string) *http.Response {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/comments/%v/reports", id), strings.NewReader(body))
+			req.SetPathValue("id", fmt.Sprint(id))
+			req.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Result()
 		}
-		if len(dir) != 1 {
-			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
-			t.FailNow()
+
+		problem := checkProblem(t, post(visible, `{"reason": "boring"}`), 400)
+		if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != "/reason" {
+			t.Errorf("expected the problem to point to /reason, got %+v", problem.Errors)
 		}
+		checkProblem(t, post(pending, `{"reason": "spam"}`), 404)
+		checkProblem(t, post(999999, `{"reason": "spam"}`), 404)
 
+		memory := &alerts.MemoryNotifier{}
+		alerter = memory
+		defer func() { alerter = nil }()
+		if res := post(visible, `{"reason": "off_topic", "details": "not about the session"}`); res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+		pendingAlerts.Wait()
+		if sent := memory.Alerts(); len(sent) != 1 || sent[0].Type != "comment report" || sent[0].Notes != "off_topic: not about the session" {
+			t.Errorf("expected an alert about the report, got %+v", sent)
+		}
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
 		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
 		if err != nil {
 			t.Errorf("error reading file: %v", err)
 		}
-
-		matched, err := regexp.Match(fmt.Sprintf(`new_id=\$\(dbcli insert venue "{\\"venue_name\\":\\"%v\\",.*\n.*\\"session_name\\":\\"%v\\",.*`, "VenueInsert", "TestInsert2"), f)
-		if err != nil {
-			t.Errorf("error when trying match with regex: %v", err)
-		}
-
-		if !matched {
-			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		expected := fmt.Sprintf(`dbcli insert report "{\"comment\":%v,\"reason\":\"off_topic\",\"details\":\"not about the session\",\"reporter_hash\":\"%v\"}";`, visible, moderation.HashIP(ipHashSalt, "192.0.2.1"))
+		if !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
 		}
 	})
 
-	t.Run("PostSessionAltPayload", func(t *testing.T) {
-		// temp directory for migrations
+	t.Run("ModerateComments", func(t *testing.T) {
 		migrationsDirectory = t.TempDir()
+		pending, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession3Id, Content: "Buy now", Status: ptr("pending"), SpamScore: ptr(float32(1.5))})
+		if err != nil {
+			t.Fatalf("could not insert comment: %v", err)
+		}
 
-		testBody := `{"session_name":"TEST session 123","description":"dafdsc dsd.","interval":"Weekly","start_time_utc":"2024-10-16T00:00:00.000Z","duration_minutes":60,"genres":[],"session_website":"https://example.org/"}`
-
-		handler := fuego.HTTPHandler(s, PostSession)
-		req := httptest.NewRequest(http.MethodPost, "/jamsessions", strings.NewReader(testBody))
+		// held comments are not served by the public endpoints
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/jamsessions/%v/comments", testSession3Id), nil)
+		req.SetPathValue("id", fmt.Sprint(testSession3Id))
 		w := httptest.NewRecorder()
-		handler(w, req)
-		res := w.Result()
-		if res.StatusCode != 201 {
-			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		fuego.HTTPHandler(s, GetCommentsBySessionId)(w, req)
+		if strings.Contains(w.Body.String(), "Buy now") {
+			t.Errorf("expected the held comment to be left out, got %s", w.Body.String())
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/admin/comments", nil)
+		w = httptest.NewRecorder()
+		fuego.HTTPHandler(s, GetModeratedComments)(w, req)
+		var queue []dbutils.GetModeratedCommentsRow
+		if err := json.NewDecoder(w.Result().Body).Decode(&queue); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		var found bool
+		for _, c := range queue {
+			if c.CommentID == pending {
+				found = true
+			}
+			if c.Status != "pending" {
+				t.Errorf("expected only pending comments by default, got %+v", c)
+			}
+		}
+		if !found {
+			t.Errorf("expected the held comment to be in the moderation queue, got %+v", queue)
 		}
 
-		dir, err := os.ReadDir(migrationsDirectory)
-		if err != nil {
-			t.Errorf("couldn't read directory contents: %v", err)
-			t.FailNow()
+		handler := fuego.HTTPHandler(s, PutCommentStatusById)
+		put := func(id int32, body string) *http.Response {
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/admin/comments/%v/status", id), strings.NewReader(body))
+			req.SetPathValue("id", fmt.Sprint(id))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Result()
 		}
-		if len(dir) != 1 {
-			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
-			t.FailNow()
+		checkProblem(t, put(pending, `{"status": "deleted"}`), 400)
+		checkProblem(t, put(999999, `{"status": "visible"}`), 404)
+		if res := put(pending, `{"status": "visible"}`); res.StatusCode != 200 {
+			t.Errorf("expected status code 200, got %v", res.StatusCode)
+		}
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
 		}
-
 		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
 		if err != nil {
 			t.Errorf("error reading file: %v", err)
 		}
+		if expected := fmt.Sprintf("dbcli comment status %v visible", pending); !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
+		}
+	})
 
-		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*`, "TEST session 123"), f)
+	t.Run("EditAndDeleteComment", func(t *testing.T) {
+		token, hash, err := newEditToken()
 		if err != nil {
-			t.Errorf("error when trying match with regex: %v", err)
+			t.Fatal(err)
+		}
+		commentId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession1Id, Content: "Grate session", EditTokenHash: &hash})
+		if err != nil {
+			t.Fatalf("could not insert comment: %v", err)
+		}
+		hiddenId, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession1Id, Content: "Hidden", Status: ptr("hidden"), EditTokenHash: &hash})
+		if err != nil {
+			t.Fatalf("could not insert comment: %v", err)
 		}
 
-		if !matched {
-			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		send := func(handler http.HandlerFunc, method string, id int32, body string, header string, value string) *http.Response {
+			req := httptest.NewRequest(method, fmt.Sprintf("/comments/%v", id), strings.NewReader(body))
+			req.SetPathValue("id", fmt.Sprint(id))
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Result()
+		}
+		patch := fuego.HTTPHandler(s, PatchCommentById)
+		del := fuego.HTTPHandler(s, DeleteCommentById)
+
+		migrationsDirectory = t.TempDir()
+		checkProblem(t, send(patch, http.MethodPatch, commentId, `{"content": "Great session"}`, "", ""), 403)
+		checkProblem(t, send(patch, http.MethodPatch, commentId, `{"content": "Great session"}`, editTokenHeader, "wrong"), 403)
+		checkProblem(t, send(patch, http.MethodPatch, hiddenId, `{"content": "Great session"}`, editTokenHeader, token), 404)
+		checkProblem(t, send(patch, http.MethodPatch, 999999, `{"content": "Great session"}`, editTokenHeader, token), 404)
+		problem := checkProblem(t, send(patch, http.MethodPatch, commentId, `{"content": " "}`, editTokenHeader, token), 400)
+		if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != "/content" {
+			t.Errorf("expected the problem to point to /content, got %+v", problem.Errors)
+		}
+		checkProblem(t, send(del, http.MethodDelete, commentId, "", editTokenHeader, "wrong"), 403)
+		if dir, _ := os.ReadDir(migrationsDirectory); len(dir) != 0 {
+			t.Fatalf("expected no migrations for rejected requests, got %v", len(dir))
+		}
+
+		memory := &alerts.MemoryNotifier{}
+		alerter = memory
+		defer func() { alerter = nil }()
+		for i, tc := range []struct {
+			handler  http.HandlerFunc
+			method   string
+			body     string
+			expected string
+			alert    string
+		}{
+			{patch, http.MethodPatch, `{"content": "Great session"}`, fmt.Sprintf(`dbcli update comment %v "{\"content\":\"Great session\"}"`, commentId), "comment edit"},
+			{del, http.MethodDelete, "", fmt.Sprintf("dbcli delete comment %v", commentId), "comment deletion"},
+		} {
+			migrationsDirectory = t.TempDir()
+			if res := send(tc.handler, tc.method, commentId, tc.body, editTokenHeader, token); res.StatusCode != 200 {
+				t.Errorf("%v: expected status code 200, got %v", tc.method, res.StatusCode)
+			}
+			pendingAlerts.Wait()
+			if sent := memory.Alerts(); len(sent) != i+1 || sent[i].Type != tc.alert || sent[i].Name != fmt.Sprintf("comment %v", commentId) {
+				t.Errorf("%v: expected a %v alert, got %+v", tc.method, tc.alert, sent)
+			}
+			dir, err := os.ReadDir(migrationsDirectory)
+			if err != nil || len(dir) != 1 {
+				t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+			}
+			f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+			if err != nil {
+				t.Errorf("error reading file: %v", err)
+			}
+			if !strings.Contains(string(f), tc.expected) {
+				t.Errorf("expected the migration to contain %s, got %s", tc.expected, f)
+			}
+		}
+
+		// admins can delete any comment
+		adminToken = "secret"
+		defer func() { adminToken = "" }()
+		migrationsDirectory = t.TempDir()
+		if res := send(del, http.MethodDelete, commentId, "", "Authorization", "Bearer secret"); res.StatusCode != 200 {
+			t.Errorf("expected status code 200, got %v", res.StatusCode)
+		}
+	})
+
+	t.Run("EditCommentWithEditToken", func(t *testing.T) {
+		// the author only has the values returned by the POST request, the ID is assigned when the migration is applied
+		migrationsDirectory = t.TempDir()
+		apply := func() {
+			t.Helper()
+			var stderr bytes.Buffer
+			cmd := exec.Command("bash", "../../scripts/run-migrations.sh", "-y")
+			cmd.Env = append(os.Environ(), "MIGRATIONS_DIRECTORY="+migrationsDirectory, "MIGRATIONS_ARCHIVE="+filepath.Join(migrationsDirectory, "archive"))
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("an error occured when running migrations: %v: %v", err, stderr.String())
+			}
+		}
+		send := func(handler http.HandlerFunc, method string, path string, body string, token string) *http.Response {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.SetPathValue("id", fmt.Sprint(testSession1Id))
+			if token != "" {
+				req.Header.Set(editTokenHeader, token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Result()
+		}
+		patch := fuego.HTTPHandler(s, PatchComment)
+		del := fuego.HTTPHandler(s, DeleteComment)
+
+		res := send(fuego.HTTPHandler(s, PostCommentForSessionById), http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", testSession1Id), `{"author": "Sam", "content": "Lovely sesion"}`, "")
+		if res.StatusCode != 201 {
+			t.Fatalf("expected status code 201, got %v", res.StatusCode)
+		}
+		var posted PostCommentResponse
+		if err := json.NewDecoder(res.Body).Decode(&posted); err != nil || posted.EditToken == "" || posted.Status != "visible" {
+			t.Fatalf("expected an edit token for a visible comment, got %+v (err: %v)", posted, err)
+		}
+
+		// the comment can't be found before the submission has been applied
+		checkProblem(t, send(patch, http.MethodPatch, "/comments", `{"content": "Lovely session"}`, posted.EditToken), 404)
+		apply()
+		checkProblem(t, send(patch, http.MethodPatch, "/comments", `{"content": "Lovely session"}`, ""), 403)
+		checkProblem(t, send(patch, http.MethodPatch, "/comments", `{"content": "Lovely session"}`, "wrong"), 404)
+		checkProblem(t, send(patch, http.MethodPatch, "/comments", `{"content": " "}`, posted.EditToken), 400)
+
+		if res := send(patch, http.MethodPatch, "/comments", `{"content": "Lovely session"}`, posted.EditToken); res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		apply()
+		comment, err := queries.GetCommentByEditTokenHash(ctx, ptr(hashEditToken(posted.EditToken)))
+		if err != nil {
+			t.Fatalf("could not get comment: %v", err)
+		}
+		if comment.Session != testSession1Id || comment.Author != "Sam" || comment.Content != "Lovely session" {
+			t.Errorf("expected the edited comment, got %+v", comment)
+		}
+
+		if res := send(del, http.MethodDelete, "/comments", "", posted.EditToken); res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		apply()
+		if _, err := queries.GetCommentById(ctx, comment.CommentID); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("expected the comment to be deleted, got %v", err)
+		}
+		checkProblem(t, send(del, http.MethodDelete, "/comments", "", posted.EditToken), 404)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		migrationsDirectory = t.TempDir()
+		var outbox bytes.Buffer
+		memory := &alerts.MemoryNotifier{}
+		authIssuer, mailer, publicUrl, alerter = auth.NewIssuer([]byte("secret")), &mail.WriterMailer{W: &outbox}, "https://example.org", memory
+		defer func() { authIssuer, mailer, publicUrl, alerter = nil, nil, "", nil }()
+
+		login := fuego.HTTPHandler(s, PostLogin)
+		post := func(body string) *http.Response {
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			login(w, req)
+			return w.Result()
+		}
+		problem := checkProblem(t, post(`{"email": "not an address"}`), 400)
+		if len(problem.Errors) != 1 || problem.Errors[0].More["pointer"] != "/email" {
+			t.Errorf("expected the problem to point to /email, got %+v", problem.Errors)
+		}
+		if res := post(`{"email": "Login.Test@Example.org"}`); res.StatusCode != 202 {
+			t.Errorf("expected status code 202, got %v", res.StatusCode)
+		}
+		link := regexp.MustCompile(`https://example\.org/v1/auth/verify\?token=(\S+)`).FindStringSubmatch(outbox.String())
+		if link == nil || !strings.Contains(outbox.String(), "To: login.test@example.org") {
+			t.Fatalf("expected a login link to be sent to login.test@example.org, got %s", outbox.String())
+		}
+		token, err := url.QueryUnescape(link[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verify := func() *http.Response {
+			req := httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil)
+			w := httptest.NewRecorder()
+			fuego.HTTPHandler(s, GetVerifyLogin)(w, req)
+			return w.Result()
+		}
+		res := verify()
+		if res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		var loggedIn LoginResponse
+		if err := json.NewDecoder(res.Body).Decode(&loggedIn); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if loggedIn.User.Email != "login.test@example.org" || loggedIn.User.UserID != nil || loggedIn.Token == "" {
+			t.Errorf("expected an unregistered user and a session token, got %+v", loggedIn)
+		}
+		if cookies := res.Cookies(); len(cookies) != 1 || cookies[0].Name != sessionCookieName || cookies[0].Value != loggedIn.Token || !cookies[0].HttpOnly || !cookies[0].Secure {
+			t.Errorf("expected a secure session cookie with the token, got %+v", cookies)
+		}
+		checkProblem(t, verify(), 401) // login links can only be used once
+		pendingAlerts.Wait()
+		if sent := memory.Alerts(); len(sent) != 1 || sent[0].Type != "new user" || sent[0].Name != "login.test@example.org" {
+			t.Errorf("expected an alert about the new user, got %+v", sent)
+		}
+
+		// logging in again before the registration has been applied doesn't register the account twice
+		outbox.Reset()
+		if res := post(`{"email": "login.test@example.org"}`); res.StatusCode != 202 {
+			t.Errorf("expected status code 202, got %v", res.StatusCode)
+		}
+		if link = regexp.MustCompile(`https://example\.org/v1/auth/verify\?token=(\S+)`).FindStringSubmatch(outbox.String()); link == nil {
+			t.Fatalf("expected a login link, got %s", outbox.String())
+		}
+		if token, err = url.QueryUnescape(link[1]); err != nil {
+			t.Fatal(err)
+		}
+		if res := verify(); res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+
+		// the account is registered through a migration
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
+		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+		if expected := `dbcli insert user "{\"email\":\"login.test@example.org\"}"`; !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
+		}
+		userId, err := queries.InsertUser(ctx, "login.test@example.org")
+		if err != nil {
+			t.Fatalf("could not insert user: %v", err)
+		}
+
+		me := AuthMiddleware(fuego.HTTPHandler(s, GetMe))
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: loggedIn.Token})
+		w := httptest.NewRecorder()
+		me.ServeHTTP(w, req)
+		var user types.User
+		if err := json.NewDecoder(w.Result().Body).Decode(&user); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if user.UserID == nil || *user.UserID != userId {
+			t.Errorf("expected the user ID %v, got %+v", userId, user)
+		}
+		w = httptest.NewRecorder()
+		me.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+		checkProblem(t, w.Result(), 401)
+
+		// comments of logged in users are attributed to their account
+		migrationsDirectory = t.TempDir()
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/jamsessions/%v/comments", testSession1Id), strings.NewReader(`{"content": "Logged in", "rating": 5}`))
+		req.SetPathValue("id", fmt.Sprint(testSession1Id))
+		req.Header.Set("Authorization", "Bearer "+loggedIn.Token)
+		w = httptest.NewRecorder()
+		AuthMiddleware(fuego.HTTPHandler(s, PostCommentForSessionById)).ServeHTTP(w, req)
+		if w.Code != 201 {
+			t.Errorf("expected status code 201, got %v", w.Code)
+		}
+		dir, err = os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
+		if f, err = os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name())); err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+		if n := strings.Count(string(f), `\"user_email\":\"login.test@example.org\"`); n != 2 {
+			t.Errorf("expected the comment and the rating to be attributed to the user, got %s", f)
+		}
+	})
+
+	t.Run("PostSession", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		testBody, err := json.Marshal(types.SessionProperties{
+			SessionName:     ptr("TestInsert"),
+			Venue:           &testVenueId,
+			Description:     ptr("Description."),
+			StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
+			DurationMinutes: ptr(int16(90)),
+			Interval:        ptr(types.FirstOfMonth),
+			SessionWebsite:  ptr("https://example.org"),
+		})
+		if err != nil {
+			t.Error("could not marshal json:", err)
+			t.FailNow()
+		}
+
+		handler := fuego.HTTPHandler(s, PostSession)
+		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Errorf("couldn't read directory contents: %v", err)
+			t.FailNow()
+		}
+		if len(dir) != 1 {
+			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
+			t.FailNow()
+		}
+
+		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+
+		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*}"$`, "TestInsert"), f)
+		if err != nil {
+			t.Errorf("error when trying match with regex: %v", err)
+		}
+
+		if !matched {
+			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		}
+	})
+
+	t.Run("PostSessionWithSubmissionNotes", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		testBody, err := json.Marshal(types.SessionPropertiesWithVenuePOST{
+			SessionProperties: types.SessionProperties{SessionName: ptr("TestInsert"),
+				Venue:           &testVenueId,
+				Description:     ptr("Description."),
+				StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
+				DurationMinutes: ptr(int16(90)),
+				Interval:        ptr(types.FirstOfMonth),
+				SessionWebsite:  ptr("https://example.org"),
+			},
+			SubmissionNotes: ptr("I run the session"),
+			SubmissionEmail: ptr("john.doe@example.com")})
+		if err != nil {
+			t.Error("could not marshal json:", err)
+			t.FailNow()
+		}
+
+		handler := fuego.HTTPHandler(s, PostSession)
+		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Errorf("couldn't read directory contents: %v", err)
+			t.FailNow()
+		}
+		if len(dir) != 1 {
+			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
+			t.FailNow()
+		}
+
+		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+
+		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*\n# submission notes: I run the session\n# email: john.doe@example.com`, "TestInsert"), f)
+		if err != nil {
+			t.Errorf("error when trying match with regex: %v", err)
+		}
+
+		if !matched {
+			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		}
+	})
+
+	t.Run("PostSessionAndVenue", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		testBody, err := json.Marshal(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
+			SessionName:     ptr("TestInsert2"),
+			Description:     ptr("Description."),
+			StartTimeUtc:    ptr(time.Date(2024, 3, 4, 5, 5, 3, 5, time.UTC)),
+			DurationMinutes: ptr(int16(90)),
+			Interval:        ptr(types.FirstOfMonth),
+			SessionWebsite:  ptr("https://example.org"),
+		},
+			VenueProperties: types.VenueProperties{
+				VenueName:        ptr("VenueInsert"),
+				AddressFirstLine: ptr("1 Random St"),
+				City:             ptr("Randomtown"),
+				Postcode:         ptr("ABC 123"),
+				VenueWebsite:     ptr("https://example.org"),
+				Backline:         &[]types.Backline{types.PA, types.Drums},
+			},
+		})
+		if err != nil {
+			t.Error("could not marshal json:", err)
+			t.FailNow()
+		}
+
+		handler := fuego.HTTPHandler(s, PostSession)
+		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Errorf("couldn't read directory contents: %v", err)
+			t.FailNow()
+		}
+		if len(dir) != 1 {
+			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
+			t.FailNow()
+		}
+
+		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+
+		matched, err := regexp.Match(fmt.Sprintf(`new_id=\$\(dbcli insert venue "{\\"venue_name\\":\\"%v\\",.*\n.*\\"session_name\\":\\"%v\\",.*`, "VenueInsert", "TestInsert2"), f)
+		if err != nil {
+			t.Errorf("error when trying match with regex: %v", err)
+		}
+
+		if !matched {
+			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		}
+	})
+
+	t.Run("PostSessionAltPayload", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		testBody := `{"session_name":"TEST session 123","description":"dafdsc dsd.","interval":"Weekly","start_time_utc":"2024-10-16T00:00:00.000Z","duration_minutes":60,"genres":[],"session_website":"https://example.org/"}`
+
+		handler := fuego.HTTPHandler(s, PostSession)
+		req := httptest.NewRequest(http.MethodPost, "/jamsessions", strings.NewReader(testBody))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 201 {
+			t.Errorf("expected status code 201, got %v", res.StatusCode)
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Errorf("couldn't read directory contents: %v", err)
+			t.FailNow()
+		}
+		if len(dir) != 1 {
+			t.Errorf("expected exactly 1 file in the directory, got %v", len(dir))
+			t.FailNow()
+		}
+
+		f, err := os.ReadFile(filepath.Join(migrationsDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+
+		matched, err := regexp.Match(fmt.Sprintf(`insert session "{\\"session_name\\":\\"%v\\",.*`, "TEST session 123"), f)
+		if err != nil {
+			t.Errorf("error when trying match with regex: %v", err)
+		}
+
+		if !matched {
+			t.Errorf("expected the regex to match. instead got file contents: %s", f)
+		}
+	})
+	t.Run("PostSessionDuplicate", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		// same venue, interval, weekday and time as test_session1
+		testBody, err := json.Marshal(types.SessionProperties{
+			SessionName:     ptr("TestDuplicate"),
+			Venue:           &testVenueId,
+			StartTimeUtc:    ptr(time.Date(2024, 5, 6, 19, 30, 0, 0, time.UTC)),
+			DurationMinutes: ptr(int16(90)),
+			Interval:        ptr(types.Weekly),
+		})
+		if err != nil {
+			t.Error("could not marshal json:", err)
+			t.FailNow()
+		}
+
+		handler := fuego.HTTPHandler(s, PostSession)
+		req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		if res.StatusCode != 409 {
+			t.Errorf("expected status code 409, got %v", res.StatusCode)
+		}
+		var body fuego.HTTPError
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		if len(body.Errors) != 1 || body.Errors[0].More["session_id"] != float64(testSession1Id) {
+			t.Errorf("expected the response to reference session %v, got %+v", testSession1Id, body)
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil {
+			t.Errorf("couldn't read directory contents: %v", err)
+			t.FailNow()
+		}
+		if len(dir) != 0 {
+			t.Errorf("expected no migration to be written, got %v files", len(dir))
+		}
+	})
+
+	t.Run("PostSessionPendingDuplicate", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		post := func(body types.SessionPropertiesWithVenuePOST) {
+			testBody, err := json.Marshal(body)
+			if err != nil {
+				t.Error("could not marshal json:", err)
+				t.FailNow()
+			}
+			handler := fuego.HTTPHandler(s, PostSession)
+			req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if res := w.Result(); res.StatusCode != 201 {
+				t.Errorf("expected status code 201, got %v", res.StatusCode)
+			}
+		}
+
+		// two weeks apart, so the fortnightly schedules are the same
+		post(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
+			SessionName: ptr("TestPending"), Description: ptr(`the "best" jam`), Venue: &testVenueId, StartTimeUtc: ptr(time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)), DurationMinutes: ptr(int16(90)), Interval: ptr(types.Fortnightly),
+		}})
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
+		first := dir[0].Name()
+
+		post(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
+			SessionName: ptr("TestPending2"), Venue: &testVenueId, StartTimeUtc: ptr(time.Date(2024, 5, 15, 20, 0, 0, 0, time.UTC)), DurationMinutes: ptr(int16(90)), Interval: ptr(types.Fortnightly),
+		}})
+		// one week apart - not a duplicate
+		post(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
+			SessionName: ptr("TestPending3"), Venue: &testVenueId, StartTimeUtc: ptr(time.Date(2024, 5, 8, 20, 0, 0, 0, time.UTC)), DurationMinutes: ptr(int16(90)), Interval: ptr(types.Fortnightly),
+		}})
+
+		dir, err = os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 3 {
+			t.Fatalf("expected exactly 3 files in the directory, got %v (err: %v)", len(dir), err)
+		}
+		for _, entry := range dir {
+			f, err := os.ReadFile(filepath.Join(migrationsDirectory, entry.Name()))
+			if err != nil {
+				t.Errorf("error reading file: %v", err)
+			}
+			flagged := strings.Contains(string(f), "# likely duplicate of pending submission "+first)
+			if shouldBeFlagged := strings.Contains(entry.Name(), "TestPending2"); flagged != shouldBeFlagged {
+				t.Errorf("expected %v to be flagged: %v, got file contents: %s", entry.Name(), shouldBeFlagged, f)
+			}
+		}
+	})
+
+	t.Run("PostSessionAndVenuePendingDuplicate", func(t *testing.T) {
+		// temp directory for migrations
+		migrationsDirectory = t.TempDir()
+
+		var first string
+		for _, venueName := range []string{"Pending Venue", "pending venue"} {
+			testBody, err := json.Marshal(types.SessionPropertiesWithVenuePOST{SessionProperties: types.SessionProperties{
+				SessionName:     ptr("TestPendingNewVenue"),
+				StartTimeUtc:    ptr(time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)),
+				DurationMinutes: ptr(int16(90)),
+				Interval:        ptr(types.Weekly),
+			},
+				VenueProperties: types.VenueProperties{
+					VenueName:        ptr(venueName),
+					AddressFirstLine: ptr("1 Random St"),
+					City:             ptr("Randomtown"),
+					Postcode:         ptr("ABC 123"),
+				},
+			})
+			if err != nil {
+				t.Error("could not marshal json:", err)
+				t.FailNow()
+			}
+			handler := fuego.HTTPHandler(s, PostSession)
+			req := httptest.NewRequest(http.MethodPost, "/jamsessions", bytes.NewReader(testBody))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if res := w.Result(); res.StatusCode != 201 {
+				t.Errorf("expected status code 201, got %v", res.StatusCode)
+			}
+			if first == "" {
+				dir, err := os.ReadDir(migrationsDirectory)
+				if err != nil || len(dir) != 1 {
+					t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+				}
+				first = dir[0].Name()
+			}
+		}
+
+		dir, err := os.ReadDir(migrationsDirectory)
+		if err != nil || len(dir) != 2 {
+			t.Fatalf("expected exactly 2 files in the directory, got %v (err: %v)", len(dir), err)
+		}
+		for _, entry := range dir {
+			if entry.Name() == first {
+				continue
+			}
+			f, err := os.ReadFile(filepath.Join(migrationsDirectory, entry.Name()))
+			if err != nil {
+				t.Errorf("error reading file: %v", err)
+			}
+			if !strings.Contains(string(f), "# likely duplicate of pending submission "+first) {
+				t.Errorf("expected the second submission to be flagged, got file contents: %s", f)
+			}
+		}
+	})
+
+	t.Run("GetSessionsPaginated", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessions)
+		seen := make(map[int32]bool)
+		next := "/jamsessions?limit=1&sort=name"
+		var lastName string
+		for pages := 0; next != ""; pages++ {
+			if pages > 1000 {
+				t.Fatalf("pagination didn't terminate")
+			}
+			req := httptest.NewRequest(http.MethodGet, next, nil)
+			w := httptest.NewRecorder()
+			handler(w, req)
+			res := w.Result()
+			if res.StatusCode != 200 {
+				t.Fatalf("expected status code 200, got %v", res.StatusCode)
+			}
+			var body types.SessionFeatureCollection
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			if len(body.Features) != 1 {
+				t.Fatalf("expected exactly 1 feature per page, got %v", len(body.Features))
+			}
+			f := body.Features[0].Properties
+			if seen[*f.SessionID] {
+				t.Errorf("session %v was returned twice", *f.SessionID)
+			}
+			seen[*f.SessionID] = true
+			if *f.SessionName < lastName {
+				t.Errorf("expected sessions to be sorted by name, got %v after %v", *f.SessionName, lastName)
+			}
+			lastName = *f.SessionName
+			next = ""
+			for _, l := range body.Links {
+				if l.Rel == "next" {
+					next = l.Href
+					if res.Header.Get("Link") != fmt.Sprintf(`<%v>; rel="next"`, l.Href) {
+						t.Errorf("expected the Link header to point to %v, got %v", l.Href, res.Header.Get("Link"))
+					}
+				}
+			}
+		}
+		for _, id := range []int32{testSession1Id, testSession2Id, testSession3Id} {
+			if !seen[id] {
+				t.Errorf("expected session %v to be on one of the pages", id)
+			}
+		}
+	})
+
+	t.Run("GetSessionsSortedByDistance", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessions)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions?sort=-distance&near=-0.132,51.514", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body types.SessionFeatureCollection
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		pos := make(map[int32]int)
+		for i, f := range body.Features {
+			pos[*f.Properties.SessionID] = i
+		}
+		// session 3 takes place at the venue further away from the point
+		if pos[testSession3Id] > pos[testSession1Id] {
+			t.Errorf("expected session %v to come before session %v when sorting by descending distance", testSession3Id, testSession1Id)
+		}
+	})
+
+	t.Run("GetSessionsSortedByRating", func(t *testing.T) {
+		// a single 5 star rating shouldn't outrank ten ratings averaging 4.8
+		ratings := map[int32][]int16{
+			testSession1Id: {5},
+			testSession2Id: {5, 5, 5, 5, 5, 5, 5, 5, 4, 4},
+			testSession3Id: {2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+		}
+		for session, values := range ratings {
+			for _, r := range values {
+				if _, err := queries.InsertSessionRating(ctx, dbutils.InsertSessionRatingParams{Session: session, Rating: ptr(r)}); err != nil {
+					t.Fatalf("could not insert rating: %v", err)
+				}
+			}
+		}
+
+		handler := fuego.HTTPHandler(s, GetSessions)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions?sort=-rating", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body types.SessionFeatureCollection
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		pos := make(map[int32]int)
+		props := make(map[int32]types.SessionProperties)
+		for i, f := range body.Features {
+			pos[*f.Properties.SessionID] = i
+			props[*f.Properties.SessionID] = f.Properties
+		}
+		if !(pos[testSession2Id] < pos[testSession1Id] && pos[testSession1Id] < pos[testSession3Id]) {
+			t.Errorf("expected the order %v, %v, %v when sorting by descending rating, got positions %v", testSession2Id, testSession1Id, testSession3Id, pos)
+		}
+		p := props[testSession2Id]
+		if p.Rating == nil || *p.Rating != 4.8 || p.RatingCount == nil || *p.RatingCount != 10 {
+			t.Errorf("expected an average of 4.8 from 10 ratings, got %v from %v", p.Rating, p.RatingCount)
+		}
+		if p.RatingHistogram == nil || fmt.Sprint(*p.RatingHistogram) != "[0 0 0 2 8]" {
+			t.Errorf("expected the histogram [0 0 0 2 8], got %v", p.RatingHistogram)
+		}
+		if p.RatingScore == nil || *p.RatingScore >= *p.Rating {
+			t.Errorf("expected the rating score to be below the average, got %v", p.RatingScore)
+		}
+	})
+
+	t.Run("GetSessionsFields", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessions)
+		req := httptest.NewRequest(http.MethodGet, "/jamsessions?fields=session_id,session_name", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body struct {
+			Features []struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"features"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if len(body.Features) == 0 {
+			t.Fatalf("expected at least 1 feature")
+		}
+		for _, f := range body.Features {
+			if len(f.Properties) != 2 || f.Properties["session_id"] == nil || f.Properties["session_name"] == nil {
+				t.Errorf("expected only session_id and session_name in the properties, got %v", f.Properties)
+			}
+		}
+	})
+
+	t.Run("GetSessionsInvalidListParams", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetSessions)
+		for param, query := range map[string]string{
+			"near":   "sort=distance",
+			"sort":   "sort=venue",
+			"limit":  "limit=0",
+			"cursor": "cursor=abc",
+			"fields": "fields=session_id,password",
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/jamsessions?"+query, nil)
+			w := httptest.NewRecorder()
+			handler(w, req)
+			body := checkProblem(t, w.Result(), 400)
+			if len(body.Errors) != 1 || body.Errors[0].Name != param {
+				t.Errorf("expected the problem to point to parameter %v (query %v), got %+v", param, query, body.Errors)
+			}
+		}
+	})
+
+	t.Run("GetVenuesPaginated", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetVenues)
+		req := httptest.NewRequest(http.MethodGet, "/venues?limit=1&sort=-name", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body types.VenueFeatureCollection
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if len(body.Features) != 1 {
+			t.Fatalf("expected exactly 1 feature, got %v", len(body.Features))
+		}
+		if len(body.Links) != 1 || body.Links[0].Rel != "next" {
+			t.Fatalf("expected a link to the next page, got %+v", body.Links)
+		}
+		req = httptest.NewRequest(http.MethodGet, body.Links[0].Href, nil)
+		w = httptest.NewRecorder()
+		handler(w, req)
+		var page2 types.VenueFeatureCollection
+		if err := json.NewDecoder(w.Result().Body).Decode(&page2); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if len(page2.Features) != 1 {
+			t.Fatalf("expected exactly 1 feature on the second page, got %v", len(page2.Features))
+		}
+		if *page2.Features[0].Properties.VenueName > *body.Features[0].Properties.VenueName {
+			t.Errorf("expected venues to be sorted by descending name, got %v after %v", *page2.Features[0].Properties.VenueName, *body.Features[0].Properties.VenueName)
+		}
+	})
+
+	t.Run("GetCommentsPaginated", func(t *testing.T) {
+		for _, content := range []string{"first", "second", "third"} {
+			if _, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession2Id, Content: content}); err != nil {
+				t.Fatalf("could not insert comment: %v", err)
+			}
+		}
+		handler := fuego.HTTPHandler(s, GetCommentsBySessionId)
+		var contents []string
+		next := fmt.Sprintf("/jamsessions/%v/comments?limit=2", testSession2Id)
+		for next != "" {
+			req := httptest.NewRequest(http.MethodGet, next, nil)
+			req.SetPathValue("id", fmt.Sprint(testSession2Id))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			res := w.Result()
+			if res.StatusCode != 200 {
+				t.Fatalf("expected status code 200, got %v", res.StatusCode)
+			}
+			var body []types.Comment
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			for _, c := range body {
+				contents = append(contents, c.Content)
+			}
+			next = ""
+			if link := res.Header.Get("Link"); link != "" {
+				next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+		if strings.Join(contents, ",") != "first,second,third" {
+			t.Errorf("expected the comments first,second,third, got %v", contents)
+		}
+	})
+
+	t.Run("GetCommentsThreaded", func(t *testing.T) {
+		root, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession3Id, Content: "root"})
+		if err != nil {
+			t.Fatalf("could not insert comment: %v", err)
+		}
+		var firstReply int32
+		for i := 0; i < maxNestedReplies+2; i++ {
+			reply, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession3Id, Content: fmt.Sprintf("reply %v", i), ParentComment: &root, Verified: ptr(i == 0)})
+			if err != nil {
+				t.Fatalf("could not insert reply: %v", err)
+			}
+			if i == 0 {
+				firstReply = reply
+			}
+		}
+		if _, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession3Id, Content: "nested reply", ParentComment: &firstReply}); err != nil {
+			t.Fatalf("could not insert reply: %v", err)
+		}
+
+		handler := fuego.HTTPHandler(s, GetCommentsBySessionId)
+		get := func(query string) (*http.Response, []types.Comment) {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/jamsessions/%v/comments?%v", testSession3Id, query), nil)
+			req.SetPathValue("id", fmt.Sprint(testSession3Id))
+			w := httptest.NewRecorder()
+			handler(w, req)
+			res := w.Result()
+			if res.StatusCode != 200 {
+				t.Fatalf("expected status code 200 for %v, got %v", query, res.StatusCode)
+			}
+			var body []types.Comment
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			return res, body
+		}
+
+		// flat (default) - all comments with parent ids
+		_, flat := get("")
+		if len(flat) != maxNestedReplies+4 {
+			t.Errorf("expected %v comments in the flat view, got %v", maxNestedReplies+4, len(flat))
+		}
+
+		// tree - one thread, replies are truncated
+		_, tree := get("view=tree")
+		if len(tree) != 1 || tree[0].CommentID != root {
+			t.Fatalf("expected the root comment to be the only thread, got %+v", tree)
+		}
+		if tree[0].ReplyCount != maxNestedReplies+2 || len(tree[0].Replies) != maxNestedReplies {
+			t.Errorf("expected %v of %v replies to be nested, got %v (reply_count %v)", maxNestedReplies, maxNestedReplies+2, len(tree[0].Replies), tree[0].ReplyCount)
+		}
+		first := tree[0].Replies[0]
+		if first.CommentID != firstReply || !first.Verified || len(first.Replies) != 1 || first.Replies[0].Content != "nested reply" {
+			t.Errorf("expected the verified first reply with a nested reply, got %+v", first)
+		}
+
+		// page through the replies of the thread
+		res, page := get(fmt.Sprintf("parent_comment=%v&limit=5", root))
+		if len(page) != 5 || res.Header.Get("Link") == "" {
+			t.Errorf("expected a page of 5 replies with a link to the next page, got %v (Link: %v)", len(page), res.Header.Get("Link"))
+		}
+		for _, c := range page {
+			if c.ParentComment == nil || *c.ParentComment != root {
+				t.Errorf("expected only replies to %v, got %+v", root, c)
+			}
+		}
+	})
+
+	t.Run("GetVenuesConditional", func(t *testing.T) {
+		handler := CacheMiddleware(fuego.HTTPHandler(s, GetVenues))
+		get := func(header string, value string) *http.Response {
+			req := httptest.NewRequest(http.MethodGet, "/venues", nil)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w.Result()
+		}
+		res := get("", "")
+		etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+		if res.StatusCode != 200 || etag == "" || lastModified == "" || res.Header.Get("Cache-Control") == "" {
+			t.Fatalf("expected status 200 with ETag, Last-Modified and Cache-Control headers, got %v and headers %v", res.StatusCode, res.Header)
+		}
+		for header, value := range map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified} {
+			res = get(header, value)
+			if res.StatusCode != 304 {
+				t.Errorf("expected status code 304 for %v: %v, got %v", header, value, res.StatusCode)
+			}
+			if body, _ := io.ReadAll(res.Body); len(body) != 0 {
+				t.Errorf("expected an empty body for status 304, got %s", body)
+			}
+		}
+
+		// any change to the data invalidates the ETag
+		if _, err := queries.InsertSessionComment(ctx, dbutils.InsertSessionCommentParams{Session: testSession1Id, Content: "cache buster"}); err != nil {
+			t.Fatalf("could not insert comment: %v", err)
+		}
+		res = get("If-None-Match", etag)
+		if res.StatusCode != 200 {
+			t.Errorf("expected status code 200 after the data changed, got %v", res.StatusCode)
+		}
+		if res.Header.Get("ETag") == etag {
+			t.Errorf("expected the ETag to change after the data changed")
+		}
+	})
+
+	t.Run("ResponseCacheInvalidation", func(t *testing.T) {
+		listenCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		connected := make(chan bool, 1)
+		listener := dbutils.NewListener(pool, dbutils.DataChangedChannel)
+		listener.OnNotification(func(n *pgconn.Notification) { cache.invalidate() })
+		listener.OnConnectionChange(func(c bool) {
+			cache.setEnabled(c)
+			if c {
+				connected <- c
+			}
+		})
+		go listener.Listen(listenCtx)
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("listener didn't connect")
+		}
+
+		handler := CacheMiddleware(fuego.HTTPHandler(s, GetVenues))
+		get := func() (*http.Response, types.VenueFeatureCollection) {
+			req := httptest.NewRequest(http.MethodGet, "/venues?sort=name", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			var body types.VenueFeatureCollection
+			if err := json.NewDecoder(w.Result().Body).Decode(&body); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			return w.Result(), body
+		}
+		res, first := get()
+		if res.Header.Get("X-Cache") != "MISS" {
+			t.Errorf("expected the first response to be a cache miss, got %v", res.Header.Get("X-Cache"))
+		}
+		res, second := get()
+		if res.Header.Get("X-Cache") != "HIT" {
+			t.Errorf("expected the second response to be served from the cache, got %v", res.Header.Get("X-Cache"))
+		}
+		if len(first.Features) != len(second.Features) {
+			t.Errorf("expected the cached response to match the original one")
+		}
+
+		if _, err := queries.InsertVenue(ctx, dbutils.InsertVenueParams{
+			VenueName:        "TEST CACHE",
+			AddressFirstLine: "3 Main Street",
+			City:             "London",
+			Postcode:         "ABC 123",
+			Geom:             geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.1, 51.5}),
+		}); err != nil {
+			t.Fatalf("could not insert venue: %v", err)
+		}
+		// the notification is delivered asynchronously
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			res, third := get()
+			if res.Header.Get("X-Cache") == "MISS" {
+				if len(third.Features) != len(first.Features)+1 {
+					t.Errorf("expected %v venues after the insert, got %v", len(first.Features)+1, len(third.Features))
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the cache to be invalidated after the insert")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	t.Run("OpenAPIConformance", func(t *testing.T) {
+		srv, spec := newTestServerWithSpec()
+		for _, tc := range []struct {
+			route string
+			path  string
+		}{
+			{"/v1/venues", "/v1/venues"},
+			{"/v1/venues", "/v1/venues?limit=1&sort=name&fields=venue_name,backline"},
+			{"/v1/venues/{id}", fmt.Sprintf("/v1/venues/%v", testVenueId)},
+			{"/v1/venues/{id}/jamsessions", fmt.Sprintf("/v1/venues/%v/jamsessions", testVenueId)},
+			{"/v1/jamsessions", "/v1/jamsessions"},
+			{"/v1/jamsessions", "/v1/jamsessions?date=2024-01-09&limit=1&sort=-rating"},
+			{"/v1/jamsessions/{id}", fmt.Sprintf("/v1/jamsessions/%v", testSession1Id)},
+			{"/v1/jamsessions/{id}/comments", fmt.Sprintf("/v1/jamsessions/%v/comments?limit=2", testSession2Id)},
+		} {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			srv.Mux.ServeHTTP(w, req)
+			res := w.Result()
+			if res.StatusCode != 200 {
+				t.Errorf("%v: expected status code 200, got %v", tc.path, res.StatusCode)
+				continue
+			}
+			checkOpenAPIConformance(t, spec, tc.route, req, res)
+		}
+	})
+
+	t.Run("GetDuplicateVenues", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetDuplicateVenues)
+		req := httptest.NewRequest(http.MethodGet, "/admin/venues/duplicates?name_similarity=0.5&distance=0", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("expected status code 200, got %v", res.StatusCode)
+		}
+		var body []dbutils.GetDuplicateVenuesRow
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		found := false
+		for _, pair := range body {
+			if pair.VenueID == testVenueId && pair.DuplicateVenueID == testVenueId2 {
+				found = true
+				if pair.NameSimilarity < 0.5 {
+					t.Errorf("expected the name similarity to be at least 0.5, got %v", pair.NameSimilarity)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected venues %v and %v to be reported as duplicates, got %+v", testVenueId, testVenueId2, body)
+		}
+	})
+
+	t.Run("GetDuplicateVenuesInvalidParam", func(t *testing.T) {
+		handler := fuego.HTTPHandler(s, GetDuplicateVenues)
+		req := httptest.NewRequest(http.MethodGet, "/admin/venues/duplicates?name_similarity=2", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		if res.StatusCode != 400 {
+			t.Errorf("expected status code 400, got %v", res.StatusCode)
+		}
+	})
+
+	t.Run("Favourites", func(t *testing.T) {
+		authIssuer, publicUrl = auth.NewIssuer([]byte("secret")), "https://example.org"
+		autoApplyDirectory = t.TempDir()
+		defer func() { authIssuer, publicUrl, autoApplyDirectory = nil, "", "" }()
+		token, err := authIssuer.SessionToken("fan@example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := func(handler http.Handler, method string, path string, pathParams map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			for k, v := range pathParams {
+				req.SetPathValue(k, v)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			AuthMiddleware(handler).ServeHTTP(w, req)
+			return w
+		}
+		put := fuego.HTTPHandler(s, PutFavourite)
+		session := map[string]string{"session_id": fmt.Sprint(testSession1Id)}
+
+		// the account has to be registered first
+		checkProblem(t, call(put, http.MethodPut, "/me/favourites", session).Result(), 409)
+		userId, err := queries.InsertUser(ctx, "fan@example.org")
+		if err != nil {
+			t.Fatalf("could not insert user: %v", err)
+		}
+		checkProblem(t, call(put, http.MethodPut, "/me/favourites", map[string]string{"session_id": "999999"}).Result(), 404)
+		if w := call(put, http.MethodPut, "/me/favourites", session); w.Code != 202 {
+			t.Fatalf("expected status code 202, got %v", w.Code)
+		}
+
+		// favourites are applied without review
+		dir, err := os.ReadDir(autoApplyDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
+		f, err := os.ReadFile(filepath.Join(autoApplyDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+		if expected := fmt.Sprintf(`dbcli favourite add "{\"user_email\":\"fan@example.org\",\"session\":%v}"`, testSession1Id); !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
+		}
+		if _, err := queries.InsertFavourite(ctx, dbutils.InsertFavouriteParams{UserEmail: "fan@example.org", Session: testSession1Id}); err != nil {
+			t.Fatalf("could not insert favourite: %v", err)
+		}
+
+		w := call(fuego.HTTPHandler(s, GetFavourites), http.MethodGet, "/me/favourites", nil)
+		var favourites types.SessionWithVenueFeatureCollection
+		if err := json.NewDecoder(w.Result().Body).Decode(&favourites); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(favourites.Features) != 1 || *favourites.Features[0].Properties.SessionID != testSession1Id {
+			t.Errorf("expected session %v to be the only favourite, got %+v", testSession1Id, favourites)
+		}
+
+		w = call(fuego.HTTPHandler(s, GetFavouritesCalendarLink), http.MethodGet, "/me/favourites/calendar", nil)
+		var link CalendarLinkResponse
+		if err := json.NewDecoder(w.Result().Body).Decode(&link); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		feedToken := authIssuer.CalendarToken(userId)
+		if link.URL != "https://example.org/v1/calendar/"+feedToken+".ics" {
+			t.Errorf("unexpected calendar link %v", link.URL)
+		}
+
+		// the feed doesn't require a login
+		req := httptest.NewRequest(http.MethodGet, "/calendar/"+feedToken+".ics", nil)
+		req.SetPathValue("token", feedToken+".ics")
+		w = httptest.NewRecorder()
+		fuego.HTTPHandler(s, GetCalendarFeed)(w, req)
+		if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/calendar") {
+			t.Fatalf("expected a calendar, got status %v and content type %v", w.Code, w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(w.Body.String(), "SUMMARY:test_session1") {
+			t.Errorf("expected the feed to contain the session, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Follows", func(t *testing.T) {
+		authIssuer = auth.NewIssuer([]byte("secret"))
+		autoApplyDirectory = t.TempDir()
+		defer func() { authIssuer, autoApplyDirectory = nil, "" }()
+		token, err := authIssuer.SessionToken("follower@example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := func(handler http.Handler, method string, body string, pathParams map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/me/follows", strings.NewReader(body))
+			for k, v := range pathParams {
+				req.SetPathValue(k, v)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			AuthMiddleware(handler).ServeHTTP(w, req)
+			return w
+		}
+		followVenue := fuego.HTTPHandler(s, PutFollowVenue)
+		venue := map[string]string{"id": fmt.Sprint(testVenueId)}
+
+		// the account has to be registered first
+		checkProblem(t, call(followVenue, http.MethodPut, "", venue).Result(), 409)
+		if _, err := queries.InsertUser(ctx, "follower@example.org"); err != nil {
+			t.Fatalf("could not insert user: %v", err)
+		}
+		checkProblem(t, call(followVenue, http.MethodPut, "", map[string]string{"id": "999999"}).Result(), 404)
+		if w := call(followVenue, http.MethodPut, "", venue); w.Code != 202 {
+			t.Fatalf("expected status code 202, got %v", w.Code)
+		}
+
+		postChannel := fuego.HTTPHandler(s, PostNotificationChannel)
+		checkProblem(t, call(postChannel, http.MethodPost, `{"kind": "pigeon"}`, nil).Result(), 400)
+		checkProblem(t, call(postChannel, http.MethodPost, `{"kind": "webhook", "endpoint": "http://example.org/hook"}`, nil).Result(), 400)
+		checkProblem(t, call(postChannel, http.MethodPost, `{"kind": "webpush", "endpoint": "https://push.example.org/abc", "keys": {"p256dh": "abc", "auth": "def"}}`, nil).Result(), 400)
+		if w := call(postChannel, http.MethodPost, `{"kind": "webhook", "endpoint": "https://example.org/hook"}`, nil); w.Code != 202 {
+			t.Fatalf("expected status code 202, got %v: %v", w.Code, w.Body.String())
+		}
+
+		// follows and channels are applied without review
+		dir, err := os.ReadDir(autoApplyDirectory)
+		if err != nil || len(dir) != 2 {
+			t.Fatalf("expected exactly 2 files in the directory, got %v (err: %v)", len(dir), err)
+		}
+		var migrations string
+		for _, entry := range dir {
+			f, err := os.ReadFile(filepath.Join(autoApplyDirectory, entry.Name()))
+			if err != nil {
+				t.Errorf("error reading file: %v", err)
+			}
+			migrations += string(f)
+		}
+		for _, expected := range []string{
+			fmt.Sprintf(`dbcli follow add "{\"user_email\":\"follower@example.org\",\"venue\":%v}"`, testVenueId),
+			`dbcli channel add "{\"user_email\":\"follower@example.org\",\"kind\":\"webhook\",\"endpoint\":\"https://example.org/hook\"}"`,
+		} {
+			if !strings.Contains(migrations, expected) {
+				t.Errorf("expected the migrations to contain %s, got %s", expected, migrations)
+			}
+		}
+
+		if _, err := queries.InsertFollow(ctx, dbutils.InsertFollowParams{UserEmail: "follower@example.org", Venue: &testVenueId}); err != nil {
+			t.Fatalf("could not insert follow: %v", err)
+		}
+		w := call(fuego.HTTPHandler(s, GetFollows), http.MethodGet, "", nil)
+		var follows []types.Follow
+		if err := json.NewDecoder(w.Result().Body).Decode(&follows); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(follows) != 1 || follows[0].Venue == nil || *follows[0].Venue != testVenueId || follows[0].Session != nil {
+			t.Errorf("expected venue %v to be the only follow, got %+v", testVenueId, follows)
+		}
+
+		// channels of other users can't be removed
+		checkProblem(t, call(fuego.HTTPHandler(s, DeleteNotificationChannel), http.MethodDelete, "", map[string]string{"id": "999999"}).Result(), 404)
+	})
+
+	t.Run("SavedSearches", func(t *testing.T) {
+		authIssuer = auth.NewIssuer([]byte("secret"))
+		autoApplyDirectory = t.TempDir()
+		defer func() { authIssuer, autoApplyDirectory = nil, "" }()
+		token, err := authIssuer.SessionToken("searcher@example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := queries.InsertUser(ctx, "searcher@example.org"); err != nil {
+			t.Fatalf("could not insert user: %v", err)
+		}
+		call := func(handler http.Handler, method string, body string, pathParams map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/me/saved-searches", strings.NewReader(body))
+			for k, v := range pathParams {
+				req.SetPathValue(k, v)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			AuthMiddleware(handler).ServeHTTP(w, req)
+			return w
+		}
+		post := fuego.HTTPHandler(s, PostSavedSearch)
+		checkProblem(t, call(post, http.MethodPost, `{"search_name": "Polka", "genres": ["Polka"]}`, nil).Result(), 400)
+		if w := call(post, http.MethodPost, `{"search_name": "Blues; nearby", "genres": ["Blues"], "weekdays": [1, 5]}`, nil); w.Code != 202 {
+			t.Fatalf("expected status code 202, got %v: %v", w.Code, w.Body.String())
+		}
+
+		// saved searches are applied without review
+		dir, err := os.ReadDir(autoApplyDirectory)
+		if err != nil || len(dir) != 1 {
+			t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(dir), err)
+		}
+		f, err := os.ReadFile(filepath.Join(autoApplyDirectory, dir[0].Name()))
+		if err != nil {
+			t.Errorf("error reading file: %v", err)
+		}
+		if expected := `dbcli search add "{\"search_name\":\"Blues; nearby\",\"genres\":[\"Blues\"],\"backline\":null,\"weekdays\":[1,5],`; !strings.Contains(string(f), expected) {
+			t.Errorf("expected the migration to contain %s, got %s", expected, f)
+		}
+
+		id, err := queries.InsertSavedSearch(ctx, dbutils.InsertSavedSearchParams{UserEmail: "searcher@example.org", SearchName: "Blues; nearby", Genres: []string{"Blues"}, Weekdays: []int16{1, 5}})
+		if err != nil {
+			t.Fatalf("could not insert saved search: %v", err)
+		}
+		w := call(fuego.HTTPHandler(s, GetSavedSearches), http.MethodGet, "", nil)
+		var searches []types.SavedSearch
+		if err := json.NewDecoder(w.Result().Body).Decode(&searches); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(searches) != 1 || searches[0].SearchID != id || searches[0].SearchName != "Blues; nearby" || len(searches[0].Weekdays) != 2 || searches[0].DtLastDigest != nil {
+			t.Errorf("expected the saved search, got %+v", searches)
+		}
+
+		del := fuego.HTTPHandler(s, DeleteSavedSearch)
+		checkProblem(t, call(del, http.MethodDelete, "", map[string]string{"id": "999999"}).Result(), 404)
+		if w := call(del, http.MethodDelete, "", map[string]string{"id": fmt.Sprint(id)}); w.Code != 202 {
+			t.Errorf("expected status code 202, got %v", w.Code)
+		}
+	})
+
+	t.Run("Webhooks", func(t *testing.T) {
+		defer func(dir string) { migrationsDirectory = dir }(migrationsDirectory)
+		migrationsDirectory = t.TempDir()
+		id, err := queries.InsertWebhookSubscription(ctx, dbutils.InsertWebhookSubscriptionParams{Url: "https://example.org/hook", Secret: "whsec_test", EventTypes: []string{"session.created"}})
+		if err != nil {
+			t.Fatalf("could not insert webhook subscription: %v", err)
+		}
+		for _, eventType := range []string{"session.created", "venue.created"} { // the subscription only receives the first
+			if _, err := queries.EnqueueWebhookDeliveries(ctx, dbutils.EnqueueWebhookDeliveriesParams{EventID: "evt_" + eventType, EventType: eventType, Payload: []byte(`{}`)}); err != nil {
+				t.Fatalf("could not enqueue event: %v", err)
+			}
+		}
+		call := func(handler http.Handler, query string, pathParams map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/admin/webhooks"+query, nil)
+			for k, v := range pathParams {
+				req.SetPathValue(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		var subscriptions []types.WebhookSubscription
+		if err := json.NewDecoder(call(fuego.HTTPHandler(s, GetWebhooks), "", nil).Result().Body).Decode(&subscriptions); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(subscriptions) == 0 || subscriptions[len(subscriptions)-1].SubscriptionID != id || subscriptions[len(subscriptions)-1].PendingDeliveries != 1 {
+			t.Errorf("expected the subscription with one pending delivery, got %+v", subscriptions)
+		}
+
+		deliveries := fuego.HTTPHandler(s, GetWebhookDeliveries)
+		var entries []types.WebhookDelivery
+		if err := json.NewDecoder(call(deliveries, "", map[string]string{"id": fmt.Sprint(id)}).Result().Body).Decode(&entries); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(entries) != 1 || entries[0].EventID != "evt_session.created" || entries[0].Status != "pending" || entries[0].Attempts != 0 || entries[0].NextAttemptAt == nil {
+			t.Errorf("expected the pending delivery, got %+v", entries)
+		}
+		if err := json.NewDecoder(call(deliveries, "?status=delivered", map[string]string{"id": fmt.Sprint(id)}).Result().Body).Decode(&entries); err != nil || len(entries) != 0 {
+			t.Errorf("expected no delivered events, got %+v (err: %v)", entries, err)
+		}
+		checkProblem(t, call(deliveries, "?status=sent", map[string]string{"id": fmt.Sprint(id)}).Result(), 400)
+		checkProblem(t, call(deliveries, "", map[string]string{"id": "999999"}).Result(), 404)
+
+		del := fuego.HTTPHandler(s, DeleteWebhook)
+		checkProblem(t, call(del, "", map[string]string{"id": "999999"}).Result(), 404)
+		if w := call(del, "", map[string]string{"id": fmt.Sprint(id)}); w.Code != 202 {
+			t.Errorf("expected status code 202, got %v", w.Code)
+		}
+		if dir, _ := os.ReadDir(migrationsDirectory); len(dir) != 1 {
+			t.Errorf("expected the removal to be written to the migrations directory, got %v files", len(dir))
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		after, err := queries.GetLatestEventId(ctx)
+		if err != nil {
+			t.Fatalf("could not get the latest event: %v", err)
+		}
+		venue, err := queries.GetVenueById(ctx, testVenueId)
+		if err != nil {
+			t.Fatalf("could not get venue: %v", err)
+		}
+		name := venue.VenueName + " (renamed)"
+		if err := queries.UpdateVenueById(ctx, dbutils.UpdateVenueByIdParams{VenueID: testVenueId, VenueName: &name}); err != nil {
+			t.Fatalf("could not update venue: %v", err)
+		}
+		defer queries.UpdateVenueById(ctx, dbutils.UpdateVenueByIdParams{VenueID: testVenueId, VenueName: &venue.VenueName})
+
+		// the timestamps of the sessions of the venue are updated as well, which is not an event of its own
+		rows, err := queries.GetEventsAfter(ctx, dbutils.GetEventsAfterParams{AfterID: after, MaxResults: 10})
+		if err != nil {
+			t.Fatalf("could not get events: %v", err)
+		}
+		if len(rows) != 1 || rows[0].EventType != "venue.updated" {
+			t.Fatalf("expected a single venue.updated event, got %+v", rows)
+		}
+		var data struct {
+			VenueID   int32  `json:"venue_id"`
+			VenueName string `json:"venue_name"`
+		}
+		if err := json.Unmarshal(rows[0].Data, &data); err != nil {
+			t.Fatalf("could not decode event data: %v", err)
+		}
+		if data.VenueID != testVenueId || data.VenueName != name {
+			t.Errorf("expected the ID and new name of the venue, got %+v", data)
+		}
+
+		// clients resume after the last event they received
+		defer func(f func(context.Context, dbutils.GetEventsAfterParams) ([]dbutils.LondonJamSessionsEvent, error)) {
+			loadEvents = f
+		}(loadEvents)
+		loadEvents = queries.GetEventsAfter
+		reqCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/v1/events", nil).WithContext(reqCtx)
+		req.Header.Set("Last-Event-ID", fmt.Sprint(after))
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			http.HandlerFunc(GetEvents).ServeHTTP(w, req)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-done
+		if body := w.Body.String(); !strings.Contains(body, fmt.Sprintf("id: %v\nevent: venue.updated\n", rows[0].EventID)) {
+			t.Errorf("expected the venue.updated event to be replayed, got %q", body)
+		}
+	})
+
+	t.Run("RecordApiKeyUsage", func(t *testing.T) {
+		key, err := apikeys.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyId, err := queries.InsertApiKey(ctx, dbutils.InsertApiKeyParams{Name: "Usage bot", KeyPrefix: apikeys.DisplayPrefix(key), KeyHash: apikeys.Hash(key), RateLimit: 60})
+		if err != nil {
+			t.Fatal(err)
+		}
+		usage := map[int32]apikeys.Count{keyId: {Requests: 3, LastUsed: time.Now()}}
+		for i := 0; i < 2; i++ {
+			if err := recordApiKeyUsage(ctx, pool, usage); err != nil {
+				t.Fatalf("expected error to be nil, got %v", err)
+			}
+		}
+		keys, err := queries.ListApiKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			if k.KeyID == keyId && k.RequestCount != 6 {
+				t.Errorf("expected 6 requests to be recorded, got %v", k.RequestCount)
+			}
+		}
+	})
+
+	t.Run("GraphQL", func(t *testing.T) {
+		query := `query ($id: Int!) {
+			session(id: $id) {
+				session_id
+				venue { venue_id sessions { session_id } }
+				occurrences(first: 3) { date start_time_utc session { session_id } }
+				comments(first: 2) { comment_id author session { session_id } }
+				rating { rating_count rating_histogram aspects { aspect rating } }
+			}
+			missing: session(id: 999999) { session_id }
+		}`
+		body, _ := json.Marshal(map[string]any{"query": query, "variables": map[string]any{"id": testSession1Id}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/graphql", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		fuego.HTTPHandler(s, PostGraphQL).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, got %v (%v)", w.Code, w.Body.String())
+		}
+		var res struct {
+			Data struct {
+				Session struct {
+					SessionID int32 `json:"session_id"`
+					Venue     struct {
+						VenueID  int32 `json:"venue_id"`
+						Sessions []struct {
+							SessionID int32 `json:"session_id"`
+						} `json:"sessions"`
+					} `json:"venue"`
+					Occurrences []struct {
+						Date         string `json:"date"`
+						StartTimeUtc string `json:"start_time_utc"`
+					} `json:"occurrences"`
+					Comments []struct {
+						Session struct {
+							SessionID int32 `json:"session_id"`
+						} `json:"session"`
+					} `json:"comments"`
+					Rating struct {
+						RatingHistogram []int32 `json:"rating_histogram"`
+					} `json:"rating"`
+				} `json:"session"`
+				Missing *struct{} `json:"missing"`
+			} `json:"data"`
+			Errors []gqlerrors.FormattedError `json:"errors"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Errors) != 0 {
+			t.Fatalf("expected no errors, got %+v", res.Errors)
+		}
+		session := res.Data.Session
+		if session.SessionID != testSession1Id || session.Venue.VenueID != testVenueId || len(session.Venue.Sessions) != 2 {
+			t.Errorf("expected the session with its venue and the two sessions of the venue, got %+v", session)
+		}
+		if len(session.Occurrences) > 3 {
+			t.Errorf("expected at most 3 occurrences, got %v", len(session.Occurrences))
+		}
+		for _, o := range session.Occurrences {
+			if !strings.HasPrefix(o.StartTimeUtc, o.Date+"T") {
+				t.Errorf("expected the start time on the date of the occurrence, got %+v", o)
+			}
+		}
+		if len(session.Comments) > 2 {
+			t.Errorf("expected at most 2 comments, got %v", len(session.Comments))
+		}
+		for _, c := range session.Comments {
+			if c.Session.SessionID != testSession1Id {
+				t.Errorf("expected the comments of session %v, got %+v", testSession1Id, c)
+			}
+		}
+		if session.Rating.RatingHistogram == nil {
+			t.Error("expected a rating histogram")
+		}
+		if res.Data.Missing != nil {
+			t.Errorf("expected null for a session that doesn't exist, got %+v", res.Data.Missing)
+		}
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	adminToken = "admin-secret"
+	migrationsDirectory = t.TempDir()
+	autoApplyDirectory = t.TempDir()
+	userPermissions = func(ctx context.Context, email string) (dbutils.GetUserPermissionsRow, error) {
+		switch email {
+		case "trusted@example.org":
+			return dbutils.GetUserPermissionsRow{Role: "trusted_contributor", OwnedVenues: []int32{}}, nil
+		case "owner@example.org":
+			return dbutils.GetUserPermissionsRow{Role: "contributor", OwnedVenues: []int32{3}}, nil
+		case "moderator@example.org":
+			return dbutils.GetUserPermissionsRow{Role: "moderator", OwnedVenues: []int32{}}, nil
+		case "contributor@example.org":
+			return dbutils.GetUserPermissionsRow{Role: "contributor", OwnedVenues: []int32{}}, nil
+		}
+		return dbutils.GetUserPermissionsRow{}, pgx.ErrNoRows // not registered yet
+	}
+	sessionVenue = func(ctx context.Context, session int32) (int32, error) {
+		if session == 5 {
+			return 3, nil
+		}
+		return 0, pgx.ErrNoRows
+	}
+	defer func() {
+		authIssuer, adminToken, userPermissions = nil, "", nil
+		sessionVenue = func(ctx context.Context, session int32) (int32, error) { return queries.GetSessionVenue(ctx, session) }
+	}()
+
+	credentials := map[string]string{"anonymous": "", "admin token": "Bearer admin-secret", "wrong token": "Bearer wrong"}
+	for _, user := range []string{"contributor", "unregistered", "trusted", "owner", "moderator"} {
+		token, err := authIssuer.SessionToken(user + "@example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentials[user] = "Bearer " + token
+	}
+
+	srv, _ := newTestServerWithSpec()
+
+	// where the migration of an allowed request ends up
+	const review, applied = "review", "auto-applied"
+	cases := []struct {
+		user      string
+		method    string
+		url       string
+		expStatus int
+		expTarget string
+	}{
+		{"anonymous", http.MethodDelete, "/v1/venues/3", http.StatusUnauthorized, ""},
+		{"anonymous", http.MethodDelete, "/v1/jamsessions/5", http.StatusUnauthorized, ""},
+		{"wrong token", http.MethodDelete, "/v1/venues/3", http.StatusUnauthorized, ""},
+		{"anonymous", http.MethodPatch, "/v1/venues/3", http.StatusOK, review},
+		{"anonymous", http.MethodPatch, "/v1/jamsessions/5", http.StatusOK, review},
+		{"unregistered", http.MethodDelete, "/v1/venues/3", http.StatusOK, review},
+		{"contributor", http.MethodDelete, "/v1/venues/3", http.StatusOK, review},
+		{"contributor", http.MethodDelete, "/v1/jamsessions/5", http.StatusOK, review},
+		{"contributor", http.MethodPatch, "/v1/venues/3", http.StatusOK, review},
+		{"trusted", http.MethodDelete, "/v1/venues/4", http.StatusOK, applied},
+		{"trusted", http.MethodPatch, "/v1/jamsessions/5", http.StatusOK, applied},
+		{"owner", http.MethodDelete, "/v1/venues/3", http.StatusOK, applied},
+		{"owner", http.MethodPatch, "/v1/venues/3", http.StatusOK, applied},
+		{"owner", http.MethodPatch, "/v1/jamsessions/5", http.StatusOK, applied},
+		{"owner", http.MethodPatch, "/v1/jamsessions/5?venue=3", http.StatusOK, applied},
+		{"owner", http.MethodPatch, "/v1/jamsessions/5?venue=4", http.StatusOK, review}, // moved to a venue of someone else
+		{"trusted", http.MethodPatch, "/v1/jamsessions/5?venue=4", http.StatusOK, applied},
+		{"owner", http.MethodDelete, "/v1/venues/4", http.StatusOK, review},
+		{"owner", http.MethodDelete, "/v1/jamsessions/6", http.StatusOK, review},
+		{"moderator", http.MethodDelete, "/v1/venues/4", http.StatusOK, applied},
+		{"admin token", http.MethodDelete, "/v1/jamsessions/5", http.StatusOK, applied},
+	}
+	for _, tc := range cases {
+		var body io.Reader
+		if tc.method == http.MethodPatch && strings.HasPrefix(tc.url, "/v1/venues") {
+			body = strings.NewReader(`{"venue_website": "https://example.org"}`)
+		} else if venue, ok := strings.CutPrefix(tc.url, "/v1/jamsessions/5?venue="); ok {
+			body = strings.NewReader(`{"venue": ` + venue + `}`)
+		} else if tc.method == http.MethodPatch {
+			body = strings.NewReader(`{"description": "Edited"}`)
+		}
+		req := httptest.NewRequest(tc.method, tc.url, body)
+		req.Header.Set("Content-Type", "application/json")
+		if credentials[tc.user] != "" {
+			req.Header.Set("Authorization", credentials[tc.user])
+		}
+		reviewed, _ := os.ReadDir(migrationsDirectory)
+		autoApplied, _ := os.ReadDir(autoApplyDirectory)
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code != tc.expStatus {
+			t.Errorf("%v %v as %v: expected status %v, got %v (%v)", tc.method, tc.url, tc.user, tc.expStatus, w.Code, w.Body.String())
+		}
+		target := ""
+		if dir, _ := os.ReadDir(migrationsDirectory); len(dir) > len(reviewed) {
+			target = review
+		}
+		if dir, _ := os.ReadDir(autoApplyDirectory); len(dir) > len(autoApplied) {
+			target = applied
+		}
+		if target != tc.expTarget {
+			t.Errorf("%v %v as %v: expected the migration to be %q, got %q", tc.method, tc.url, tc.user, tc.expTarget, target)
+		}
+	}
+
+	// admin routes
+	handler := AuthMiddleware(RequireRole(auth.RoleModerator, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	adminOnly := AuthMiddleware(RequireRole(auth.RoleAdmin, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	adminCases := []struct {
+		user           string
+		expStatus      int
+		expStatusAdmin int
+	}{
+		{"anonymous", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"wrong token", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"contributor", http.StatusForbidden, http.StatusForbidden},
+		{"trusted", http.StatusForbidden, http.StatusForbidden},
+		{"owner", http.StatusForbidden, http.StatusForbidden},
+		{"moderator", http.StatusNoContent, http.StatusForbidden},
+		{"admin token", http.StatusNoContent, http.StatusNoContent},
+	}
+	for _, tc := range adminCases {
+		for i, h := range []http.Handler{handler, adminOnly} {
+			expStatus := []int{tc.expStatus, tc.expStatusAdmin}[i]
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/comments", nil)
+			if credentials[tc.user] != "" {
+				req.Header.Set("Authorization", credentials[tc.user])
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != expStatus {
+				t.Errorf("admin route as %v: expected status %v, got %v", tc.user, expStatus, w.Code)
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	key, revoked := "jsk_valid", "jsk_revoked"
+	lookups := 0
+	lookupApiKey = func(ctx context.Context, hash string) (dbutils.LondonJamSessionsApiKey, error) {
+		lookups++
+		if hash == apikeys.Hash(key) {
+			return dbutils.LondonJamSessionsApiKey{KeyID: 7, Name: "bot", RateLimit: 3}, nil
+		}
+		return dbutils.LondonJamSessionsApiKey{}, pgx.ErrNoRows
+	}
+	anonymousRateLimit, adminToken = 2, "admin-secret"
+	rateLimiter, apiKeyUsage = apikeys.NewLimiter(time.Minute), apikeys.NewUsage()
+	defer func() {
+		lookupApiKey, anonymousRateLimit, adminToken = nil, defaultAnonymousRateLimit, ""
+		rateLimiter, apiKeyUsage = apikeys.NewLimiter(time.Minute), apikeys.NewUsage()
+	}()
+
+	handler := RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	cases := []struct {
+		authorization string
+		remoteAddr    string
+		expStatus     int
+		expRemaining  string
+	}{
+		{"", "192.0.2.1:1234", http.StatusNoContent, "1"},
+		{"", "192.0.2.1:1234", http.StatusNoContent, "0"},
+		{"", "192.0.2.1:1234", http.StatusTooManyRequests, "0"},
+		{"", "192.0.2.2:1234", http.StatusNoContent, "1"}, // limited per client IP
+		{"Bearer " + key, "192.0.2.1:1234", http.StatusNoContent, "2"},
+		{"Bearer " + key, "192.0.2.2:1234", http.StatusNoContent, "1"}, // limited per key
+		{"Bearer " + key, "192.0.2.1:1234", http.StatusNoContent, "0"},
+		{"Bearer " + key, "192.0.2.1:1234", http.StatusTooManyRequests, "0"},
+		{"Bearer " + revoked, "192.0.2.3:1234", http.StatusUnauthorized, ""},
+		{"Bearer " + revoked, "192.0.2.3:1234", http.StatusUnauthorized, ""},
+		{"Bearer admin-secret", "192.0.2.1:1234", http.StatusNoContent, ""}, // not limited
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jamsessions", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.expStatus {
+			t.Errorf("case %v: expected status %v, got %v", i, tc.expStatus, w.Code)
+		}
+		if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != tc.expRemaining {
+			t.Errorf("case %v: expected %q remaining requests, got %q", i, tc.expRemaining, remaining)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("case %v: expected the Retry-After header to be set", i)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("expected keys to be cached, got %v lookups", lookups)
+	}
+	if usage := apiKeyUsage.Take(); usage[7].Requests != 3 {
+		t.Errorf("expected 3 requests to be counted for the key, got %+v", usage)
+	}
+}
+
+func TestSameSchedule(t *testing.T) {
+	monday := time.Date(2024, 1, 1, 19, 30, 0, 0, time.UTC)
+	cases := []struct {
+		interval types.Interval
+		other    time.Time
+		exp      bool
+	}{
+		{types.Weekly, monday.AddDate(0, 0, 7), true},
+		{types.Weekly, monday.AddDate(0, 0, 1), false},
+		{types.Weekly, monday.Add(time.Hour), false},
+		{types.Weekly, monday.In(time.FixedZone("UTC+1", 3600)), true},
+		{types.Fortnightly, monday.AddDate(0, 0, -14), true},
+		{types.Fortnightly, monday.AddDate(0, 0, 7), false},
+		{types.Daily, monday.AddDate(0, 0, 3), true},
+		{types.Once, monday, true},
+		{types.Once, monday.AddDate(0, 0, 7), false},
+		{types.LastOfMonth, monday.AddDate(0, 0, 28), true},
+	}
+	for _, tc := range cases {
+		if got := sameSchedule(tc.interval, monday, tc.other); got != tc.exp {
+			t.Errorf("expected sameSchedule(%v, %v, %v) to be %v", tc.interval, monday, tc.other, tc.exp)
+		}
+	}
+}
+
+func TestProblem(t *testing.T) {
+	cases := map[string]struct {
+		err       error
+		expStatus int
+	}{
+		"no rows":          {fmt.Errorf("query failed: %w", pgx.ErrNoRows), 404},
+		"unique violation": {&pgconn.PgError{Code: "23505", Message: `duplicate key value violates unique constraint "jamsessions_venue_start_time_utc_interval_key"`, ConstraintName: "jamsessions_venue_start_time_utc_interval_key"}, 409},
+		"foreign key":      {fmt.Errorf("insert failed: %w", &pgconn.PgError{Code: "23503"}), 409},
+		"other db error":   {&pgconn.PgError{Code: "42P01"}, 500},
+		"validation error": {types.ValidationError{Msg: "invalid", Field: "interval"}, 400},
+		"already mapped":   {fuego.ForbiddenError{Err: errors.New("forbidden")}, 403},
+		"unknown error":    {errors.New("something went wrong"), 500},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			handler := fuego.HTTPHandler(s, func(c *fuego.ContextNoBody) (any, error) {
+				return nil, problem(tc.err)
+			})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			res := w.Result()
+			defer res.Body.Close()
+			body := checkProblem(t, res, tc.expStatus)
+			if tc.expStatus == 500 && body.Detail != unknownErrorDetail {
+				t.Errorf("expected internal errors not to be exposed, got %v", body.Detail)
+			}
+			if tc.expStatus == 409 && (body.Detail != conflictDetail || len(body.Errors) != 0) {
+				t.Errorf("expected the constraint violation not to be exposed, got %+v", body)
+			}
+		})
+	}
+}
+
+func TestUnknownQueryParams(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{fuego.HTTPHandler(s, GetSessions), fuego.HTTPHandler(s, GetVenues)} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/?limit=2&foo=1&bar=2", nil))
+		res := w.Result()
+		defer res.Body.Close()
+		body := checkProblem(t, res, 400)
+		if body.Detail != "The following query parameters are not recognised: bar,foo" {
+			t.Errorf("unexpected detail %v", body.Detail)
+		}
+		if len(body.Errors) != 2 || body.Errors[0].Name != "bar" || body.Errors[1].Name != "foo" {
+			t.Errorf("expected an error for every unknown parameter, got %+v", body.Errors)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, n := range []float64{0, -3.5, 1e9, math.Inf(1), math.Inf(-1)} {
+		c := newCursor("-rating", n, ptr("name"), 42)
+		decoded, err := decodeCursor(c.encode())
+		if err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if *decoded != c || *decoded.sortNum() != n {
+			t.Errorf("expected %+v after round trip, got %+v", c, *decoded)
+		}
+	}
+	for _, s := range []string{"", "abc", base64.RawURLEncoding.EncodeToString([]byte(`{"n":"x"}`))} {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("expected an error when decoding cursor %q", s)
+		}
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	row := dbutils.GetDataVersionRow{LastModified: pgtype.Timestamptz{Time: now.Add(-time.Hour), Valid: true}, SessionCount: 3, VenueCount: 2}
+	v := newDataVersion(row, now)
+	if !v.LastModified.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected Last-Modified to be %v, got %v", now.Add(-time.Hour), v.LastModified)
+	}
+	if v2 := newDataVersion(row, now.Add(time.Hour)); v2.ETag != v.ETag {
+		t.Errorf("expected the ETag to be stable within the same day")
+	}
+	if v2 := newDataVersion(row, now.Add(24*time.Hour)); v2.ETag == v.ETag || !v2.LastModified.Equal(now.Truncate(24*time.Hour).Add(24*time.Hour)) {
+		t.Errorf("expected ETag and Last-Modified to change at midnight, got %+v (before: %+v)", v2, v)
+	}
+	deleted := row
+	deleted.SessionCount--
+	if newDataVersion(deleted, now).ETag == v.ETag {
+		t.Errorf("expected the ETag to change when a row is deleted")
+	}
+
+	for _, tc := range []struct {
+		header   string
+		value    string
+		expected bool
+	}{
+		{"If-None-Match", v.ETag, true},
+		{"If-None-Match", `"abc", W/` + v.ETag, true},
+		{"If-None-Match", "*", true},
+		{"If-None-Match", `"abc"`, false},
+		{"If-Modified-Since", v.LastModified.Format(http.TimeFormat), true},
+		{"If-Modified-Since", v.LastModified.Add(-time.Second).Format(http.TimeFormat), false},
+		{"If-Modified-Since", "yesterday", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/venues", nil)
+		req.Header.Set(tc.header, tc.value)
+		if v.notModified(req) != tc.expected {
+			t.Errorf("expected notModified to be %v for %v: %v", tc.expected, tc.header, tc.value)
+		}
+	}
+	// If-None-Match takes precedence
+	req := httptest.NewRequest(http.MethodGet, "/venues", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+	req.Header.Set("If-Modified-Since", v.LastModified.Format(http.TimeFormat))
+	if v.notModified(req) {
+		t.Errorf("expected If-Modified-Since to be ignored if If-None-Match is present")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	rc := newResponseCache()
+	res := cachedResponse{day: "2025-03-01", status: 200, body: []byte("{}")}
+
+	_, _, generation := rc.get("/venues?", "2025-03-01")
+	rc.put("/venues?", generation, res)
+	if _, ok, _ := rc.get("/venues?", "2025-03-01"); ok {
+		t.Errorf("expected the cache to be inactive until it is enabled")
+	}
+
+	rc.setEnabled(true)
+	_, _, generation = rc.get("/venues?", "2025-03-01")
+	rc.put("/venues?", generation, res)
+	if cached, ok, _ := rc.get("/venues?", "2025-03-01"); !ok || string(cached.body) != "{}" {
+		t.Errorf("expected a cache hit, got %v", ok)
+	}
+	if _, ok, _ := rc.get("/venues?", "2025-03-02"); ok {
+		t.Errorf("expected responses from the previous day to be ignored")
+	}
+
+	// responses computed before an invalidation are discarded
+	_, _, generation = rc.get("/jamsessions?", "2025-03-01")
+	rc.invalidate()
+	rc.put("/jamsessions?", generation, res)
+	for _, key := range []string{"/venues?", "/jamsessions?"} {
+		if _, ok, _ := rc.get(key, "2025-03-01"); ok {
+			t.Errorf("expected %v not to be cached after the invalidation", key)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/jamsessions?sort=name&limit=1", nil)
+	req2 := httptest.NewRequest(http.MethodGet, "/jamsessions?limit=1&sort=name", nil)
+	if cacheKey(req) != cacheKey(req2) {
+		t.Errorf("expected the order of query parameters not to matter, got %v and %v", cacheKey(req), cacheKey(req2))
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	_, spec := newTestServerWithSpec()
+	raw := []byte(`{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-0.132, 51.514]}, "properties": {"session_id": 1, "rating": 4.5, "start_time_utc": "2024-01-01T19:30:00+00:00", "dates": ["2024-01-09"], "genres": ["Blues"]}}]}`)
+	withLinks, err := appendLinks(raw, types.Link{Rel: "next", Href: "/v1/jamsessions?cursor=abc"})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	for name, ans := range map[string]types.SessionWithVenueFeatureCollection{
+		"raw":   types.RawFeatureCollection[types.SessionFeature[types.SessionPropertiesWithVenue]](withLinks),
+		"typed": {Type: "FeatureCollection", Features: []types.SessionFeature[types.SessionPropertiesWithVenue]{{Type: "Feature", Geometry: types.Geometry{Type: "Point", Coordinates: []float64{-0.132, 51.514}}}}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jamsessions", nil)
+		w := httptest.NewRecorder()
+		if err := Serialize(w, req, ans); err != nil {
+			t.Fatalf("%v: expected error to be nil got %v", name, err)
+		}
+		res := w.Result()
+		if name == "raw" {
+			body, _ := io.ReadAll(res.Body)
+			if !bytes.Equal(body, withLinks) {
+				t.Errorf("expected the raw document to be sent as is, got %s", body)
+			}
+			res.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		checkOpenAPIConformance(t, spec, "/v1/jamsessions", req, res)
+	}
+}
+
+func TestAppendLinks(t *testing.T) {
+	link := types.Link{Rel: "next", Href: "/v1/venues?cursor=abc"}
+	for in, expected := range map[string]string{
+		`{"type":"FeatureCollection"}` + "\n": `{"type":"FeatureCollection","links":[{"rel":"next","href":"/v1/venues?cursor=abc"}]}`,
+		`{}`:                                  `{"links":[{"rel":"next","href":"/v1/venues?cursor=abc"}]}`,
+	} {
+		out, err := appendLinks([]byte(in), link)
+		if err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		if string(out) != expected {
+			t.Errorf("expected %v, got %s", expected, out)
+		}
+		if !json.Valid(out) {
+			t.Errorf("expected valid JSON, got %s", out)
+		}
+	}
+	if _, err := appendLinks([]byte(`[1, 2]`), link); err == nil {
+		t.Errorf("expected an error when appending links to an array")
+	}
+}
+
+func TestNestReplies(t *testing.T) {
+	comments := []types.Comment{{CommentID: 1}, {CommentID: 2}}
+	var replies []types.Comment
+	for i := int32(0); i < maxNestedReplies+1; i++ {
+		replies = append(replies, types.Comment{CommentID: 100 + i, ParentComment: ptr(int32(1))})
+	}
+	replies = append(replies, types.Comment{CommentID: 200, ParentComment: ptr(int32(100))}, types.Comment{CommentID: 201, ParentComment: ptr(int32(200))})
+
+	nestReplies(comments, replies)
+	if len(comments[0].Replies) != maxNestedReplies || comments[0].Replies[0].CommentID != 100 {
+		t.Errorf("expected the first %v replies to be nested under comment 1, got %+v", maxNestedReplies, comments[0].Replies)
+	}
+	if nested := comments[0].Replies[0].Replies; len(nested) != 1 || len(nested[0].Replies) != 1 || nested[0].Replies[0].CommentID != 201 {
+		t.Errorf("expected replies to be nested recursively, got %+v", nested)
+	}
+	if comments[1].Replies != nil {
+		t.Errorf("expected no replies for comment 2, got %+v", comments[1].Replies)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	defer func() { authIssuer = nil }()
+	token, err := authIssuer.SessionToken("player@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginToken, err := authIssuer.LoginToken("player@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email *string
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email = userEmail(r)
+	}))
+	cases := []struct {
+		header   string
+		cookie   string
+		expected string
+	}{
+		{"", "", ""},
+		{"Bearer " + token, "", "player@example.org"},
+		{"", token, "player@example.org"},
+		{"Bearer wrong", "", ""},
+		{"Bearer " + loginToken, "", ""}, // login tokens can't be used as session tokens
+		{"", "wrong", ""},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.cookie})
+		}
+		email = nil
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if (email == nil && tc.expected != "") || (email != nil && *email != tc.expected) {
+			t.Errorf("case %v: expected the user %q, got %v", i, tc.expected, email)
+		}
+	}
+}
+
+func TestNotificationEndpoints(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	defer func() { authIssuer, vapidPublicKey = nil, "" }()
+	srv, _ := newTestServerWithSpec()
+
+	for _, tc := range []struct {
+		method, path string
+		expected     int
+	}{
+		{http.MethodGet, "/v1/me/follows", http.StatusUnauthorized},
+		{http.MethodPut, "/v1/me/follows/jamsessions/1", http.StatusUnauthorized},
+		{http.MethodDelete, "/v1/me/follows/venues/1", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/me/notification-channels", http.StatusUnauthorized},
+		{http.MethodDelete, "/v1/me/notification-channels/1", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/notifications/webpush-key", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("%v %v: expected status %v, got %v", tc.method, tc.path, tc.expected, w.Code)
+		}
+	}
+
+	vapidPublicKey = "BPublicKey"
+	w := httptest.NewRecorder()
+	srv.Mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/notifications/webpush-key", nil))
+	var key WebPushKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&key); err != nil || key.PublicKey != "BPublicKey" {
+		t.Errorf("expected the public key, got %+v (err: %v)", key, err)
+	}
+}
+
+func TestSavedSearchValidation(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	defer func() { authIssuer = nil }()
+	srv, _ := newTestServerWithSpec()
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, httptest.NewRequest(method, "/v1/me/saved-searches", strings.NewReader(`{"search_name": "Blues"}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%v: expected status 401, got %v", method, w.Code)
+		}
+	}
+
+	lon, lat, latOutOfRange, radius, tooFar := -0.13, 51.51, 151.0, int32(2000), int32(100000)
+	for i, tc := range []struct {
+		body  SavedSearchBody
+		field string // empty if valid
+	}{
+		{SavedSearchBody{SearchName: "Blues", Genres: []string{"Blues"}, Backline: []string{"PA"}, Weekdays: []int16{1, 7}, Lon: &lon, Lat: &lat, RadiusMetres: &radius}, ""},
+		{SavedSearchBody{SearchName: "  "}, "search_name"},
+		{SavedSearchBody{SearchName: strings.Repeat("a", 101)}, "search_name"},
+		{SavedSearchBody{SearchName: "Polka", Genres: []string{"Polka"}}, "genres"},
+		{SavedSearchBody{SearchName: "Tuba", Backline: []string{"Tuba"}}, "backline"},
+		{SavedSearchBody{SearchName: "Funday", Weekdays: []int16{0}}, "weekdays"},
+		{SavedSearchBody{SearchName: "Nearby", Lon: &lon, Lat: &lat}, "radius_metres"},
+		{SavedSearchBody{SearchName: "Everywhere", Lon: &lon, Lat: &lat, RadiusMetres: &tooFar}, "radius_metres"},
+		{SavedSearchBody{SearchName: "Mars", Lon: &lon, Lat: &latOutOfRange, RadiusMetres: &radius}, "lon"},
+	} {
+		err := tc.body.validate()
+		if tc.field == "" {
+			if err != nil {
+				t.Errorf("case %v: expected the search to be valid, got %v", i, err)
+			}
+			continue
+		}
+		var badRequest fuego.BadRequestError
+		if !errors.As(err, &badRequest) || len(badRequest.Errors) != 1 || badRequest.Errors[0].Name != tc.field {
+			t.Errorf("case %v: expected a validation error for %v, got %v", i, tc.field, err)
+		}
+	}
+}
+
+func TestFavouritesCalendar(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	defer func() { authIssuer = nil }()
+	srv, _ := newTestServerWithSpec()
+
+	for path, expected := range map[string]int{
+		"/v1/me/favourites":                         http.StatusUnauthorized,
+		"/v1/me/favourites/calendar":                http.StatusUnauthorized,
+		"/v1/calendar/1-wrong.ics":                  http.StatusNotFound,
+		"/v1/calendar/2-" + strings.Repeat("A", 43): http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("%v: expected status %v, got %v", path, expected, w.Code)
+		}
+	}
+
+	website := "https://example.org/jam"
+	cal := favouritesCalendar([]dbutils.GetFavouriteOccurrencesRow{{
+		SessionID:        7,
+		SessionName:      "Blues Jam",
+		StartTimeUtc:     pgtype.Timestamptz{Time: time.Date(2024, 1, 1, 19, 30, 0, 0, time.UTC), Valid: true},
+		DurationMinutes:  150,
+		SessionWebsite:   &website,
+		VenueName:        "The Pub",
+		AddressFirstLine: "1 Main Street",
+		City:             "London",
+		Postcode:         "N1 1AA",
+		Dates:            []pgtype.Date{{Time: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), Valid: true}, {Time: time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), Valid: true}},
+	}})
+	if len(cal.Events) != 2 {
+		t.Fatalf("expected an event per date, got %+v", cal.Events)
+	}
+	e := cal.Events[1]
+	if !e.Start.Equal(time.Date(2024, 6, 10, 19, 30, 0, 0, time.UTC)) || !e.End.Equal(time.Date(2024, 6, 10, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the event to take place on 2024-06-10 from 19:30 to 22:00, got %v - %v", e.Start, e.End)
+	}
+	if e.Location != "The Pub, 1 Main Street, London, N1 1AA" || e.URL != website {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Write(&buf, time.Now()); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	if err := Serialize(w, httptest.NewRequest(http.MethodGet, "/v1/calendar/x.ics", nil), CalendarFeed(buf.Bytes())); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/calendar; charset=utf-8" {
+		t.Errorf("expected the feed to be sent as text/calendar, got %v", ct)
+	}
+	if !strings.Contains(w.Body.String(), "SUMMARY:Blues Jam") {
+		t.Errorf("expected the feed to contain the session, got %v", w.Body.String())
+	}
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	authIssuer = auth.NewIssuer([]byte("secret"))
+	adminToken = "admin-secret"
+	migrationsDirectory = t.TempDir()
+	autoApplyDirectory = t.TempDir()
+	defer func() { authIssuer, adminToken = nil, "" }()
+	srv, _ := newTestServerWithSpec()
+
+	for _, tc := range []struct {
+		method, path, body string
+		authorization      string
+		expected           int
+	}{
+		{http.MethodGet, "/v1/admin/webhooks", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/admin/webhooks", `{"url": "https://example.org/hook", "event_types": ["session.created"]}`, "", http.StatusUnauthorized},
+		{http.MethodDelete, "/v1/admin/webhooks/1", "", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/admin/webhooks/1/deliveries", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/admin/webhooks", `{"url": "http://example.org/hook", "event_types": ["session.created"]}`, "Bearer admin-secret", http.StatusBadRequest},
+		{http.MethodPost, "/v1/admin/webhooks", `{"url": "https://example.org/hook", "event_types": ["session.rated"]}`, "Bearer admin-secret", http.StatusBadRequest},
+		{http.MethodPost, "/v1/admin/webhooks", `{"url": "https://example.org/hook", "event_types": []}`, "Bearer admin-secret", http.StatusBadRequest},
+		{http.MethodGet, "/v1/admin/webhooks/abc/deliveries", "", "Bearer admin-secret", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("%v %v %v: expected status %v, got %v (%v)", tc.method, tc.path, tc.body, tc.expected, w.Code, w.Body.String())
+		}
+	}
+
+	// the subscription is created by a migration (applied without review), the secret is only returned now
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/webhooks", strings.NewReader(`{"url": "https://example.org/hook?a=1", "event_types": ["session.created", "venue.deleted"], "description": "Gig guide; weekly"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	srv.Mux.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %v (%v)", w.Code, w.Body.String())
+	}
+	var created WebhookCreated
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || !strings.HasPrefix(created.Secret, "whsec_") || len(created.EventTypes) != 2 {
+		t.Fatalf("expected the subscription and its secret, got %+v (err: %v)", created, err)
+	}
+	files, _ := os.ReadDir(autoApplyDirectory)
+	if len(files) != 1 {
+		t.Fatalf("expected one migration in the auto-apply directory, got %v", len(files))
+	}
+	migration, err := os.ReadFile(filepath.Join(autoApplyDirectory, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`dbcli webhook add "`, created.Secret, `https://example.org/hook?a=1`, `Gig guide; weekly`} {
+		if !strings.Contains(string(migration), expected) {
+			t.Errorf("expected the migration to contain %v, got %s", expected, migration)
+		}
+	}
+}
+
+func TestSubmissionAlerts(t *testing.T) {
+	memory := &alerts.MemoryNotifier{}
+	alerter, adminToken, publicUrl = memory, "admin-secret", "https://example.org/api/"
+	migrationsDirectory, autoApplyDirectory, suggestionsDirectory = t.TempDir(), t.TempDir(), t.TempDir()
+	defer func() { alerter, adminToken, publicUrl = nil, "", "" }()
+	srv, _ := newTestServerWithSpec()
+
+	for _, tc := range []struct {
+		method, path, body, authorization string
+	}{
+		{http.MethodPatch, "/v1/venues/3", `{"venue_name": "The Old Pub"}`, ""},
+		{http.MethodPatch, "/v1/venues/3", `{"venue_name": "The New Pub"}`, "Bearer admin-secret"}, // applied without review
+		{http.MethodPost, "/v1/jamsessions/5/suggestions", `{"author": "Sam", "content": "The session has moved to Tuesdays"}`, ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code >= 300 {
+			t.Fatalf("%v %v: expected the submission to be accepted, got %v (%v)", tc.method, tc.path, w.Code, w.Body.String())
+		}
+		pendingAlerts.Wait() // alerts are sent in the background
+	}
+
+	sent := memory.Alerts()
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", sent)
+	}
+	if sent[0].Type != "venue update" || sent[0].Name != "The Old Pub" || sent[0].ReviewURL != "https://example.org/api/v1/admin/submissions" || sent[0].Time.IsZero() {
+		t.Errorf("expected an alert about the venue update, got %+v", sent[0])
+	}
+	if sent[1].Type != "suggestion" || sent[1].Name != "session 5" || sent[1].Notes != "The session has moved to Tuesdays" || sent[1].Submitter != "Sam" {
+		t.Errorf("expected an alert about the suggestion, got %+v", sent[1])
+	}
+
+	// the review endpoint lists both submissions
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/submissions", nil)
+	w := httptest.NewRecorder()
+	srv.Mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without credentials, got %v", w.Code)
+	}
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w = httptest.NewRecorder()
+	srv.Mux.ServeHTTP(w, req)
+	var submissions []types.Submission
+	if err := json.NewDecoder(w.Body).Decode(&submissions); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(submissions) != 2 || submissions[0].Type != "migration" || !strings.Contains(submissions[0].Content, `dbcli update venue 3`) ||
+		submissions[1].Type != "suggestion" || !strings.Contains(submissions[1].Content, "Tuesdays") {
+		t.Errorf("expected the venue update and the suggestion, got %+v", submissions)
+	}
+}
+
+// helper func - reads the next event (or comment) of a text/event-stream
+func readStreamEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fields
+		}
+		k, v, _ := strings.Cut(line, ": ")
+		fields[k] = v
+	}
+}
+
+func TestGraphQLEndpoints(t *testing.T) {
+	srv, _ := newTestServerWithSpec()
+
+	for _, tc := range []struct {
+		method, path, body string
+		expected           int
+		contains           string
+	}{
+		{http.MethodPost, "/v1/graphql", `{"query": "{ session(id: 1) { session_name"}`, http.StatusBadRequest, graphqlParseFailed},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ session(id: 1) { name } }"}`, http.StatusBadRequest, graphqlValidationFailed},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ session { session_name } }"}`, http.StatusBadRequest, graphqlValidationFailed},
+		// 100 sessions with 100 comments each
+		{http.MethodPost, "/v1/graphql", `{"query": "{ sessions(first: 100) { comments(first: 100) { session { session_id } } } }"}`, http.StatusBadRequest, graphqlQueryTooComplex},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ sessions { venue { sessions { venue { sessions { venue { sessions { venue { venue_id } } } } } } } } }"}`, http.StatusBadRequest, graphqlQueryTooComplex},
+		// limits apply to the operation that is executed, introspection is free
+		{http.MethodPost, "/v1/graphql", `{"query": "query A { __typename } query B { sessions(first: 100) { comments(first: 100) { session { session_id } } } }", "operationName": "A"}`, http.StatusOK, `"__typename":"Query"`},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ __schema { queryType { name } types { name fields { name type { name ofType { name ofType { name ofType { name ofType { name } } } } } } } } }"}`, http.StatusOK, `"queryType":{"name":"Query"}`},
+		{http.MethodPost, "/v1/graphql", `{"query": "mutation { session(id: 1) { session_id } }"}`, http.StatusBadRequest, graphqlValidationFailed},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Mux.ServeHTTP(w, req)
+		if w.Code != tc.expected || !strings.Contains(w.Body.String(), tc.contains) {
+			t.Errorf("%v %v %v: expected status %v and %q in the body, got %v (%v)", tc.method, tc.path, tc.body, tc.expected, tc.contains, w.Code, w.Body.String())
+		}
+	}
+
+	// the GET routes are cached, which requires the database (the handlers are called directly)
+	w := httptest.NewRecorder()
+	fuego.HTTPHandler(s, GetGraphQL).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/graphql?query=%7B%20venues%20%7B%20venue_id%20%7D%20%7D&variables=abc", nil))
+	checkProblem(t, w.Result(), http.StatusBadRequest)
+	w = httptest.NewRecorder()
+	fuego.HTTPHandler(s, GetGraphQLSchema).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/graphql/schema", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "type Session {") {
+		t.Errorf("expected the schema, got %v (%v)", w.Code, w.Body.String())
+	}
+}
+
+func TestGraphQLLoader(t *testing.T) {
+	// a list of 3 items whose parents are loaded by one loader - the executor calls the thunks of a level after all
+	// resolvers of the level, so the parents are fetched with a single call
+	var fetches [][]int
+	parents := newLoader(func(keys []int) (map[int]string, error) {
+		fetches = append(fetches, keys)
+		result := map[int]string{}
+		for _, k := range keys {
+			if k != 3 {
+				result[k] = fmt.Sprintf("parent %d", k)
+			}
+		}
+		return result, nil
+	})
+	item := graphql.NewObject(graphql.ObjectConfig{Name: "Item", Fields: graphql.Fields{
+		"parent": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return parents.load(p.Source.(int) % 4), nil
+		}},
+	}})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"items": {Type: graphql.NewList(item), Resolve: func(p graphql.ResolveParams) (any, error) { return []int{1, 3, 5}, nil }},
+	}})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ items { parent } again: items { parent } }"})
+	if len(res.Errors) > 0 {
+		t.Fatalf("expected no errors, got %v", res.Errors)
+	}
+	if got, _ := json.Marshal(res.Data); string(got) != `{"again":[{"parent":"parent 1"},{"parent":""},{"parent":"parent 1"}],"items":[{"parent":"parent 1"},{"parent":""},{"parent":"parent 1"}]}` {
+		t.Errorf("unexpected result %s", got)
+	}
+	if len(fetches) != 1 || len(fetches[0]) != 2 {
+		t.Errorf("expected the keys 1 and 3 to be fetched at once, got %v", fetches)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	recorded := []streamEvent{
+		{ID: 1, Type: "session.created", Data: json.RawMessage(`{"session_id":1,"session_name":"Jazz Jam","venue_id":1}`)},
+		{ID: 2, Type: "venue.updated", Data: json.RawMessage(`{"venue_id":1,"venue_name":"The Pub"}`)},
+	}
+	defer func(f func(context.Context, dbutils.GetEventsAfterParams) ([]dbutils.LondonJamSessionsEvent, error)) {
+		loadEvents = f
+	}(loadEvents)
+	loadEvents = func(ctx context.Context, arg dbutils.GetEventsAfterParams) ([]dbutils.LondonJamSessionsEvent, error) {
+		var rows []dbutils.LondonJamSessionsEvent
+		for _, e := range recorded {
+			if e.ID > arg.AfterID && len(rows) < int(arg.MaxResults) {
+				rows = append(rows, dbutils.LondonJamSessionsEvent{EventID: e.ID, EventType: e.Type, Data: e.Data, DtCreated: pgtype.Timestamptz{Time: e.Time, Valid: true}})
+			}
+		}
+		return rows, nil
+	}
+	eventStream = newEventBroker(2)
+	srv, _ := newTestServerWithSpec()
+	server := httptest.NewServer(srv.Mux)
+	defer server.Close()
+
+	t.Run("InvalidLastEventId", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/v1/events?last_event_id=abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status code 400, got %v", res.StatusCode)
+		}
+	})
+
+	t.Run("ReplayAndLive", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/events", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %v %v", res.StatusCode, res.Header)
+		}
+		r := bufio.NewReader(res.Body)
+		if e := readStreamEvent(t, r); e["retry"] == "" {
+			t.Errorf("expected the reconnection delay first, got %v", e)
+		}
+		// the event after the Last-Event-ID is replayed
+		e := readStreamEvent(t, r)
+		if e["id"] != "2" || e["event"] != "venue.updated" {
+			t.Fatalf("expected event 2 to be replayed, got %v", e)
+		}
+		var payload streamEvent
+		if err := json.Unmarshal([]byte(e["data"]), &payload); err != nil || payload.ID != 2 || string(payload.Data) != string(recorded[1].Data) {
+			t.Errorf("expected the event as data, got %v (%v)", e["data"], err)
+		}
+		// events that have been replayed already are skipped
+		eventStream.handleNotification(&pgconn.Notification{Channel: dbutils.EventsChannel, Payload: `{"id":2,"type":"venue.updated","time":"2024-01-30T12:00:00+00:00","data":{}}`})
+		eventStream.handleNotification(&pgconn.Notification{Channel: dbutils.EventsChannel, Payload: `{"id":3,"type":"comment.created","time":"2024-01-30T12:00:00+00:00","data":{"comment_id":5,"session_id":1,"parent_comment":null}}`})
+		if e := readStreamEvent(t, r); e["id"] != "3" || e["event"] != "comment.created" {
+			t.Errorf("expected live event 3, got %v", e)
+		}
+	})
+
+	t.Run("SlowSubscriber", func(t *testing.T) {
+		broker := newEventBroker(0)
+		ch := broker.subscribe()
+		for i := range eventBufferSize + 1 {
+			broker.publish(streamEvent{ID: int64(i + 1), Type: "session.updated"})
+		}
+		n := 0
+		for range ch {
+			n++
+		}
+		if n != eventBufferSize {
+			t.Errorf("expected the channel to be closed after %v events, got %v", eventBufferSize, n)
+		}
+		broker.unsubscribe(ch) // no-op
+		if broker.lastID != int64(eventBufferSize+1) {
+			t.Errorf("expected the last published ID, got %v", broker.lastID)
+		}
+	})
+
+	t.Run("CatchUp", func(t *testing.T) {
+		broker := newEventBroker(1)
+		ch := broker.subscribe()
+		defer broker.unsubscribe(ch)
+		if err := broker.catchUp(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if e := <-ch; e.ID != 2 || len(ch) != 0 {
+			t.Errorf("expected the events after the last published one, got %+v", e)
 		}
 	})
 }
@@ -2,13 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"io/fs"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
 	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	"github.com/felix-schott/jamsessions/backend/internal/moderation"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/cors"
 )
 
@@ -16,6 +28,14 @@ var queries *dbutils.Queries
 var ctx = context.Background()
 var suggestionsDirectory string
 var migrationsDirectory string
+var autoApplyDirectory string
+var adminToken string
+var cache = newResponseCache()
+var spamScorer moderation.SpamScorer
+var ipHashSalt string
+var authIssuer *auth.Issuer
+var mailer mail.Mailer
+var publicUrl string
 
 func main() {
 
@@ -28,6 +48,34 @@ func main() {
 
 	queries = dbutils.New(pool)
 
+	// RESPONSE CACHE - cleared whenever the data changes (see notify_data_changed in schema.sql)
+	listener := dbutils.NewListener(pool, dbutils.DataChangedChannel)
+	listener.OnNotification(func(n *pgconn.Notification) {
+		slog.Debug("Data changed, clearing response cache", "payload", n.Payload)
+		cache.invalidate()
+	})
+	listener.OnConnectionChange(cache.setEnabled)
+	go listener.Listen(ctx)
+
+	// EVENT STREAM - events recorded by the record_event triggers are pushed to the clients of /v1/events
+	loadEvents = queries.GetEventsAfter
+	latestEventId, err := queries.GetLatestEventId(ctx)
+	if err != nil {
+		log.Fatalf("could not get the latest event: %v", err)
+	}
+	eventStream = newEventBroker(latestEventId)
+	eventListener := dbutils.NewListener(pool, dbutils.EventsChannel)
+	eventListener.OnNotification(eventStream.handleNotification)
+	eventListener.OnConnectionChange(func(connected bool) {
+		if !connected {
+			return
+		}
+		if err := eventStream.catchUp(ctx); err != nil {
+			slog.Error("Event stream", "msg", "could not publish the missed events", "err", err)
+		}
+	})
+	go eventListener.Listen(ctx)
+
 	// cli migrations env vars
 	suggestionsDirectory = os.Getenv("MIGRATIONS_SUGGESTIONS")
 	if suggestionsDirectory == "" {
@@ -48,14 +96,98 @@ func main() {
 		log.Fatalf("could not create directory %v: %v", migrationsDirectory, err)
 	}
 
+	// migrations of trusted contributors, applied by a cron job without review (run-migrations.sh -y $MIGRATIONS_DIRECTORY/auto)
+	autoApplyDirectory = os.Getenv("AUTO_APPLY_DIRECTORY")
+	if autoApplyDirectory == "" {
+		autoApplyDirectory = filepath.Join(migrationsDirectory, "auto")
+	}
+	if err := os.MkdirAll(autoApplyDirectory, fs.FileMode(int(0755))); err != nil {
+		log.Fatalf("could not create directory %v: %v", autoApplyDirectory, err)
+	}
+
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, admin endpoints are only available to moderators and admins that are logged in")
+	}
+
+	// COMMENT MODERATION
+	ipHashSalt = os.Getenv("IP_HASH_SALT")
+	if ipHashSalt == "" {
+		// hashes of reporters/submitters can't be compared across restarts without a fixed salt
+		log.Println("IP_HASH_SALT is not set, using a random salt")
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			log.Fatal(err)
+		}
+		ipHashSalt = hex.EncodeToString(salt)
+	}
+	blocklist := moderation.DefaultBlocklist
+	if fp := os.Getenv("SPAM_BLOCKLIST_FILE"); fp != "" {
+		if blocklist, err = moderation.ReadBlocklist(fp); err != nil {
+			log.Fatalf("could not read spam blocklist %v: %v", fp, err)
+		}
+	}
+	spamScorer = moderation.NewLocalScorer(blocklist, queries.CountCommentsWithContent)
+
+	// ACCOUNTS - magic link login
+	authSecret := os.Getenv("AUTH_SECRET")
+	if authSecret == "" {
+		// sessions don't survive restarts without a fixed secret
+		log.Println("AUTH_SECRET is not set, using a random secret")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal(err)
+		}
+		authSecret = hex.EncodeToString(secret)
+	}
+	authIssuer = auth.NewIssuer([]byte(authSecret))
+	userPermissions = queries.GetUserPermissions
+	if mailer, err = mail.New(os.Getenv("MAILER"), os.Getenv("MAIL_DIRECTORY"), os.Getenv("MAIL_FROM"), mail.SMTPServer{
+		Addr:     os.Getenv("SMTP_ADDRESS"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}); err != nil {
+		log.Fatalf("could not set up the mailer: %v", err)
+	}
+	publicUrl = os.Getenv("PUBLIC_URL")
+	if publicUrl == "" {
+		publicUrl = "http://localhost"
+		log.Println("PUBLIC_URL is not set, login links point to", publicUrl)
+	}
+
+	// ALERTS - the admins are notified about new submissions
+	if alerter, err = alerts.New(os.Getenv("ALERT_NOTIFIERS"), alerts.Config{
+		TelegramToken:   os.Getenv("TELEGRAM_TOKEN"),
+		TelegramChatID:  os.Getenv("TELEGRAM_CHAT_ID"),
+		SlackWebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+		Mailer:          mailer,
+		EmailTo:         strings.FieldsFunc(os.Getenv("ALERT_EMAIL"), func(r rune) bool { return r == ',' || r == ' ' }),
+	}); err != nil {
+		log.Fatalf("could not set up alerts: %v", err)
+	}
+
+	// NOTIFICATIONS (delivered by dbcli)
+	vapidPublicKey = os.Getenv("VAPID_PUBLIC_KEY")
+
+	// API KEYS AND RATE LIMITS
+	lookupApiKey = queries.GetApiKeyByHash
+	if v := os.Getenv("ANONYMOUS_RATE_LIMIT"); v != "" {
+		if anonymousRateLimit, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("ANONYMOUS_RATE_LIMIT must be a number of requests per minute, got %v", v)
+		}
+	}
+	go flushApiKeyUsage(ctx, pool, time.Minute)
+
 	// SERVER
 	serverAddr := os.Getenv("SERVER_ADDRESS")
 	if serverAddr == "" {
 		log.Fatal("Please provide a server address (host:port) using the environment variable SERVER_ADDRESS")
 	}
-	s := fuego.NewServer(fuego.WithAddr(serverAddr), fuego.WithCorsMiddleware(cors.New(cors.Options{
+	s := fuego.NewServer(fuego.WithAddr(serverAddr), fuego.WithErrorHandler(ProblemErrorHandler), fuego.WithSerializer(Serialize), fuego.WithCorsMiddleware(cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", editTokenHeader},
+		ExposedHeaders: []string{"ETag", "Last-Modified", "Link", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 	}).Handler))
 	s.OpenApiSpec.Info = &openapi3.Info{
 		Title:       "Jam Sessions",
@@ -63,43 +195,7 @@ func main() {
 		Version:     "0.0.1",
 	}
 
-	// MIDDLEWARE
-	// fuego.Use(s, LoggingMiddleware) //, CORSMiddleWare) - for some reason the logging middleware leads to EOF errors (with post requests)
-
-	// API VERSION 1 - Routes
-	v1 := fuego.Group(s, "/v1")
-
-	fuego.Get(v1, "/", func(c *fuego.ContextNoBody) (string, error) {
-		return "Please use the versioned route /v1 (consult /swagger/index.html for interactive documentation).", nil
-	})
-
-	fuego.Get(v1, "/venues", GetVenues).Summary("Get all venues")
-
-	fuego.Get(v1, "/venues/{id}", GetVenueById).Summary("Get a venue by its ID")
-
-	fuego.Get(v1, "/venues/{id}/jamsessions", GetSessionsByVenueId).Summary("Get jam sessions by venue ID")
-
-	fuego.Post(v1, "/venues", PostVenue).Summary("Add a venue")
-
-	fuego.Patch(v1, "/venues/{id}", PatchVenueById).Summary("Update a venue by ID")
-
-	fuego.Delete(v1, "/venues/{id}", DeleteVenueById).Summary("Delete a venue by ID")
-
-	fuego.Get(v1, "/jamsessions", GetSessions).Summary("Get all jam sessions").Description("Use '/v1/jamsessions?date=2024-01-30' to list jamsessions happening on a particular date. The result is inferred and may not be accurate, especially for past time frames. Use '/jamsessions?backline=PA,Drums' to filter by backline provided (accepted values: 'PA', 'Drums', 'Guitar_Amp', 'Bass_Amp', 'Microphone', 'MiscPercussion'.") // TODO, explain query params
-
-	fuego.Post(v1, "/jamsessions", PostSession).Summary("Add a jam session")
-
-	fuego.Get(v1, "/jamsessions/{id}", GetSessionById).Summary("Get a jam session by ID")
-
-	fuego.Patch(v1, "/jamsessions/{id}", PatchSessionById).Summary("Update a jam session by ID")
-
-	fuego.Delete(v1, "/jamsessions/{id}", DeleteSessionById).Summary("Delete a jam session by ID")
-
-	fuego.Post(v1, "/jamsessions/{id}/comments", PostCommentForSessionById).Summary("Post a comment for a session by ID")
-
-	fuego.Post(v1, "/jamsessions/{id}/suggestions", PostSuggestionsForSessionById).Summary("Post feedback/suggest changes for a session by ID")
-
-	fuego.Get(v1, "/jamsessions/{id}/comments", GetCommentsBySessionId).Summary("Get all comments for a session by ID")
+	registerRoutes(s)
 
 	s.Run()
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/subtle"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -64,3 +66,12 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		slog.Info("Handled request", "method", method, "url", url, "requestBody", requestBody, "requestHeaders", r.Header, "duration", duration, "statusCode", responseData.status, "responseSize", responseData.size)
 	})
 }
+
+// ADMIN
+
+// hasAdminToken reports whether the request carries the admin token (environment variable ADMIN_TOKEN) in the Authorization
+// header ('Authorization: Bearer <token>'), always false if no token is configured. Such requests are made by an admin (see resolvePrincipal).
+func hasAdminToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/felix-schott/jamsessions/backend/internal/moderation"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+// reasons for reporting a comment (see the CHECK constraint of comment_reports.reason)
+var reportReasons = []string{"spam", "offensive", "off_topic", "other"}
+
+// moderation states of a comment, only 'visible' comments are served by the public endpoints
+var commentStatuses = []string{"visible", "hidden", "pending"}
+
+// commentPayload is the JSON written to the insert comment migration (same keys as dbutils.InsertSessionCommentParams)
+type commentPayload struct {
+	CommentBody
+	Status        string   `json:"status,omitempty"`
+	SpamScore     *float64 `json:"spam_score,omitempty"`
+	EditTokenHash string   `json:"edit_token_hash"`
+	UserEmail     *string  `json:"user_email,omitempty"`
+}
+
+// clientIP returns the address of the client - the last entry of X-Forwarded-For (appended by the reverse proxy)
+// or the remote address of the connection
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		parts := strings.Split(fwd, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// scoreComment runs the spam scorer on a comment submission. Errors are logged and the comment is let through,
+// a failing spam check shouldn't prevent users from commenting.
+func scoreComment(r *http.Request, session int, payload CommentBody) moderation.Verdict {
+	if spamScorer == nil || payload.Verified {
+		return moderation.Verdict{}
+	}
+	verdict, err := spamScorer.Score(r.Context(), moderation.Submission{
+		Session: int32(session),
+		Author:  payload.Author,
+		Content: payload.Content,
+		Source:  moderation.HashIP(ipHashSalt, clientIP(r)),
+		Time:    time.Now(),
+	})
+	if err != nil {
+		slog.Error("scoreComment", "session", session, "msg", err)
+		return moderation.Verdict{}
+	}
+	if verdict.Score > 0 {
+		slog.Info("scoreComment", "session", session, "score", verdict.Score, "reasons", verdict.Reasons)
+	}
+	return verdict
+}
+
+type ReportBody struct {
+	// one of 'spam', 'offensive', 'off_topic' or 'other'
+	Reason  string  `json:"reason"`
+	Details *string `json:"details,omitempty"`
+}
+
+func PostReportForCommentById(c *fuego.ContextWithBody[ReportBody]) (any, error) {
+	slog.Info("PostReportForCommentById", "id", c.PathParam("id"))
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/comments/{id}/reports'), got: %v", c.PathParam("id")))
+	}
+	payload, err := c.Body()
+	if err != nil {
+		return nil, problem(err)
+	}
+	if !slices.Contains(reportReasons, payload.Reason) {
+		detail := fmt.Sprintf("'reason' must be one of %v, got: %q", strings.Join(reportReasons, ", "), payload.Reason)
+		return nil, invalidField("reason", detail, errors.New(detail))
+	}
+	comment, err := queries.GetCommentById(ctx, int32(id))
+	if err == nil && comment.Status != "visible" {
+		err = pgx.ErrNoRows // hidden and pending comments can't be reported
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFound("comment", id, err)
+	} else if err != nil {
+		return nil, problem(err)
+	}
+
+	// same keys as dbutils.InsertCommentReportParams, the reporter is only stored as a salted hash
+	reportJson, err := json.Marshal(dbutils.InsertCommentReportParams{
+		Comment:      int32(id),
+		Reason:       payload.Reason,
+		Details:      payload.Details,
+		ReporterHash: ptr(moderation.HashIP(ipHashSalt, clientIP(c.Request()))),
+	})
+	if err != nil {
+		return nil, problem(err)
+	}
+	cmd := fmt.Sprintf(`dbcli insert report "%s";`, reportJson)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("insert_report_comment_%v", id), migrationsDirectory); err != nil {
+		slog.Error("PostReportForCommentById", "id", id, "msg", err)
+		return nil, problem(err)
+	}
+	notes := payload.Reason
+	if details := orFallback(payload.Details, ""); details != "" {
+		notes += ": " + details
+	}
+	alertSubmission(alerts.Alert{Type: "comment report", Name: fmt.Sprintf("comment %v", id), Notes: notes}, migrationsDirectory)
+	c.SetStatus(201)
+	return nil, nil
+}
+
+func GetModeratedComments(c *fuego.ContextNoBody) ([]dbutils.GetModeratedCommentsRow, error) {
+	var params dbutils.GetModeratedCommentsParams
+	if v := c.QueryParam("status"); v != "" {
+		if !slices.Contains(commentStatuses, v) {
+			return []dbutils.GetModeratedCommentsRow{}, invalidParam("status", fmt.Sprintf("'status' must be one of %v, got: %v", strings.Join(commentStatuses, ", "), v))
+		}
+		params.Status = &v
+	}
+	if v := c.QueryParam("reported"); v != "" {
+		reported, err := strconv.ParseBool(v)
+		if err != nil {
+			return []dbutils.GetModeratedCommentsRow{}, invalidParam("reported", fmt.Sprintf("'reported' must be true or false, got: %v", v))
+		}
+		params.Reported = reported
+	}
+	if params.Status == nil && !params.Reported {
+		// default to the moderation queue: held comments and comments with open reports
+		params.Status, params.Reported = ptr("pending"), false
+	}
+	slog.Info("GetModeratedComments", "params", params)
+	res, err := queries.GetModeratedComments(ctx, params)
+	if err != nil {
+		slog.Error("GetModeratedComments", "err", err)
+		return []dbutils.GetModeratedCommentsRow{}, problem(err)
+	}
+	if res == nil {
+		res = []dbutils.GetModeratedCommentsRow{}
+	}
+	return res, nil
+}
+
+type CommentStatusBody struct {
+	// one of 'visible', 'hidden' or 'pending'
+	Status string `json:"status"`
+}
+
+func PutCommentStatusById(c *fuego.ContextWithBody[CommentStatusBody]) (any, error) {
+	slog.Info("PutCommentStatusById", "id", c.PathParam("id"))
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/admin/comments/{id}/status'), got: %v", c.PathParam("id")))
+	}
+	payload, err := c.Body()
+	if err != nil {
+		return nil, problem(err)
+	}
+	if !slices.Contains(commentStatuses, payload.Status) {
+		detail := fmt.Sprintf("'status' must be one of %v, got: %q", strings.Join(commentStatuses, ", "), payload.Status)
+		return nil, invalidField("status", detail, errors.New(detail))
+	}
+	if _, err := queries.GetCommentById(ctx, int32(id)); errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFound("comment", id, err)
+	} else if err != nil {
+		return nil, problem(err)
+	}
+	cmd := fmt.Sprintf(`dbcli comment status %v %v`, id, payload.Status)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("comment_status_%v", id), migrationDirectory(requestRole(c.Request()))); err != nil {
+		slog.Error("PutCommentStatusById", "id", id, "msg", err)
+		return nil, problem(err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	types "github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+)
+
+// PAGINATION, SORTING AND FIELD SELECTION
+// collection endpoints accept 'limit', 'cursor', 'sort' (prefix with '-' for descending order), 'near' (required for sort=distance)
+// and 'fields'. Pagination is keyset based - the cursor encodes the sort key and ID of the last item of the previous page.
+
+const maxPageLimit = 500
+
+var listQueryParams = map[string]struct{}{"limit": {}, "cursor": {}, "sort": {}, "near": {}, "fields": {}}
+
+var sessionSortOptions = []string{"rating", "name", "next_occurrence", "distance", "dt_updated_utc"}
+var venueSortOptions = []string{"name", "distance", "dt_updated_utc"}
+var commentSortOptions = []string{"dt_posted", "rating"}
+
+var sessionFields = jsonFields(types.SessionPropertiesWithVenue{})
+var venueFields = jsonFields(types.VenueProperties{})
+
+type listParams struct {
+	Limit      *int32
+	Sort       string // sort option without the direction prefix, empty for the default order (by ID)
+	Descending bool
+	Cursor     *cursor
+	Fields     []string
+	Lon        *float64
+	Lat        *float64
+}
+
+type cursor struct {
+	Sort    string `json:"s"` // sort parameter the cursor was created for (incl. direction prefix)
+	SortNum string `json:"n"` // formatted with strconv to survive +/-Inf
+	Text    string `json:"t,omitempty"`
+	ID      int32  `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c cursor) sortNum() *float64 {
+	f, err := strconv.ParseFloat(c.SortNum, 64)
+	if err != nil {
+		return ptr(0.0)
+	}
+	return &f
+}
+
+func newCursor(sortParam string, sortNum float64, text *string, id int32) cursor {
+	c := cursor{Sort: sortParam, SortNum: strconv.FormatFloat(sortNum, 'g', -1, 64), ID: id}
+	if text != nil {
+		c.Text = *text
+	}
+	return c
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseFloat(c.SortNum, 64); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// helper func - collects the json field names of a struct (including embedded structs)
+func jsonFields(v any) map[string]struct{} {
+	fields := make(map[string]struct{})
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			for k := range jsonFields(reflect.New(f.Type).Elem().Interface()) {
+				fields[k] = struct{}{}
+			}
+			continue
+		}
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+			fields[name] = struct{}{}
+		}
+	}
+	return fields
+}
+
+// parseListParams parses the pagination, sorting and field selection query parameters.
+// pass nil for allowedFields if the endpoint doesn't support field selection.
+func parseListParams(c *fuego.ContextNoBody, sortOptions []string, allowedFields map[string]struct{}) (listParams, error) {
+	var p listParams
+
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, invalidParam("limit", fmt.Sprintf("'limit' must be a number between 1 and %v, got: %v", maxPageLimit, v))
+		}
+		p.Limit = ptr(int32(limit))
+	}
+
+	sortParam := c.QueryParam("sort")
+	if sortParam != "" {
+		p.Sort, p.Descending = strings.CutPrefix(sortParam, "-")
+		valid := false
+		for _, o := range sortOptions {
+			valid = valid || o == p.Sort
+		}
+		if !valid {
+			return p, invalidParam("sort", fmt.Sprintf("%v is not a valid value for 'sort', valid values: %v (prefix with '-' for descending order)", sortParam, strings.Join(sortOptions, ", ")))
+		}
+	}
+
+	if v := c.QueryParam("near"); v != "" {
+		lonLat := strings.Split(v, ",")
+		if len(lonLat) != 2 {
+			return p, invalidParam("near", fmt.Sprintf("please provide 'near' as 'lon,lat', got: %v", v))
+		}
+		lon, errLon := strconv.ParseFloat(lonLat[0], 64)
+		lat, errLat := strconv.ParseFloat(lonLat[1], 64)
+		if errLon != nil || errLat != nil || math.Abs(lon) > 180 || math.Abs(lat) > 90 {
+			return p, invalidParam("near", fmt.Sprintf("please provide 'near' as 'lon,lat' in degrees, got: %v", v))
+		}
+		p.Lon, p.Lat = &lon, &lat
+	}
+	if p.Sort == "distance" && p.Lon == nil {
+		return p, invalidParam("near", "please provide a location ('near=lon,lat') to sort by distance")
+	}
+
+	if v := c.QueryParam("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil {
+			return p, invalidParam("cursor", "the cursor is invalid, please use the 'next' link of the previous page")
+		}
+		if cur.Sort != sortParam {
+			return p, invalidParam("cursor", "the cursor was created for a different sort order, please use the 'next' link of the previous page")
+		}
+		p.Cursor = cur
+	}
+
+	if v := c.QueryParam("fields"); v != "" {
+		if allowedFields == nil {
+			return p, invalidParam("fields", "this endpoint doesn't support field selection")
+		}
+		for _, f := range strings.Split(v, ",") {
+			if _, ok := allowedFields[f]; !ok {
+				valid := make([]string, 0, len(allowedFields))
+				for k := range allowedFields {
+					valid = append(valid, k)
+				}
+				sort.Strings(valid)
+				return p, invalidParam("fields", fmt.Sprintf("%v is not a valid field, valid values: %v", f, strings.Join(valid, ", ")))
+			}
+			p.Fields = append(p.Fields, f)
+		}
+	}
+	return p, nil
+}
+
+// nextLink returns the URL of the next page (the current request URL with the cursor replaced)
+// and sets the Link header of the response
+func nextLink(c *fuego.ContextNoBody, next cursor) types.Link {
+	u := *c.Request().URL
+	q := u.Query()
+	q.Set("cursor", next.encode())
+	u.RawQuery = q.Encode()
+	href := u.RequestURI()
+	c.SetHeader("Link", fmt.Sprintf(`<%v>; rel="next"`, href))
+	return types.Link{Rel: "next", Href: href}
+}
+
+// withListParams documents the pagination, sorting and field selection query parameters of a route in the OpenAPI spec
+func withListParams[T any, B any](r fuego.Route[T, B], sortOptions []string, fieldSelection bool) fuego.Route[T, B] {
+	r = r.QueryParam("limit", fmt.Sprintf("Maximum number of items per page (1-%v), all items are returned if omitted", maxPageLimit)).
+		QueryParam("cursor", "Cursor of the next page, use the 'next' link of the previous page (Link header)").
+		QueryParam("sort", fmt.Sprintf("Sort order, one of %v, prefix with '-' for descending order", strings.Join(sortOptions, ", ")))
+	for _, o := range sortOptions {
+		if o == "distance" {
+			r = r.QueryParam("near", "Location as 'lon,lat', required for sort=distance")
+		}
+	}
+	if fieldSelection {
+		r = r.QueryParam("fields", "Comma separated list of properties to include in the features")
+	}
+	return r
+}
+
+// appendLinks adds the links to a JSON object built by the database (e.g. a FeatureCollection) without decoding it
+func appendLinks(object []byte, links ...types.Link) ([]byte, error) {
+	object = bytes.TrimRight(object, " \t\r\n")
+	if len(links) == 0 {
+		return object, nil
+	}
+	if len(object) < 2 || object[len(object)-1] != '}' {
+		return nil, fmt.Errorf("expected a JSON object, got: %.50s", object)
+	}
+	l, err := json.Marshal(links)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, 0, len(object)+len(l)+10)
+	res = append(res, object[:len(object)-1]...)
+	if len(bytes.TrimSpace(object[1:len(object)-1])) > 0 { // not an empty object
+		res = append(res, ',')
+	}
+	res = append(res, `"links":`...)
+	res = append(res, l...)
+	return append(res, '}'), nil
+}
+
+// pageAsGeoJSON returns the FeatureCollection built by the database as is, adding the link to the next page if there is one
+func pageAsGeoJSON[T types.Feature](c *fuego.ContextNoBody, featureCollection []byte, nextID *int32, nextSortNum float64, nextSortText *string) (types.FeatureCollection[T], error) {
+	if nextID == nil {
+		return types.RawFeatureCollection[T](featureCollection), nil
+	}
+	b, err := appendLinks(featureCollection, nextLink(c, newCursor(c.QueryParam("sort"), nextSortNum, nextSortText, *nextID)))
+	if err != nil {
+		return types.FeatureCollection[T]{}, problem(err)
+	}
+	return types.RawFeatureCollection[T](b), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+)
+
+// AUTHORISATION
+//
+// anonymous users and contributors can submit sessions, venues and edits, which are reviewed before they are applied.
+// Edits of trusted contributors (and of venue owners concerning their venue) are applied automatically
+// (see autoApplyDirectory), deleting requires an account. Moderators can moderate comments and admins manage accounts.
+
+type roleContextKey struct{}
+
+// venueScope returns the venue a request concerns (nil if it can't be determined), owners of the venue get the venue owner role
+type venueScope func(r *http.Request) (*int32, error)
+
+// venueOfPath is the scope of the /venues/{id} routes
+func venueOfPath(r *http.Request) (*int32, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, nil // the handler rejects the ID
+	}
+	return ptr(int32(id)), nil
+}
+
+// sessionVenue returns the venue of a session (replaced in tests)
+var sessionVenue = func(ctx context.Context, session int32) (int32, error) {
+	return queries.GetSessionVenue(ctx, session)
+}
+
+// venueOfSession is the scope of the /jamsessions/{id} routes
+func venueOfSession(r *http.Request) (*int32, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, nil
+	}
+	venue, err := sessionVenue(r.Context(), int32(id))
+	if err != nil {
+		return nil, err
+	}
+	return &venue, nil
+}
+
+// RequireRole only lets requests through whose user has at least the given role. If a scope is given,
+// owners of the venue the request concerns are treated as venue owners. The role is stored in the request context
+// (see requestRole). Anonymous requests are rejected with 401, requests of users that lack the role with 403.
+func RequireRole(min auth.Role, scope venueScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := currentPrincipal(r)
+			role := principal.Role
+			if scope != nil && len(principal.OwnedVenues) > 0 {
+				venue, err := scope(r)
+				if err != nil { // e.g. the session doesn't exist, the handler deals with that
+					slog.Warn("RequireRole", "msg", "couldn't determine the venue of the request", "url", r.URL.Path, "err", err)
+				}
+				role = principal.RoleFor(venue)
+			}
+			if !role.AtLeast(min) {
+				if principal.Role == auth.RoleAnonymous {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					sendProblem(w, http.StatusUnauthorized, "Please log in")
+				} else {
+					sendProblem(w, http.StatusForbidden, "You don't have the permission to do this")
+				}
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleContextKey{}, role)))
+		})
+	}
+}
+
+// requestRole returns the role determined by RequireRole, or the role of the user if the route doesn't require one
+func requestRole(r *http.Request) auth.Role {
+	if role, ok := r.Context().Value(roleContextKey{}).(auth.Role); ok {
+		return role
+	}
+	return currentPrincipal(r).Role
+}
+
+// lowerRole returns the lower ranking of the roles
+func lowerRole(a, b auth.Role) auth.Role {
+	if a.AtLeast(b) {
+		return b
+	}
+	return a
+}
+
+// migrationDirectory returns the directory the migrations of a request are written to: edits of trusted contributors
+// (and higher roles) are applied without review if an auto-apply directory is configured
+func migrationDirectory(role auth.Role) string {
+	if autoApplyDirectory != "" && role.AtLeast(auth.RoleTrustedContributor) {
+		return autoApplyDirectory
+	}
+	return migrationsDirectory
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"github.com/felix-schott/jamsessions/backend/internal/auth"
+	"github.com/go-fuego/fuego"
+)
+
+// registerRoutes registers all routes of the API (also used by the tests to generate the OpenAPI spec)
+func registerRoutes(s *fuego.Server) {
+	// MIDDLEWARE
+	// fuego.Use(s, LoggingMiddleware) //, CORSMiddleWare) - for some reason the logging middleware leads to EOF errors (with post requests)
+
+	// API VERSION 1 - Routes (GET routes are cached by clients and proxies, see CacheMiddleware)
+	v1 := fuego.Group(s, "/v1")
+	fuego.Use(v1, RateLimitMiddleware, AuthMiddleware)
+
+	fuego.Get(v1, "/", func(c *fuego.ContextNoBody) (string, error) {
+		return "Please use the versioned route /v1 (consult /swagger/index.html for interactive documentation).", nil
+	})
+
+	withListParams(fuego.Get(v1, "/venues", GetVenues, CacheMiddleware).Summary("Get all venues"), venueSortOptions, true)
+
+	fuego.Get(v1, "/venues/{id}", GetVenueById, CacheMiddleware).Summary("Get a venue by its ID")
+
+	fuego.Get(v1, "/venues/{id}/jamsessions", GetSessionsByVenueId, CacheMiddleware).Summary("Get jam sessions by venue ID")
+
+	fuego.Post(v1, "/venues", PostVenue).Summary("Add a venue")
+
+	fuego.Patch(v1, "/venues/{id}", PatchVenueById, RequireRole(auth.RoleAnonymous, venueOfPath)).Summary("Update a venue by ID").Description("Edits are reviewed before they are applied, unless they are made by a trusted contributor or the owner of the venue.")
+
+	fuego.Delete(v1, "/venues/{id}", DeleteVenueById, RequireRole(auth.RoleContributor, venueOfPath)).Summary("Delete a venue by ID").Description("Requires an account. Deletions are reviewed before they are applied, unless they are requested by a trusted contributor or the owner of the venue.")
+
+	withListParams(fuego.Get(v1, "/jamsessions", GetSessions, CacheMiddleware).Summary("Get all jam sessions").Description("Use '/v1/jamsessions?date=2024-01-30' (or a range '?date=2024-01-30/2024-02-06') to list jamsessions happening on a particular date. The result is inferred and may not be accurate, especially for past time frames. Use '/jamsessions?backline=PA,Drums' to filter by backline provided (accepted values: 'PA', 'Drums', 'Guitar_Amp', 'Bass_Amp', 'Microphone', 'MiscPercussion'."), sessionSortOptions, true)
+
+	fuego.Post(v1, "/jamsessions", PostSession).Summary("Add a jam session")
+
+	fuego.Get(v1, "/jamsessions/{id}", GetSessionById, CacheMiddleware).Summary("Get a jam session by ID")
+
+	fuego.Patch(v1, "/jamsessions/{id}", PatchSessionById, RequireRole(auth.RoleAnonymous, venueOfSession)).Summary("Update a jam session by ID").Description("Edits are reviewed before they are applied, unless they are made by a trusted contributor or the owner of the venue.")
+
+	fuego.Delete(v1, "/jamsessions/{id}", DeleteSessionById, RequireRole(auth.RoleContributor, venueOfSession)).Summary("Delete a jam session by ID").Description("Requires an account. Deletions are reviewed before they are applied, unless they are requested by a trusted contributor or the owner of the venue.")
+
+	fuego.Post(v1, "/jamsessions/{id}/comments", PostCommentForSessionById).Summary("Post a comment for a session by ID")
+
+	fuego.Post(v1, "/jamsessions/{id}/suggestions", PostSuggestionsForSessionById).Summary("Post feedback/suggest changes for a session by ID")
+
+	withListParams(fuego.Get(v1, "/jamsessions/{id}/comments", GetCommentsBySessionId, CacheMiddleware).Summary("Get all comments for a session by ID").
+		QueryParam("view", "'flat' (default) lists comments and replies alike (replies reference their parent in 'parent_comment'), 'tree' pages through threads and nests the replies under the comment they respond to").
+		QueryParam("parent_comment", "Only return the replies to the comment with this ID, use it to page through long threads"), commentSortOptions, false)
+
+	fuego.Patch(v1, "/comments/{id}", PatchCommentById).Summary("Edit a comment").Description("Requires the edit token returned when the comment was posted (header 'X-Edit-Token'). Edits go through the same spam checks as new comments, previous versions are kept.")
+
+	fuego.Delete(v1, "/comments/{id}", DeleteCommentById).Summary("Delete a comment").Description("Requires the edit token returned when the comment was posted (header 'X-Edit-Token'). The rating submitted with the comment is deleted as well, replies are kept.")
+
+	fuego.Patch(v1, "/comments", PatchComment).Summary("Edit a comment by its edit token").Description("Same as PATCH /v1/comments/{id} for authors who only have the edit token returned when the comment was posted (header 'X-Edit-Token'). Returns 404 until the comment has been published.")
+
+	fuego.Delete(v1, "/comments", DeleteComment).Summary("Delete a comment by its edit token").Description("Same as DELETE /v1/comments/{id} for authors who only have the edit token returned when the comment was posted (header 'X-Edit-Token'). Returns 404 until the comment has been published.")
+
+	fuego.Post(v1, "/comments/{id}/reports", PostReportForCommentById).Summary("Report a comment").Description("Accepted reasons: 'spam', 'offensive', 'off_topic', 'other'. Comments reported by three different users are hidden until a moderator has reviewed them.")
+
+	// GRAPHQL - GET requests are cached like the REST endpoints, POST requests are not
+	fuego.Post(v1, "/graphql", PostGraphQL).Summary("Query sessions, venues, occurrences, comments and ratings (GraphQL)").Description("Fetches related records in one round trip, e.g. '{ session(id: 1) { session_name venue { venue_name } occurrences(first: 5) { date } comments(first: 3) { author content } rating { rating rating_count } } }'. The schema is published at /v1/graphql/schema and through introspection. Queries nested deeper than 8 levels or whose cost exceeds 1000 are rejected with 400 (every object counts as one point, lists count as their 'first' argument).")
+
+	fuego.Get(v1, "/graphql", GetGraphQL, CacheMiddleware).Summary("Query sessions, venues, occurrences, comments and ratings (GraphQL)").Description("Same as POST /v1/graphql with the request in the query parameters.").
+		QueryParam("query", "The GraphQL query").
+		QueryParam("operationName", "The operation to execute if the query contains several").
+		QueryParam("variables", "The variables of the operation as a JSON object")
+
+	fuego.Get(v1, "/graphql/schema", GetGraphQLSchema, CacheMiddleware).Summary("Get the GraphQL schema (SDL)")
+
+	// EVENTS - not cached, the stream stays open until the client disconnects
+	fuego.GetStd(v1, "/events", GetEvents).Summary("Stream the applied changes (Server-Sent Events)").Description("Pushes 'session.created', 'session.updated', 'session.deleted', 'venue.created', 'venue.updated', 'venue.deleted' and 'comment.created' events once a change has been applied. The 'data' of an event is a JSON object with its 'id', 'type', 'time' and the IDs and names of the affected records. Clients that reconnect with the 'Last-Event-ID' header (or the 'last_event_id' parameter) receive the events they missed, events are kept for 30 days. Returns 503 if too many clients are connected.").
+		QueryParam("last_event_id", "Replay the events after this ID (alternative to the 'Last-Event-ID' header)")
+
+	// ACCOUNTS - submissions, comments and ratings of logged in users are attributed to their account
+	fuego.Post(v1, "/auth/login", PostLogin).Summary("Request a login link").Description("Sends a link to the email address that logs the user in (valid for 15 minutes). Accounts are created on the first login.")
+
+	fuego.Get(v1, "/auth/verify", GetVerifyLogin).Summary("Log in with the token of a login link").Description("Sets the session cookie and returns the session token, which can be sent as bearer token instead of the cookie.").QueryParam("token", "Token of the login link")
+
+	fuego.Post(v1, "/auth/logout", PostLogout).Summary("Log out (clears the session cookie)")
+
+	fuego.Get(v1, "/me", GetMe).Summary("Get the account of the logged in user")
+
+	fuego.Get(v1, "/me/favourites", GetFavourites).Summary("Get the favourite sessions of the logged in user")
+
+	fuego.Put(v1, "/me/favourites/{session_id}", PutFavourite).Summary("Add a session to the favourites").Description("Favourites are applied without review, it can take a few minutes until they show up.")
+
+	fuego.Delete(v1, "/me/favourites/{session_id}", DeleteFavourite).Summary("Remove a session from the favourites")
+
+	fuego.Get(v1, "/me/favourites/calendar", GetFavouritesCalendarLink).Summary("Get the link to the calendar feed of the favourites").Description("The feed (iCalendar) contains the dates of the favourite sessions of the last 30 and the next 180 days, calendar apps can subscribe to it. Keep the link private.")
+
+	fuego.Get(v1, "/calendar/{token}", GetCalendarFeed).Summary("Get a calendar feed of favourite sessions").Description("Use the link returned by '/v1/me/favourites/calendar'.")
+
+	// FOLLOWS - followers are notified when an approved change renames, reschedules, moves or cancels a session or venue
+	fuego.Get(v1, "/me/follows", GetFollows).Summary("Get the sessions and venues the logged in user follows")
+
+	fuego.Put(v1, "/me/follows/jamsessions/{id}", PutFollowSession).Summary("Follow a jam session").Description("Follows are applied without review, it can take a few minutes until they show up.")
+
+	fuego.Delete(v1, "/me/follows/jamsessions/{id}", DeleteFollowSession).Summary("Unfollow a jam session")
+
+	fuego.Put(v1, "/me/follows/venues/{id}", PutFollowVenue).Summary("Follow a venue").Description("Followers of a venue are notified about changes of the venue and all of its sessions.")
+
+	fuego.Delete(v1, "/me/follows/venues/{id}", DeleteFollowVenue).Summary("Unfollow a venue")
+
+	fuego.Get(v1, "/me/notification-channels", GetNotificationChannels).Summary("Get the notification channels of the logged in user").Description("Users without channels are notified by email.")
+
+	fuego.Post(v1, "/me/notification-channels", PostNotificationChannel).Summary("Add a notification channel").Description("Accepted kinds: 'email', 'webpush' (endpoint and keys of the PushSubscription of the browser, see '/v1/notifications/webpush-key') and 'webhook' (https URL the notifications are posted to as JSON).")
+
+	fuego.Delete(v1, "/me/notification-channels/{id}", DeleteNotificationChannel).Summary("Remove a notification channel")
+
+	fuego.Get(v1, "/notifications/webpush-key", GetWebPushKey).Summary("Get the VAPID public key for push subscriptions").Description("Returns 404 if push notifications are disabled.")
+
+	// SAVED SEARCHES - users receive a weekly digest email of the sessions matching their saved searches
+	fuego.Get(v1, "/me/saved-searches", GetSavedSearches).Summary("Get the saved searches of the logged in user")
+
+	fuego.Post(v1, "/me/saved-searches", PostSavedSearch).Summary("Save a search").Description("Filters sessions by genres, backline (like '/v1/jamsessions'), ISO weekdays (1 = Monday) and area (lon, lat and radius_metres). The weekly digest lists the matching sessions of the coming week and the sessions added since the last digest. Saving a search under an existing name replaces it.")
+
+	fuego.Delete(v1, "/me/saved-searches/{id}", DeleteSavedSearch).Summary("Delete a saved search (stops its digest)")
+
+	// ADMIN - Routes (require the moderator role or the admin token)
+	admin := fuego.Group(v1, "/admin")
+	fuego.Use(admin, RequireRole(auth.RoleModerator, nil))
+
+	fuego.Get(admin, "/venues/duplicates", GetDuplicateVenues).Summary("Get pairs of venues that are likely duplicates").Description("Venues are considered duplicates if their names are similar (trigram similarity of at least 'name_similarity', default 0.6), their normalised addresses match or they are less than 'distance' metres apart (default 25). Merge duplicates using 'dbcli venue merge <keep> <drop>'.")
+
+	fuego.Get(admin, "/submissions", GetSubmissions).Summary("Get the submissions that wait for review").Description("Migration scripts in the migrations directory (apply them with run-migrations.sh) and suggestions, oldest first. The admins are alerted about new submissions through the notifiers configured in ALERT_NOTIFIERS.")
+
+	fuego.Get(admin, "/comments", GetModeratedComments).Summary("Get the comment moderation queue").
+		QueryParam("status", "Only return comments with this status ('visible', 'hidden' or 'pending'), defaults to 'pending' unless 'reported' is set").
+		QueryParam("reported", "Only return comments with open reports ('true' or 'false')")
+
+	fuego.Put(admin, "/comments/{id}/status", PutCommentStatusById).Summary("Set the moderation status of a comment").Description("'visible' publishes a comment, 'hidden' removes it from the public endpoints. Open reports of the comment are resolved.")
+
+	fuego.Put(admin, "/users/{id}/role", PutUserRoleById, RequireRole(auth.RoleAdmin, nil)).Summary("Set the role of a user").Description("Accepted roles: 'contributor', 'trusted_contributor' (edits are applied without review), 'moderator' and 'admin'. Requires the admin role.")
+
+	fuego.Get(admin, "/webhooks", GetWebhooks, RequireRole(auth.RoleAdmin, nil)).Summary("Get the webhook subscriptions").Description("Requires the admin role. Secrets are only returned when a subscription is created.")
+	fuego.Post(admin, "/webhooks", PostWebhook, RequireRole(auth.RoleAdmin, nil)).Summary("Subscribe a URL to events about sessions and venues").Description("Requires the admin role. Events are posted as JSON once the change has been applied, signed with the returned secret: the 'X-Jamsessions-Signature' header is 'sha256=' followed by the hex encoded HMAC-SHA256 of '<X-Jamsessions-Timestamp>.<body>'. Failed deliveries (other than 410 Gone) are retried with an exponential backoff.")
+	fuego.Delete(admin, "/webhooks/{id}", DeleteWebhook, RequireRole(auth.RoleAdmin, nil)).Summary("Remove a webhook subscription").Description("Requires the admin role. Removes the delivery log of the subscription as well.")
+	fuego.Get(admin, "/webhooks/{id}/deliveries", GetWebhookDeliveries, RequireRole(auth.RoleAdmin, nil)).Summary("Get the delivery log of a webhook subscription").Description("Requires the admin role. Most recent deliveries first.").
+		QueryParam("status", "Only return deliveries with this status ('pending', 'delivered' or 'failed')").
+		QueryParam("limit", "Maximum number of deliveries (default 100, at most 500)")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+	"github.com/jackc/pgx/v5"
+)
+
+// SAVED SEARCHES - users receive a weekly digest of the sessions matching their saved searches (sent by
+// 'dbcli digest send')
+
+// maximum radius of the area of a saved search
+const maxSearchRadiusMetres = 50000
+
+type SavedSearchBody struct {
+	// saving a search under an existing name replaces it
+	SearchName string `json:"search_name" validate:"required"`
+	// sessions have all of these genres
+	Genres []string `json:"genres,omitempty"`
+	// venues provide all of this backline
+	Backline []string `json:"backline,omitempty"`
+	// ISO weekdays (1 = Monday, 7 = Sunday) the sessions take place on
+	Weekdays []int16 `json:"weekdays,omitempty"`
+	// centre and radius (up to 50 km) of the area the venues are in
+	Lon          *float64 `json:"lon,omitempty"`
+	Lat          *float64 `json:"lat,omitempty"`
+	RadiusMetres *int32   `json:"radius_metres,omitempty"`
+}
+
+// validate returns a 400 problem pointing to the first invalid field
+func (b SavedSearchBody) validate() error {
+	invalid := func(field string, detail string) error { return invalidField(field, detail, errors.New(detail)) }
+	if name := strings.TrimSpace(b.SearchName); name == "" || len(name) > 100 {
+		return invalid("search_name", "Please provide a name of at most 100 characters")
+	}
+	for _, g := range b.Genres {
+		if _, ok := types.Genres[types.Genre(g)]; !ok {
+			return invalid("genres", fmt.Sprintf("%v is not a valid value for 'genres'", g))
+		}
+	}
+	for _, bl := range b.Backline {
+		if _, ok := types.BacklineOptions[types.Backline(bl)]; !ok {
+			return invalid("backline", fmt.Sprintf("%v is not a valid value for 'backline'", bl))
+		}
+	}
+	for _, d := range b.Weekdays {
+		if d < 1 || d > 7 {
+			return invalid("weekdays", fmt.Sprintf("%v is not an ISO weekday (1 = Monday, 7 = Sunday)", d))
+		}
+	}
+	if (b.Lon == nil) != (b.Lat == nil) || (b.Lon == nil) != (b.RadiusMetres == nil) {
+		return invalid("radius_metres", "Please provide all of 'lon', 'lat' and 'radius_metres' or none of them")
+	}
+	if b.Lon != nil {
+		if *b.Lon < -180 || *b.Lon > 180 || *b.Lat < -90 || *b.Lat > 90 {
+			return invalid("lon", "'lon' and 'lat' have to be WGS84 coordinates")
+		}
+		if *b.RadiusMetres < 1 || *b.RadiusMetres > maxSearchRadiusMetres {
+			return invalid("radius_metres", fmt.Sprintf("'radius_metres' has to be between 1 and %v", maxSearchRadiusMetres))
+		}
+	}
+	return nil
+}
+
+func GetSavedSearches(c *fuego.ContextNoBody) ([]types.SavedSearch, error) {
+	claims, err := loggedInUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queries.GetSavedSearchesByEmail(ctx, claims.Email())
+	if err != nil {
+		slog.Error("GetSavedSearches", "msg", err)
+		return nil, problem(err)
+	}
+	searches := make([]types.SavedSearch, len(rows))
+	for i, r := range rows {
+		searches[i] = types.SavedSearch{
+			SearchID:     r.SearchID,
+			SearchName:   r.SearchName,
+			Genres:       r.Genres,
+			Backline:     r.Backline,
+			Weekdays:     r.Weekdays,
+			Lon:          r.Lon,
+			Lat:          r.Lat,
+			RadiusMetres: r.RadiusMetres,
+			DtCreated:    r.DtCreated.Time,
+		}
+		if r.DtLastDigest.Valid {
+			searches[i].DtLastDigest = &r.DtLastDigest.Time
+		}
+	}
+	return searches, nil
+}
+
+func PostSavedSearch(c *fuego.ContextWithBody[SavedSearchBody]) (any, error) {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	if err := body.validate(); err != nil {
+		return nil, err
+	}
+	payload := dbutils.InsertSavedSearchParams{
+		UserEmail:    user.Email,
+		SearchName:   strings.TrimSpace(body.SearchName),
+		Genres:       body.Genres,
+		Backline:     body.Backline,
+		Weekdays:     body.Weekdays,
+		Lon:          body.Lon,
+		Lat:          body.Lat,
+		RadiusMetres: body.RadiusMetres,
+	}
+	if err := writePersonalMigration("search add", payload, "search_add"); err != nil {
+		return nil, err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil, nil
+}
+
+func DeleteSavedSearch(c *fuego.ContextNoBody) (any, error) {
+	user, err := registeredUser(c.Request())
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return nil, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/me/saved-searches/{id}'), got: %v", c.PathParam("id")))
+	}
+	searches, err := queries.GetSavedSearchesByEmail(ctx, user.Email)
+	if err != nil {
+		slog.Error("DeleteSavedSearch", "msg", err)
+		return nil, problem(err)
+	}
+	found := false
+	for _, s := range searches {
+		found = found || s.SearchID == int32(id)
+	}
+	if !found {
+		return nil, notFound("saved search", id, pgx.ErrNoRows)
+	}
+	payload := dbutils.DeleteSavedSearchParams{UserEmail: user.Email, SearchID: int32(id)}
+	if err := writePersonalMigration("search remove", payload, fmt.Sprintf("search_remove_%v", id)); err != nil {
+		return nil, err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	types "github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+)
+
+// Serialize is passed to fuego.WithSerializer - responses that hold a JSON document built by the database
+// (see types.RawJSON) are written as is, everything else is handed to the default serializer.
+// Raw documents are always sent as JSON, regardless of the Accept header. Calendar feeds are sent as text/calendar.
+func Serialize(w http.ResponseWriter, r *http.Request, ans any) error {
+	if feed, ok := ans.(CalendarFeed); ok {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		_, err := w.Write(feed)
+		return err
+	}
+	if raw, ok := ans.(types.RawJSON); ok && raw.RawJSON() != nil {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write(raw.RawJSON())
+		return err
+	}
+	return fuego.Send(w, r, ans)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/alerts"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/go-fuego/fuego"
+)
+
+// SUBMISSIONS - changes that need to be reviewed are written to the migrations directory (suggestions to the
+// suggestions directory), the admins are alerted about every new submission
+
+// alerter notifies the admins, nil disables alerts (set up in main from ALERT_NOTIFIERS)
+var alerter alerts.Notifier
+
+// alerts are sent in the background so that they don't slow down the submissions
+var pendingAlerts sync.WaitGroup
+
+const alertTimeout = 30 * time.Second
+
+// alertSubmission alerts the admins about a submission that has been written to dir, submissions that are applied
+// without review (written to the auto-apply directory) don't raise alerts
+func alertSubmission(a alerts.Alert, dir string) {
+	if alerter == nil || (dir != migrationsDirectory && dir != suggestionsDirectory) {
+		return
+	}
+	if a.ReviewURL == "" {
+		a.ReviewURL = fmt.Sprintf("%v/v1/admin/submissions", strings.TrimSuffix(publicUrl, "/"))
+	}
+	a.Time = time.Now().UTC()
+	pendingAlerts.Add(1)
+	go func() {
+		defer pendingAlerts.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+		if err := alerter.Notify(ctx, a); err != nil {
+			slog.Error("alertSubmission", "type", a.Type, "name", a.Name, "msg", err)
+		}
+	}()
+}
+
+// orFallback returns the value of s or the fallback if it is nil or empty
+func orFallback(s *string, fallback string) string {
+	if s == nil || *s == "" {
+		return fallback
+	}
+	return *s
+}
+
+// GetSubmissions lists the submissions that wait for review, oldest first
+func GetSubmissions(c *fuego.ContextNoBody) ([]types.Submission, error) {
+	submissions := []types.Submission{}
+	for _, dir := range []struct {
+		path, kind, pattern string
+	}{
+		{migrationsDirectory, "migration", "*.sh"},
+		{suggestionsDirectory, "suggestion", "*"},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir.path, dir.pattern))
+		if err != nil {
+			return nil, problem(err)
+		}
+		for _, fp := range files {
+			info, err := os.Stat(fp)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			content, err := os.ReadFile(fp)
+			if err != nil {
+				slog.Error("GetSubmissions", "file", fp, "msg", err)
+				return nil, problem(err)
+			}
+			submissions = append(submissions, types.Submission{File: filepath.Base(fp), Type: dir.kind, Content: string(content), DtCreated: info.ModTime().UTC()})
+		}
+	}
+	sort.SliceStable(submissions, func(i, j int) bool { return submissions[i].DtCreated.Before(submissions[j].DtCreated) })
+	return submissions, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	dbutils "github.com/felix-schott/jamsessions/backend/internal/db"
+	migrationutils "github.com/felix-schott/jamsessions/backend/internal/migrations"
+	"github.com/felix-schott/jamsessions/backend/internal/notify"
+	"github.com/felix-schott/jamsessions/backend/internal/types"
+	"github.com/felix-schott/jamsessions/backend/internal/webhooks"
+	"github.com/go-fuego/fuego"
+)
+
+// WEBHOOKS - third parties subscribe to events about sessions and venues, 'dbcli' queues the events when it applies
+// a change and 'dbcli webhook deliver' (cron) posts them, signed with the secret of the subscription
+
+const defaultDeliveryLogLimit = 100
+
+type WebhookBody struct {
+	// https URL the events are posted to
+	URL string `json:"url" validate:"required"`
+	// any of 'session.created', 'session.updated', 'session.deleted', 'venue.created', 'venue.updated' and 'venue.deleted'
+	EventTypes  []string `json:"event_types" validate:"required"`
+	Description *string  `json:"description,omitempty"`
+}
+
+// validate returns a 400 problem pointing to the first invalid field
+func (b WebhookBody) validate() error {
+	if err := (notify.Channel{Kind: notify.Webhook, Endpoint: b.URL}).Validate(); err != nil {
+		return invalidField("url", err.Error(), err)
+	}
+	if len(b.URL) > 2000 {
+		return invalidField("url", "'url' must be at most 2000 characters long", errors.New("url too long"))
+	}
+	if err := webhooks.ValidateEventTypes(b.EventTypes); err != nil {
+		return invalidField("event_types", err.Error(), err)
+	}
+	if b.Description != nil && len(*b.Description) > 255 {
+		return invalidField("description", "'description' must be at most 255 characters long", errors.New("description too long"))
+	}
+	return nil
+}
+
+// WebhookCreated is returned once when a subscription is created, receivers verify the signatures with the secret
+type WebhookCreated struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+}
+
+func GetWebhooks(c *fuego.ContextNoBody) ([]types.WebhookSubscription, error) {
+	rows, err := queries.GetWebhookSubscriptions(ctx)
+	if err != nil {
+		slog.Error("GetWebhooks", "msg", err)
+		return nil, problem(err)
+	}
+	subscriptions := make([]types.WebhookSubscription, len(rows))
+	for i, r := range rows {
+		subscriptions[i] = types.WebhookSubscription{
+			SubscriptionID:    r.SubscriptionID,
+			URL:               r.Url,
+			EventTypes:        r.EventTypes,
+			Description:       r.Description,
+			PendingDeliveries: r.PendingDeliveries,
+			FailedDeliveries:  r.FailedDeliveries,
+			DtCreated:         r.DtCreated.Time,
+		}
+	}
+	return subscriptions, nil
+}
+
+func PostWebhook(c *fuego.ContextWithBody[WebhookBody]) (*WebhookCreated, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	if err := body.validate(); err != nil {
+		return nil, err
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return nil, problem(err)
+	}
+	payload := dbutils.InsertWebhookSubscriptionParams{Url: body.URL, Secret: secret, EventTypes: body.EventTypes, Description: body.Description}
+	if err := writeJSONMigration("webhook add", payload, "webhook_add", migrationDirectory(requestRole(c.Request()))); err != nil {
+		return nil, err
+	}
+	c.SetStatus(http.StatusAccepted)
+	return &WebhookCreated{URL: body.URL, EventTypes: body.EventTypes, Secret: secret}, nil
+}
+
+// webhookOfPath returns the subscription ID of the path, checking that it exists
+func webhookOfPath(c *fuego.ContextNoBody) (int, error) {
+	id, err := strconv.Atoi(c.PathParam("id"))
+	if err != nil {
+		return 0, invalidParam("id", fmt.Sprintf("Please provide a numeric ID ('/admin/webhooks/{id}'), got: %v", c.PathParam("id")))
+	}
+	exists, err := queries.GetWebhookSubscriptionExists(ctx, int32(id))
+	if err != nil {
+		return 0, problem(err)
+	}
+	if !exists {
+		return 0, notFound("webhook subscription", id, errors.New("webhook subscription not found"))
+	}
+	return id, nil
+}
+
+func DeleteWebhook(c *fuego.ContextNoBody) (any, error) {
+	id, err := webhookOfPath(c)
+	if err != nil {
+		return nil, err
+	}
+	cmd := fmt.Sprintf(`dbcli webhook remove %v`, id)
+	if _, err := migrationutils.WriteMigration(cmd, fmt.Sprintf("webhook_remove_%v", id), migrationDirectory(requestRole(c.Request()))); err != nil {
+		slog.Error("DeleteWebhook", "id", id, "msg", err)
+		return nil, problem(err)
+	}
+	c.SetStatus(http.StatusAccepted)
+	return nil, nil
+}
+
+func GetWebhookDeliveries(c *fuego.ContextNoBody) ([]types.WebhookDelivery, error) {
+	id, err := webhookOfPath(c)
+	if err != nil {
+		return nil, err
+	}
+	params := dbutils.GetWebhookDeliveriesParams{SubscriptionID: int32(id), MaxResults: defaultDeliveryLogLimit}
+	if v := c.QueryParam("status"); v != "" {
+		if v != webhooks.Pending && v != webhooks.Delivered && v != webhooks.Failed {
+			return nil, invalidParam("status", fmt.Sprintf("'status' must be one of 'pending', 'delivered' or 'failed', got: %v", v))
+		}
+		params.Status = &v
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return nil, invalidParam("limit", fmt.Sprintf("'limit' must be a number between 1 and %v, got: %v", maxPageLimit, v))
+		}
+		params.MaxResults = int32(limit)
+	}
+	rows, err := queries.GetWebhookDeliveries(ctx, params)
+	if err != nil {
+		slog.Error("GetWebhookDeliveries", "id", id, "msg", err)
+		return nil, problem(err)
+	}
+	deliveries := make([]types.WebhookDelivery, len(rows))
+	for i, r := range rows {
+		deliveries[i] = types.WebhookDelivery{
+			DeliveryID:     r.DeliveryID,
+			EventID:        r.EventID,
+			EventType:      r.EventType,
+			Status:         r.Status,
+			Attempts:       r.Attempts,
+			ResponseStatus: r.ResponseStatus,
+			LastError:      r.LastError,
+			DtCreated:      r.DtCreated.Time,
+		}
+		if r.Status == webhooks.Pending {
+			deliveries[i].NextAttemptAt = &r.NextAttemptAt.Time
+		}
+		if r.DtDelivered.Valid {
+			deliveries[i].DtDelivered = &r.DtDelivered.Time
+		}
+	}
+	return deliveries, nil
+}
@@ -6,6 +6,8 @@ require (
 	github.com/alexflint/go-arg v1.5.1
 	github.com/getkin/kin-openapi v0.126.0
 	github.com/go-fuego/fuego v0.14.0
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/graphql-go/graphql v0.8.1
 	github.com/jackc/pgx/v5 v5.7.1
 	github.com/rs/cors v1.11.1
 	github.com/twpayne/go-geom v1.5.7
@@ -21,7 +23,6 @@ require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator/v10 v10.22.0 // indirect
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
 	github.com/gorilla/schema v1.4.1 // indirect
 	github.com/invopop/yaml v0.3.1 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
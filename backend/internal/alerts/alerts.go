@@ -0,0 +1,236 @@
+// Package alerts notifies the admins about new submissions that wait for review
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+)
+
+// Alert describes a submission that waits for review
+type Alert struct {
+	// kind of submission, e.g. 'new session' or 'venue deletion'
+	Type string
+	// name of the session or venue (or its ID if the submission doesn't contain the name)
+	Name string
+	// notes of the submitter
+	Notes     string
+	Submitter string
+	// link to the review endpoint
+	ReviewURL string
+	Time      time.Time
+}
+
+// Title returns the one line summary of the alert
+func (a Alert) Title() string {
+	if a.Name == "" {
+		return fmt.Sprintf("Pending %v", a.Type)
+	}
+	return fmt.Sprintf("Pending %v: %v", a.Type, a.Name)
+}
+
+// Text returns the summary of the alert including the notes and the review link
+func (a Alert) Text() string {
+	lines := []string{a.Title()}
+	if a.Notes != "" {
+		lines = append(lines, "Notes: "+a.Notes)
+	}
+	if a.Submitter != "" {
+		lines = append(lines, "Submitted by: "+a.Submitter)
+	}
+	if a.ReviewURL != "" {
+		lines = append(lines, "Review: "+a.ReviewURL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notifier sends alerts to the admins
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Config holds the settings of the notifiers
+type Config struct {
+	TelegramToken  string
+	TelegramChatID string
+	// incoming webhook URL of Slack (or of a Slack-compatible service like Mattermost or Rocket.Chat)
+	SlackWebhookURL string
+	Mailer          mail.Mailer
+	// recipients of the email alerts
+	EmailTo []string
+	Logger  *slog.Logger
+}
+
+// New returns the notifiers of the comma separated list of kinds: 'log' (default), 'telegram', 'slack', 'email' or
+// 'memory'. Several notifiers are combined with Multi.
+func New(kinds string, cfg Config) (Notifier, error) {
+	if strings.TrimSpace(kinds) == "" {
+		kinds = "log"
+	}
+	var notifiers Multi
+	for _, kind := range strings.Split(kinds, ",") {
+		var n Notifier
+		switch kind = strings.TrimSpace(kind); kind {
+		case "log":
+			n = &LogNotifier{Logger: cfg.Logger}
+		case "telegram":
+			if cfg.TelegramToken == "" || cfg.TelegramChatID == "" {
+				return nil, errors.New("the telegram notifier needs a bot token and a chat ID")
+			}
+			n = &TelegramNotifier{Token: cfg.TelegramToken, ChatID: cfg.TelegramChatID}
+		case "slack":
+			if !strings.HasPrefix(cfg.SlackWebhookURL, "https://") {
+				return nil, fmt.Errorf("the slack notifier needs an https webhook URL, got %q", cfg.SlackWebhookURL)
+			}
+			n = &SlackNotifier{WebhookURL: cfg.SlackWebhookURL}
+		case "email":
+			if cfg.Mailer == nil || len(cfg.EmailTo) == 0 {
+				return nil, errors.New("the email notifier needs a mailer and at least one recipient")
+			}
+			n = &EmailNotifier{Mailer: cfg.Mailer, To: cfg.EmailTo}
+		case "memory":
+			n = &MemoryNotifier{}
+		default:
+			return nil, fmt.Errorf("unknown notifier %q (available: 'log', 'telegram', 'slack', 'email', 'memory')", kind)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if len(notifiers) == 1 {
+		return notifiers[0], nil
+	}
+	return notifiers, nil
+}
+
+// Multi sends alerts through all of its notifiers, failures of one notifier don't stop the others
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, a Alert) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogNotifier writes alerts to the log
+type LogNotifier struct {
+	Logger *slog.Logger // slog.Default() if nil
+}
+
+func (l *LogNotifier) Notify(ctx context.Context, a Alert) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.WarnContext(ctx, a.Title(), "type", a.Type, "name", a.Name, "notes", a.Notes, "submitter", a.Submitter, "review", a.ReviewURL)
+	return nil
+}
+
+const deliveryTimeout = 10 * time.Second
+
+// TelegramNotifier sends alerts to a chat through a Telegram bot
+type TelegramNotifier struct {
+	Token  string
+	ChatID string
+	APIURL string       // https://api.telegram.org if empty
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (t *TelegramNotifier) Notify(ctx context.Context, a Alert) error {
+	api := t.APIURL
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	return postJSON(ctx, t.Client, fmt.Sprintf("%v/bot%v/sendMessage", strings.TrimSuffix(api, "/"), t.Token), map[string]any{
+		"chat_id":                  t.ChatID,
+		"text":                     a.Text(),
+		"disable_web_page_preview": true,
+	})
+}
+
+// SlackNotifier posts alerts to a Slack-compatible incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client // http.DefaultClient if nil
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, a Alert) error {
+	return postJSON(ctx, s.Client, s.WebhookURL, map[string]any{"text": a.Text()})
+}
+
+// postJSON posts the body as JSON, responses other than 2xx are errors
+func postJSON(ctx context.Context, client *http.Client, url string, body any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jamsessions-alerts")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%v responded with status %v: %s", req.URL.Host, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// EmailNotifier emails alerts to the admins
+type EmailNotifier struct {
+	Mailer mail.Mailer
+	To     []string
+}
+
+func (e *EmailNotifier) Notify(ctx context.Context, a Alert) error {
+	var errs []error
+	for _, to := range e.To {
+		if err := e.Mailer.Send(ctx, mail.Message{To: to, Subject: a.Title(), Text: a.Text()}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MemoryNotifier keeps the alerts in memory (for tests)
+type MemoryNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (m *MemoryNotifier) Notify(ctx context.Context, a Alert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alerts = append(m.alerts, a)
+	return nil
+}
+
+// Alerts returns the alerts sent so far
+func (m *MemoryNotifier) Alerts() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Alert(nil), m.alerts...)
+}
@@ -0,0 +1,134 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+)
+
+var alert = Alert{Type: "new session", Name: "Blues Jam", Notes: "Every Monday, ask for Sam", Submitter: "sam@example.org", ReviewURL: "https://example.org/v1/admin/submissions", Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+func TestText(t *testing.T) {
+	expected := "Pending new session: Blues Jam\nNotes: Every Monday, ask for Sam\nSubmitted by: sam@example.org\nReview: https://example.org/v1/admin/submissions"
+	if text := alert.Text(); text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
+	if title := (Alert{Type: "suggestion"}).Text(); title != "Pending suggestion" {
+		t.Errorf("expected only the title, got %q", title)
+	}
+}
+
+// receiver records the JSON bodies posted to it
+func receiver(t *testing.T, status int) (*httptest.Server, *[]map[string]any, *[]string) {
+	var bodies []map[string]any
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON body, got %v (err: %v)", r.Header.Get("Content-Type"), err)
+		}
+		bodies = append(bodies, body)
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies, &paths
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	srv, bodies, paths := receiver(t, http.StatusOK)
+	n := &TelegramNotifier{Token: "123:abc", ChatID: "-1001", APIURL: srv.URL, Client: srv.Client()}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if len(*bodies) != 1 || (*paths)[0] != "/bot123:abc/sendMessage" || (*bodies)[0]["chat_id"] != "-1001" || (*bodies)[0]["text"] != alert.Text() {
+		t.Errorf("expected the message to be sent to the chat, got %v %v", *paths, *bodies)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	srv, bodies, _ := receiver(t, http.StatusOK)
+	n := &SlackNotifier{WebhookURL: srv.URL + "/hooks/abc", Client: srv.Client()}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if len(*bodies) != 1 || (*bodies)[0]["text"] != alert.Text() {
+		t.Errorf("expected the text to be posted, got %v", *bodies)
+	}
+
+	failing, _, _ := receiver(t, http.StatusForbidden)
+	n = &SlackNotifier{WebhookURL: failing.URL, Client: failing.Client()}
+	if err := n.Notify(context.Background(), alert); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected an error for the 403 response, got %v", err)
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	var outbox bytes.Buffer
+	n := &EmailNotifier{Mailer: &mail.WriterMailer{W: &outbox}, To: []string{"admin@example.org", "moderator@example.org"}}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for _, expected := range []string{"To: admin@example.org", "To: moderator@example.org", "Subject: Pending new session: Blues Jam", "Review: https://example.org/v1/admin/submissions"} {
+		if !strings.Contains(outbox.String(), expected) {
+			t.Errorf("expected the emails to contain %q, got %v", expected, outbox.String())
+		}
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var out bytes.Buffer
+	n := &LogNotifier{Logger: slog.New(slog.NewTextHandler(&out, nil))}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !strings.Contains(out.String(), `level=WARN msg="Pending new session: Blues Jam"`) || !strings.Contains(out.String(), "submitter=sam@example.org") {
+		t.Errorf("expected the alert to be logged, got %v", out.String())
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, a Alert) error {
+	return errors.New("unreachable")
+}
+
+func TestMulti(t *testing.T) {
+	memory := &MemoryNotifier{}
+	err := Multi{failingNotifier{}, memory}.Notify(context.Background(), alert)
+	if err == nil || err.Error() != "unreachable" {
+		t.Errorf("expected the error of the failing notifier, got %v", err)
+	}
+	if a := memory.Alerts(); len(a) != 1 || a[0].Name != "Blues Jam" {
+		t.Errorf("expected the other notifiers to be notified, got %+v", a)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if n, err := New("", Config{}); err != nil {
+		t.Errorf("expected error to be nil, got %v", err)
+	} else if _, ok := n.(*LogNotifier); !ok {
+		t.Errorf("expected the log notifier by default, got %T", n)
+	}
+	n, err := New("log, telegram,slack,email", Config{TelegramToken: "123:abc", TelegramChatID: "-1001", SlackWebhookURL: "https://hooks.example.org/abc", Mailer: &mail.WriterMailer{}, EmailTo: []string{"admin@example.org"}})
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if m, ok := n.(Multi); !ok || len(m) != 4 {
+		t.Errorf("expected 4 notifiers, got %#v", n)
+	}
+	for _, kinds := range []string{"telegram", "slack", "email", "pigeon"} {
+		if _, err := New(kinds, Config{SlackWebhookURL: "http://hooks.example.org/abc"}); err == nil {
+			t.Errorf("expected an error for %v", kinds)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of all API keys, distinguishes them from session tokens in the Authorization header
+const Prefix = "jsk_"
+
+// DefaultRateLimit is the number of requests per minute a key may make unless configured otherwise
+const DefaultRateLimit = 600
+
+// Generate returns a new random API key. Only its hash (see Hash) is stored, the key is shown once when it is created.
+func Generate() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Prefix + hex.EncodeToString(b), nil
+}
+
+// Hash returns the SHA-256 hash of the key (hex encoded)
+func Hash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// DisplayPrefix returns the beginning of the key, stored to tell keys apart in listings
+func DisplayPrefix(key string) string {
+	return key[:min(len(key), len(Prefix)+8)]
+}
+
+// IsKey reports whether the bearer token looks like an API key
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Limiter counts the requests of clients (API keys or IP addresses) in fixed windows
+type Limiter struct {
+	Window time.Duration
+	Now    func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func NewLimiter(length time.Duration) *Limiter {
+	return &Limiter{Window: length, Now: time.Now, windows: make(map[string]*window)}
+}
+
+// Allow registers a request of the client and reports whether it is within the limit (requests per window).
+// It also returns the number of remaining requests and the time the current window ends.
+func (l *Limiter) Allow(client string, limit int) (bool, int, time.Time) {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= l.Window { // forget clients whose window has ended
+		for c, w := range l.windows {
+			if now.Sub(w.start) >= l.Window {
+				delete(l.windows, c)
+			}
+		}
+		l.lastSweep = now
+	}
+	w, ok := l.windows[client]
+	if !ok || now.Sub(w.start) >= l.Window {
+		w = &window{start: now}
+		l.windows[client] = w
+	}
+	reset := w.start.Add(l.Window)
+	if w.count >= limit {
+		return false, 0, reset
+	}
+	w.count++
+	return true, limit - w.count, reset
+}
+
+// Count is the number of requests made with a key since the counters were last taken
+type Count struct {
+	Requests int64
+	LastUsed time.Time
+}
+
+// Usage counts the requests per key in memory, so that they can be written to the database in batches
+type Usage struct {
+	mu     sync.Mutex
+	counts map[int32]Count
+}
+
+func NewUsage() *Usage {
+	return &Usage{counts: make(map[int32]Count)}
+}
+
+// Add counts n requests of the key, the last of which was made at t
+func (u *Usage) Add(key int32, n int64, t time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.counts[key]
+	c.Requests += n
+	if t.After(c.LastUsed) {
+		c.LastUsed = t
+	}
+	u.counts[key] = c
+}
+
+// Take returns the counts of all keys and resets them
+func (u *Usage) Take() map[int32]Count {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := u.counts
+	u.counts = make(map[int32]Count)
+	return counts
+}
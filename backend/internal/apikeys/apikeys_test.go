@@ -0,0 +1,75 @@
+package apikeys
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	key, err := Generate()
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !IsKey(key) || len(key) != len(Prefix)+40 {
+		t.Errorf("unexpected key %v", key)
+	}
+	other, _ := Generate()
+	if key == other {
+		t.Error("expected keys to be random")
+	}
+	if Hash(key) == Hash(other) || len(Hash(key)) != 64 {
+		t.Errorf("unexpected hash %v", Hash(key))
+	}
+	if prefix := DisplayPrefix(key); prefix != key[:12] {
+		t.Errorf("expected the prefix %v, got %v", key[:12], prefix)
+	}
+	if IsKey("eyJhbGciOiJIUzI1NiJ9") {
+		t.Error("expected a JWT not to be recognised as API key")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 19, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(time.Minute)
+	limiter.Now = func() time.Time { return now }
+
+	for i := 2; i >= 0; i-- {
+		ok, remaining, reset := limiter.Allow("a", 3)
+		if !ok || remaining != i || !reset.Equal(now.Add(time.Minute)) {
+			t.Errorf("expected the request to be allowed with %v remaining, got %v, %v, %v", i, ok, remaining, reset)
+		}
+	}
+	if ok, _, _ := limiter.Allow("a", 3); ok {
+		t.Error("expected the fourth request to be rejected")
+	}
+	if ok, _, _ := limiter.Allow("b", 3); !ok {
+		t.Error("expected clients to be limited separately")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, remaining, _ := limiter.Allow("a", 3); !ok || remaining != 2 {
+		t.Errorf("expected the limit to be reset after the window, got %v, %v", ok, remaining)
+	}
+	if _, ok := limiter.windows["b"]; ok {
+		t.Error("expected the window of b to be forgotten")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	now := time.Date(2024, 5, 1, 19, 0, 0, 0, time.UTC)
+	usage := NewUsage()
+	usage.Add(1, 1, now)
+	usage.Add(1, 2, now.Add(-time.Minute))
+	usage.Add(2, 1, now)
+
+	counts := usage.Take()
+	if c := counts[1]; c.Requests != 3 || !c.LastUsed.Equal(now) {
+		t.Errorf("expected 3 requests last made at %v, got %+v", now, c)
+	}
+	if c := counts[2]; c.Requests != 1 {
+		t.Errorf("expected 1 request, got %+v", c)
+	}
+	if counts := usage.Take(); len(counts) != 0 {
+		t.Errorf("expected the counts to be reset, got %v", counts)
+	}
+}
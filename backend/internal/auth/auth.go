@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// lifetime of the links that are emailed to users
+	LoginTokenTTL = 15 * time.Minute
+	// lifetime of the session cookies/tokens issued once a login link has been used
+	SessionTokenTTL = 30 * 24 * time.Hour
+)
+
+const (
+	purposeLogin   = "login"
+	purposeSession = "session"
+	// calendar tokens aren't JWTs, the purpose is part of the signed message (see CalendarToken)
+	purposeCalendar = "calendar"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+var ErrTokenUsed = errors.New("the login link has already been used")
+
+// Claims of the tokens issued by an Issuer, the subject is the (normalised) email address of the user
+type Claims struct {
+	jwt.RegisteredClaims
+	Purpose string `json:"purpose"`
+}
+
+// Email returns the email address of the user
+func (c *Claims) Email() string {
+	return c.Subject
+}
+
+// Issuer signs and verifies login and session tokens (HS256 JWTs). Login tokens can only be redeemed once,
+// used tokens are remembered in memory until they expire. The API server can't write to the database, so instead of
+// storing the used tokens, login tokens are bound to the Issuer that signed them: after a restart, the login links
+// sent before are invalid (used or not) and users have to request a new one. Session tokens aren't affected.
+type Issuer struct {
+	secret   []byte
+	instance string // random ID of the Issuer, the issuer claim of its login tokens
+	Now      func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time // ID of redeemed login tokens -> expiry
+}
+
+func NewIssuer(secret []byte) *Issuer {
+	instance := make([]byte, 16)
+	if _, err := rand.Read(instance); err != nil {
+		panic(fmt.Errorf("failed to generate the issuer ID: %w", err))
+	}
+	return &Issuer{secret: secret, instance: hex.EncodeToString(instance), Now: time.Now, used: make(map[string]time.Time)}
+}
+
+func (i *Issuer) sign(email string, purpose string, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	var issuer string
+	if purpose == purposeLogin {
+		issuer = i.instance
+	}
+	now := i.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   email,
+			ID:        hex.EncodeToString(id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Purpose: purpose,
+	}).SignedString(i.secret)
+}
+
+func (i *Issuer) verify(token string, purpose string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return i.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithTimeFunc(i.Now))
+	if err != nil || claims.Purpose != purpose || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// LoginToken returns a short-lived token for the login link of the user
+func (i *Issuer) LoginToken(email string) (string, error) {
+	return i.sign(email, purposeLogin, LoginTokenTTL)
+}
+
+// SessionToken returns a token that authenticates the user for SessionTokenTTL
+func (i *Issuer) SessionToken(email string) (string, error) {
+	return i.sign(email, purposeSession, SessionTokenTTL)
+}
+
+// RedeemLoginToken verifies a login token and marks it as used. Tokens signed by another Issuer (e.g. before a
+// restart of the server) are invalid.
+func (i *Issuer) RedeemLoginToken(token string) (*Claims, error) {
+	claims, err := i.verify(token, purposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != i.instance {
+		return nil, ErrInvalidToken
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := i.Now()
+	for id, exp := range i.used {
+		if now.After(exp) {
+			delete(i.used, id)
+		}
+	}
+	if _, ok := i.used[claims.ID]; ok {
+		return nil, ErrTokenUsed
+	}
+	i.used[claims.ID] = claims.ExpiresAt.Time
+	return claims, nil
+}
+
+// VerifySessionToken returns the claims of a valid session token
+func (i *Issuer) VerifySessionToken(token string) (*Claims, error) {
+	return i.verify(token, purposeSession)
+}
+
+// CalendarToken returns the token of the calendar feed of the user. Calendar apps can't send credentials, so the token
+// is part of the feed URL - it doesn't expire and doesn't reveal the email address of the user.
+func (i *Issuer) CalendarToken(userID int32) string {
+	id := strconv.Itoa(int(userID))
+	return id + "-" + i.calendarSignature(id)
+}
+
+// VerifyCalendarToken returns the ID of the user the calendar token was issued to
+func (i *Issuer) VerifyCalendarToken(token string) (int32, error) {
+	id, signature, ok := strings.Cut(token, "-")
+	if !ok || !hmac.Equal([]byte(signature), []byte(i.calendarSignature(id))) {
+		return 0, ErrInvalidToken
+	}
+	userID, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return int32(userID), nil
+}
+
+func (i *Issuer) calendarSignature(id string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(purposeCalendar + ":" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NormaliseEmail validates an email address and returns it in lower case (without display name)
+func NormaliseEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return "", fmt.Errorf("invalid email address %q", email)
+	}
+	return strings.ToLower(addr.Address), nil
+}
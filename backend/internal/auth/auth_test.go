@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginToken(t *testing.T) {
+	issuer := NewIssuer([]byte("secret"))
+	token, err := issuer.LoginToken("player@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, err := issuer.VerifySessionToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a login token to be rejected as session token, got %v", err)
+	}
+	claims, err := issuer.RedeemLoginToken(token)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if claims.Email() != "player@example.org" {
+		t.Errorf("expected the email player@example.org, got %v", claims.Email())
+	}
+	if _, err := issuer.RedeemLoginToken(token); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("expected the login token to be usable only once, got %v", err)
+	}
+
+	// a restarted server doesn't know which tokens were used, so it doesn't accept any it didn't sign itself
+	token, err = issuer.LoginToken("player@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	restarted := NewIssuer([]byte("secret"))
+	if _, err := restarted.RedeemLoginToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a login token of another issuer to be rejected, got %v", err)
+	}
+	session, err := issuer.SessionToken("player@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, err := restarted.VerifySessionToken(session); err != nil {
+		t.Errorf("expected session tokens to remain valid after a restart, got %v", err)
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	issuer := NewIssuer([]byte("secret"))
+	token, err := issuer.SessionToken("player@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, err := issuer.RedeemLoginToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a session token to be rejected as login token, got %v", err)
+	}
+	if claims, err := issuer.VerifySessionToken(token); err != nil || claims.Email() != "player@example.org" {
+		t.Errorf("expected the token to be valid, got %v (err: %v)", claims, err)
+	}
+	if _, err := NewIssuer([]byte("other")).VerifySessionToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected tokens signed with another secret to be rejected, got %v", err)
+	}
+
+	// expired
+	issuer.Now = func() time.Time { return time.Now().Add(SessionTokenTTL + time.Minute) }
+	if _, err := issuer.VerifySessionToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the token to be expired, got %v", err)
+	}
+}
+
+func TestNormaliseEmail(t *testing.T) {
+	for _, tc := range []struct {
+		email    string
+		expected string
+		valid    bool
+	}{
+		{" Player@Example.org ", "player@example.org", true},
+		{"player", "", false},
+		{"Player <player@example.org>", "", false},
+		{"player@example.org\r\nBcc: victim@example.org", "", false},
+	} {
+		email, err := NormaliseEmail(tc.email)
+		if (err == nil) != tc.valid || email != tc.expected {
+			t.Errorf("%q: expected %q (valid: %v), got %q (err: %v)", tc.email, tc.expected, tc.valid, email, err)
+		}
+	}
+}
+
+func TestCalendarToken(t *testing.T) {
+	issuer := NewIssuer([]byte("secret"))
+	token := issuer.CalendarToken(42)
+	if id, err := issuer.VerifyCalendarToken(token); err != nil || id != 42 {
+		t.Errorf("expected user 42, got %v (err: %v)", id, err)
+	}
+	if strings.Contains(token, "/") || strings.Contains(token, "+") {
+		t.Errorf("expected the token to be URL safe, got %v", token)
+	}
+	for _, invalid := range []string{"", "42", "43" + token[2:], token + "x", NewIssuer([]byte("other")).CalendarToken(42)} {
+		if _, err := issuer.VerifyCalendarToken(invalid); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected %q to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	if !RoleAdmin.AtLeast(RoleModerator) || !RoleContributor.AtLeast(RoleAnonymous) || RoleContributor.AtLeast(RoleTrustedContributor) {
+		t.Error("expected roles to be ordered")
+	}
+	if Role("unknown").AtLeast(RoleAnonymous) {
+		t.Error("expected unknown roles to rank below anonymous")
+	}
+	if _, err := ParseRole("venue_owner"); err == nil {
+		t.Error("expected venue_owner not to be assignable to accounts")
+	}
+	if role, err := ParseRole("trusted_contributor"); err != nil || role != RoleTrustedContributor {
+		t.Errorf("expected trusted_contributor, got %v (err: %v)", role, err)
+	}
+
+	owner := Principal{Email: "owner@example.org", Role: RoleContributor, OwnedVenues: []int32{3}}
+	if role := owner.RoleFor(ptr(int32(3))); role != RoleVenueOwner {
+		t.Errorf("expected the owner to be venue_owner of venue 3, got %v", role)
+	}
+	if role := owner.RoleFor(ptr(int32(4))); role != RoleContributor {
+		t.Errorf("expected the owner to be contributor for venue 4, got %v", role)
+	}
+	if role := owner.RoleFor(nil); role != RoleContributor {
+		t.Errorf("expected the owner to be contributor, got %v", role)
+	}
+	moderator := Principal{Email: "moderator@example.org", Role: RoleModerator, OwnedVenues: []int32{3}}
+	if role := moderator.RoleFor(ptr(int32(3))); role != RoleModerator {
+		t.Errorf("expected higher roles to take precedence over venue ownership, got %v", role)
+	}
+}
+
+func ptr[T any](t T) *T { return &t }
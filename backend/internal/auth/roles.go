@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Role of the user making a request. Roles are ordered, every role has the permissions of the roles below it.
+type Role string
+
+const (
+	RoleAnonymous          Role = "anonymous"
+	RoleContributor        Role = "contributor"
+	RoleTrustedContributor Role = "trusted_contributor"
+	// venue owners are assigned to venues rather than accounts (see Principal.RoleFor)
+	RoleVenueOwner Role = "venue_owner"
+	RoleModerator  Role = "moderator"
+	RoleAdmin      Role = "admin"
+)
+
+var roleOrder = []Role{RoleAnonymous, RoleContributor, RoleTrustedContributor, RoleVenueOwner, RoleModerator, RoleAdmin}
+
+// AssignableRoles are the roles that can be stored with an account
+var AssignableRoles = []Role{RoleContributor, RoleTrustedContributor, RoleModerator, RoleAdmin}
+
+// ParseRole returns the role if it can be assigned to an account
+func ParseRole(role string) (Role, error) {
+	if !slices.Contains(AssignableRoles, Role(role)) {
+		return "", fmt.Errorf("unknown role %q (available: %v)", role, AssignableRoles)
+	}
+	return Role(role), nil
+}
+
+// AtLeast reports whether the role has the permissions of the other role. Unknown roles rank below anonymous.
+func (r Role) AtLeast(other Role) bool {
+	return slices.Index(roleOrder, r) >= slices.Index(roleOrder, other)
+}
+
+// Principal is the user a request is made on behalf of
+type Principal struct {
+	// empty for anonymous requests and requests authorised with the admin token
+	Email       string
+	Role        Role
+	OwnedVenues []int32
+}
+
+// Anonymous is the principal of requests without (valid) credentials
+var Anonymous = Principal{Role: RoleAnonymous}
+
+// RoleFor returns the role of the principal for requests concerning the venue (nil if the request doesn't concern a
+// particular venue). Owners of the venue are venue owners unless their role ranks higher.
+func (p Principal) RoleFor(venue *int32) Role {
+	if venue != nil && slices.Contains(p.OwnedVenues, *venue) && !p.Role.AtLeast(RoleVenueOwner) {
+		return RoleVenueOwner
+	}
+	return p.Role
+}
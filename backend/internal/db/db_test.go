@@ -176,9 +176,15 @@ func TestInsertJamsession(t *testing.T) {
 		t.Errorf("expected dt_updated_utc to point to a value within the last minute before execution time of this statement, got %v (now: %v)", result.DtUpdatedUtc.Time, time.Now().UTC())
 	}
 
-	if result.Rating != 3 {
+	if result.Rating == nil || *result.Rating != 3 {
 		t.Errorf("expected the rating to be the average of {1,5} = 3, got %v", result.Rating)
 	}
+	if result.RatingCount == nil || *result.RatingCount != 2 {
+		t.Errorf("expected the rating count to be 2, got %v", result.RatingCount)
+	}
+	if fmt.Sprint(result.RatingHistogram) != "[1 0 0 0 1]" {
+		t.Errorf("expected one 1 star and one 5 star rating in the histogram, got %v", result.RatingHistogram)
+	}
 }
 
 func TestGetAllSessionsAsGeoJSON(t *testing.T) {
@@ -212,7 +218,7 @@ func TestGetSessionsByIdAsGeoJSON(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to retrieve sessions as geojson: %v", err)
 	}
-	err = json.Unmarshal([]byte(result.(string)), &geojson)
+	err = json.Unmarshal(result, &geojson)
 	if err != nil {
 		t.Fatalf("failed to unmarshal json query result: %v", err)
 	}
@@ -553,3 +559,211 @@ func TestGetSessionsByDateRange(t *testing.T) {
 		t.Error("dates property shouldn't be nil")
 	}
 }
+
+func TestRateLimiter(t *testing.T) {
+	spacing := 200 * time.Millisecond
+	// two limiters sharing the same name simulate two separate processes
+	limiter1 := NewRateLimiter(queries.db, "test_rate_limiter", spacing)
+	limiter2 := NewRateLimiter(queries.db, "test_rate_limiter", spacing)
+
+	start := time.Now()
+	for _, l := range []*RateLimiter{limiter1, limiter2, limiter1} {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("failed to wait for rate limiter: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*spacing {
+		t.Errorf("expected three requests to take at least %v, took %v", 2*spacing, elapsed)
+	}
+
+	// a cancelled context should not block
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter2.Wait(cancelledCtx); err == nil {
+		t.Error("expected an error when waiting with a cancelled context")
+	}
+}
+
+// helper func - maps session ids to the dates returned by GetSessionIdsByDateRange
+func sessionDatesByDateRange(start time.Time, end time.Time) (map[int32][]time.Time, error) {
+	result, err := queries.GetSessionIdsByDateRange(ctx, GetSessionIdsByDateRangeParams{
+		StartDate: pgtype.Date{Time: start, Valid: true},
+		EndDate:   pgtype.Date{Time: end, Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int32][]time.Time)
+	for _, i := range result {
+		s := i.([]any)
+		for _, d := range s[1].([]any) {
+			m[s[0].(int32)] = append(m[s[0].(int32)], d.(time.Time))
+		}
+	}
+	return m, nil
+}
+
+func TestSessionOccurrences(t *testing.T) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	computed, err := sessionDatesByDateRange(start, end)
+	if err != nil {
+		t.Fatalf("could not retrieve session ids by date range: %v", err)
+	}
+
+	// materialise the occurrences for the whole of 2024
+	if err := queries.SetOccurrencesHorizon(ctx, SetOccurrencesHorizonParams{
+		StartDate: pgtype.Date{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		EndDate:   pgtype.Date{Time: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), Valid: true},
+	}); err != nil {
+		t.Fatalf("could not set horizon: %v", err)
+	}
+	if err := queries.RefreshSessionOccurrences(ctx, nil); err != nil {
+		t.Fatalf("could not refresh session occurrences: %v", err)
+	}
+	horizon, err := queries.GetOccurrencesHorizon(ctx)
+	if err != nil {
+		t.Fatalf("could not retrieve horizon: %v", err)
+	}
+	if horizon.Occurrences == 0 || !horizon.DtRefreshedUtc.Valid {
+		t.Errorf("expected the occurrences to be materialised, got %+v", horizon)
+	}
+
+	materialised, err := sessionDatesByDateRange(start, end)
+	if err != nil {
+		t.Fatalf("could not retrieve session ids by date range: %v", err)
+	}
+	if fmt.Sprint(computed) != fmt.Sprint(materialised) {
+		t.Errorf("expected the materialised occurrences %v to match the computed occurrences %v", materialised, computed)
+	}
+
+	// new sessions are materialised by the trigger
+	sessionId, err := insertSession("Ronnie Scott's Jazz Canteen", 51, 1, 45, "Once")
+	if err != nil {
+		t.Fatalf("could not insert session: %v", err)
+	}
+	result, err := queries.GetSessionIdsByDate(ctx, pgtype.Date{Time: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), Valid: true})
+	if err != nil {
+		t.Fatalf("could not retrieve session ids by date: %v", err)
+	}
+	var found bool
+	for _, i := range result {
+		if i.([]any)[0].(int32) == sessionId {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected newly inserted session %v to be returned for 2024-08-01, got %v", sessionId, result)
+	}
+
+	// outside of the horizon the occurrences are computed on the fly
+	outside, err := sessionDatesByDateRange(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 7, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("could not retrieve session ids by date range: %v", err)
+	}
+	if len(outside[fixtureDaily]) != 7 {
+		t.Errorf("expected 7 dates for the daily session outside of the horizon, got %v", outside[fixtureDaily])
+	}
+}
+
+// compares computing the dates of all sessions in a month to looking them up in session_occurrences
+func BenchmarkSessionDates(b *testing.B) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 11, 30, 0, 0, 0, 0, time.UTC)
+	if err := queries.SetOccurrencesHorizon(ctx, SetOccurrencesHorizonParams{
+		StartDate: pgtype.Date{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		EndDate:   pgtype.Date{Time: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), Valid: true},
+	}); err != nil {
+		b.Fatalf("could not set horizon: %v", err)
+	}
+	if err := queries.RefreshSessionOccurrences(ctx, nil); err != nil {
+		b.Fatalf("could not refresh session occurrences: %v", err)
+	}
+	for _, bm := range []struct {
+		name  string
+		query string
+	}{
+		{"Computed", "SELECT * FROM london_jam_sessions.sessions_in_date_range($1, $2)"},
+		{"Materialised", "SELECT * FROM london_jam_sessions.session_dates($1, $2)"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rows, err := queries.db.Query(ctx, bm.query, start, end)
+				if err != nil {
+					b.Fatal(err)
+				}
+				rows.Close()
+				if err := rows.Err(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRatingStats(t *testing.T) {
+	sessionId, err := insertSession("Ronnie Scott's Jazz Lounge", 52, 2, 50, "Once")
+	if err != nil {
+		t.Fatalf("could not insert session: %v", err)
+	}
+	unrated, err := insertSession("Ronnie Scott's Jazz Kitchen", 53, 3, 55, "Once")
+	if err != nil {
+		t.Fatalf("could not insert session: %v", err)
+	}
+	if _, err := queries.db.Exec(ctx, "DELETE FROM london_jam_sessions.ratings WHERE session = $1", unrated); err != nil {
+		t.Fatalf("could not delete ratings: %v", err)
+	}
+	// ratings are {5, 1} from insertSession, change the 1 to a 4 and add another 4 and a rating of the house band only
+	if _, err := queries.db.Exec(ctx, "UPDATE london_jam_sessions.ratings SET rating = 4 WHERE session = $1 AND rating = 1", sessionId); err != nil {
+		t.Fatalf("could not update rating: %v", err)
+	}
+	for _, r := range []InsertSessionRatingParams{
+		{Session: sessionId, Rating: ptr(int16(4)), HouseBand: ptr(int16(5)), Sound: ptr(int16(3))},
+		{Session: sessionId, HouseBand: ptr(int16(4))},
+	} {
+		if _, err := queries.InsertSessionRating(ctx, r); err != nil {
+			t.Fatalf("could not insert rating: %v", err)
+		}
+	}
+
+	result, err := queries.GetSessionById(ctx, sessionId)
+	if err != nil {
+		t.Fatalf("could not retrieve session: %v", err)
+	}
+	if result.Rating == nil || *result.Rating != 4.33 {
+		t.Errorf("expected the rating to be the average of {5,4,4} = 4.33, got %v", result.Rating)
+	}
+	if result.RatingCount == nil || *result.RatingCount != 3 {
+		t.Errorf("expected the rating count to be 3, got %v", result.RatingCount)
+	}
+	if fmt.Sprint(result.RatingHistogram) != "[0 0 0 2 1]" {
+		t.Errorf("expected two 4 star and one 5 star rating in the histogram, got %v", result.RatingHistogram)
+	}
+	if result.RatingScore == nil || *result.RatingScore >= *result.Rating {
+		t.Errorf("expected the score (%v) to be pulled below the average towards the mean of all ratings", result.RatingScore)
+	}
+	var aspects types.AspectRatingStats
+	if err := json.Unmarshal(result.AspectRatings, &aspects); err != nil {
+		t.Fatalf("could not unmarshal aspect ratings %s: %v", result.AspectRatings, err)
+	}
+	if aspects.HouseBand == nil || aspects.HouseBand.Rating != 4.5 || aspects.HouseBand.RatingCount != 2 {
+		t.Errorf("expected the house band to be rated 4.5 on average by 2 ratings, got %+v", aspects.HouseBand)
+	}
+	if aspects.Sound == nil || aspects.Sound.Rating != 3 || aspects.Sound.RatingCount != 1 {
+		t.Errorf("expected the sound to be rated 3 by 1 rating, got %+v", aspects.Sound)
+	}
+	if aspects.Crowd != nil || aspects.Backline != nil || aspects.BeginnerFriendliness != nil {
+		t.Errorf("expected aspects without ratings to be left out, got %s", result.AspectRatings)
+	}
+
+	result, err = queries.GetSessionById(ctx, unrated)
+	if err != nil {
+		t.Fatalf("could not retrieve session: %v", err)
+	}
+	if result.Rating != nil || result.RatingCount == nil || *result.RatingCount != 0 || fmt.Sprint(result.RatingHistogram) != "[0 0 0 0 0]" {
+		t.Errorf("expected no rating for a session without ratings, got %v (count %v, histogram %v)", result.Rating, result.RatingCount, result.RatingHistogram)
+	}
+	if result.RatingScore == nil {
+		t.Error("expected a session without ratings to have the mean of all ratings as score")
+	}
+}
@@ -0,0 +1,113 @@
+package dbutils
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DataChangedChannel is the channel the notify_data_changed trigger sends notifications on (see schema.sql)
+const DataChangedChannel = "data_changed"
+
+// EventsChannel is the channel the record_event trigger sends the recorded events on (see schema.sql)
+const EventsChannel = "events"
+
+// Listener holds a dedicated connection of the pool that LISTENs on a channel and passes every
+// notification to the registered handlers. The connection is re-established (with backoff) if it is lost,
+// handlers registered with OnConnectionChange are told about it because notifications may have been missed.
+type Listener struct {
+	pool    *pgxpool.Pool
+	channel string
+
+	mu                 sync.RWMutex
+	handlers           []func(*pgconn.Notification)
+	connectionHandlers []func(connected bool)
+}
+
+// NewListener returns a Listener for the channel, call Listen to start listening
+func NewListener(pool *pgxpool.Pool, channel string) *Listener {
+	return &Listener{pool: pool, channel: channel}
+}
+
+// OnNotification registers a handler that is called for every notification (from the listening goroutine)
+func (l *Listener) OnNotification(handler func(*pgconn.Notification)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers = append(l.handlers, handler)
+}
+
+// OnConnectionChange registers a handler that is called when the listening connection is established or lost
+func (l *Listener) OnConnectionChange(handler func(connected bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.connectionHandlers = append(l.connectionHandlers, handler)
+}
+
+// Listen blocks until the context is done, reconnecting whenever the connection is lost
+func (l *Listener) Listen(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		connected, err := l.listen(ctx)
+		if connected {
+			l.connectionChanged(false)
+			backoff = time.Second
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Error("Listener", "channel", l.channel, "msg", "connection lost, reconnecting", "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// helper func - listens on a single connection until an error occurs, reports whether the connection was established
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	// the connection stays in LISTEN state, take it out of the pool instead of releasing it
+	pgConn := conn.Hijack()
+	defer pgConn.Close(context.Background())
+
+	if _, err := pgConn.Exec(ctx, "LISTEN "+quoteIdentifier(l.channel)); err != nil {
+		return false, err
+	}
+	l.connectionChanged(true)
+	for {
+		n, err := pgConn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		l.mu.RLock()
+		handlers := l.handlers
+		l.mu.RUnlock()
+		for _, h := range handlers {
+			h(n)
+		}
+	}
+}
+
+func (l *Listener) connectionChanged(connected bool) {
+	l.mu.RLock()
+	handlers := l.connectionHandlers
+	l.mu.RUnlock()
+	for _, h := range handlers {
+		h(connected)
+	}
+}
+
+// helper func - quotes a channel name for use in LISTEN statements (which don't accept parameters)
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
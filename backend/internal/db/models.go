@@ -8,12 +8,83 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type LondonJamSessionsApiKey struct {
+	KeyID     int32              `json:"key_id"`
+	Name      string             `json:"name"`
+	KeyPrefix string             `json:"key_prefix"`
+	KeyHash   string             `json:"key_hash"`
+	RateLimit int32              `json:"rate_limit"`
+	DtCreated pgtype.Timestamptz `json:"dt_created"`
+	DtRevoked pgtype.Timestamptz `json:"dt_revoked"`
+}
+
+type LondonJamSessionsApiKeyUsage struct {
+	KeyID        int32              `json:"key_id"`
+	Day          pgtype.Date        `json:"day"`
+	RequestCount int64              `json:"request_count"`
+	DtLastUsed   pgtype.Timestamptz `json:"dt_last_used"`
+}
+
+type LondonJamSessionsAspectRatingAggregate struct {
+	Session     int32  `json:"session"`
+	Aspect      string `json:"aspect"`
+	RatingCount int32  `json:"rating_count"`
+	RatingSum   int32  `json:"rating_sum"`
+}
+
 type LondonJamSessionsComment struct {
-	CommentID int32              `json:"comment_id"`
-	Session   int32              `json:"session"`
-	Author    string             `json:"author"`
-	Content   string             `json:"content"`
-	DtPosted  pgtype.Timestamptz `json:"dt_posted"`
+	CommentID     int32              `json:"comment_id"`
+	Session       int32              `json:"session"`
+	Author        string             `json:"author"`
+	Content       string             `json:"content"`
+	DtPosted      pgtype.Timestamptz `json:"dt_posted"`
+	ParentComment *int32             `json:"parent_comment"`
+	Verified      bool               `json:"verified"`
+	Status        string             `json:"status"`
+	SpamScore     *float32           `json:"spam_score"`
+	DtModerated   pgtype.Timestamptz `json:"dt_moderated"`
+	EditTokenHash *string            `json:"edit_token_hash"`
+	UserID        *int32             `json:"user_id"`
+	DtEdited      pgtype.Timestamptz `json:"dt_edited"`
+}
+
+type LondonJamSessionsCommentEdit struct {
+	EditID   int32              `json:"edit_id"`
+	Comment  int32              `json:"comment"`
+	Author   string             `json:"author"`
+	Content  string             `json:"content"`
+	DtEdited pgtype.Timestamptz `json:"dt_edited"`
+}
+
+type LondonJamSessionsCommentReport struct {
+	ReportID     int32              `json:"report_id"`
+	Comment      int32              `json:"comment"`
+	Reason       string             `json:"reason"`
+	Details      *string            `json:"details"`
+	ReporterHash *string            `json:"reporter_hash"`
+	DtReported   pgtype.Timestamptz `json:"dt_reported"`
+	DtResolved   pgtype.Timestamptz `json:"dt_resolved"`
+}
+
+type LondonJamSessionsEvent struct {
+	EventID   int64              `json:"event_id"`
+	EventType string             `json:"event_type"`
+	Data      []byte             `json:"data"`
+	DtCreated pgtype.Timestamptz `json:"dt_created"`
+}
+
+type LondonJamSessionsFavourite struct {
+	UserID  int32              `json:"user_id"`
+	Session int32              `json:"session"`
+	DtAdded pgtype.Timestamptz `json:"dt_added"`
+}
+
+type LondonJamSessionsFollow struct {
+	FollowID int32              `json:"follow_id"`
+	UserID   int32              `json:"user_id"`
+	Session  *int32             `json:"session"`
+	Venue    *int32             `json:"venue"`
+	DtAdded  pgtype.Timestamptz `json:"dt_added"`
 }
 
 type LondonJamSessionsJamsession struct {
@@ -29,12 +100,92 @@ type LondonJamSessionsJamsession struct {
 	DtUpdatedUtc    pgtype.Timestamptz `json:"dt_updated_utc"`
 }
 
+type LondonJamSessionsNotificationChannel struct {
+	ChannelID  int32              `json:"channel_id"`
+	UserID     int32              `json:"user_id"`
+	Kind       string             `json:"kind"`
+	Endpoint   *string            `json:"endpoint"`
+	P256dh     *string            `json:"p256dh"`
+	AuthSecret *string            `json:"auth_secret"`
+	DtCreated  pgtype.Timestamptz `json:"dt_created"`
+}
+
+type LondonJamSessionsRateLimit struct {
+	Name     string             `json:"name"`
+	NextSlot pgtype.Timestamptz `json:"next_slot"`
+}
+
 type LondonJamSessionsRating struct {
-	RatingID int32              `json:"rating_id"`
-	Session  int32              `json:"session"`
-	Comment  *int32             `json:"comment"`
-	Rating   *int16             `json:"rating"`
-	DtPosted pgtype.Timestamptz `json:"dt_posted"`
+	RatingID             int32              `json:"rating_id"`
+	Session              int32              `json:"session"`
+	Comment              *int32             `json:"comment"`
+	Rating               *int16             `json:"rating"`
+	HouseBand            *int16             `json:"house_band"`
+	Backline             *int16             `json:"backline"`
+	BeginnerFriendliness *int16             `json:"beginner_friendliness"`
+	Sound                *int16             `json:"sound"`
+	Crowd                *int16             `json:"crowd"`
+	DtPosted             pgtype.Timestamptz `json:"dt_posted"`
+	UserID               *int32             `json:"user_id"`
+}
+
+type LondonJamSessionsRatingAggregate struct {
+	Session         int32   `json:"session"`
+	RatingCount     int32   `json:"rating_count"`
+	RatingSum       int32   `json:"rating_sum"`
+	RatingHistogram []int32 `json:"rating_histogram"`
+}
+
+type LondonJamSessionsSavedSearch struct {
+	SearchID      int32              `json:"search_id"`
+	UserID        int32              `json:"user_id"`
+	SearchName    string             `json:"search_name"`
+	Genres        []string           `json:"genres"`
+	Backline      []string           `json:"backline"`
+	Weekdays      []int16            `json:"weekdays"`
+	Lon           *float64           `json:"lon"`
+	Lat           *float64           `json:"lat"`
+	RadiusMetres  *int32             `json:"radius_metres"`
+	LastSessionID int32              `json:"last_session_id"`
+	DtCreated     pgtype.Timestamptz `json:"dt_created"`
+	DtLastDigest  pgtype.Timestamptz `json:"dt_last_digest"`
+}
+
+type LondonJamSessionsSessionOccurrence struct {
+	Session int32       `json:"session"`
+	D       pgtype.Date `json:"d"`
+}
+
+type LondonJamSessionsSessionOccurrencesHorizon struct {
+	ID             bool               `json:"id"`
+	StartDate      pgtype.Date        `json:"start_date"`
+	EndDate        pgtype.Date        `json:"end_date"`
+	DtRefreshedUtc pgtype.Timestamptz `json:"dt_refreshed_utc"`
+}
+
+type LondonJamSessionsSessionRatingStat struct {
+	Session         int32   `json:"session"`
+	Rating          float32 `json:"rating"`
+	RatingCount     int32   `json:"rating_count"`
+	RatingHistogram []int32 `json:"rating_histogram"`
+	RatingScore     float32 `json:"rating_score"`
+	AspectRatings   []byte  `json:"aspect_ratings"`
+}
+
+type LondonJamSessionsUser struct {
+	UserID    int32              `json:"user_id"`
+	Email     string             `json:"email"`
+	Role      string             `json:"role"`
+	DtCreated pgtype.Timestamptz `json:"dt_created"`
+}
+
+type LondonJamSessionsUserContribution struct {
+	ContributionID int32              `json:"contribution_id"`
+	UserID         int32              `json:"user_id"`
+	Session        *int32             `json:"session"`
+	Venue          *int32             `json:"venue"`
+	Action         string             `json:"action"`
+	DtContributed  pgtype.Timestamptz `json:"dt_contributed"`
 }
 
 type LondonJamSessionsVenue struct {
@@ -50,3 +201,32 @@ type LondonJamSessionsVenue struct {
 	VenueComments     []string           `json:"venue_comments"`
 	VenueDtUpdatedUtc pgtype.Timestamptz `json:"venue_dt_updated_utc"`
 }
+
+type LondonJamSessionsVenueOwner struct {
+	UserID int32 `json:"user_id"`
+	Venue  int32 `json:"venue"`
+}
+
+type LondonJamSessionsWebhookDelivery struct {
+	DeliveryID     int64              `json:"delivery_id"`
+	SubscriptionID int32              `json:"subscription_id"`
+	EventID        string             `json:"event_id"`
+	EventType      string             `json:"event_type"`
+	Payload        []byte             `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int16              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	ResponseStatus *int16             `json:"response_status"`
+	LastError      *string            `json:"last_error"`
+	DtCreated      pgtype.Timestamptz `json:"dt_created"`
+	DtDelivered    pgtype.Timestamptz `json:"dt_delivered"`
+}
+
+type LondonJamSessionsWebhookSubscription struct {
+	SubscriptionID int32              `json:"subscription_id"`
+	Url            string             `json:"url"`
+	Secret         string             `json:"secret"`
+	EventTypes     []string           `json:"event_types"`
+	Description    *string            `json:"description"`
+	DtCreated      pgtype.Timestamptz `json:"dt_created"`
+}
@@ -13,46 +13,75 @@ WITH t AS (
     SELECT * FROM london_jam_sessions.venues
     WHERE venue_id = $1
 )
-SELECT public.ST_AsGeoJSON(t.*) FROM t;
+SELECT json_strip_nulls(public.ST_AsGeoJSON(t.*)::json) FROM t;
 
 -- name: GetVenueByName :one
 SELECT * FROM london_jam_sessions.venues
 WHERE venue_name = $1;
 
+-- name: GetAllVenueLocations :many
+SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode,
+    public.ST_X(geom)::float8 AS lon, public.ST_Y(geom)::float8 AS lat
+FROM london_jam_sessions.venues
+ORDER BY venue_id;
+
+-- name: GetDuplicateVenues :many
+SELECT a.venue_id, a.venue_name, b.venue_id AS duplicate_venue_id, b.venue_name AS duplicate_venue_name,
+    public.similarity(a.venue_name, b.venue_name)::real AS name_similarity,
+    london_jam_sessions.normalise_address(a.address_first_line, a.postcode) = london_jam_sessions.normalise_address(b.address_first_line, b.postcode) AS same_address,
+    public.ST_Distance(a.geom::public.geography, b.geom::public.geography)::float8 AS distance_metres
+FROM london_jam_sessions.venues a
+JOIN london_jam_sessions.venues b ON a.venue_id < b.venue_id
+WHERE public.similarity(a.venue_name, b.venue_name) >= sqlc.arg(min_name_similarity)::real
+OR london_jam_sessions.normalise_address(a.address_first_line, a.postcode) = london_jam_sessions.normalise_address(b.address_first_line, b.postcode)
+OR public.ST_DWithin(a.geom::public.geography, b.geom::public.geography, sqlc.arg(max_distance_metres)::float8)
+ORDER BY name_similarity DESC, distance_metres;
+
+-- name: GetConflictingSessions :many
+-- sessions at the same venue with the same recurrence that take place on the same day(s) at the same time
+SELECT session_id, session_name, start_time_utc, interval FROM london_jam_sessions.jamsessions
+WHERE venue = sqlc.arg(venue)
+AND interval = sqlc.arg(interval)
+AND (start_time_utc AT TIME ZONE 'UTC')::time = (sqlc.arg(start_time_utc)::timestamptz AT TIME ZONE 'UTC')::time
+AND CASE interval
+    WHEN 'Daily' THEN true
+    WHEN 'Once' THEN (start_time_utc AT TIME ZONE 'UTC')::date = (sqlc.arg(start_time_utc)::timestamptz AT TIME ZONE 'UTC')::date
+    WHEN 'Fortnightly' THEN ((start_time_utc AT TIME ZONE 'UTC')::date - (sqlc.arg(start_time_utc)::timestamptz AT TIME ZONE 'UTC')::date) % 14 = 0
+    ELSE EXTRACT(isodow FROM start_time_utc AT TIME ZONE 'UTC') = EXTRACT(isodow FROM sqlc.arg(start_time_utc)::timestamptz AT TIME ZONE 'UTC')
+END
+ORDER BY session_id;
+
 -- name: GetSessionsByVenueIdAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.venue_id = $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
-    'features', json_agg(public.ST_AsGeoJSON(t.*)::json)
+    'features', coalesce(json_agg(json_strip_nulls(public.ST_AsGeoJSON(t.*)::json)), '[]'::json)
 ) FROM t;
 
 -- name: GetCommentsBySessionId :many
 SELECT c.*, r.rating, r.rating_id FROM london_jam_sessions.comments c
 LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
-WHERE c.session = $1;
+WHERE c.session = $1 AND c.status = 'visible';
 
 -- name: GetRatingsBySessionId :many
 SELECT * FROM london_jam_sessions.ratings
 WHERE session = $1;
 
 -- name: GetAllSessions :many
-SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
 JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-GROUP BY s.session_id, l.venue_id;
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id;
 
 -- name: GetAllSessionsAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -60,30 +89,27 @@ SELECT json_build_object(
 ) FROM t;
 
 -- name: GetSessionById :one
-SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
 JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-WHERE s.session_id = $1
-GROUP BY s.session_id, l.venue_id;
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+WHERE s.session_id = $1;
 
 -- name: GetSessionByIdAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.session_id = $1
-    GROUP BY s.session_id, l.venue_id
 )
-SELECT public.ST_AsGeoJSON(t.*) FROM t;
+SELECT json_strip_nulls(public.ST_AsGeoJSON(t.*)::json) FROM t;
 
 -- name: GetSessionsByDateAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
-    FROM london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) d
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
+    FROM london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) AS d(session_id, dates)
     LEFT OUTER JOIN london_jam_sessions.jamsessions s ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id, d.d
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -91,20 +117,19 @@ SELECT json_build_object(
 ) FROM t;
 
 -- name: GetSessionIdsByDateRange :many
-SELECT * FROM london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date);
+SELECT * FROM london_jam_sessions.session_dates(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date);
 
 -- name: GetSessionIdsByDate :many
-SELECT * FROM london_jam_sessions.sessions_on_date(sqlc.arg(date)::date);
+SELECT * FROM london_jam_sessions.session_dates(sqlc.arg(date)::date, sqlc.arg(date)::date);
 
 -- name: GetSessionsByDateRangeAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id, d.d
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -113,11 +138,10 @@ SELECT json_build_object(
 
 -- name: GetSessionsByBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -126,11 +150,10 @@ SELECT json_build_object(
 
 -- name: GetSessionsByGenreAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -139,12 +162,11 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateAndGenreAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) d ON d.session_id = s.session_id 
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) AS d(session_id, dates) ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> sqlc.arg(genres) 
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -153,14 +175,13 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateRangeAndGenreAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> sqlc.arg(genres)
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -170,12 +191,11 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) d ON d.session_id = s.session_id
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) AS d(session_id, dates) ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> sqlc.arg(backline)
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -184,14 +204,13 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateRangeAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> sqlc.arg(backline)
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -200,13 +219,12 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateAndGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) d ON d.session_id = s.session_id
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date(sqlc.arg(date)::date) AS d(session_id, dates) ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> sqlc.arg(genres)
     AND l.backline @> sqlc.arg(backline)
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -215,15 +233,14 @@ SELECT json_build_object(
 
 -- name: GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.d, s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> sqlc.arg(genres)
     AND l.backline @> sqlc.arg(backline)
-    GROUP BY s.session_id, l.venue_id, d.d
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -232,12 +249,11 @@ SELECT json_build_object(
 
 -- name: GetSessionsByGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT s.*, l.*, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> sqlc.arg(genres)
     AND l.backline @> sqlc.arg(backline)
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -262,7 +278,8 @@ SET -- see https://docs.sqlc.dev/en/latest/howto/named_parameters.html#nullable-
     geom = coalesce(sqlc.narg(geom), geom),
     venue_website = coalesce(sqlc.narg(venue_website), venue_website),
     backline = coalesce(sqlc.narg(backline), backline),
-    venue_comments = coalesce(sqlc.narg(venue_comments), venue_comments)
+    venue_comments = coalesce(sqlc.narg(venue_comments), venue_comments),
+    venue_dt_updated_utc = NOW() AT TIME ZONE 'utc'
 WHERE venue_id = $1;
 
 -- name: InsertJamSession :one
@@ -281,21 +298,47 @@ SET
     start_time_utc = coalesce(sqlc.narg(start_time_utc), start_time_utc),
     interval = coalesce(sqlc.narg(interval), interval),
     duration_minutes = coalesce(sqlc.narg(duration_minutes), duration_minutes),
-    session_website = coalesce(sqlc.narg(session_website), session_website)
+    session_website = coalesce(sqlc.narg(session_website), session_website),
+    dt_updated_utc = NOW() AT TIME ZONE 'utc'
 WHERE session_id = $1;
 
 -- name: InsertSessionComment :one
 INSERT INTO london_jam_sessions.comments (
-    session, author, content
+    session, author, content, parent_comment, verified, status, spam_score, edit_token_hash, user_id
 ) VALUES (
-    $1, $2, $3
+    $1, $2, $3, $4, coalesce(sqlc.narg(verified)::bool, FALSE), coalesce(sqlc.narg(status)::text, 'visible'), sqlc.narg(spam_score), sqlc.narg(edit_token_hash),
+    (SELECT u.user_id FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.narg(user_email)::text))
 ) RETURNING comment_id;
 
+-- name: UpdateCommentById :execrows
+-- edit of the author (the previous version is kept by the track_comment_edits trigger)
+UPDATE london_jam_sessions.comments
+SET author = coalesce(sqlc.narg(author), author),
+    content = coalesce(sqlc.narg(content), content),
+    status = coalesce(sqlc.narg(status), status),
+    spam_score = coalesce(sqlc.narg(spam_score), spam_score)
+WHERE comment_id = sqlc.arg(comment_id);
+
+-- name: DeleteCommentById :execrows
+DELETE FROM london_jam_sessions.comments
+WHERE comment_id = $1;
+
+-- name: DeleteRatingsByCommentId :execrows
+DELETE FROM london_jam_sessions.ratings
+WHERE comment = $1;
+
+-- name: GetCommentEdits :many
+-- previous versions of a comment, latest first
+SELECT * FROM london_jam_sessions.comment_edits
+WHERE comment = $1
+ORDER BY dt_edited DESC, edit_id DESC;
+
 -- name: InsertSessionRating :one
 INSERT INTO london_jam_sessions.ratings (
-    session, rating, comment
+    session, rating, comment, house_band, backline, beginner_friendliness, sound, crowd, user_id
 ) VALUES (
-    $1, $2, $3
+    $1, $2, $3, $4, $5, $6, $7, $8,
+    (SELECT u.user_id FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.narg(user_email)::text))
 ) RETURNING rating_id;
 
 -- name: DeleteJamSessionById :exec
@@ -309,4 +352,625 @@ WHERE venue_id = $1;
 -- name: DeleteVenueByJamSessionId :exec
 DELETE FROM london_jam_sessions.venues l
 USING london_jam_sessions.jamsessions s
-WHERE s.venue = l.venue_id AND s.session_id = $1;
\ No newline at end of file
+WHERE s.venue = l.venue_id AND s.session_id = $1;
+
+-- name: ReserveRateLimitSlot :one
+-- reserves the next free slot of the named rate limit and returns the number of seconds until the slot starts
+INSERT INTO london_jam_sessions.rate_limits AS r (
+    name, next_slot
+) VALUES (
+    sqlc.arg(name), clock_timestamp() + sqlc.arg(spacing)::interval
+)
+ON CONFLICT (name) DO UPDATE
+SET next_slot = greatest(r.next_slot, clock_timestamp()) + sqlc.arg(spacing)::interval
+RETURNING extract(epoch FROM r.next_slot - sqlc.arg(spacing)::interval - clock_timestamp())::float8 AS wait_seconds;
+
+-- name: RepointCommentsOfDuplicateSessions :execrows
+-- sessions of the dropped venue that also exist at the kept venue (same time and interval) are merged into the existing session
+UPDATE london_jam_sessions.comments c
+SET session = k.session_id
+FROM london_jam_sessions.jamsessions dup
+JOIN london_jam_sessions.jamsessions k ON k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval
+WHERE dup.venue = sqlc.arg(drop_venue) AND k.venue = sqlc.arg(keep_venue) AND c.session = dup.session_id;
+
+-- name: RepointRatingsOfDuplicateSessions :execrows
+UPDATE london_jam_sessions.ratings r
+SET session = k.session_id
+FROM london_jam_sessions.jamsessions dup
+JOIN london_jam_sessions.jamsessions k ON k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval
+WHERE dup.venue = sqlc.arg(drop_venue) AND k.venue = sqlc.arg(keep_venue) AND r.session = dup.session_id;
+
+-- name: DeleteDuplicateSessions :execrows
+DELETE FROM london_jam_sessions.jamsessions dup
+USING london_jam_sessions.jamsessions k
+WHERE dup.venue = sqlc.arg(drop_venue) AND k.venue = sqlc.arg(keep_venue)
+AND k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval;
+
+-- name: MoveSessionsToVenue :execrows
+UPDATE london_jam_sessions.jamsessions
+SET venue = sqlc.arg(keep_venue), dt_updated_utc = NOW() AT TIME ZONE 'utc'
+WHERE venue = sqlc.arg(drop_venue);
+-- name: GetSessionsPageAsGeoJSON :one
+-- returns a page of sessions as a FeatureCollection, optionally filtered by date (or date range), genres and backline.
+-- rows are ordered by a sort key (see 'sort') and the session id, the keyset of the last row on the page
+-- is returned as next_* (null on the last page) and is used as the cursor for the next page.
+-- 'fields' trims the feature properties to the given keys, null properties are left out.
+-- the result is sent to the client as is (see types.RawJSON).
+WITH matches AS (
+    SELECT o.session_id, o.dates
+    FROM london_jam_sessions.session_dates(sqlc.narg(start_date)::date, coalesce(sqlc.narg(end_date)::date, sqlc.narg(start_date)::date)) AS o(session_id, dates)
+    WHERE sqlc.narg(start_date)::date IS NOT NULL
+), upcoming AS ( -- only evaluated when sorting by the next occurrence
+    SELECT u.session_id, min(x.d)::date AS next_date
+    FROM london_jam_sessions.session_dates(current_date, (current_date + 62)::date) AS u(session_id, dates)
+    CROSS JOIN unnest(u.dates) x(d)
+    WHERE sqlc.arg(sort)::text = 'next_occurrence'
+    GROUP BY u.session_id
+), t AS (
+    SELECT m.dates, s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings
+    FROM london_jam_sessions.jamsessions s
+    JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+    LEFT OUTER JOIN matches m ON m.session_id = s.session_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+    WHERE (sqlc.narg(start_date)::date IS NULL OR m.session_id IS NOT NULL)
+    AND (sqlc.narg(genres)::text[] IS NULL OR s.genres @> sqlc.narg(genres)::text[])
+    AND (sqlc.narg(backline)::text[] IS NULL OR l.backline @> sqlc.narg(backline)::text[])
+), keyed AS (
+    SELECT t.session_id AS id,
+        coalesce(CASE sqlc.arg(sort)::text
+            WHEN 'rating' THEN t.rating_score::float8
+            WHEN 'next_occurrence' THEN extract(epoch FROM u.next_date + (t.start_time_utc AT TIME ZONE 'UTC')::time)::float8
+            WHEN 'distance' THEN public.ST_Distance(t.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint(sqlc.narg(lon)::float8, sqlc.narg(lat)::float8), 4326)::public.geography)
+            WHEN 'dt_updated_utc' THEN extract(epoch FROM t.dt_updated_utc)::float8
+        END, CASE WHEN sqlc.arg(descending)::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END) AS sort_num, -- missing values go last
+        coalesce(CASE sqlc.arg(sort)::text WHEN 'name' THEN lower(t.session_name) END, '')::text AS sort_text
+    FROM t
+    LEFT OUTER JOIN upcoming u ON u.session_id = t.session_id
+), page AS (
+    SELECT k.*, row_number() OVER (ORDER BY
+        CASE WHEN sqlc.arg(descending)::bool THEN k.sort_num END DESC,
+        CASE WHEN sqlc.arg(descending)::bool THEN k.sort_text END DESC,
+        CASE WHEN sqlc.arg(descending)::bool THEN k.id END DESC,
+        k.sort_num, k.sort_text, k.id
+    ) AS n
+    FROM keyed k
+    WHERE sqlc.narg(cursor_id)::int IS NULL
+    OR (NOT sqlc.arg(descending)::bool AND (k.sort_num, k.sort_text, k.id) > (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_sort_text)::text, sqlc.narg(cursor_id)::int))
+    OR (sqlc.arg(descending)::bool AND (k.sort_num, k.sort_text, k.id) < (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_sort_text)::text, sqlc.narg(cursor_id)::int))
+), features AS (
+    SELECT p.n, CASE WHEN sqlc.narg(fields)::text[] IS NULL THEN f.feature
+        ELSE jsonb_set(f.feature, '{properties}', (
+            SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(f.feature->'properties') WHERE key = ANY(sqlc.narg(fields)::text[])
+        ))
+    END AS feature
+    FROM page p
+    JOIN t ON t.session_id = p.id
+    CROSS JOIN LATERAL (SELECT jsonb_strip_nulls(public.ST_AsGeoJSON(t.*)::jsonb) AS feature) f
+    WHERE sqlc.narg(page_limit)::int IS NULL OR p.n <= sqlc.narg(page_limit)::int
+)
+SELECT jsonb_build_object(
+    'type', 'FeatureCollection',
+    'features', (SELECT coalesce(jsonb_agg(feature ORDER BY n), '[]'::jsonb) FROM features)
+)::json AS feature_collection,
+    coalesce(last.sort_num, 0)::float8 AS next_sort_num, last.sort_text AS next_sort_text, last.id AS next_id
+FROM (SELECT 1) dummy
+LEFT OUTER JOIN page last ON last.n = sqlc.narg(page_limit)::int AND EXISTS (SELECT 1 FROM page WHERE n > sqlc.narg(page_limit)::int);
+
+-- name: GetVenuesPageAsGeoJSON :one
+-- returns a page of venues as a FeatureCollection, see GetSessionsPageAsGeoJSON
+WITH keyed AS (
+    SELECT v.venue_id AS id,
+        coalesce(CASE sqlc.arg(sort)::text
+            WHEN 'distance' THEN public.ST_Distance(v.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint(sqlc.narg(lon)::float8, sqlc.narg(lat)::float8), 4326)::public.geography)
+            WHEN 'dt_updated_utc' THEN extract(epoch FROM v.venue_dt_updated_utc)::float8
+        END, CASE WHEN sqlc.arg(descending)::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END) AS sort_num, -- missing values go last
+        coalesce(CASE sqlc.arg(sort)::text WHEN 'name' THEN lower(v.venue_name) END, '')::text AS sort_text
+    FROM london_jam_sessions.venues v
+), page AS (
+    SELECT k.*, row_number() OVER (ORDER BY
+        CASE WHEN sqlc.arg(descending)::bool THEN k.sort_num END DESC,
+        CASE WHEN sqlc.arg(descending)::bool THEN k.sort_text END DESC,
+        CASE WHEN sqlc.arg(descending)::bool THEN k.id END DESC,
+        k.sort_num, k.sort_text, k.id
+    ) AS n
+    FROM keyed k
+    WHERE sqlc.narg(cursor_id)::int IS NULL
+    OR (NOT sqlc.arg(descending)::bool AND (k.sort_num, k.sort_text, k.id) > (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_sort_text)::text, sqlc.narg(cursor_id)::int))
+    OR (sqlc.arg(descending)::bool AND (k.sort_num, k.sort_text, k.id) < (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_sort_text)::text, sqlc.narg(cursor_id)::int))
+), features AS (
+    SELECT p.n, CASE WHEN sqlc.narg(fields)::text[] IS NULL THEN f.feature
+        ELSE jsonb_set(f.feature, '{properties}', (
+            SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(f.feature->'properties') WHERE key = ANY(sqlc.narg(fields)::text[])
+        ))
+    END AS feature
+    FROM page p
+    JOIN london_jam_sessions.venues v ON v.venue_id = p.id
+    CROSS JOIN LATERAL (SELECT jsonb_strip_nulls(public.ST_AsGeoJSON(v.*)::jsonb) AS feature) f
+    WHERE sqlc.narg(page_limit)::int IS NULL OR p.n <= sqlc.narg(page_limit)::int
+)
+SELECT jsonb_build_object(
+    'type', 'FeatureCollection',
+    'features', (SELECT coalesce(jsonb_agg(feature ORDER BY n), '[]'::jsonb) FROM features)
+)::json AS feature_collection,
+    coalesce(last.sort_num, 0)::float8 AS next_sort_num, last.sort_text AS next_sort_text, last.id AS next_id
+FROM (SELECT 1) dummy
+LEFT OUTER JOIN page last ON last.n = sqlc.narg(page_limit)::int AND EXISTS (SELECT 1 FROM page WHERE n > sqlc.narg(page_limit)::int);
+
+-- name: GetCommentsPageBySessionId :many
+-- returns up to page_limit + 1 comments (the extra row signals that there is a next page), see GetSessionsPageAsGeoJSON.
+-- 'parent_comment' restricts the result to the replies to a comment, 'top_level' to comments that aren't replies.
+-- only visible comments are returned (see comments.status).
+WITH keyed AS (
+    SELECT c.*, r.rating, r.rating_id,
+        (SELECT count(*) FROM london_jam_sessions.comments rc WHERE rc.parent_comment = c.comment_id AND rc.status = 'visible')::int AS reply_count,
+        coalesce(CASE sqlc.arg(sort)::text
+            WHEN 'rating' THEN r.rating::float8
+            WHEN 'dt_posted' THEN extract(epoch FROM c.dt_posted)::float8
+        END, CASE WHEN sqlc.arg(descending)::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END)::float8 AS sort_num -- missing values go last
+    FROM london_jam_sessions.comments c
+    LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
+    WHERE c.session = sqlc.arg(session) AND c.status = 'visible'
+    AND (sqlc.narg(parent_comment)::int IS NULL OR c.parent_comment = sqlc.narg(parent_comment)::int)
+    AND (NOT sqlc.arg(top_level)::bool OR c.parent_comment IS NULL)
+)
+SELECT * FROM keyed k
+WHERE sqlc.narg(cursor_id)::int IS NULL
+OR (NOT sqlc.arg(descending)::bool AND (k.sort_num, k.comment_id) > (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_id)::int))
+OR (sqlc.arg(descending)::bool AND (k.sort_num, k.comment_id) < (sqlc.narg(cursor_sort_num)::float8, sqlc.narg(cursor_id)::int))
+ORDER BY
+    CASE WHEN sqlc.arg(descending)::bool THEN k.sort_num END DESC,
+    CASE WHEN sqlc.arg(descending)::bool THEN k.comment_id END DESC,
+    k.sort_num, k.comment_id
+LIMIT sqlc.narg(page_limit)::int + 1;
+
+-- name: GetCommentReplies :many
+-- returns all direct and indirect visible replies to the given comments, oldest first
+WITH RECURSIVE replies AS (
+    SELECT c.* FROM london_jam_sessions.comments c
+    WHERE c.parent_comment = ANY(sqlc.arg(comment_ids)::int[]) AND c.status = 'visible'
+    UNION ALL
+    SELECT c.* FROM london_jam_sessions.comments c
+    JOIN replies p ON c.parent_comment = p.comment_id
+    WHERE c.status = 'visible'
+)
+SELECT rp.*, r.rating, r.rating_id,
+    (SELECT count(*) FROM london_jam_sessions.comments rc WHERE rc.parent_comment = rp.comment_id AND rc.status = 'visible')::int AS reply_count
+FROM replies rp
+LEFT OUTER JOIN london_jam_sessions.ratings r ON rp.comment_id = r.comment
+ORDER BY rp.dt_posted, rp.comment_id;
+
+-- name: GetCommentById :one
+SELECT * FROM london_jam_sessions.comments
+WHERE comment_id = $1;
+
+-- name: GetCommentByEditTokenHash :one
+SELECT * FROM london_jam_sessions.comments
+WHERE edit_token_hash = $1;
+
+-- name: GetDataVersion :one
+-- latest modification time and row counts of all tables that are exposed by the API,
+-- the counts make sure deletions (which leave no timestamp behind) are picked up too
+SELECT
+    greatest(
+        (SELECT max(dt_updated_utc) FROM london_jam_sessions.jamsessions),
+        (SELECT max(venue_dt_updated_utc) FROM london_jam_sessions.venues),
+        (SELECT max(dt_posted) FROM london_jam_sessions.comments),
+        (SELECT max(dt_moderated) FROM london_jam_sessions.comments),
+        (SELECT max(dt_edited) FROM london_jam_sessions.comments),
+        (SELECT max(dt_posted) FROM london_jam_sessions.ratings)
+    )::timestamptz AS last_modified,
+    (SELECT count(*) FROM london_jam_sessions.jamsessions) AS session_count,
+    (SELECT count(*) FROM london_jam_sessions.venues) AS venue_count,
+    (SELECT count(*) FROM london_jam_sessions.comments) AS comment_count,
+    (SELECT count(*) FROM london_jam_sessions.ratings) AS rating_count;
+
+-- name: SetOccurrencesHorizon :exec
+-- moves the horizon of the materialised session occurrences, call RefreshSessionOccurrences afterwards
+INSERT INTO london_jam_sessions.session_occurrences_horizon (start_date, end_date)
+VALUES (sqlc.arg(start_date)::date, sqlc.arg(end_date)::date)
+ON CONFLICT (id) DO UPDATE SET start_date = EXCLUDED.start_date, end_date = EXCLUDED.end_date;
+
+-- name: RefreshSessionOccurrences :exec
+-- recomputes the occurrences of the given sessions (all sessions if null) within the horizon
+SELECT london_jam_sessions.refresh_session_occurrences(sqlc.narg(sessions)::int[]);
+
+-- name: GetOccurrencesHorizon :one
+SELECT h.start_date, h.end_date, h.dt_refreshed_utc, (SELECT count(*) FROM london_jam_sessions.session_occurrences) AS occurrences
+FROM london_jam_sessions.session_occurrences_horizon h;
+
+-- name: CountCommentsWithContent :one
+-- number of comments posted within the last 30 days with the same content (ignoring case and surrounding whitespace)
+SELECT count(*) FROM london_jam_sessions.comments
+WHERE lower(btrim(content)) = lower(btrim(sqlc.arg(content)::text))
+AND dt_posted > NOW() - interval '30 days';
+
+-- name: InsertCommentReport :one
+INSERT INTO london_jam_sessions.comment_reports (
+    comment, reason, details, reporter_hash
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING report_id;
+
+-- name: UpdateCommentStatus :execrows
+UPDATE london_jam_sessions.comments
+SET status = sqlc.arg(status), dt_moderated = NOW() AT TIME ZONE 'utc'
+WHERE comment_id = sqlc.arg(comment_id);
+
+-- name: ResolveCommentReports :execrows
+UPDATE london_jam_sessions.comment_reports
+SET dt_resolved = NOW() AT TIME ZONE 'utc'
+WHERE comment = $1 AND dt_resolved IS NULL;
+
+-- name: GetModeratedComments :many
+-- comments for the moderation queue: comments with the given status (if set) and/or open reports (if reported is true),
+-- ordered by the number of open reports and the spam score
+SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.dt_edited,
+    count(r.report_id)::int AS report_count,
+    coalesce(array_agg(DISTINCT r.reason) FILTER (WHERE r.report_id IS NOT NULL), '{}')::text[] AS report_reasons,
+    max(r.dt_reported)::timestamptz AS dt_last_reported
+FROM london_jam_sessions.comments c
+LEFT OUTER JOIN london_jam_sessions.comment_reports r ON r.comment = c.comment_id AND r.dt_resolved IS NULL
+WHERE sqlc.narg(status)::text IS NULL OR c.status = sqlc.narg(status)::text
+GROUP BY c.comment_id
+HAVING NOT sqlc.arg(reported)::bool OR count(r.report_id) > 0
+ORDER BY count(r.report_id) DESC, c.spam_score DESC NULLS LAST, c.comment_id
+LIMIT 500;
+
+-- name: GetUserByEmail :one
+SELECT * FROM london_jam_sessions.users
+WHERE email = lower(sqlc.arg(email)::text);
+
+-- name: InsertUser :one
+-- registers a user on their first login (a no-op if the account already exists)
+INSERT INTO london_jam_sessions.users (email) VALUES (lower(sqlc.arg(email)::text))
+ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+RETURNING user_id;
+
+-- name: InsertUserContribution :execrows
+-- attributes a session or venue to a user (nothing is recorded if there is no user with the email address)
+INSERT INTO london_jam_sessions.user_contributions (user_id, session, venue, action)
+SELECT u.user_id, sqlc.narg(session), sqlc.narg(venue), sqlc.arg(action)
+FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.arg(user_email)::text);
+
+-- name: GetUserContributions :many
+SELECT * FROM london_jam_sessions.user_contributions
+WHERE user_id = $1
+ORDER BY dt_contributed DESC, contribution_id DESC;
+
+-- name: GetUserPermissions :one
+-- role of the user and the venues they own (see venue_owners)
+SELECT u.role, COALESCE(array_agg(o.venue) FILTER (WHERE o.venue IS NOT NULL), '{}')::int[] AS owned_venues
+FROM london_jam_sessions.users u
+LEFT OUTER JOIN london_jam_sessions.venue_owners o ON o.user_id = u.user_id
+WHERE u.email = lower(sqlc.arg(email)::text)
+GROUP BY u.user_id;
+
+-- name: UpdateUserRole :execrows
+UPDATE london_jam_sessions.users SET role = sqlc.arg(role)
+WHERE email = lower(sqlc.arg(email)::text);
+
+-- name: InsertVenueOwner :execrows
+INSERT INTO london_jam_sessions.venue_owners (user_id, venue)
+SELECT u.user_id, sqlc.arg(venue) FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.arg(email)::text)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteVenueOwner :execrows
+DELETE FROM london_jam_sessions.venue_owners o
+USING london_jam_sessions.users u
+WHERE o.user_id = u.user_id AND u.email = lower(sqlc.arg(email)::text) AND o.venue = sqlc.arg(venue);
+
+-- name: GetSessionVenue :one
+SELECT venue FROM london_jam_sessions.jamsessions WHERE session_id = $1;
+
+-- name: GetUserById :one
+SELECT * FROM london_jam_sessions.users WHERE user_id = $1;
+
+-- name: InsertApiKey :one
+INSERT INTO london_jam_sessions.api_keys (name, key_prefix, key_hash, rate_limit)
+VALUES ($1, $2, $3, $4)
+RETURNING key_id;
+
+-- name: RevokeApiKey :execrows
+UPDATE london_jam_sessions.api_keys SET dt_revoked = NOW() AT TIME ZONE 'utc'
+WHERE key_id = $1 AND dt_revoked IS NULL;
+
+-- name: GetApiKeyByHash :one
+-- only returns keys that haven't been revoked
+SELECT * FROM london_jam_sessions.api_keys
+WHERE key_hash = $1 AND dt_revoked IS NULL;
+
+-- name: ListApiKeys :many
+SELECT k.key_id, k.name, k.key_prefix, k.rate_limit, k.dt_created, k.dt_revoked,
+    COALESCE(SUM(u.request_count), 0)::bigint AS request_count,
+    COALESCE(SUM(u.request_count) FILTER (WHERE u.day = CURRENT_DATE), 0)::bigint AS requests_today,
+    MAX(u.dt_last_used)::timestamptz AS dt_last_used
+FROM london_jam_sessions.api_keys k
+LEFT OUTER JOIN london_jam_sessions.api_key_usage u ON u.key_id = k.key_id
+GROUP BY k.key_id
+ORDER BY k.key_id;
+
+-- name: RecordApiKeyUsage :exec
+-- adds the requests made with a key (counted by the API server) to the usage of the day
+INSERT INTO london_jam_sessions.api_key_usage (key_id, day, request_count, dt_last_used)
+VALUES (sqlc.arg(key_id), sqlc.arg(last_used)::timestamptz::date, sqlc.arg(request_count), sqlc.arg(last_used))
+ON CONFLICT (key_id, day) DO UPDATE SET
+    request_count = london_jam_sessions.api_key_usage.request_count + EXCLUDED.request_count,
+    dt_last_used = GREATEST(london_jam_sessions.api_key_usage.dt_last_used, EXCLUDED.dt_last_used);
+
+-- name: InsertFavourite :execrows
+INSERT INTO london_jam_sessions.favourites (user_id, session)
+SELECT u.user_id, sqlc.arg(session) FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.arg(user_email)::text)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteFavourite :execrows
+DELETE FROM london_jam_sessions.favourites f
+USING london_jam_sessions.users u
+WHERE f.user_id = u.user_id AND u.email = lower(sqlc.arg(user_email)::text) AND f.session = sqlc.arg(session);
+
+-- name: GetFavouriteSessionsAsGeoJSON :one
+WITH t AS (
+    SELECT s.*, l.*, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.favourites f
+    JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+    JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+    JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+    WHERE u.email = lower(sqlc.arg(email)::text)
+    ORDER BY f.dt_added DESC
+)
+SELECT json_build_object(
+    'type', 'FeatureCollection',
+    'features', coalesce(json_agg(json_strip_nulls(public.ST_AsGeoJSON(t.*)::json)), '[]'::json)
+) FROM t;
+
+-- name: GetFavouriteOccurrences :many
+-- dates on which the favourite sessions of a user take place (same recurrence logic as the date filters)
+SELECT s.session_id, s.session_name, s.start_time_utc, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc,
+    l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, d.dates::date[] AS dates
+FROM london_jam_sessions.favourites f
+JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+JOIN london_jam_sessions.session_dates(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d ON d.session_id = s.session_id
+WHERE f.user_id = sqlc.arg(user_id)
+ORDER BY s.session_id;
+
+-- name: InsertFollow :execrows
+INSERT INTO london_jam_sessions.follows (user_id, session, venue)
+SELECT u.user_id, sqlc.narg(session), sqlc.narg(venue) FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.arg(user_email)::text)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteFollow :execrows
+DELETE FROM london_jam_sessions.follows f
+USING london_jam_sessions.users u
+WHERE f.user_id = u.user_id AND u.email = lower(sqlc.arg(user_email)::text)
+AND f.session IS NOT DISTINCT FROM sqlc.narg(session) AND f.venue IS NOT DISTINCT FROM sqlc.narg(venue);
+
+-- name: GetFollows :many
+SELECT f.follow_id, f.session, s.session_name, f.venue, l.venue_name, f.dt_added FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+LEFT JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+LEFT JOIN london_jam_sessions.venues l ON l.venue_id = f.venue
+WHERE u.email = lower(sqlc.arg(email)::text)
+ORDER BY f.dt_added DESC, f.follow_id DESC;
+
+-- name: GetSessionFollowers :many
+-- users that follow the session or its venue
+SELECT DISTINCT u.user_id, u.email FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+JOIN london_jam_sessions.jamsessions s ON s.session_id = sqlc.arg(session)
+WHERE f.session = s.session_id OR f.venue = s.venue;
+
+-- name: GetVenueFollowers :many
+-- users that follow the venue or one of its sessions
+SELECT DISTINCT u.user_id, u.email FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+LEFT JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+WHERE f.venue = sqlc.arg(venue)::int OR s.venue = sqlc.arg(venue)::int;
+
+-- name: InsertNotificationChannel :one
+INSERT INTO london_jam_sessions.notification_channels (user_id, kind, endpoint, p256dh, auth_secret)
+SELECT u.user_id, sqlc.arg(kind), sqlc.narg(endpoint), sqlc.narg(p256dh), sqlc.narg(auth_secret) FROM london_jam_sessions.users u
+WHERE u.email = lower(sqlc.arg(user_email)::text)
+ON CONFLICT (user_id, kind, endpoint) DO UPDATE SET p256dh = EXCLUDED.p256dh, auth_secret = EXCLUDED.auth_secret
+RETURNING channel_id;
+
+-- name: DeleteNotificationChannel :execrows
+DELETE FROM london_jam_sessions.notification_channels c
+USING london_jam_sessions.users u
+WHERE c.user_id = u.user_id AND u.email = lower(sqlc.arg(user_email)::text) AND c.channel_id = sqlc.arg(channel_id);
+
+-- name: DeleteNotificationChannelById :exec
+DELETE FROM london_jam_sessions.notification_channels
+WHERE channel_id = $1;
+
+-- name: GetNotificationChannelsByEmail :many
+SELECT c.channel_id, c.kind, c.endpoint, c.dt_created FROM london_jam_sessions.notification_channels c
+JOIN london_jam_sessions.users u ON u.user_id = c.user_id
+WHERE u.email = lower(sqlc.arg(email)::text)
+ORDER BY c.channel_id;
+
+-- name: GetNotificationChannelsByUserIds :many
+SELECT * FROM london_jam_sessions.notification_channels
+WHERE user_id = ANY(sqlc.arg(user_ids)::int[])
+ORDER BY user_id, channel_id;
+
+-- name: CopyVenueFollows :execrows
+-- followers of the dropped venue follow the kept venue (venue merge)
+INSERT INTO london_jam_sessions.follows (user_id, venue)
+SELECT f.user_id, sqlc.arg(keep_venue)::int FROM london_jam_sessions.follows f WHERE f.venue = sqlc.arg(drop_venue)::int
+ON CONFLICT DO NOTHING;
+
+-- name: InsertSavedSearch :one
+-- saving a search under an existing name replaces its filters. sessions that exist when the search is first saved
+-- are not new to its first digest.
+INSERT INTO london_jam_sessions.saved_searches (user_id, search_name, genres, backline, weekdays, lon, lat, radius_metres, last_session_id)
+SELECT u.user_id, sqlc.arg(search_name), sqlc.narg(genres)::text[], sqlc.narg(backline)::text[], sqlc.narg(weekdays)::smallint[],
+    sqlc.narg(lon)::float8, sqlc.narg(lat)::float8, sqlc.narg(radius_metres)::int,
+    (SELECT coalesce(max(session_id), 0) FROM london_jam_sessions.jamsessions)
+FROM london_jam_sessions.users u WHERE u.email = lower(sqlc.arg(user_email)::text)
+ON CONFLICT (user_id, search_name) DO UPDATE SET genres = EXCLUDED.genres, backline = EXCLUDED.backline, weekdays = EXCLUDED.weekdays,
+    lon = EXCLUDED.lon, lat = EXCLUDED.lat, radius_metres = EXCLUDED.radius_metres
+RETURNING search_id;
+
+-- name: DeleteSavedSearch :execrows
+DELETE FROM london_jam_sessions.saved_searches s
+USING london_jam_sessions.users u
+WHERE s.user_id = u.user_id AND u.email = lower(sqlc.arg(user_email)::text) AND s.search_id = sqlc.arg(search_id);
+
+-- name: GetSavedSearchesByEmail :many
+SELECT s.* FROM london_jam_sessions.saved_searches s
+JOIN london_jam_sessions.users u ON u.user_id = s.user_id
+WHERE u.email = lower(sqlc.arg(email)::text)
+ORDER BY s.search_id;
+
+-- name: GetDueSavedSearches :many
+-- saved searches whose last digest was sent before due_before (or that haven't had a digest yet)
+SELECT s.*, u.email FROM london_jam_sessions.saved_searches s
+JOIN london_jam_sessions.users u ON u.user_id = s.user_id
+WHERE s.dt_last_digest IS NULL OR s.dt_last_digest < sqlc.arg(due_before)
+ORDER BY s.search_id;
+
+-- name: GetSavedSearchMatches :many
+-- sessions matching the saved search with their dates between start_date and end_date (on the weekdays of the search).
+-- sessions added since the last digest are included (is_new) even if they don't take place in that period.
+SELECT m.* FROM (
+    SELECT j.session_id, j.session_name, j.start_time_utc, j.duration_minutes, j.interval, l.venue_id, l.venue_name,
+        coalesce(ARRAY(
+            SELECT x.d FROM unnest(d.dates) x(d)
+            WHERE s.weekdays IS NULL OR extract(isodow FROM x.d)::smallint = ANY(s.weekdays)
+            ORDER BY x.d
+        ), '{}')::date[] AS dates,
+        (j.session_id > s.last_session_id AND (s.weekdays IS NULL OR extract(isodow FROM j.start_time_utc AT TIME ZONE 'UTC')::smallint = ANY(s.weekdays)))::bool AS is_new
+    FROM london_jam_sessions.saved_searches s
+    JOIN london_jam_sessions.jamsessions j ON s.genres IS NULL OR j.genres @> s.genres
+    JOIN london_jam_sessions.venues l ON l.venue_id = j.venue
+    LEFT JOIN london_jam_sessions.session_dates(sqlc.arg(start_date)::date, sqlc.arg(end_date)::date) d(session_id, dates) ON d.session_id = j.session_id
+    WHERE s.search_id = sqlc.arg(search_id)
+    AND (s.backline IS NULL OR l.backline @> s.backline)
+    AND (s.radius_metres IS NULL OR public.ST_DWithin(l.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint(s.lon, s.lat), 4326)::public.geography, s.radius_metres))
+) m
+WHERE cardinality(m.dates) > 0 OR m.is_new
+ORDER BY m.dates[1] NULLS LAST, m.session_id;
+
+-- name: SetSavedSearchDigestSent :exec
+-- sessions that exist at this point are not new to the next digest
+UPDATE london_jam_sessions.saved_searches
+SET dt_last_digest = sqlc.arg(dt_last_digest), last_session_id = (SELECT coalesce(max(session_id), 0) FROM london_jam_sessions.jamsessions)
+WHERE search_id = sqlc.arg(search_id);
+
+-- name: InsertWebhookSubscription :one
+INSERT INTO london_jam_sessions.webhook_subscriptions (url, secret, event_types, description)
+VALUES (sqlc.arg(url), sqlc.arg(secret), sqlc.arg(event_types)::text[], sqlc.narg(description))
+RETURNING subscription_id;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM london_jam_sessions.webhook_subscriptions WHERE subscription_id = $1;
+
+-- name: GetWebhookSubscriptions :many
+-- the secrets are only returned when a subscription is created
+SELECT w.subscription_id, w.url, w.event_types, w.description, w.dt_created,
+    count(wd.delivery_id) FILTER (WHERE wd.status = 'pending')::bigint AS pending_deliveries,
+    count(wd.delivery_id) FILTER (WHERE wd.status = 'failed')::bigint AS failed_deliveries
+FROM london_jam_sessions.webhook_subscriptions w
+LEFT OUTER JOIN london_jam_sessions.webhook_deliveries wd ON wd.subscription_id = w.subscription_id
+GROUP BY w.subscription_id
+ORDER BY w.subscription_id;
+
+-- name: GetWebhookSubscriptionExists :one
+SELECT EXISTS (SELECT 1 FROM london_jam_sessions.webhook_subscriptions WHERE subscription_id = $1);
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- queues the event for every subscription to its type
+INSERT INTO london_jam_sessions.webhook_deliveries (subscription_id, event_id, event_type, payload)
+SELECT w.subscription_id, sqlc.arg(event_id), sqlc.arg(event_type), sqlc.arg(payload)::jsonb
+FROM london_jam_sessions.webhook_subscriptions w
+WHERE sqlc.arg(event_type)::text = ANY(w.event_types);
+
+-- name: ClaimWebhookDeliveries :many
+-- picks the due deliveries and postpones their next attempt to lease_until, so that concurrent
+-- deliverers (and a crashed one) don't send them twice before the result is recorded
+UPDATE london_jam_sessions.webhook_deliveries wd
+SET attempts = wd.attempts + 1, next_attempt_at = sqlc.arg(lease_until)
+FROM london_jam_sessions.webhook_subscriptions w
+WHERE w.subscription_id = wd.subscription_id AND wd.delivery_id IN (
+    SELECT p.delivery_id FROM london_jam_sessions.webhook_deliveries p
+    WHERE p.status = 'pending' AND p.next_attempt_at <= sqlc.arg(now)
+    ORDER BY p.next_attempt_at, p.delivery_id
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING wd.delivery_id, wd.event_id, wd.event_type, wd.payload, wd.attempts, w.url, w.secret;
+
+-- name: SetWebhookDeliveryResult :exec
+UPDATE london_jam_sessions.webhook_deliveries
+SET status = sqlc.arg(status), response_status = sqlc.narg(response_status), last_error = sqlc.narg(last_error),
+    next_attempt_at = sqlc.arg(next_attempt_at), dt_delivered = sqlc.narg(dt_delivered)
+WHERE delivery_id = sqlc.arg(delivery_id);
+
+-- name: GetWebhookDeliveries :many
+-- delivery log of a subscription, most recent first
+SELECT wd.delivery_id, wd.event_id, wd.event_type, wd.status, wd.attempts, wd.next_attempt_at, wd.response_status, wd.last_error, wd.dt_created, wd.dt_delivered
+FROM london_jam_sessions.webhook_deliveries wd
+WHERE wd.subscription_id = sqlc.arg(subscription_id)
+AND (sqlc.narg(status)::text IS NULL OR wd.status = sqlc.narg(status)::text)
+ORDER BY wd.delivery_id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: GetEventsAfter :many
+-- events are replayed in the order they were recorded
+SELECT event_id, event_type, data, dt_created
+FROM london_jam_sessions.events
+WHERE event_id > sqlc.arg(after_id)
+ORDER BY event_id
+LIMIT sqlc.arg(max_results);
+
+-- name: GetLatestEventId :one
+SELECT coalesce(max(event_id), 0)::bigint FROM london_jam_sessions.events;
+
+-- name: DeleteEventsBefore :execrows
+DELETE FROM london_jam_sessions.events WHERE dt_created < $1;
+
+-- GRAPHQL - the loaders of the GraphQL endpoint fetch the records of all objects on a level of the query at once
+
+-- name: ListVenues :many
+-- venues by ID (all venues if ids is null)
+SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode, venue_website, backline, venue_comments, venue_dt_updated_utc,
+    public.ST_X(geom)::float8 AS lon, public.ST_Y(geom)::float8 AS lat
+FROM london_jam_sessions.venues
+WHERE sqlc.narg(ids)::int[] IS NULL OR venue_id = ANY(sqlc.narg(ids)::int[])
+ORDER BY venue_id
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: ListSessions :many
+-- sessions by ID and/or venue (all sessions if both are null)
+SELECT * FROM london_jam_sessions.jamsessions
+WHERE (sqlc.narg(ids)::int[] IS NULL OR session_id = ANY(sqlc.narg(ids)::int[]))
+AND (sqlc.narg(venue_ids)::int[] IS NULL OR venue = ANY(sqlc.narg(venue_ids)::int[]))
+ORDER BY session_id
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: GetRatingStatsBySessionIds :many
+SELECT s.session_id, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings
+FROM london_jam_sessions.jamsessions s
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+WHERE s.session_id = ANY(sqlc.arg(session_ids)::int[]);
+
+-- name: GetUpcomingOccurrences :many
+-- the first max_per_session occurrences of the sessions from start_date on (within the horizon of the materialised occurrences)
+SELECT o.session, o.d FROM (
+    SELECT session, d, row_number() OVER (PARTITION BY session ORDER BY d) AS n
+    FROM london_jam_sessions.session_occurrences
+    WHERE session = ANY(sqlc.arg(session_ids)::int[]) AND d >= sqlc.arg(start_date)::date
+) o
+WHERE o.n <= sqlc.arg(max_per_session)::int
+ORDER BY o.session, o.d;
+
+-- name: GetLatestComments :many
+-- the latest max_per_session visible comments of the sessions
+SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.dt_edited, c.parent_comment, c.verified, c.rating FROM (
+    SELECT c.*, r.rating, row_number() OVER (PARTITION BY c.session ORDER BY c.dt_posted DESC, c.comment_id DESC) AS n
+    FROM london_jam_sessions.comments c
+    LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
+    WHERE c.session = ANY(sqlc.arg(session_ids)::int[]) AND c.status = 'visible'
+) c
+WHERE c.n <= sqlc.arg(max_per_session)::int
+ORDER BY c.session, c.dt_posted DESC, c.comment_id DESC;
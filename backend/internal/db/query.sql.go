@@ -12,6 +12,185 @@ import (
 	geom "github.com/twpayne/go-geom"
 )
 
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE london_jam_sessions.webhook_deliveries wd
+SET attempts = wd.attempts + 1, next_attempt_at = $1
+FROM london_jam_sessions.webhook_subscriptions w
+WHERE w.subscription_id = wd.subscription_id AND wd.delivery_id IN (
+    SELECT p.delivery_id FROM london_jam_sessions.webhook_deliveries p
+    WHERE p.status = 'pending' AND p.next_attempt_at <= $2
+    ORDER BY p.next_attempt_at, p.delivery_id
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING wd.delivery_id, wd.event_id, wd.event_type, wd.payload, wd.attempts, w.url, w.secret
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LeaseUntil pgtype.Timestamptz `json:"lease_until"`
+	Now        pgtype.Timestamptz `json:"now"`
+	BatchSize  int32              `json:"batch_size"`
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	DeliveryID int64  `json:"delivery_id"`
+	EventID    string `json:"event_id"`
+	EventType  string `json:"event_type"`
+	Payload    []byte `json:"payload"`
+	Attempts   int16  `json:"attempts"`
+	Url        string `json:"url"`
+	Secret     string `json:"secret"`
+}
+
+// picks the due deliveries and postpones their next attempt to lease_until, so that concurrent
+// deliverers (and a crashed one) don't send them twice before the result is recorded
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.LeaseUntil, arg.Now, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.DeliveryID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Attempts,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const copyVenueFollows = `-- name: CopyVenueFollows :execrows
+INSERT INTO london_jam_sessions.follows (user_id, venue)
+SELECT f.user_id, $1::int FROM london_jam_sessions.follows f WHERE f.venue = $2::int
+ON CONFLICT DO NOTHING
+`
+
+type CopyVenueFollowsParams struct {
+	KeepVenue int32 `json:"keep_venue"`
+	DropVenue int32 `json:"drop_venue"`
+}
+
+// followers of the dropped venue follow the kept venue (venue merge)
+func (q *Queries) CopyVenueFollows(ctx context.Context, arg CopyVenueFollowsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, copyVenueFollows, arg.KeepVenue, arg.DropVenue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countCommentsWithContent = `-- name: CountCommentsWithContent :one
+SELECT count(*) FROM london_jam_sessions.comments
+WHERE lower(btrim(content)) = lower(btrim($1::text))
+AND dt_posted > NOW() - interval '30 days'
+`
+
+// number of comments posted within the last 30 days with the same content (ignoring case and surrounding whitespace)
+func (q *Queries) CountCommentsWithContent(ctx context.Context, content string) (int64, error) {
+	row := q.db.QueryRow(ctx, countCommentsWithContent, content)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteCommentById = `-- name: DeleteCommentById :execrows
+DELETE FROM london_jam_sessions.comments
+WHERE comment_id = $1
+`
+
+func (q *Queries) DeleteCommentById(ctx context.Context, commentID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCommentById, commentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteDuplicateSessions = `-- name: DeleteDuplicateSessions :execrows
+DELETE FROM london_jam_sessions.jamsessions dup
+USING london_jam_sessions.jamsessions k
+WHERE dup.venue = $1 AND k.venue = $2
+AND k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval
+`
+
+type DeleteDuplicateSessionsParams struct {
+	DropVenue int32 `json:"drop_venue"`
+	KeepVenue int32 `json:"keep_venue"`
+}
+
+func (q *Queries) DeleteDuplicateSessions(ctx context.Context, arg DeleteDuplicateSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDuplicateSessions, arg.DropVenue, arg.KeepVenue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteEventsBefore = `-- name: DeleteEventsBefore :execrows
+DELETE FROM london_jam_sessions.events WHERE dt_created < $1
+`
+
+func (q *Queries) DeleteEventsBefore(ctx context.Context, dtCreated pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteEventsBefore, dtCreated)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteFavourite = `-- name: DeleteFavourite :execrows
+DELETE FROM london_jam_sessions.favourites f
+USING london_jam_sessions.users u
+WHERE f.user_id = u.user_id AND u.email = lower($1::text) AND f.session = $2
+`
+
+type DeleteFavouriteParams struct {
+	UserEmail string `json:"user_email"`
+	Session   int32  `json:"session"`
+}
+
+func (q *Queries) DeleteFavourite(ctx context.Context, arg DeleteFavouriteParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteFavourite, arg.UserEmail, arg.Session)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteFollow = `-- name: DeleteFollow :execrows
+DELETE FROM london_jam_sessions.follows f
+USING london_jam_sessions.users u
+WHERE f.user_id = u.user_id AND u.email = lower($1::text)
+AND f.session IS NOT DISTINCT FROM $2 AND f.venue IS NOT DISTINCT FROM $3
+`
+
+type DeleteFollowParams struct {
+	UserEmail string `json:"user_email"`
+	Session   *int32 `json:"session"`
+	Venue     *int32 `json:"venue"`
+}
+
+func (q *Queries) DeleteFollow(ctx context.Context, arg DeleteFollowParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteFollow, arg.UserEmail, arg.Session, arg.Venue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteJamSessionById = `-- name: DeleteJamSessionById :exec
 DELETE FROM london_jam_sessions.jamsessions
 WHERE session_id = $1
@@ -22,6 +201,67 @@ func (q *Queries) DeleteJamSessionById(ctx context.Context, sessionID int32) err
 	return err
 }
 
+const deleteNotificationChannel = `-- name: DeleteNotificationChannel :execrows
+DELETE FROM london_jam_sessions.notification_channels c
+USING london_jam_sessions.users u
+WHERE c.user_id = u.user_id AND u.email = lower($1::text) AND c.channel_id = $2
+`
+
+type DeleteNotificationChannelParams struct {
+	UserEmail string `json:"user_email"`
+	ChannelID int32  `json:"channel_id"`
+}
+
+func (q *Queries) DeleteNotificationChannel(ctx context.Context, arg DeleteNotificationChannelParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteNotificationChannel, arg.UserEmail, arg.ChannelID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteNotificationChannelById = `-- name: DeleteNotificationChannelById :exec
+DELETE FROM london_jam_sessions.notification_channels
+WHERE channel_id = $1
+`
+
+func (q *Queries) DeleteNotificationChannelById(ctx context.Context, channelID int32) error {
+	_, err := q.db.Exec(ctx, deleteNotificationChannelById, channelID)
+	return err
+}
+
+const deleteRatingsByCommentId = `-- name: DeleteRatingsByCommentId :execrows
+DELETE FROM london_jam_sessions.ratings
+WHERE comment = $1
+`
+
+func (q *Queries) DeleteRatingsByCommentId(ctx context.Context, comment *int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteRatingsByCommentId, comment)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSavedSearch = `-- name: DeleteSavedSearch :execrows
+DELETE FROM london_jam_sessions.saved_searches s
+USING london_jam_sessions.users u
+WHERE s.user_id = u.user_id AND u.email = lower($1::text) AND s.search_id = $2
+`
+
+type DeleteSavedSearchParams struct {
+	UserEmail string `json:"user_email"`
+	SearchID  int32  `json:"search_id"`
+}
+
+func (q *Queries) DeleteSavedSearch(ctx context.Context, arg DeleteSavedSearchParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSavedSearch, arg.UserEmail, arg.SearchID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteVenueById = `-- name: DeleteVenueById :exec
 DELETE FROM london_jam_sessions.venues
 WHERE venue_id = $1
@@ -43,11 +283,63 @@ func (q *Queries) DeleteVenueByJamSessionId(ctx context.Context, sessionID int32
 	return err
 }
 
+const deleteVenueOwner = `-- name: DeleteVenueOwner :execrows
+DELETE FROM london_jam_sessions.venue_owners o
+USING london_jam_sessions.users u
+WHERE o.user_id = u.user_id AND u.email = lower($1::text) AND o.venue = $2
+`
+
+type DeleteVenueOwnerParams struct {
+	Email string `json:"email"`
+	Venue int32  `json:"venue"`
+}
+
+func (q *Queries) DeleteVenueOwner(ctx context.Context, arg DeleteVenueOwnerParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteVenueOwner, arg.Email, arg.Venue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteWebhookSubscription = `-- name: DeleteWebhookSubscription :execrows
+DELETE FROM london_jam_sessions.webhook_subscriptions WHERE subscription_id = $1
+`
+
+func (q *Queries) DeleteWebhookSubscription(ctx context.Context, subscriptionID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhookSubscription, subscriptionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO london_jam_sessions.webhook_deliveries (subscription_id, event_id, event_type, payload)
+SELECT w.subscription_id, $1, $2, $3::jsonb
+FROM london_jam_sessions.webhook_subscriptions w
+WHERE $2::text = ANY(w.event_types)
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	Payload   []byte `json:"payload"`
+}
+
+// queues the event for every subscription to its type
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, enqueueWebhookDeliveries, arg.EventID, arg.EventType, arg.Payload)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getAllSessions = `-- name: GetAllSessions :many
-SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
 JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-GROUP BY s.session_id, l.venue_id
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 `
 
 type GetAllSessionsRow struct {
@@ -72,7 +364,11 @@ type GetAllSessionsRow struct {
 	Backline          []string           `json:"backline"`
 	VenueComments     []string           `json:"venue_comments"`
 	VenueDtUpdatedUtc pgtype.Timestamptz `json:"venue_dt_updated_utc"`
-	Rating            float32            `json:"rating"`
+	Rating            *float32           `json:"rating"`
+	RatingCount       *int32             `json:"rating_count"`
+	RatingHistogram   []int32            `json:"rating_histogram"`
+	RatingScore       *float32           `json:"rating_score"`
+	AspectRatings     []byte             `json:"aspect_ratings"`
 }
 
 func (q *Queries) GetAllSessions(ctx context.Context) ([]GetAllSessionsRow, error) {
@@ -107,6 +403,10 @@ func (q *Queries) GetAllSessions(ctx context.Context) ([]GetAllSessionsRow, erro
 			&i.VenueComments,
 			&i.VenueDtUpdatedUtc,
 			&i.Rating,
+			&i.RatingCount,
+			&i.RatingHistogram,
+			&i.RatingScore,
+			&i.AspectRatings,
 		); err != nil {
 			return nil, err
 		}
@@ -120,10 +420,9 @@ func (q *Queries) GetAllSessions(ctx context.Context) ([]GetAllSessionsRow, erro
 
 const getAllSessionsAsGeoJSON = `-- name: GetAllSessionsAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -138,6 +437,53 @@ func (q *Queries) GetAllSessionsAsGeoJSON(ctx context.Context) ([]byte, error) {
 	return json_build_object, err
 }
 
+const getAllVenueLocations = `-- name: GetAllVenueLocations :many
+SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode,
+    public.ST_X(geom)::float8 AS lon, public.ST_Y(geom)::float8 AS lat
+FROM london_jam_sessions.venues
+ORDER BY venue_id
+`
+
+type GetAllVenueLocationsRow struct {
+	VenueID           int32   `json:"venue_id"`
+	VenueName         string  `json:"venue_name"`
+	AddressFirstLine  string  `json:"address_first_line"`
+	AddressSecondLine *string `json:"address_second_line"`
+	City              string  `json:"city"`
+	Postcode          string  `json:"postcode"`
+	Lon               float64 `json:"lon"`
+	Lat               float64 `json:"lat"`
+}
+
+func (q *Queries) GetAllVenueLocations(ctx context.Context) ([]GetAllVenueLocationsRow, error) {
+	rows, err := q.db.Query(ctx, getAllVenueLocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAllVenueLocationsRow
+	for rows.Next() {
+		var i GetAllVenueLocationsRow
+		if err := rows.Scan(
+			&i.VenueID,
+			&i.VenueName,
+			&i.AddressFirstLine,
+			&i.AddressSecondLine,
+			&i.City,
+			&i.Postcode,
+			&i.Lon,
+			&i.Lat,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getAllVenuesAsGeoJSON = `-- name: GetAllVenuesAsGeoJSON :one
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -152,20 +498,207 @@ func (q *Queries) GetAllVenuesAsGeoJSON(ctx context.Context) ([]byte, error) {
 	return json_build_object, err
 }
 
+const getApiKeyByHash = `-- name: GetApiKeyByHash :one
+SELECT key_id, name, key_prefix, key_hash, rate_limit, dt_created, dt_revoked FROM london_jam_sessions.api_keys
+WHERE key_hash = $1 AND dt_revoked IS NULL
+`
+
+// only returns keys that haven't been revoked
+func (q *Queries) GetApiKeyByHash(ctx context.Context, keyHash string) (LondonJamSessionsApiKey, error) {
+	row := q.db.QueryRow(ctx, getApiKeyByHash, keyHash)
+	var i LondonJamSessionsApiKey
+	err := row.Scan(
+		&i.KeyID,
+		&i.Name,
+		&i.KeyPrefix,
+		&i.KeyHash,
+		&i.RateLimit,
+		&i.DtCreated,
+		&i.DtRevoked,
+	)
+	return i, err
+}
+
+const getCommentByEditTokenHash = `-- name: GetCommentByEditTokenHash :one
+SELECT comment_id, session, author, content, dt_posted, parent_comment, verified, status, spam_score, dt_moderated, edit_token_hash, user_id, dt_edited FROM london_jam_sessions.comments
+WHERE edit_token_hash = $1
+`
+
+func (q *Queries) GetCommentByEditTokenHash(ctx context.Context, editTokenHash *string) (LondonJamSessionsComment, error) {
+	row := q.db.QueryRow(ctx, getCommentByEditTokenHash, editTokenHash)
+	var i LondonJamSessionsComment
+	err := row.Scan(
+		&i.CommentID,
+		&i.Session,
+		&i.Author,
+		&i.Content,
+		&i.DtPosted,
+		&i.ParentComment,
+		&i.Verified,
+		&i.Status,
+		&i.SpamScore,
+		&i.DtModerated,
+		&i.EditTokenHash,
+		&i.UserID,
+		&i.DtEdited,
+	)
+	return i, err
+}
+
+const getCommentById = `-- name: GetCommentById :one
+SELECT comment_id, session, author, content, dt_posted, parent_comment, verified, status, spam_score, dt_moderated, edit_token_hash, user_id, dt_edited FROM london_jam_sessions.comments
+WHERE comment_id = $1
+`
+
+func (q *Queries) GetCommentById(ctx context.Context, commentID int32) (LondonJamSessionsComment, error) {
+	row := q.db.QueryRow(ctx, getCommentById, commentID)
+	var i LondonJamSessionsComment
+	err := row.Scan(
+		&i.CommentID,
+		&i.Session,
+		&i.Author,
+		&i.Content,
+		&i.DtPosted,
+		&i.ParentComment,
+		&i.Verified,
+		&i.Status,
+		&i.SpamScore,
+		&i.DtModerated,
+		&i.EditTokenHash,
+		&i.UserID,
+		&i.DtEdited,
+	)
+	return i, err
+}
+
+const getCommentEdits = `-- name: GetCommentEdits :many
+SELECT edit_id, comment, author, content, dt_edited FROM london_jam_sessions.comment_edits
+WHERE comment = $1
+ORDER BY dt_edited DESC, edit_id DESC
+`
+
+// previous versions of a comment, latest first
+func (q *Queries) GetCommentEdits(ctx context.Context, comment int32) ([]LondonJamSessionsCommentEdit, error) {
+	rows, err := q.db.Query(ctx, getCommentEdits, comment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsCommentEdit
+	for rows.Next() {
+		var i LondonJamSessionsCommentEdit
+		if err := rows.Scan(
+			&i.EditID,
+			&i.Comment,
+			&i.Author,
+			&i.Content,
+			&i.DtEdited,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCommentReplies = `-- name: GetCommentReplies :many
+WITH RECURSIVE replies AS (
+    SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.edit_token_hash, c.user_id, c.dt_edited FROM london_jam_sessions.comments c
+    WHERE c.parent_comment = ANY($1::int[]) AND c.status = 'visible'
+    UNION ALL
+    SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.edit_token_hash, c.user_id, c.dt_edited FROM london_jam_sessions.comments c
+    JOIN replies p ON c.parent_comment = p.comment_id
+    WHERE c.status = 'visible'
+)
+SELECT rp.comment_id, rp.session, rp.author, rp.content, rp.dt_posted, rp.parent_comment, rp.verified, rp.status, rp.spam_score, rp.dt_moderated, rp.edit_token_hash, rp.user_id, rp.dt_edited, r.rating, r.rating_id,
+    (SELECT count(*) FROM london_jam_sessions.comments rc WHERE rc.parent_comment = rp.comment_id AND rc.status = 'visible')::int AS reply_count
+FROM replies rp
+LEFT OUTER JOIN london_jam_sessions.ratings r ON rp.comment_id = r.comment
+ORDER BY rp.dt_posted, rp.comment_id
+`
+
+type GetCommentRepliesRow struct {
+	CommentID     int32              `json:"comment_id"`
+	Session       int32              `json:"session"`
+	Author        string             `json:"author"`
+	Content       string             `json:"content"`
+	DtPosted      pgtype.Timestamptz `json:"dt_posted"`
+	ParentComment *int32             `json:"parent_comment"`
+	Verified      bool               `json:"verified"`
+	Status        string             `json:"status"`
+	SpamScore     *float32           `json:"spam_score"`
+	DtModerated   pgtype.Timestamptz `json:"dt_moderated"`
+	EditTokenHash *string            `json:"edit_token_hash"`
+	UserID        *int32             `json:"user_id"`
+	DtEdited      pgtype.Timestamptz `json:"dt_edited"`
+	Rating        *int16             `json:"rating"`
+	RatingID      *int32             `json:"rating_id"`
+	ReplyCount    int32              `json:"reply_count"`
+}
+
+// returns all direct and indirect visible replies to the given comments, oldest first
+func (q *Queries) GetCommentReplies(ctx context.Context, commentIds []int32) ([]GetCommentRepliesRow, error) {
+	rows, err := q.db.Query(ctx, getCommentReplies, commentIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCommentRepliesRow
+	for rows.Next() {
+		var i GetCommentRepliesRow
+		if err := rows.Scan(
+			&i.CommentID,
+			&i.Session,
+			&i.Author,
+			&i.Content,
+			&i.DtPosted,
+			&i.ParentComment,
+			&i.Verified,
+			&i.Status,
+			&i.SpamScore,
+			&i.DtModerated,
+			&i.EditTokenHash,
+			&i.UserID,
+			&i.DtEdited,
+			&i.Rating,
+			&i.RatingID,
+			&i.ReplyCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getCommentsBySessionId = `-- name: GetCommentsBySessionId :many
-SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, r.rating, r.rating_id FROM london_jam_sessions.comments c
+SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.edit_token_hash, c.user_id, c.dt_edited, r.rating, r.rating_id FROM london_jam_sessions.comments c
 LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
-WHERE c.session = $1
+WHERE c.session = $1 AND c.status = 'visible'
 `
 
 type GetCommentsBySessionIdRow struct {
-	CommentID int32              `json:"comment_id"`
-	Session   int32              `json:"session"`
-	Author    string             `json:"author"`
-	Content   string             `json:"content"`
-	DtPosted  pgtype.Timestamptz `json:"dt_posted"`
-	Rating    *int16             `json:"rating"`
-	RatingID  *int32             `json:"rating_id"`
+	CommentID     int32              `json:"comment_id"`
+	Session       int32              `json:"session"`
+	Author        string             `json:"author"`
+	Content       string             `json:"content"`
+	DtPosted      pgtype.Timestamptz `json:"dt_posted"`
+	ParentComment *int32             `json:"parent_comment"`
+	Verified      bool               `json:"verified"`
+	Status        string             `json:"status"`
+	SpamScore     *float32           `json:"spam_score"`
+	DtModerated   pgtype.Timestamptz `json:"dt_moderated"`
+	EditTokenHash *string            `json:"edit_token_hash"`
+	UserID        *int32             `json:"user_id"`
+	DtEdited      pgtype.Timestamptz `json:"dt_edited"`
+	Rating        *int16             `json:"rating"`
+	RatingID      *int32             `json:"rating_id"`
 }
 
 func (q *Queries) GetCommentsBySessionId(ctx context.Context, session int32) ([]GetCommentsBySessionIdRow, error) {
@@ -183,6 +716,14 @@ func (q *Queries) GetCommentsBySessionId(ctx context.Context, session int32) ([]
 			&i.Author,
 			&i.Content,
 			&i.DtPosted,
+			&i.ParentComment,
+			&i.Verified,
+			&i.Status,
+			&i.SpamScore,
+			&i.DtModerated,
+			&i.EditTokenHash,
+			&i.UserID,
+			&i.DtEdited,
 			&i.Rating,
 			&i.RatingID,
 		); err != nil {
@@ -196,26 +737,101 @@ func (q *Queries) GetCommentsBySessionId(ctx context.Context, session int32) ([]
 	return items, nil
 }
 
-const getRatingsBySessionId = `-- name: GetRatingsBySessionId :many
-SELECT rating_id, session, comment, rating, dt_posted FROM london_jam_sessions.ratings
-WHERE session = $1
+const getCommentsPageBySessionId = `-- name: GetCommentsPageBySessionId :many
+WITH keyed AS (
+    SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.edit_token_hash, c.user_id, c.dt_edited, r.rating, r.rating_id,
+        (SELECT count(*) FROM london_jam_sessions.comments rc WHERE rc.parent_comment = c.comment_id AND rc.status = 'visible')::int AS reply_count,
+        coalesce(CASE $5::text
+            WHEN 'rating' THEN r.rating::float8
+            WHEN 'dt_posted' THEN extract(epoch FROM c.dt_posted)::float8
+        END, CASE WHEN $2::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END)::float8 AS sort_num -- missing values go last
+    FROM london_jam_sessions.comments c
+    LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
+    WHERE c.session = $6 AND c.status = 'visible'
+    AND ($7::int IS NULL OR c.parent_comment = $7::int)
+    AND (NOT $8::bool OR c.parent_comment IS NULL)
+)
+SELECT comment_id, session, author, content, dt_posted, parent_comment, verified, status, spam_score, dt_moderated, edit_token_hash, user_id, dt_edited, rating, rating_id, reply_count, sort_num FROM keyed k
+WHERE $1::int IS NULL
+OR (NOT $2::bool AND (k.sort_num, k.comment_id) > ($3::float8, $1::int))
+OR ($2::bool AND (k.sort_num, k.comment_id) < ($3::float8, $1::int))
+ORDER BY
+    CASE WHEN $2::bool THEN k.sort_num END DESC,
+    CASE WHEN $2::bool THEN k.comment_id END DESC,
+    k.sort_num, k.comment_id
+LIMIT $4::int + 1
 `
 
-func (q *Queries) GetRatingsBySessionId(ctx context.Context, session int32) ([]LondonJamSessionsRating, error) {
-	rows, err := q.db.Query(ctx, getRatingsBySessionId, session)
+type GetCommentsPageBySessionIdParams struct {
+	CursorID      *int32   `json:"cursor_id"`
+	Descending    bool     `json:"descending"`
+	CursorSortNum *float64 `json:"cursor_sort_num"`
+	PageLimit     *int32   `json:"page_limit"`
+	Sort          string   `json:"sort"`
+	Session       int32    `json:"session"`
+	ParentComment *int32   `json:"parent_comment"`
+	TopLevel      bool     `json:"top_level"`
+}
+
+type GetCommentsPageBySessionIdRow struct {
+	CommentID     int32              `json:"comment_id"`
+	Session       int32              `json:"session"`
+	Author        string             `json:"author"`
+	Content       string             `json:"content"`
+	DtPosted      pgtype.Timestamptz `json:"dt_posted"`
+	ParentComment *int32             `json:"parent_comment"`
+	Verified      bool               `json:"verified"`
+	Status        string             `json:"status"`
+	SpamScore     *float32           `json:"spam_score"`
+	DtModerated   pgtype.Timestamptz `json:"dt_moderated"`
+	EditTokenHash *string            `json:"edit_token_hash"`
+	UserID        *int32             `json:"user_id"`
+	DtEdited      pgtype.Timestamptz `json:"dt_edited"`
+	Rating        *int16             `json:"rating"`
+	RatingID      *int32             `json:"rating_id"`
+	ReplyCount    int32              `json:"reply_count"`
+	SortNum       float64            `json:"sort_num"`
+}
+
+// returns up to page_limit + 1 comments (the extra row signals that there is a next page), see GetSessionsPageAsGeoJSON.
+// 'parent_comment' restricts the result to the replies to a comment, 'top_level' to comments that aren't replies.
+// only visible comments are returned (see comments.status).
+func (q *Queries) GetCommentsPageBySessionId(ctx context.Context, arg GetCommentsPageBySessionIdParams) ([]GetCommentsPageBySessionIdRow, error) {
+	rows, err := q.db.Query(ctx, getCommentsPageBySessionId,
+		arg.CursorID,
+		arg.Descending,
+		arg.CursorSortNum,
+		arg.PageLimit,
+		arg.Sort,
+		arg.Session,
+		arg.ParentComment,
+		arg.TopLevel,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []LondonJamSessionsRating
+	var items []GetCommentsPageBySessionIdRow
 	for rows.Next() {
-		var i LondonJamSessionsRating
+		var i GetCommentsPageBySessionIdRow
 		if err := rows.Scan(
-			&i.RatingID,
+			&i.CommentID,
 			&i.Session,
-			&i.Comment,
-			&i.Rating,
+			&i.Author,
+			&i.Content,
 			&i.DtPosted,
+			&i.ParentComment,
+			&i.Verified,
+			&i.Status,
+			&i.SpamScore,
+			&i.DtModerated,
+			&i.EditTokenHash,
+			&i.UserID,
+			&i.DtEdited,
+			&i.Rating,
+			&i.RatingID,
+			&i.ReplyCount,
+			&i.SortNum,
 		); err != nil {
 			return nil, err
 		}
@@ -227,27 +843,838 @@ func (q *Queries) GetRatingsBySessionId(ctx context.Context, session int32) ([]L
 	return items, nil
 }
 
-const getSessionById = `-- name: GetSessionById :one
-SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-WHERE s.session_id = $1
-GROUP BY s.session_id, l.venue_id
+const getConflictingSessions = `-- name: GetConflictingSessions :many
+SELECT session_id, session_name, start_time_utc, interval FROM london_jam_sessions.jamsessions
+WHERE venue = $1
+AND interval = $2
+AND (start_time_utc AT TIME ZONE 'UTC')::time = ($3::timestamptz AT TIME ZONE 'UTC')::time
+AND CASE interval
+    WHEN 'Daily' THEN true
+    WHEN 'Once' THEN (start_time_utc AT TIME ZONE 'UTC')::date = ($3::timestamptz AT TIME ZONE 'UTC')::date
+    WHEN 'Fortnightly' THEN ((start_time_utc AT TIME ZONE 'UTC')::date - ($3::timestamptz AT TIME ZONE 'UTC')::date) % 14 = 0
+    ELSE EXTRACT(isodow FROM start_time_utc AT TIME ZONE 'UTC') = EXTRACT(isodow FROM $3::timestamptz AT TIME ZONE 'UTC')
+END
+ORDER BY session_id
 `
 
-type GetSessionByIdRow struct {
-	SessionID         int32              `json:"session_id"`
-	SessionName       string             `json:"session_name"`
-	Venue             int32              `json:"venue"`
-	Genres            []string           `json:"genres"`
-	StartTimeUtc      pgtype.Timestamptz `json:"start_time_utc"`
-	Interval          string             `json:"interval"`
-	DurationMinutes   int16              `json:"duration_minutes"`
-	Description       string             `json:"description"`
-	SessionWebsite    *string            `json:"session_website"`
-	DtUpdatedUtc      pgtype.Timestamptz `json:"dt_updated_utc"`
-	VenueID           int32              `json:"venue_id"`
-	VenueName         string             `json:"venue_name"`
+type GetConflictingSessionsParams struct {
+	Venue        int32              `json:"venue"`
+	Interval     string             `json:"interval"`
+	StartTimeUtc pgtype.Timestamptz `json:"start_time_utc"`
+}
+
+type GetConflictingSessionsRow struct {
+	SessionID    int32              `json:"session_id"`
+	SessionName  string             `json:"session_name"`
+	StartTimeUtc pgtype.Timestamptz `json:"start_time_utc"`
+	Interval     string             `json:"interval"`
+}
+
+// sessions at the same venue with the same recurrence that take place on the same day(s) at the same time
+func (q *Queries) GetConflictingSessions(ctx context.Context, arg GetConflictingSessionsParams) ([]GetConflictingSessionsRow, error) {
+	rows, err := q.db.Query(ctx, getConflictingSessions, arg.Venue, arg.Interval, arg.StartTimeUtc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetConflictingSessionsRow
+	for rows.Next() {
+		var i GetConflictingSessionsRow
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.SessionName,
+			&i.StartTimeUtc,
+			&i.Interval,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDataVersion = `-- name: GetDataVersion :one
+SELECT
+    greatest(
+        (SELECT max(dt_updated_utc) FROM london_jam_sessions.jamsessions),
+        (SELECT max(venue_dt_updated_utc) FROM london_jam_sessions.venues),
+        (SELECT max(dt_posted) FROM london_jam_sessions.comments),
+        (SELECT max(dt_moderated) FROM london_jam_sessions.comments),
+        (SELECT max(dt_edited) FROM london_jam_sessions.comments),
+        (SELECT max(dt_posted) FROM london_jam_sessions.ratings)
+    )::timestamptz AS last_modified,
+    (SELECT count(*) FROM london_jam_sessions.jamsessions) AS session_count,
+    (SELECT count(*) FROM london_jam_sessions.venues) AS venue_count,
+    (SELECT count(*) FROM london_jam_sessions.comments) AS comment_count,
+    (SELECT count(*) FROM london_jam_sessions.ratings) AS rating_count
+`
+
+type GetDataVersionRow struct {
+	LastModified pgtype.Timestamptz `json:"last_modified"`
+	SessionCount int64              `json:"session_count"`
+	VenueCount   int64              `json:"venue_count"`
+	CommentCount int64              `json:"comment_count"`
+	RatingCount  int64              `json:"rating_count"`
+}
+
+// latest modification time and row counts of all tables that are exposed by the API,
+// the counts make sure deletions (which leave no timestamp behind) are picked up too
+func (q *Queries) GetDataVersion(ctx context.Context) (GetDataVersionRow, error) {
+	row := q.db.QueryRow(ctx, getDataVersion)
+	var i GetDataVersionRow
+	err := row.Scan(
+		&i.LastModified,
+		&i.SessionCount,
+		&i.VenueCount,
+		&i.CommentCount,
+		&i.RatingCount,
+	)
+	return i, err
+}
+
+const getDueSavedSearches = `-- name: GetDueSavedSearches :many
+SELECT s.search_id, s.user_id, s.search_name, s.genres, s.backline, s.weekdays, s.lon, s.lat, s.radius_metres, s.last_session_id, s.dt_created, s.dt_last_digest, u.email FROM london_jam_sessions.saved_searches s
+JOIN london_jam_sessions.users u ON u.user_id = s.user_id
+WHERE s.dt_last_digest IS NULL OR s.dt_last_digest < $1
+ORDER BY s.search_id
+`
+
+type GetDueSavedSearchesRow struct {
+	SearchID      int32              `json:"search_id"`
+	UserID        int32              `json:"user_id"`
+	SearchName    string             `json:"search_name"`
+	Genres        []string           `json:"genres"`
+	Backline      []string           `json:"backline"`
+	Weekdays      []int16            `json:"weekdays"`
+	Lon           *float64           `json:"lon"`
+	Lat           *float64           `json:"lat"`
+	RadiusMetres  *int32             `json:"radius_metres"`
+	LastSessionID int32              `json:"last_session_id"`
+	DtCreated     pgtype.Timestamptz `json:"dt_created"`
+	DtLastDigest  pgtype.Timestamptz `json:"dt_last_digest"`
+	Email         string             `json:"email"`
+}
+
+// saved searches whose last digest was sent before due_before (or that haven't had a digest yet)
+func (q *Queries) GetDueSavedSearches(ctx context.Context, dueBefore pgtype.Timestamptz) ([]GetDueSavedSearchesRow, error) {
+	rows, err := q.db.Query(ctx, getDueSavedSearches, dueBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDueSavedSearchesRow
+	for rows.Next() {
+		var i GetDueSavedSearchesRow
+		if err := rows.Scan(
+			&i.SearchID,
+			&i.UserID,
+			&i.SearchName,
+			&i.Genres,
+			&i.Backline,
+			&i.Weekdays,
+			&i.Lon,
+			&i.Lat,
+			&i.RadiusMetres,
+			&i.LastSessionID,
+			&i.DtCreated,
+			&i.DtLastDigest,
+			&i.Email,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDuplicateVenues = `-- name: GetDuplicateVenues :many
+SELECT a.venue_id, a.venue_name, b.venue_id AS duplicate_venue_id, b.venue_name AS duplicate_venue_name,
+    public.similarity(a.venue_name, b.venue_name)::real AS name_similarity,
+    london_jam_sessions.normalise_address(a.address_first_line, a.postcode) = london_jam_sessions.normalise_address(b.address_first_line, b.postcode) AS same_address,
+    public.ST_Distance(a.geom::public.geography, b.geom::public.geography)::float8 AS distance_metres
+FROM london_jam_sessions.venues a
+JOIN london_jam_sessions.venues b ON a.venue_id < b.venue_id
+WHERE public.similarity(a.venue_name, b.venue_name) >= $1::real
+OR london_jam_sessions.normalise_address(a.address_first_line, a.postcode) = london_jam_sessions.normalise_address(b.address_first_line, b.postcode)
+OR public.ST_DWithin(a.geom::public.geography, b.geom::public.geography, $2::float8)
+ORDER BY name_similarity DESC, distance_metres
+`
+
+type GetDuplicateVenuesParams struct {
+	MinNameSimilarity float32 `json:"min_name_similarity"`
+	MaxDistanceMetres float64 `json:"max_distance_metres"`
+}
+
+type GetDuplicateVenuesRow struct {
+	VenueID            int32   `json:"venue_id"`
+	VenueName          string  `json:"venue_name"`
+	DuplicateVenueID   int32   `json:"duplicate_venue_id"`
+	DuplicateVenueName string  `json:"duplicate_venue_name"`
+	NameSimilarity     float32 `json:"name_similarity"`
+	SameAddress        bool    `json:"same_address"`
+	DistanceMetres     float64 `json:"distance_metres"`
+}
+
+func (q *Queries) GetDuplicateVenues(ctx context.Context, arg GetDuplicateVenuesParams) ([]GetDuplicateVenuesRow, error) {
+	rows, err := q.db.Query(ctx, getDuplicateVenues, arg.MinNameSimilarity, arg.MaxDistanceMetres)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDuplicateVenuesRow
+	for rows.Next() {
+		var i GetDuplicateVenuesRow
+		if err := rows.Scan(
+			&i.VenueID,
+			&i.VenueName,
+			&i.DuplicateVenueID,
+			&i.DuplicateVenueName,
+			&i.NameSimilarity,
+			&i.SameAddress,
+			&i.DistanceMetres,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEventsAfter = `-- name: GetEventsAfter :many
+SELECT event_id, event_type, data, dt_created
+FROM london_jam_sessions.events
+WHERE event_id > $1
+ORDER BY event_id
+LIMIT $2
+`
+
+type GetEventsAfterParams struct {
+	AfterID    int64 `json:"after_id"`
+	MaxResults int32 `json:"max_results"`
+}
+
+// events are replayed in the order they were recorded
+func (q *Queries) GetEventsAfter(ctx context.Context, arg GetEventsAfterParams) ([]LondonJamSessionsEvent, error) {
+	rows, err := q.db.Query(ctx, getEventsAfter, arg.AfterID, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsEvent
+	for rows.Next() {
+		var i LondonJamSessionsEvent
+		if err := rows.Scan(
+			&i.EventID,
+			&i.EventType,
+			&i.Data,
+			&i.DtCreated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFavouriteOccurrences = `-- name: GetFavouriteOccurrences :many
+SELECT s.session_id, s.session_name, s.start_time_utc, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc,
+    l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, d.dates::date[] AS dates
+FROM london_jam_sessions.favourites f
+JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+JOIN london_jam_sessions.session_dates($1::date, $2::date) d ON d.session_id = s.session_id
+WHERE f.user_id = $3
+ORDER BY s.session_id
+`
+
+type GetFavouriteOccurrencesParams struct {
+	StartDate pgtype.Date `json:"start_date"`
+	EndDate   pgtype.Date `json:"end_date"`
+	UserID    int32       `json:"user_id"`
+}
+
+type GetFavouriteOccurrencesRow struct {
+	SessionID         int32              `json:"session_id"`
+	SessionName       string             `json:"session_name"`
+	StartTimeUtc      pgtype.Timestamptz `json:"start_time_utc"`
+	DurationMinutes   int16              `json:"duration_minutes"`
+	Description       string             `json:"description"`
+	SessionWebsite    *string            `json:"session_website"`
+	DtUpdatedUtc      pgtype.Timestamptz `json:"dt_updated_utc"`
+	VenueName         string             `json:"venue_name"`
+	AddressFirstLine  string             `json:"address_first_line"`
+	AddressSecondLine *string            `json:"address_second_line"`
+	City              string             `json:"city"`
+	Postcode          string             `json:"postcode"`
+	Dates             []pgtype.Date      `json:"dates"`
+}
+
+// dates on which the favourite sessions of a user take place (same recurrence logic as the date filters)
+func (q *Queries) GetFavouriteOccurrences(ctx context.Context, arg GetFavouriteOccurrencesParams) ([]GetFavouriteOccurrencesRow, error) {
+	rows, err := q.db.Query(ctx, getFavouriteOccurrences, arg.StartDate, arg.EndDate, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFavouriteOccurrencesRow
+	for rows.Next() {
+		var i GetFavouriteOccurrencesRow
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.SessionName,
+			&i.StartTimeUtc,
+			&i.DurationMinutes,
+			&i.Description,
+			&i.SessionWebsite,
+			&i.DtUpdatedUtc,
+			&i.VenueName,
+			&i.AddressFirstLine,
+			&i.AddressSecondLine,
+			&i.City,
+			&i.Postcode,
+			&i.Dates,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFavouriteSessionsAsGeoJSON = `-- name: GetFavouriteSessionsAsGeoJSON :one
+WITH t AS (
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.favourites f
+    JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+    JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+    JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+    WHERE u.email = lower($1::text)
+    ORDER BY f.dt_added DESC
+)
+SELECT json_build_object(
+    'type', 'FeatureCollection',
+    'features', coalesce(json_agg(json_strip_nulls(public.ST_AsGeoJSON(t.*)::json)), '[]'::json)
+) FROM t
+`
+
+func (q *Queries) GetFavouriteSessionsAsGeoJSON(ctx context.Context, email string) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getFavouriteSessionsAsGeoJSON, email)
+	var json_build_object []byte
+	err := row.Scan(&json_build_object)
+	return json_build_object, err
+}
+
+const getFollows = `-- name: GetFollows :many
+SELECT f.follow_id, f.session, s.session_name, f.venue, l.venue_name, f.dt_added FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+LEFT JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+LEFT JOIN london_jam_sessions.venues l ON l.venue_id = f.venue
+WHERE u.email = lower($1::text)
+ORDER BY f.dt_added DESC, f.follow_id DESC
+`
+
+type GetFollowsRow struct {
+	FollowID    int32              `json:"follow_id"`
+	Session     *int32             `json:"session"`
+	SessionName *string            `json:"session_name"`
+	Venue       *int32             `json:"venue"`
+	VenueName   *string            `json:"venue_name"`
+	DtAdded     pgtype.Timestamptz `json:"dt_added"`
+}
+
+func (q *Queries) GetFollows(ctx context.Context, email string) ([]GetFollowsRow, error) {
+	rows, err := q.db.Query(ctx, getFollows, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFollowsRow
+	for rows.Next() {
+		var i GetFollowsRow
+		if err := rows.Scan(
+			&i.FollowID,
+			&i.Session,
+			&i.SessionName,
+			&i.Venue,
+			&i.VenueName,
+			&i.DtAdded,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestComments = `-- name: GetLatestComments :many
+SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.dt_edited, c.parent_comment, c.verified, c.rating FROM (
+    SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.edit_token_hash, c.user_id, c.dt_edited, r.rating, row_number() OVER (PARTITION BY c.session ORDER BY c.dt_posted DESC, c.comment_id DESC) AS n
+    FROM london_jam_sessions.comments c
+    LEFT OUTER JOIN london_jam_sessions.ratings r ON c.comment_id = r.comment
+    WHERE c.session = ANY($1::int[]) AND c.status = 'visible'
+) c
+WHERE c.n <= $2::int
+ORDER BY c.session, c.dt_posted DESC, c.comment_id DESC
+`
+
+type GetLatestCommentsParams struct {
+	SessionIds    []int32 `json:"session_ids"`
+	MaxPerSession int32   `json:"max_per_session"`
+}
+
+type GetLatestCommentsRow struct {
+	CommentID     int32              `json:"comment_id"`
+	Session       int32              `json:"session"`
+	Author        string             `json:"author"`
+	Content       string             `json:"content"`
+	DtPosted      pgtype.Timestamptz `json:"dt_posted"`
+	DtEdited      pgtype.Timestamptz `json:"dt_edited"`
+	ParentComment *int32             `json:"parent_comment"`
+	Verified      bool               `json:"verified"`
+	Rating        *int16             `json:"rating"`
+}
+
+// the latest max_per_session visible comments of the sessions
+func (q *Queries) GetLatestComments(ctx context.Context, arg GetLatestCommentsParams) ([]GetLatestCommentsRow, error) {
+	rows, err := q.db.Query(ctx, getLatestComments, arg.SessionIds, arg.MaxPerSession)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetLatestCommentsRow
+	for rows.Next() {
+		var i GetLatestCommentsRow
+		if err := rows.Scan(
+			&i.CommentID,
+			&i.Session,
+			&i.Author,
+			&i.Content,
+			&i.DtPosted,
+			&i.DtEdited,
+			&i.ParentComment,
+			&i.Verified,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestEventId = `-- name: GetLatestEventId :one
+SELECT coalesce(max(event_id), 0)::bigint FROM london_jam_sessions.events
+`
+
+func (q *Queries) GetLatestEventId(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, getLatestEventId)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getModeratedComments = `-- name: GetModeratedComments :many
+SELECT c.comment_id, c.session, c.author, c.content, c.dt_posted, c.parent_comment, c.verified, c.status, c.spam_score, c.dt_moderated, c.dt_edited,
+    count(r.report_id)::int AS report_count,
+    coalesce(array_agg(DISTINCT r.reason) FILTER (WHERE r.report_id IS NOT NULL), '{}')::text[] AS report_reasons,
+    max(r.dt_reported)::timestamptz AS dt_last_reported
+FROM london_jam_sessions.comments c
+LEFT OUTER JOIN london_jam_sessions.comment_reports r ON r.comment = c.comment_id AND r.dt_resolved IS NULL
+WHERE $1::text IS NULL OR c.status = $1::text
+GROUP BY c.comment_id
+HAVING NOT $2::bool OR count(r.report_id) > 0
+ORDER BY count(r.report_id) DESC, c.spam_score DESC NULLS LAST, c.comment_id
+LIMIT 500
+`
+
+type GetModeratedCommentsParams struct {
+	Status   *string `json:"status"`
+	Reported bool    `json:"reported"`
+}
+
+type GetModeratedCommentsRow struct {
+	CommentID      int32              `json:"comment_id"`
+	Session        int32              `json:"session"`
+	Author         string             `json:"author"`
+	Content        string             `json:"content"`
+	DtPosted       pgtype.Timestamptz `json:"dt_posted"`
+	ParentComment  *int32             `json:"parent_comment"`
+	Verified       bool               `json:"verified"`
+	Status         string             `json:"status"`
+	SpamScore      *float32           `json:"spam_score"`
+	DtModerated    pgtype.Timestamptz `json:"dt_moderated"`
+	DtEdited       pgtype.Timestamptz `json:"dt_edited"`
+	ReportCount    int32              `json:"report_count"`
+	ReportReasons  []string           `json:"report_reasons"`
+	DtLastReported pgtype.Timestamptz `json:"dt_last_reported"`
+}
+
+// comments for the moderation queue: comments with the given status (if set) and/or open reports (if reported is true),
+// ordered by the number of open reports and the spam score
+func (q *Queries) GetModeratedComments(ctx context.Context, arg GetModeratedCommentsParams) ([]GetModeratedCommentsRow, error) {
+	rows, err := q.db.Query(ctx, getModeratedComments, arg.Status, arg.Reported)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetModeratedCommentsRow
+	for rows.Next() {
+		var i GetModeratedCommentsRow
+		if err := rows.Scan(
+			&i.CommentID,
+			&i.Session,
+			&i.Author,
+			&i.Content,
+			&i.DtPosted,
+			&i.ParentComment,
+			&i.Verified,
+			&i.Status,
+			&i.SpamScore,
+			&i.DtModerated,
+			&i.DtEdited,
+			&i.ReportCount,
+			&i.ReportReasons,
+			&i.DtLastReported,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationChannelsByEmail = `-- name: GetNotificationChannelsByEmail :many
+SELECT c.channel_id, c.kind, c.endpoint, c.dt_created FROM london_jam_sessions.notification_channels c
+JOIN london_jam_sessions.users u ON u.user_id = c.user_id
+WHERE u.email = lower($1::text)
+ORDER BY c.channel_id
+`
+
+type GetNotificationChannelsByEmailRow struct {
+	ChannelID int32              `json:"channel_id"`
+	Kind      string             `json:"kind"`
+	Endpoint  *string            `json:"endpoint"`
+	DtCreated pgtype.Timestamptz `json:"dt_created"`
+}
+
+func (q *Queries) GetNotificationChannelsByEmail(ctx context.Context, email string) ([]GetNotificationChannelsByEmailRow, error) {
+	rows, err := q.db.Query(ctx, getNotificationChannelsByEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetNotificationChannelsByEmailRow
+	for rows.Next() {
+		var i GetNotificationChannelsByEmailRow
+		if err := rows.Scan(
+			&i.ChannelID,
+			&i.Kind,
+			&i.Endpoint,
+			&i.DtCreated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationChannelsByUserIds = `-- name: GetNotificationChannelsByUserIds :many
+SELECT channel_id, user_id, kind, endpoint, p256dh, auth_secret, dt_created FROM london_jam_sessions.notification_channels
+WHERE user_id = ANY($1::int[])
+ORDER BY user_id, channel_id
+`
+
+func (q *Queries) GetNotificationChannelsByUserIds(ctx context.Context, userIds []int32) ([]LondonJamSessionsNotificationChannel, error) {
+	rows, err := q.db.Query(ctx, getNotificationChannelsByUserIds, userIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsNotificationChannel
+	for rows.Next() {
+		var i LondonJamSessionsNotificationChannel
+		if err := rows.Scan(
+			&i.ChannelID,
+			&i.UserID,
+			&i.Kind,
+			&i.Endpoint,
+			&i.P256dh,
+			&i.AuthSecret,
+			&i.DtCreated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOccurrencesHorizon = `-- name: GetOccurrencesHorizon :one
+SELECT h.start_date, h.end_date, h.dt_refreshed_utc, (SELECT count(*) FROM london_jam_sessions.session_occurrences) AS occurrences
+FROM london_jam_sessions.session_occurrences_horizon h
+`
+
+type GetOccurrencesHorizonRow struct {
+	StartDate      pgtype.Date        `json:"start_date"`
+	EndDate        pgtype.Date        `json:"end_date"`
+	DtRefreshedUtc pgtype.Timestamptz `json:"dt_refreshed_utc"`
+	Occurrences    int64              `json:"occurrences"`
+}
+
+func (q *Queries) GetOccurrencesHorizon(ctx context.Context) (GetOccurrencesHorizonRow, error) {
+	row := q.db.QueryRow(ctx, getOccurrencesHorizon)
+	var i GetOccurrencesHorizonRow
+	err := row.Scan(
+		&i.StartDate,
+		&i.EndDate,
+		&i.DtRefreshedUtc,
+		&i.Occurrences,
+	)
+	return i, err
+}
+
+const getRatingStatsBySessionIds = `-- name: GetRatingStatsBySessionIds :many
+SELECT s.session_id, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings
+FROM london_jam_sessions.jamsessions s
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+WHERE s.session_id = ANY($1::int[])
+`
+
+type GetRatingStatsBySessionIdsRow struct {
+	SessionID       int32    `json:"session_id"`
+	Rating          *float32 `json:"rating"`
+	RatingCount     *int32   `json:"rating_count"`
+	RatingHistogram []int32  `json:"rating_histogram"`
+	RatingScore     *float32 `json:"rating_score"`
+	AspectRatings   []byte   `json:"aspect_ratings"`
+}
+
+func (q *Queries) GetRatingStatsBySessionIds(ctx context.Context, sessionIds []int32) ([]GetRatingStatsBySessionIdsRow, error) {
+	rows, err := q.db.Query(ctx, getRatingStatsBySessionIds, sessionIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRatingStatsBySessionIdsRow
+	for rows.Next() {
+		var i GetRatingStatsBySessionIdsRow
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.Rating,
+			&i.RatingCount,
+			&i.RatingHistogram,
+			&i.RatingScore,
+			&i.AspectRatings,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRatingsBySessionId = `-- name: GetRatingsBySessionId :many
+SELECT rating_id, session, comment, rating, house_band, backline, beginner_friendliness, sound, crowd, dt_posted, user_id FROM london_jam_sessions.ratings
+WHERE session = $1
+`
+
+func (q *Queries) GetRatingsBySessionId(ctx context.Context, session int32) ([]LondonJamSessionsRating, error) {
+	rows, err := q.db.Query(ctx, getRatingsBySessionId, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsRating
+	for rows.Next() {
+		var i LondonJamSessionsRating
+		if err := rows.Scan(
+			&i.RatingID,
+			&i.Session,
+			&i.Comment,
+			&i.Rating,
+			&i.HouseBand,
+			&i.Backline,
+			&i.BeginnerFriendliness,
+			&i.Sound,
+			&i.Crowd,
+			&i.DtPosted,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSavedSearchMatches = `-- name: GetSavedSearchMatches :many
+SELECT m.session_id, m.session_name, m.start_time_utc, m.duration_minutes, m.interval, m.venue_id, m.venue_name, m.dates, m.is_new FROM (
+    SELECT j.session_id, j.session_name, j.start_time_utc, j.duration_minutes, j.interval, l.venue_id, l.venue_name,
+        coalesce(ARRAY(
+            SELECT x.d FROM unnest(d.dates) x(d)
+            WHERE s.weekdays IS NULL OR extract(isodow FROM x.d)::smallint = ANY(s.weekdays)
+            ORDER BY x.d
+        ), '{}')::date[] AS dates,
+        (j.session_id > s.last_session_id AND (s.weekdays IS NULL OR extract(isodow FROM j.start_time_utc AT TIME ZONE 'UTC')::smallint = ANY(s.weekdays)))::bool AS is_new
+    FROM london_jam_sessions.saved_searches s
+    JOIN london_jam_sessions.jamsessions j ON s.genres IS NULL OR j.genres @> s.genres
+    JOIN london_jam_sessions.venues l ON l.venue_id = j.venue
+    LEFT JOIN london_jam_sessions.session_dates($1::date, $2::date) d(session_id, dates) ON d.session_id = j.session_id
+    WHERE s.search_id = $3
+    AND (s.backline IS NULL OR l.backline @> s.backline)
+    AND (s.radius_metres IS NULL OR public.ST_DWithin(l.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint(s.lon, s.lat), 4326)::public.geography, s.radius_metres))
+) m
+WHERE cardinality(m.dates) > 0 OR m.is_new
+ORDER BY m.dates[1] NULLS LAST, m.session_id
+`
+
+type GetSavedSearchMatchesParams struct {
+	StartDate pgtype.Date `json:"start_date"`
+	EndDate   pgtype.Date `json:"end_date"`
+	SearchID  int32       `json:"search_id"`
+}
+
+type GetSavedSearchMatchesRow struct {
+	SessionID       int32              `json:"session_id"`
+	SessionName     string             `json:"session_name"`
+	StartTimeUtc    pgtype.Timestamptz `json:"start_time_utc"`
+	DurationMinutes int16              `json:"duration_minutes"`
+	Interval        string             `json:"interval"`
+	VenueID         int32              `json:"venue_id"`
+	VenueName       string             `json:"venue_name"`
+	Dates           []pgtype.Date      `json:"dates"`
+	IsNew           bool               `json:"is_new"`
+}
+
+// sessions matching the saved search with their dates between start_date and end_date (on the weekdays of the search).
+// sessions added since the last digest are included (is_new) even if they don't take place in that period.
+func (q *Queries) GetSavedSearchMatches(ctx context.Context, arg GetSavedSearchMatchesParams) ([]GetSavedSearchMatchesRow, error) {
+	rows, err := q.db.Query(ctx, getSavedSearchMatches, arg.StartDate, arg.EndDate, arg.SearchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSavedSearchMatchesRow
+	for rows.Next() {
+		var i GetSavedSearchMatchesRow
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.SessionName,
+			&i.StartTimeUtc,
+			&i.DurationMinutes,
+			&i.Interval,
+			&i.VenueID,
+			&i.VenueName,
+			&i.Dates,
+			&i.IsNew,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSavedSearchesByEmail = `-- name: GetSavedSearchesByEmail :many
+SELECT s.search_id, s.user_id, s.search_name, s.genres, s.backline, s.weekdays, s.lon, s.lat, s.radius_metres, s.last_session_id, s.dt_created, s.dt_last_digest FROM london_jam_sessions.saved_searches s
+JOIN london_jam_sessions.users u ON u.user_id = s.user_id
+WHERE u.email = lower($1::text)
+ORDER BY s.search_id
+`
+
+func (q *Queries) GetSavedSearchesByEmail(ctx context.Context, email string) ([]LondonJamSessionsSavedSearch, error) {
+	rows, err := q.db.Query(ctx, getSavedSearchesByEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsSavedSearch
+	for rows.Next() {
+		var i LondonJamSessionsSavedSearch
+		if err := rows.Scan(
+			&i.SearchID,
+			&i.UserID,
+			&i.SearchName,
+			&i.Genres,
+			&i.Backline,
+			&i.Weekdays,
+			&i.Lon,
+			&i.Lat,
+			&i.RadiusMetres,
+			&i.LastSessionID,
+			&i.DtCreated,
+			&i.DtLastDigest,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSessionById = `-- name: GetSessionById :one
+SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+LEFT OUTER JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+WHERE s.session_id = $1
+`
+
+type GetSessionByIdRow struct {
+	SessionID         int32              `json:"session_id"`
+	SessionName       string             `json:"session_name"`
+	Venue             int32              `json:"venue"`
+	Genres            []string           `json:"genres"`
+	StartTimeUtc      pgtype.Timestamptz `json:"start_time_utc"`
+	Interval          string             `json:"interval"`
+	DurationMinutes   int16              `json:"duration_minutes"`
+	Description       string             `json:"description"`
+	SessionWebsite    *string            `json:"session_website"`
+	DtUpdatedUtc      pgtype.Timestamptz `json:"dt_updated_utc"`
+	VenueID           int32              `json:"venue_id"`
+	VenueName         string             `json:"venue_name"`
 	AddressFirstLine  string             `json:"address_first_line"`
 	AddressSecondLine *string            `json:"address_second_line"`
 	City              string             `json:"city"`
@@ -257,7 +1684,11 @@ type GetSessionByIdRow struct {
 	Backline          []string           `json:"backline"`
 	VenueComments     []string           `json:"venue_comments"`
 	VenueDtUpdatedUtc pgtype.Timestamptz `json:"venue_dt_updated_utc"`
-	Rating            float32            `json:"rating"`
+	Rating            *float32           `json:"rating"`
+	RatingCount       *int32             `json:"rating_count"`
+	RatingHistogram   []int32            `json:"rating_histogram"`
+	RatingScore       *float32           `json:"rating_score"`
+	AspectRatings     []byte             `json:"aspect_ratings"`
 }
 
 func (q *Queries) GetSessionById(ctx context.Context, sessionID int32) (GetSessionByIdRow, error) {
@@ -286,30 +1717,66 @@ func (q *Queries) GetSessionById(ctx context.Context, sessionID int32) (GetSessi
 		&i.VenueComments,
 		&i.VenueDtUpdatedUtc,
 		&i.Rating,
+		&i.RatingCount,
+		&i.RatingHistogram,
+		&i.RatingScore,
+		&i.AspectRatings,
 	)
 	return i, err
 }
 
 const getSessionByIdAsGeoJSON = `-- name: GetSessionByIdAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.session_id = $1
-    GROUP BY s.session_id, l.venue_id
 )
-SELECT public.ST_AsGeoJSON(t.*) FROM t
+SELECT json_strip_nulls(public.ST_AsGeoJSON(t.*)::json) FROM t
 `
 
-func (q *Queries) GetSessionByIdAsGeoJSON(ctx context.Context, sessionID int32) (interface{}, error) {
+func (q *Queries) GetSessionByIdAsGeoJSON(ctx context.Context, sessionID int32) ([]byte, error) {
 	row := q.db.QueryRow(ctx, getSessionByIdAsGeoJSON, sessionID)
-	var st_asgeojson interface{}
-	err := row.Scan(&st_asgeojson)
-	return st_asgeojson, err
+	var json_strip_nulls []byte
+	err := row.Scan(&json_strip_nulls)
+	return json_strip_nulls, err
+}
+
+const getSessionFollowers = `-- name: GetSessionFollowers :many
+SELECT DISTINCT u.user_id, u.email FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+JOIN london_jam_sessions.jamsessions s ON s.session_id = $1
+WHERE f.session = s.session_id OR f.venue = s.venue
+`
+
+type GetSessionFollowersRow struct {
+	UserID int32  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// users that follow the session or its venue
+func (q *Queries) GetSessionFollowers(ctx context.Context, session int32) ([]GetSessionFollowersRow, error) {
+	rows, err := q.db.Query(ctx, getSessionFollowers, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSessionFollowersRow
+	for rows.Next() {
+		var i GetSessionFollowersRow
+		if err := rows.Scan(&i.UserID, &i.Email); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getSessionIdsByDate = `-- name: GetSessionIdsByDate :many
-SELECT sessions_on_date FROM london_jam_sessions.sessions_on_date($1::date)
+SELECT session_dates FROM london_jam_sessions.session_dates($1::date, $1::date)
 `
 
 func (q *Queries) GetSessionIdsByDate(ctx context.Context, date pgtype.Date) ([]interface{}, error) {
@@ -320,11 +1787,11 @@ func (q *Queries) GetSessionIdsByDate(ctx context.Context, date pgtype.Date) ([]
 	defer rows.Close()
 	var items []interface{}
 	for rows.Next() {
-		var sessions_on_date interface{}
-		if err := rows.Scan(&sessions_on_date); err != nil {
+		var session_dates interface{}
+		if err := rows.Scan(&session_dates); err != nil {
 			return nil, err
 		}
-		items = append(items, sessions_on_date)
+		items = append(items, session_dates)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -333,7 +1800,7 @@ func (q *Queries) GetSessionIdsByDate(ctx context.Context, date pgtype.Date) ([]
 }
 
 const getSessionIdsByDateRange = `-- name: GetSessionIdsByDateRange :many
-SELECT sessions_in_date_range FROM london_jam_sessions.sessions_in_date_range($1::date, $2::date)
+SELECT session_dates FROM london_jam_sessions.session_dates($1::date, $2::date)
 `
 
 type GetSessionIdsByDateRangeParams struct {
@@ -349,11 +1816,11 @@ func (q *Queries) GetSessionIdsByDateRange(ctx context.Context, arg GetSessionId
 	defer rows.Close()
 	var items []interface{}
 	for rows.Next() {
-		var sessions_in_date_range interface{}
-		if err := rows.Scan(&sessions_in_date_range); err != nil {
+		var session_dates interface{}
+		if err := rows.Scan(&session_dates); err != nil {
 			return nil, err
 		}
-		items = append(items, sessions_in_date_range)
+		items = append(items, session_dates)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -361,13 +1828,23 @@ func (q *Queries) GetSessionIdsByDateRange(ctx context.Context, arg GetSessionId
 	return items, nil
 }
 
+const getSessionVenue = `-- name: GetSessionVenue :one
+SELECT venue FROM london_jam_sessions.jamsessions WHERE session_id = $1
+`
+
+func (q *Queries) GetSessionVenue(ctx context.Context, sessionID int32) (int32, error) {
+	row := q.db.QueryRow(ctx, getSessionVenue, sessionID)
+	var venue int32
+	err := row.Scan(&venue)
+	return venue, err
+}
+
 const getSessionsByBacklineAsGeoJSON = `-- name: GetSessionsByBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -384,12 +1861,11 @@ func (q *Queries) GetSessionsByBacklineAsGeoJSON(ctx context.Context, backline [
 
 const getSessionsByDateAndBacklineAsGeoJSON = `-- name: GetSessionsByDateAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) d ON d.session_id = s.session_id
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) AS d(session_id, dates) ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> $2
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -411,13 +1887,12 @@ func (q *Queries) GetSessionsByDateAndBacklineAsGeoJSON(ctx context.Context, arg
 
 const getSessionsByDateAndGenreAndBacklineAsGeoJSON = `-- name: GetSessionsByDateAndGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) d ON d.session_id = s.session_id
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) AS d(session_id, dates) ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $2
     AND l.backline @> $3
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -440,12 +1915,11 @@ func (q *Queries) GetSessionsByDateAndGenreAndBacklineAsGeoJSON(ctx context.Cont
 
 const getSessionsByDateAndGenreAsGeoJSON = `-- name: GetSessionsByDateAndGenreAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
-    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) d ON d.session_id = s.session_id 
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
+    LEFT OUTER JOIN london_jam_sessions.sessions_on_date($1::date) AS d(session_id, dates) ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $2 
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -467,12 +1941,11 @@ func (q *Queries) GetSessionsByDateAndGenreAsGeoJSON(ctx context.Context, arg Ge
 
 const getSessionsByDateAsGeoJSON = `-- name: GetSessionsByDateAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
-    FROM london_jam_sessions.sessions_on_date($1::date) d
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
+    FROM london_jam_sessions.sessions_on_date($1::date) AS d(session_id, dates)
     LEFT OUTER JOIN london_jam_sessions.jamsessions s ON d.session_id = s.session_id
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id, d.dates
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -489,14 +1962,13 @@ func (q *Queries) GetSessionsByDateAsGeoJSON(ctx context.Context, date pgtype.Da
 
 const getSessionsByDateRangeAndBacklineAsGeoJSON = `-- name: GetSessionsByDateRangeAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.backline @> $3
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -519,15 +1991,14 @@ func (q *Queries) GetSessionsByDateRangeAndBacklineAsGeoJSON(ctx context.Context
 
 const getSessionsByDateRangeAndGenreAndBacklineAsGeoJSON = `-- name: GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $3
     AND l.backline @> $4
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -556,14 +2027,13 @@ func (q *Queries) GetSessionsByDateRangeAndGenreAndBacklineAsGeoJSON(ctx context
 
 const getSessionsByDateRangeAndGenreAsGeoJSON = `-- name: GetSessionsByDateRangeAndGenreAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $3
-    GROUP BY s.session_id, l.venue_id, d.dates
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -586,13 +2056,12 @@ func (q *Queries) GetSessionsByDateRangeAndGenreAsGeoJSON(ctx context.Context, a
 
 const getSessionsByDateRangeAsGeoJSON = `-- name: GetSessionsByDateRangeAsGeoJSON :one
 WITH t AS (
-    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating 
+    SELECT d.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings 
     FROM london_jam_sessions.jamsessions s 
-    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) d 
+    LEFT OUTER JOIN london_jam_sessions.sessions_in_date_range($1::date, $2::date) AS d(session_id, dates) 
     ON d.session_id = s.session_id 
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
-    GROUP BY s.session_id, l.venue_id, d.dates
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -614,12 +2083,11 @@ func (q *Queries) GetSessionsByDateRangeAsGeoJSON(ctx context.Context, arg GetSe
 
 const getSessionsByGenreAndBacklineAsGeoJSON = `-- name: GetSessionsByGenreAndBacklineAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $1
     AND l.backline @> $2
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -641,11 +2109,10 @@ func (q *Queries) GetSessionsByGenreAndBacklineAsGeoJSON(ctx context.Context, ar
 
 const getSessionsByGenreAsGeoJSON = `-- name: GetSessionsByGenreAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0.0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE s.genres @> $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
@@ -662,15 +2129,14 @@ func (q *Queries) GetSessionsByGenreAsGeoJSON(ctx context.Context, genres []stri
 
 const getSessionsByVenueIdAsGeoJSON = `-- name: GetSessionsByVenueIdAsGeoJSON :one
 WITH t AS (
-    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, coalesce(round(avg(rating), 2), 0)::real AS rating FROM london_jam_sessions.jamsessions s
+    SELECT s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings FROM london_jam_sessions.jamsessions s
     JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
-    LEFT OUTER JOIN london_jam_sessions.ratings r ON s.session_id = r.session
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
     WHERE l.venue_id = $1
-    GROUP BY s.session_id, l.venue_id
 )
 SELECT json_build_object(
     'type', 'FeatureCollection',
-    'features', json_agg(public.ST_AsGeoJSON(t.*)::json)
+    'features', coalesce(json_agg(json_strip_nulls(public.ST_AsGeoJSON(t.*)::json)), '[]'::json)
 ) FROM t
 `
 
@@ -681,6 +2147,246 @@ func (q *Queries) GetSessionsByVenueIdAsGeoJSON(ctx context.Context, venueID int
 	return json_build_object, err
 }
 
+const getSessionsPageAsGeoJSON = `-- name: GetSessionsPageAsGeoJSON :one
+WITH matches AS (
+    SELECT o.session_id, o.dates
+    FROM london_jam_sessions.session_dates($2::date, coalesce($3::date, $2::date)) AS o(session_id, dates)
+    WHERE $2::date IS NOT NULL
+), upcoming AS ( -- only evaluated when sorting by the next occurrence
+    SELECT u.session_id, min(x.d)::date AS next_date
+    FROM london_jam_sessions.session_dates(current_date, (current_date + 62)::date) AS u(session_id, dates)
+    CROSS JOIN unnest(u.dates) x(d)
+    WHERE $4::text = 'next_occurrence'
+    GROUP BY u.session_id
+), t AS (
+    SELECT m.dates, s.session_id, s.session_name, s.venue, s.genres, s.start_time_utc, s.interval, s.duration_minutes, s.description, s.session_website, s.dt_updated_utc, l.venue_id, l.venue_name, l.address_first_line, l.address_second_line, l.city, l.postcode, l.geom, l.venue_website, l.backline, l.venue_comments, l.venue_dt_updated_utc, rs.rating, rs.rating_count, rs.rating_histogram, rs.rating_score, rs.aspect_ratings
+    FROM london_jam_sessions.jamsessions s
+    JOIN london_jam_sessions.venues l ON s.venue = l.venue_id
+    LEFT OUTER JOIN matches m ON m.session_id = s.session_id
+    JOIN london_jam_sessions.session_rating_stats rs ON rs.session = s.session_id
+    WHERE ($2::date IS NULL OR m.session_id IS NOT NULL)
+    AND ($5::text[] IS NULL OR s.genres @> $5::text[])
+    AND ($6::text[] IS NULL OR l.backline @> $6::text[])
+), keyed AS (
+    SELECT t.session_id AS id,
+        coalesce(CASE $4::text
+            WHEN 'rating' THEN t.rating_score::float8
+            WHEN 'next_occurrence' THEN extract(epoch FROM u.next_date + (t.start_time_utc AT TIME ZONE 'UTC')::time)::float8
+            WHEN 'distance' THEN public.ST_Distance(t.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint($7::float8, $8::float8), 4326)::public.geography)
+            WHEN 'dt_updated_utc' THEN extract(epoch FROM t.dt_updated_utc)::float8
+        END, CASE WHEN $9::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END) AS sort_num, -- missing values go last
+        coalesce(CASE $4::text WHEN 'name' THEN lower(t.session_name) END, '')::text AS sort_text
+    FROM t
+    LEFT OUTER JOIN upcoming u ON u.session_id = t.session_id
+), page AS (
+    SELECT k.id, k.sort_num, k.sort_text, row_number() OVER (ORDER BY
+        CASE WHEN $9::bool THEN k.sort_num END DESC,
+        CASE WHEN $9::bool THEN k.sort_text END DESC,
+        CASE WHEN $9::bool THEN k.id END DESC,
+        k.sort_num, k.sort_text, k.id
+    ) AS n
+    FROM keyed k
+    WHERE $10::int IS NULL
+    OR (NOT $9::bool AND (k.sort_num, k.sort_text, k.id) > ($11::float8, $12::text, $10::int))
+    OR ($9::bool AND (k.sort_num, k.sort_text, k.id) < ($11::float8, $12::text, $10::int))
+), features AS (
+    SELECT p.n, CASE WHEN $13::text[] IS NULL THEN f.feature
+        ELSE jsonb_set(f.feature, '{properties}', (
+            SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(f.feature->'properties') WHERE key = ANY($13::text[])
+        ))
+    END AS feature
+    FROM page p
+    JOIN t ON t.session_id = p.id
+    CROSS JOIN LATERAL (SELECT jsonb_strip_nulls(public.ST_AsGeoJSON(t.*)::jsonb) AS feature) f
+    WHERE $1::int IS NULL OR p.n <= $1::int
+)
+SELECT jsonb_build_object(
+    'type', 'FeatureCollection',
+    'features', (SELECT coalesce(jsonb_agg(feature ORDER BY n), '[]'::jsonb) FROM features)
+)::json AS feature_collection,
+    coalesce(last.sort_num, 0)::float8 AS next_sort_num, last.sort_text AS next_sort_text, last.id AS next_id
+FROM (SELECT 1) dummy
+LEFT OUTER JOIN page last ON last.n = $1::int AND EXISTS (SELECT 1 FROM page WHERE n > $1::int)
+`
+
+type GetSessionsPageAsGeoJSONParams struct {
+	PageLimit      *int32      `json:"page_limit"`
+	StartDate      pgtype.Date `json:"start_date"`
+	EndDate        pgtype.Date `json:"end_date"`
+	Sort           string      `json:"sort"`
+	Genres         []string    `json:"genres"`
+	Backline       []string    `json:"backline"`
+	Lon            *float64    `json:"lon"`
+	Lat            *float64    `json:"lat"`
+	Descending     bool        `json:"descending"`
+	CursorID       *int32      `json:"cursor_id"`
+	CursorSortNum  *float64    `json:"cursor_sort_num"`
+	CursorSortText *string     `json:"cursor_sort_text"`
+	Fields         []string    `json:"fields"`
+}
+
+type GetSessionsPageAsGeoJSONRow struct {
+	FeatureCollection []byte  `json:"feature_collection"`
+	NextSortNum       float64 `json:"next_sort_num"`
+	NextSortText      *string `json:"next_sort_text"`
+	NextID            *int32  `json:"next_id"`
+}
+
+// returns a page of sessions as a FeatureCollection, optionally filtered by date (or date range), genres and backline.
+// rows are ordered by a sort key (see 'sort') and the session id, the keyset of the last row on the page
+// is returned as next_* (null on the last page) and is used as the cursor for the next page.
+// 'fields' trims the feature properties to the given keys, null properties are left out.
+// the result is sent to the client as is (see types.RawJSON).
+func (q *Queries) GetSessionsPageAsGeoJSON(ctx context.Context, arg GetSessionsPageAsGeoJSONParams) (GetSessionsPageAsGeoJSONRow, error) {
+	row := q.db.QueryRow(ctx, getSessionsPageAsGeoJSON,
+		arg.PageLimit,
+		arg.StartDate,
+		arg.EndDate,
+		arg.Sort,
+		arg.Genres,
+		arg.Backline,
+		arg.Lon,
+		arg.Lat,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorSortNum,
+		arg.CursorSortText,
+		arg.Fields,
+	)
+	var i GetSessionsPageAsGeoJSONRow
+	err := row.Scan(
+		&i.FeatureCollection,
+		&i.NextSortNum,
+		&i.NextSortText,
+		&i.NextID,
+	)
+	return i, err
+}
+
+const getUpcomingOccurrences = `-- name: GetUpcomingOccurrences :many
+SELECT o.session, o.d FROM (
+    SELECT session, d, row_number() OVER (PARTITION BY session ORDER BY d) AS n
+    FROM london_jam_sessions.session_occurrences
+    WHERE session = ANY($1::int[]) AND d >= $2::date
+) o
+WHERE o.n <= $3::int
+ORDER BY o.session, o.d
+`
+
+type GetUpcomingOccurrencesParams struct {
+	SessionIds    []int32     `json:"session_ids"`
+	StartDate     pgtype.Date `json:"start_date"`
+	MaxPerSession int32       `json:"max_per_session"`
+}
+
+// the first max_per_session occurrences of the sessions from start_date on (within the horizon of the materialised occurrences)
+func (q *Queries) GetUpcomingOccurrences(ctx context.Context, arg GetUpcomingOccurrencesParams) ([]LondonJamSessionsSessionOccurrence, error) {
+	rows, err := q.db.Query(ctx, getUpcomingOccurrences, arg.SessionIds, arg.StartDate, arg.MaxPerSession)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsSessionOccurrence
+	for rows.Next() {
+		var i LondonJamSessionsSessionOccurrence
+		if err := rows.Scan(&i.Session, &i.D); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT user_id, email, role, dt_created FROM london_jam_sessions.users
+WHERE email = lower($1::text)
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (LondonJamSessionsUser, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i LondonJamSessionsUser
+	err := row.Scan(
+		&i.UserID,
+		&i.Email,
+		&i.Role,
+		&i.DtCreated,
+	)
+	return i, err
+}
+
+const getUserById = `-- name: GetUserById :one
+SELECT user_id, email, role, dt_created FROM london_jam_sessions.users WHERE user_id = $1
+`
+
+func (q *Queries) GetUserById(ctx context.Context, userID int32) (LondonJamSessionsUser, error) {
+	row := q.db.QueryRow(ctx, getUserById, userID)
+	var i LondonJamSessionsUser
+	err := row.Scan(
+		&i.UserID,
+		&i.Email,
+		&i.Role,
+		&i.DtCreated,
+	)
+	return i, err
+}
+
+const getUserContributions = `-- name: GetUserContributions :many
+SELECT contribution_id, user_id, session, venue, action, dt_contributed FROM london_jam_sessions.user_contributions
+WHERE user_id = $1
+ORDER BY dt_contributed DESC, contribution_id DESC
+`
+
+func (q *Queries) GetUserContributions(ctx context.Context, userID int32) ([]LondonJamSessionsUserContribution, error) {
+	rows, err := q.db.Query(ctx, getUserContributions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsUserContribution
+	for rows.Next() {
+		var i LondonJamSessionsUserContribution
+		if err := rows.Scan(
+			&i.ContributionID,
+			&i.UserID,
+			&i.Session,
+			&i.Venue,
+			&i.Action,
+			&i.DtContributed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserPermissions = `-- name: GetUserPermissions :one
+SELECT u.role, COALESCE(array_agg(o.venue) FILTER (WHERE o.venue IS NOT NULL), '{}')::int[] AS owned_venues
+FROM london_jam_sessions.users u
+LEFT OUTER JOIN london_jam_sessions.venue_owners o ON o.user_id = u.user_id
+WHERE u.email = lower($1::text)
+GROUP BY u.user_id
+`
+
+type GetUserPermissionsRow struct {
+	Role        string  `json:"role"`
+	OwnedVenues []int32 `json:"owned_venues"`
+}
+
+// role of the user and the venues they own (see venue_owners)
+func (q *Queries) GetUserPermissions(ctx context.Context, email string) (GetUserPermissionsRow, error) {
+	row := q.db.QueryRow(ctx, getUserPermissions, email)
+	var i GetUserPermissionsRow
+	err := row.Scan(&i.Role, &i.OwnedVenues)
+	return i, err
+}
+
 const getVenueById = `-- name: GetVenueById :one
 SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode, geom, venue_website, backline, venue_comments, venue_dt_updated_utc FROM london_jam_sessions.venues
 WHERE venue_id = $1
@@ -710,14 +2416,14 @@ WITH t AS (
     SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode, geom, venue_website, backline, venue_comments, venue_dt_updated_utc FROM london_jam_sessions.venues
     WHERE venue_id = $1
 )
-SELECT public.ST_AsGeoJSON(t.*) FROM t
+SELECT json_strip_nulls(public.ST_AsGeoJSON(t.*)::json) FROM t
 `
 
-func (q *Queries) GetVenueByIdAsGeoJSON(ctx context.Context, venueID int32) (interface{}, error) {
+func (q *Queries) GetVenueByIdAsGeoJSON(ctx context.Context, venueID int32) ([]byte, error) {
 	row := q.db.QueryRow(ctx, getVenueByIdAsGeoJSON, venueID)
-	var st_asgeojson interface{}
-	err := row.Scan(&st_asgeojson)
-	return st_asgeojson, err
+	var json_strip_nulls []byte
+	err := row.Scan(&json_strip_nulls)
+	return json_strip_nulls, err
 }
 
 const getVenueByName = `-- name: GetVenueByName :one
@@ -744,6 +2450,332 @@ func (q *Queries) GetVenueByName(ctx context.Context, venueName string) (LondonJ
 	return i, err
 }
 
+const getVenueFollowers = `-- name: GetVenueFollowers :many
+SELECT DISTINCT u.user_id, u.email FROM london_jam_sessions.follows f
+JOIN london_jam_sessions.users u ON u.user_id = f.user_id
+LEFT JOIN london_jam_sessions.jamsessions s ON s.session_id = f.session
+WHERE f.venue = $1::int OR s.venue = $1::int
+`
+
+type GetVenueFollowersRow struct {
+	UserID int32  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// users that follow the venue or one of its sessions
+func (q *Queries) GetVenueFollowers(ctx context.Context, venue int32) ([]GetVenueFollowersRow, error) {
+	rows, err := q.db.Query(ctx, getVenueFollowers, venue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVenueFollowersRow
+	for rows.Next() {
+		var i GetVenueFollowersRow
+		if err := rows.Scan(&i.UserID, &i.Email); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVenuesPageAsGeoJSON = `-- name: GetVenuesPageAsGeoJSON :one
+WITH keyed AS (
+    SELECT v.venue_id AS id,
+        coalesce(CASE $2::text
+            WHEN 'distance' THEN public.ST_Distance(v.geom::public.geography, public.ST_SetSRID(public.ST_MakePoint($3::float8, $4::float8), 4326)::public.geography)
+            WHEN 'dt_updated_utc' THEN extract(epoch FROM v.venue_dt_updated_utc)::float8
+        END, CASE WHEN $5::bool THEN '-Infinity'::float8 ELSE 'Infinity'::float8 END) AS sort_num, -- missing values go last
+        coalesce(CASE $2::text WHEN 'name' THEN lower(v.venue_name) END, '')::text AS sort_text
+    FROM london_jam_sessions.venues v
+), page AS (
+    SELECT k.id, k.sort_num, k.sort_text, row_number() OVER (ORDER BY
+        CASE WHEN $5::bool THEN k.sort_num END DESC,
+        CASE WHEN $5::bool THEN k.sort_text END DESC,
+        CASE WHEN $5::bool THEN k.id END DESC,
+        k.sort_num, k.sort_text, k.id
+    ) AS n
+    FROM keyed k
+    WHERE $6::int IS NULL
+    OR (NOT $5::bool AND (k.sort_num, k.sort_text, k.id) > ($7::float8, $8::text, $6::int))
+    OR ($5::bool AND (k.sort_num, k.sort_text, k.id) < ($7::float8, $8::text, $6::int))
+), features AS (
+    SELECT p.n, CASE WHEN $9::text[] IS NULL THEN f.feature
+        ELSE jsonb_set(f.feature, '{properties}', (
+            SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(f.feature->'properties') WHERE key = ANY($9::text[])
+        ))
+    END AS feature
+    FROM page p
+    JOIN london_jam_sessions.venues v ON v.venue_id = p.id
+    CROSS JOIN LATERAL (SELECT jsonb_strip_nulls(public.ST_AsGeoJSON(v.*)::jsonb) AS feature) f
+    WHERE $1::int IS NULL OR p.n <= $1::int
+)
+SELECT jsonb_build_object(
+    'type', 'FeatureCollection',
+    'features', (SELECT coalesce(jsonb_agg(feature ORDER BY n), '[]'::jsonb) FROM features)
+)::json AS feature_collection,
+    coalesce(last.sort_num, 0)::float8 AS next_sort_num, last.sort_text AS next_sort_text, last.id AS next_id
+FROM (SELECT 1) dummy
+LEFT OUTER JOIN page last ON last.n = $1::int AND EXISTS (SELECT 1 FROM page WHERE n > $1::int)
+`
+
+type GetVenuesPageAsGeoJSONParams struct {
+	PageLimit      *int32   `json:"page_limit"`
+	Sort           string   `json:"sort"`
+	Lon            *float64 `json:"lon"`
+	Lat            *float64 `json:"lat"`
+	Descending     bool     `json:"descending"`
+	CursorID       *int32   `json:"cursor_id"`
+	CursorSortNum  *float64 `json:"cursor_sort_num"`
+	CursorSortText *string  `json:"cursor_sort_text"`
+	Fields         []string `json:"fields"`
+}
+
+type GetVenuesPageAsGeoJSONRow struct {
+	FeatureCollection []byte  `json:"feature_collection"`
+	NextSortNum       float64 `json:"next_sort_num"`
+	NextSortText      *string `json:"next_sort_text"`
+	NextID            *int32  `json:"next_id"`
+}
+
+// returns a page of venues as a FeatureCollection, see GetSessionsPageAsGeoJSON
+func (q *Queries) GetVenuesPageAsGeoJSON(ctx context.Context, arg GetVenuesPageAsGeoJSONParams) (GetVenuesPageAsGeoJSONRow, error) {
+	row := q.db.QueryRow(ctx, getVenuesPageAsGeoJSON,
+		arg.PageLimit,
+		arg.Sort,
+		arg.Lon,
+		arg.Lat,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorSortNum,
+		arg.CursorSortText,
+		arg.Fields,
+	)
+	var i GetVenuesPageAsGeoJSONRow
+	err := row.Scan(
+		&i.FeatureCollection,
+		&i.NextSortNum,
+		&i.NextSortText,
+		&i.NextID,
+	)
+	return i, err
+}
+
+const getWebhookDeliveries = `-- name: GetWebhookDeliveries :many
+SELECT wd.delivery_id, wd.event_id, wd.event_type, wd.status, wd.attempts, wd.next_attempt_at, wd.response_status, wd.last_error, wd.dt_created, wd.dt_delivered
+FROM london_jam_sessions.webhook_deliveries wd
+WHERE wd.subscription_id = $1
+AND ($2::text IS NULL OR wd.status = $2::text)
+ORDER BY wd.delivery_id DESC
+LIMIT $3
+`
+
+type GetWebhookDeliveriesParams struct {
+	SubscriptionID int32   `json:"subscription_id"`
+	Status         *string `json:"status"`
+	MaxResults     int32   `json:"max_results"`
+}
+
+type GetWebhookDeliveriesRow struct {
+	DeliveryID     int64              `json:"delivery_id"`
+	EventID        string             `json:"event_id"`
+	EventType      string             `json:"event_type"`
+	Status         string             `json:"status"`
+	Attempts       int16              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	ResponseStatus *int16             `json:"response_status"`
+	LastError      *string            `json:"last_error"`
+	DtCreated      pgtype.Timestamptz `json:"dt_created"`
+	DtDelivered    pgtype.Timestamptz `json:"dt_delivered"`
+}
+
+// delivery log of a subscription, most recent first
+func (q *Queries) GetWebhookDeliveries(ctx context.Context, arg GetWebhookDeliveriesParams) ([]GetWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, getWebhookDeliveries, arg.SubscriptionID, arg.Status, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWebhookDeliveriesRow
+	for rows.Next() {
+		var i GetWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.DeliveryID,
+			&i.EventID,
+			&i.EventType,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.ResponseStatus,
+			&i.LastError,
+			&i.DtCreated,
+			&i.DtDelivered,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookSubscriptionExists = `-- name: GetWebhookSubscriptionExists :one
+SELECT EXISTS (SELECT 1 FROM london_jam_sessions.webhook_subscriptions WHERE subscription_id = $1)
+`
+
+func (q *Queries) GetWebhookSubscriptionExists(ctx context.Context, subscriptionID int32) (bool, error) {
+	row := q.db.QueryRow(ctx, getWebhookSubscriptionExists, subscriptionID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const getWebhookSubscriptions = `-- name: GetWebhookSubscriptions :many
+SELECT w.subscription_id, w.url, w.event_types, w.description, w.dt_created,
+    count(wd.delivery_id) FILTER (WHERE wd.status = 'pending')::bigint AS pending_deliveries,
+    count(wd.delivery_id) FILTER (WHERE wd.status = 'failed')::bigint AS failed_deliveries
+FROM london_jam_sessions.webhook_subscriptions w
+LEFT OUTER JOIN london_jam_sessions.webhook_deliveries wd ON wd.subscription_id = w.subscription_id
+GROUP BY w.subscription_id
+ORDER BY w.subscription_id
+`
+
+type GetWebhookSubscriptionsRow struct {
+	SubscriptionID    int32              `json:"subscription_id"`
+	Url               string             `json:"url"`
+	EventTypes        []string           `json:"event_types"`
+	Description       *string            `json:"description"`
+	DtCreated         pgtype.Timestamptz `json:"dt_created"`
+	PendingDeliveries int64              `json:"pending_deliveries"`
+	FailedDeliveries  int64              `json:"failed_deliveries"`
+}
+
+// the secrets are only returned when a subscription is created
+func (q *Queries) GetWebhookSubscriptions(ctx context.Context) ([]GetWebhookSubscriptionsRow, error) {
+	rows, err := q.db.Query(ctx, getWebhookSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWebhookSubscriptionsRow
+	for rows.Next() {
+		var i GetWebhookSubscriptionsRow
+		if err := rows.Scan(
+			&i.SubscriptionID,
+			&i.Url,
+			&i.EventTypes,
+			&i.Description,
+			&i.DtCreated,
+			&i.PendingDeliveries,
+			&i.FailedDeliveries,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertApiKey = `-- name: InsertApiKey :one
+INSERT INTO london_jam_sessions.api_keys (name, key_prefix, key_hash, rate_limit)
+VALUES ($1, $2, $3, $4)
+RETURNING key_id
+`
+
+type InsertApiKeyParams struct {
+	Name      string `json:"name"`
+	KeyPrefix string `json:"key_prefix"`
+	KeyHash   string `json:"key_hash"`
+	RateLimit int32  `json:"rate_limit"`
+}
+
+func (q *Queries) InsertApiKey(ctx context.Context, arg InsertApiKeyParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertApiKey,
+		arg.Name,
+		arg.KeyPrefix,
+		arg.KeyHash,
+		arg.RateLimit,
+	)
+	var key_id int32
+	err := row.Scan(&key_id)
+	return key_id, err
+}
+
+const insertCommentReport = `-- name: InsertCommentReport :one
+INSERT INTO london_jam_sessions.comment_reports (
+    comment, reason, details, reporter_hash
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING report_id
+`
+
+type InsertCommentReportParams struct {
+	Comment      int32   `json:"comment"`
+	Reason       string  `json:"reason"`
+	Details      *string `json:"details"`
+	ReporterHash *string `json:"reporter_hash"`
+}
+
+func (q *Queries) InsertCommentReport(ctx context.Context, arg InsertCommentReportParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertCommentReport,
+		arg.Comment,
+		arg.Reason,
+		arg.Details,
+		arg.ReporterHash,
+	)
+	var report_id int32
+	err := row.Scan(&report_id)
+	return report_id, err
+}
+
+const insertFavourite = `-- name: InsertFavourite :execrows
+INSERT INTO london_jam_sessions.favourites (user_id, session)
+SELECT u.user_id, $1 FROM london_jam_sessions.users u WHERE u.email = lower($2::text)
+ON CONFLICT DO NOTHING
+`
+
+type InsertFavouriteParams struct {
+	Session   int32  `json:"session"`
+	UserEmail string `json:"user_email"`
+}
+
+func (q *Queries) InsertFavourite(ctx context.Context, arg InsertFavouriteParams) (int64, error) {
+	result, err := q.db.Exec(ctx, insertFavourite, arg.Session, arg.UserEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const insertFollow = `-- name: InsertFollow :execrows
+INSERT INTO london_jam_sessions.follows (user_id, session, venue)
+SELECT u.user_id, $1, $2 FROM london_jam_sessions.users u WHERE u.email = lower($3::text)
+ON CONFLICT DO NOTHING
+`
+
+type InsertFollowParams struct {
+	Session   *int32 `json:"session"`
+	Venue     *int32 `json:"venue"`
+	UserEmail string `json:"user_email"`
+}
+
+func (q *Queries) InsertFollow(ctx context.Context, arg InsertFollowParams) (int64, error) {
+	result, err := q.db.Exec(ctx, insertFollow, arg.Session, arg.Venue, arg.UserEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const insertJamSession = `-- name: InsertJamSession :one
 INSERT INTO london_jam_sessions.jamsessions (
     session_name, venue, description, genres, start_time_utc, interval, duration_minutes, session_website
@@ -779,22 +2811,108 @@ func (q *Queries) InsertJamSession(ctx context.Context, arg InsertJamSessionPara
 	return session_id, err
 }
 
+const insertNotificationChannel = `-- name: InsertNotificationChannel :one
+INSERT INTO london_jam_sessions.notification_channels (user_id, kind, endpoint, p256dh, auth_secret)
+SELECT u.user_id, $1, $2, $3, $4 FROM london_jam_sessions.users u
+WHERE u.email = lower($5::text)
+ON CONFLICT (user_id, kind, endpoint) DO UPDATE SET p256dh = EXCLUDED.p256dh, auth_secret = EXCLUDED.auth_secret
+RETURNING channel_id
+`
+
+type InsertNotificationChannelParams struct {
+	Kind       string  `json:"kind"`
+	Endpoint   *string `json:"endpoint"`
+	P256dh     *string `json:"p256dh"`
+	AuthSecret *string `json:"auth_secret"`
+	UserEmail  string  `json:"user_email"`
+}
+
+func (q *Queries) InsertNotificationChannel(ctx context.Context, arg InsertNotificationChannelParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertNotificationChannel,
+		arg.Kind,
+		arg.Endpoint,
+		arg.P256dh,
+		arg.AuthSecret,
+		arg.UserEmail,
+	)
+	var channel_id int32
+	err := row.Scan(&channel_id)
+	return channel_id, err
+}
+
+const insertSavedSearch = `-- name: InsertSavedSearch :one
+INSERT INTO london_jam_sessions.saved_searches (user_id, search_name, genres, backline, weekdays, lon, lat, radius_metres, last_session_id)
+SELECT u.user_id, $1, $2::text[], $3::text[], $4::smallint[],
+    $5::float8, $6::float8, $7::int,
+    (SELECT coalesce(max(session_id), 0) FROM london_jam_sessions.jamsessions)
+FROM london_jam_sessions.users u WHERE u.email = lower($8::text)
+ON CONFLICT (user_id, search_name) DO UPDATE SET genres = EXCLUDED.genres, backline = EXCLUDED.backline, weekdays = EXCLUDED.weekdays,
+    lon = EXCLUDED.lon, lat = EXCLUDED.lat, radius_metres = EXCLUDED.radius_metres
+RETURNING search_id
+`
+
+type InsertSavedSearchParams struct {
+	SearchName   string   `json:"search_name"`
+	Genres       []string `json:"genres"`
+	Backline     []string `json:"backline"`
+	Weekdays     []int16  `json:"weekdays"`
+	Lon          *float64 `json:"lon"`
+	Lat          *float64 `json:"lat"`
+	RadiusMetres *int32   `json:"radius_metres"`
+	UserEmail    string   `json:"user_email"`
+}
+
+// saving a search under an existing name replaces its filters. sessions that exist when the search is first saved
+// are not new to its first digest.
+func (q *Queries) InsertSavedSearch(ctx context.Context, arg InsertSavedSearchParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertSavedSearch,
+		arg.SearchName,
+		arg.Genres,
+		arg.Backline,
+		arg.Weekdays,
+		arg.Lon,
+		arg.Lat,
+		arg.RadiusMetres,
+		arg.UserEmail,
+	)
+	var search_id int32
+	err := row.Scan(&search_id)
+	return search_id, err
+}
+
 const insertSessionComment = `-- name: InsertSessionComment :one
 INSERT INTO london_jam_sessions.comments (
-    session, author, content
+    session, author, content, parent_comment, verified, status, spam_score, edit_token_hash, user_id
 ) VALUES (
-    $1, $2, $3
+    $1, $2, $3, $4, coalesce($5::bool, FALSE), coalesce($6::text, 'visible'), $7, $8,
+    (SELECT u.user_id FROM london_jam_sessions.users u WHERE u.email = lower($9::text))
 ) RETURNING comment_id
 `
 
 type InsertSessionCommentParams struct {
-	Session int32  `json:"session"`
-	Author  string `json:"author"`
-	Content string `json:"content"`
+	Session       int32    `json:"session"`
+	Author        string   `json:"author"`
+	Content       string   `json:"content"`
+	ParentComment *int32   `json:"parent_comment"`
+	Verified      *bool    `json:"verified"`
+	Status        *string  `json:"status"`
+	SpamScore     *float32 `json:"spam_score"`
+	EditTokenHash *string  `json:"edit_token_hash"`
+	UserEmail     *string  `json:"user_email"`
 }
 
 func (q *Queries) InsertSessionComment(ctx context.Context, arg InsertSessionCommentParams) (int32, error) {
-	row := q.db.QueryRow(ctx, insertSessionComment, arg.Session, arg.Author, arg.Content)
+	row := q.db.QueryRow(ctx, insertSessionComment,
+		arg.Session,
+		arg.Author,
+		arg.Content,
+		arg.ParentComment,
+		arg.Verified,
+		arg.Status,
+		arg.SpamScore,
+		arg.EditTokenHash,
+		arg.UserEmail,
+	)
 	var comment_id int32
 	err := row.Scan(&comment_id)
 	return comment_id, err
@@ -802,25 +2920,83 @@ func (q *Queries) InsertSessionComment(ctx context.Context, arg InsertSessionCom
 
 const insertSessionRating = `-- name: InsertSessionRating :one
 INSERT INTO london_jam_sessions.ratings (
-    session, rating, comment
+    session, rating, comment, house_band, backline, beginner_friendliness, sound, crowd, user_id
 ) VALUES (
-    $1, $2, $3
+    $1, $2, $3, $4, $5, $6, $7, $8,
+    (SELECT u.user_id FROM london_jam_sessions.users u WHERE u.email = lower($9::text))
 ) RETURNING rating_id
 `
 
 type InsertSessionRatingParams struct {
-	Session int32  `json:"session"`
-	Rating  *int16 `json:"rating"`
-	Comment *int32 `json:"comment"`
+	Session              int32   `json:"session"`
+	Rating               *int16  `json:"rating"`
+	Comment              *int32  `json:"comment"`
+	HouseBand            *int16  `json:"house_band"`
+	Backline             *int16  `json:"backline"`
+	BeginnerFriendliness *int16  `json:"beginner_friendliness"`
+	Sound                *int16  `json:"sound"`
+	Crowd                *int16  `json:"crowd"`
+	UserEmail            *string `json:"user_email"`
 }
 
 func (q *Queries) InsertSessionRating(ctx context.Context, arg InsertSessionRatingParams) (int32, error) {
-	row := q.db.QueryRow(ctx, insertSessionRating, arg.Session, arg.Rating, arg.Comment)
+	row := q.db.QueryRow(ctx, insertSessionRating,
+		arg.Session,
+		arg.Rating,
+		arg.Comment,
+		arg.HouseBand,
+		arg.Backline,
+		arg.BeginnerFriendliness,
+		arg.Sound,
+		arg.Crowd,
+		arg.UserEmail,
+	)
 	var rating_id int32
 	err := row.Scan(&rating_id)
 	return rating_id, err
 }
 
+const insertUser = `-- name: InsertUser :one
+INSERT INTO london_jam_sessions.users (email) VALUES (lower($1::text))
+ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+RETURNING user_id
+`
+
+// registers a user on their first login (a no-op if the account already exists)
+func (q *Queries) InsertUser(ctx context.Context, email string) (int32, error) {
+	row := q.db.QueryRow(ctx, insertUser, email)
+	var user_id int32
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const insertUserContribution = `-- name: InsertUserContribution :execrows
+INSERT INTO london_jam_sessions.user_contributions (user_id, session, venue, action)
+SELECT u.user_id, $1, $2, $3
+FROM london_jam_sessions.users u WHERE u.email = lower($4::text)
+`
+
+type InsertUserContributionParams struct {
+	Session   *int32 `json:"session"`
+	Venue     *int32 `json:"venue"`
+	Action    string `json:"action"`
+	UserEmail string `json:"user_email"`
+}
+
+// attributes a session or venue to a user (nothing is recorded if there is no user with the email address)
+func (q *Queries) InsertUserContribution(ctx context.Context, arg InsertUserContributionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, insertUserContribution,
+		arg.Session,
+		arg.Venue,
+		arg.Action,
+		arg.UserEmail,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const insertVenue = `-- name: InsertVenue :one
 INSERT INTO london_jam_sessions.venues (
     venue_name, address_first_line, address_second_line, city, postcode, geom, venue_website, backline, venue_comments
@@ -858,6 +3034,477 @@ func (q *Queries) InsertVenue(ctx context.Context, arg InsertVenueParams) (int32
 	return venue_id, err
 }
 
+const insertVenueOwner = `-- name: InsertVenueOwner :execrows
+INSERT INTO london_jam_sessions.venue_owners (user_id, venue)
+SELECT u.user_id, $1 FROM london_jam_sessions.users u WHERE u.email = lower($2::text)
+ON CONFLICT DO NOTHING
+`
+
+type InsertVenueOwnerParams struct {
+	Venue int32  `json:"venue"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) InsertVenueOwner(ctx context.Context, arg InsertVenueOwnerParams) (int64, error) {
+	result, err := q.db.Exec(ctx, insertVenueOwner, arg.Venue, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const insertWebhookSubscription = `-- name: InsertWebhookSubscription :one
+INSERT INTO london_jam_sessions.webhook_subscriptions (url, secret, event_types, description)
+VALUES ($1, $2, $3::text[], $4)
+RETURNING subscription_id
+`
+
+type InsertWebhookSubscriptionParams struct {
+	Url         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	EventTypes  []string `json:"event_types"`
+	Description *string  `json:"description"`
+}
+
+func (q *Queries) InsertWebhookSubscription(ctx context.Context, arg InsertWebhookSubscriptionParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertWebhookSubscription,
+		arg.Url,
+		arg.Secret,
+		arg.EventTypes,
+		arg.Description,
+	)
+	var subscription_id int32
+	err := row.Scan(&subscription_id)
+	return subscription_id, err
+}
+
+const listApiKeys = `-- name: ListApiKeys :many
+SELECT k.key_id, k.name, k.key_prefix, k.rate_limit, k.dt_created, k.dt_revoked,
+    COALESCE(SUM(u.request_count), 0)::bigint AS request_count,
+    COALESCE(SUM(u.request_count) FILTER (WHERE u.day = CURRENT_DATE), 0)::bigint AS requests_today,
+    MAX(u.dt_last_used)::timestamptz AS dt_last_used
+FROM london_jam_sessions.api_keys k
+LEFT OUTER JOIN london_jam_sessions.api_key_usage u ON u.key_id = k.key_id
+GROUP BY k.key_id
+ORDER BY k.key_id
+`
+
+type ListApiKeysRow struct {
+	KeyID         int32              `json:"key_id"`
+	Name          string             `json:"name"`
+	KeyPrefix     string             `json:"key_prefix"`
+	RateLimit     int32              `json:"rate_limit"`
+	DtCreated     pgtype.Timestamptz `json:"dt_created"`
+	DtRevoked     pgtype.Timestamptz `json:"dt_revoked"`
+	RequestCount  int64              `json:"request_count"`
+	RequestsToday int64              `json:"requests_today"`
+	DtLastUsed    pgtype.Timestamptz `json:"dt_last_used"`
+}
+
+func (q *Queries) ListApiKeys(ctx context.Context) ([]ListApiKeysRow, error) {
+	rows, err := q.db.Query(ctx, listApiKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListApiKeysRow
+	for rows.Next() {
+		var i ListApiKeysRow
+		if err := rows.Scan(
+			&i.KeyID,
+			&i.Name,
+			&i.KeyPrefix,
+			&i.RateLimit,
+			&i.DtCreated,
+			&i.DtRevoked,
+			&i.RequestCount,
+			&i.RequestsToday,
+			&i.DtLastUsed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessions = `-- name: ListSessions :many
+SELECT session_id, session_name, venue, genres, start_time_utc, interval, duration_minutes, description, session_website, dt_updated_utc FROM london_jam_sessions.jamsessions
+WHERE ($1::int[] IS NULL OR session_id = ANY($1::int[]))
+AND ($2::int[] IS NULL OR venue = ANY($2::int[]))
+ORDER BY session_id
+LIMIT $4 OFFSET $3
+`
+
+type ListSessionsParams struct {
+	Ids        []int32 `json:"ids"`
+	VenueIds   []int32 `json:"venue_ids"`
+	Skip       int32   `json:"skip"`
+	MaxResults *int32  `json:"max_results"`
+}
+
+// sessions by ID and/or venue (all sessions if both are null)
+func (q *Queries) ListSessions(ctx context.Context, arg ListSessionsParams) ([]LondonJamSessionsJamsession, error) {
+	rows, err := q.db.Query(ctx, listSessions,
+		arg.Ids,
+		arg.VenueIds,
+		arg.Skip,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LondonJamSessionsJamsession
+	for rows.Next() {
+		var i LondonJamSessionsJamsession
+		if err := rows.Scan(
+			&i.SessionID,
+			&i.SessionName,
+			&i.Venue,
+			&i.Genres,
+			&i.StartTimeUtc,
+			&i.Interval,
+			&i.DurationMinutes,
+			&i.Description,
+			&i.SessionWebsite,
+			&i.DtUpdatedUtc,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVenues = `-- name: ListVenues :many
+
+SELECT venue_id, venue_name, address_first_line, address_second_line, city, postcode, venue_website, backline, venue_comments, venue_dt_updated_utc,
+    public.ST_X(geom)::float8 AS lon, public.ST_Y(geom)::float8 AS lat
+FROM london_jam_sessions.venues
+WHERE $1::int[] IS NULL OR venue_id = ANY($1::int[])
+ORDER BY venue_id
+LIMIT $3 OFFSET $2
+`
+
+type ListVenuesParams struct {
+	Ids        []int32 `json:"ids"`
+	Skip       int32   `json:"skip"`
+	MaxResults *int32  `json:"max_results"`
+}
+
+type ListVenuesRow struct {
+	VenueID           int32              `json:"venue_id"`
+	VenueName         string             `json:"venue_name"`
+	AddressFirstLine  string             `json:"address_first_line"`
+	AddressSecondLine *string            `json:"address_second_line"`
+	City              string             `json:"city"`
+	Postcode          string             `json:"postcode"`
+	VenueWebsite      *string            `json:"venue_website"`
+	Backline          []string           `json:"backline"`
+	VenueComments     []string           `json:"venue_comments"`
+	VenueDtUpdatedUtc pgtype.Timestamptz `json:"venue_dt_updated_utc"`
+	Lon               float64            `json:"lon"`
+	Lat               float64            `json:"lat"`
+}
+
+// GRAPHQL - the loaders of the GraphQL endpoint fetch the records of all objects on a level of the query at once
+// venues by ID (all venues if ids is null)
+func (q *Queries) ListVenues(ctx context.Context, arg ListVenuesParams) ([]ListVenuesRow, error) {
+	rows, err := q.db.Query(ctx, listVenues, arg.Ids, arg.Skip, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListVenuesRow
+	for rows.Next() {
+		var i ListVenuesRow
+		if err := rows.Scan(
+			&i.VenueID,
+			&i.VenueName,
+			&i.AddressFirstLine,
+			&i.AddressSecondLine,
+			&i.City,
+			&i.Postcode,
+			&i.VenueWebsite,
+			&i.Backline,
+			&i.VenueComments,
+			&i.VenueDtUpdatedUtc,
+			&i.Lon,
+			&i.Lat,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const moveSessionsToVenue = `-- name: MoveSessionsToVenue :execrows
+UPDATE london_jam_sessions.jamsessions
+SET venue = $1, dt_updated_utc = NOW() AT TIME ZONE 'utc'
+WHERE venue = $2
+`
+
+type MoveSessionsToVenueParams struct {
+	KeepVenue int32 `json:"keep_venue"`
+	DropVenue int32 `json:"drop_venue"`
+}
+
+func (q *Queries) MoveSessionsToVenue(ctx context.Context, arg MoveSessionsToVenueParams) (int64, error) {
+	result, err := q.db.Exec(ctx, moveSessionsToVenue, arg.KeepVenue, arg.DropVenue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordApiKeyUsage = `-- name: RecordApiKeyUsage :exec
+INSERT INTO london_jam_sessions.api_key_usage (key_id, day, request_count, dt_last_used)
+VALUES ($1, $2::timestamptz::date, $3, $2)
+ON CONFLICT (key_id, day) DO UPDATE SET
+    request_count = london_jam_sessions.api_key_usage.request_count + EXCLUDED.request_count,
+    dt_last_used = GREATEST(london_jam_sessions.api_key_usage.dt_last_used, EXCLUDED.dt_last_used)
+`
+
+type RecordApiKeyUsageParams struct {
+	KeyID        int32              `json:"key_id"`
+	LastUsed     pgtype.Timestamptz `json:"last_used"`
+	RequestCount int64              `json:"request_count"`
+}
+
+// adds the requests made with a key (counted by the API server) to the usage of the day
+func (q *Queries) RecordApiKeyUsage(ctx context.Context, arg RecordApiKeyUsageParams) error {
+	_, err := q.db.Exec(ctx, recordApiKeyUsage, arg.KeyID, arg.LastUsed, arg.RequestCount)
+	return err
+}
+
+const refreshSessionOccurrences = `-- name: RefreshSessionOccurrences :exec
+SELECT london_jam_sessions.refresh_session_occurrences($1::int[])
+`
+
+// recomputes the occurrences of the given sessions (all sessions if null) within the horizon
+func (q *Queries) RefreshSessionOccurrences(ctx context.Context, sessions []int32) error {
+	_, err := q.db.Exec(ctx, refreshSessionOccurrences, sessions)
+	return err
+}
+
+const repointCommentsOfDuplicateSessions = `-- name: RepointCommentsOfDuplicateSessions :execrows
+UPDATE london_jam_sessions.comments c
+SET session = k.session_id
+FROM london_jam_sessions.jamsessions dup
+JOIN london_jam_sessions.jamsessions k ON k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval
+WHERE dup.venue = $1 AND k.venue = $2 AND c.session = dup.session_id
+`
+
+type RepointCommentsOfDuplicateSessionsParams struct {
+	DropVenue int32 `json:"drop_venue"`
+	KeepVenue int32 `json:"keep_venue"`
+}
+
+// sessions of the dropped venue that also exist at the kept venue (same time and interval) are merged into the existing session
+func (q *Queries) RepointCommentsOfDuplicateSessions(ctx context.Context, arg RepointCommentsOfDuplicateSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, repointCommentsOfDuplicateSessions, arg.DropVenue, arg.KeepVenue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const repointRatingsOfDuplicateSessions = `-- name: RepointRatingsOfDuplicateSessions :execrows
+UPDATE london_jam_sessions.ratings r
+SET session = k.session_id
+FROM london_jam_sessions.jamsessions dup
+JOIN london_jam_sessions.jamsessions k ON k.start_time_utc = dup.start_time_utc AND k.interval = dup.interval
+WHERE dup.venue = $1 AND k.venue = $2 AND r.session = dup.session_id
+`
+
+type RepointRatingsOfDuplicateSessionsParams struct {
+	DropVenue int32 `json:"drop_venue"`
+	KeepVenue int32 `json:"keep_venue"`
+}
+
+func (q *Queries) RepointRatingsOfDuplicateSessions(ctx context.Context, arg RepointRatingsOfDuplicateSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, repointRatingsOfDuplicateSessions, arg.DropVenue, arg.KeepVenue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reserveRateLimitSlot = `-- name: ReserveRateLimitSlot :one
+INSERT INTO london_jam_sessions.rate_limits AS r (
+    name, next_slot
+) VALUES (
+    $1, clock_timestamp() + $2::interval
+)
+ON CONFLICT (name) DO UPDATE
+SET next_slot = greatest(r.next_slot, clock_timestamp()) + $2::interval
+RETURNING extract(epoch FROM r.next_slot - $2::interval - clock_timestamp())::float8 AS wait_seconds
+`
+
+type ReserveRateLimitSlotParams struct {
+	Name    string          `json:"name"`
+	Spacing pgtype.Interval `json:"spacing"`
+}
+
+// reserves the next free slot of the named rate limit and returns the number of seconds until the slot starts
+func (q *Queries) ReserveRateLimitSlot(ctx context.Context, arg ReserveRateLimitSlotParams) (float64, error) {
+	row := q.db.QueryRow(ctx, reserveRateLimitSlot, arg.Name, arg.Spacing)
+	var wait_seconds float64
+	err := row.Scan(&wait_seconds)
+	return wait_seconds, err
+}
+
+const resolveCommentReports = `-- name: ResolveCommentReports :execrows
+UPDATE london_jam_sessions.comment_reports
+SET dt_resolved = NOW() AT TIME ZONE 'utc'
+WHERE comment = $1 AND dt_resolved IS NULL
+`
+
+func (q *Queries) ResolveCommentReports(ctx context.Context, comment int32) (int64, error) {
+	result, err := q.db.Exec(ctx, resolveCommentReports, comment)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeApiKey = `-- name: RevokeApiKey :execrows
+UPDATE london_jam_sessions.api_keys SET dt_revoked = NOW() AT TIME ZONE 'utc'
+WHERE key_id = $1 AND dt_revoked IS NULL
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, keyID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeApiKey, keyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setOccurrencesHorizon = `-- name: SetOccurrencesHorizon :exec
+INSERT INTO london_jam_sessions.session_occurrences_horizon (start_date, end_date)
+VALUES ($1::date, $2::date)
+ON CONFLICT (id) DO UPDATE SET start_date = EXCLUDED.start_date, end_date = EXCLUDED.end_date
+`
+
+type SetOccurrencesHorizonParams struct {
+	StartDate pgtype.Date `json:"start_date"`
+	EndDate   pgtype.Date `json:"end_date"`
+}
+
+// moves the horizon of the materialised session occurrences, call RefreshSessionOccurrences afterwards
+func (q *Queries) SetOccurrencesHorizon(ctx context.Context, arg SetOccurrencesHorizonParams) error {
+	_, err := q.db.Exec(ctx, setOccurrencesHorizon, arg.StartDate, arg.EndDate)
+	return err
+}
+
+const setSavedSearchDigestSent = `-- name: SetSavedSearchDigestSent :exec
+UPDATE london_jam_sessions.saved_searches
+SET dt_last_digest = $1, last_session_id = (SELECT coalesce(max(session_id), 0) FROM london_jam_sessions.jamsessions)
+WHERE search_id = $2
+`
+
+type SetSavedSearchDigestSentParams struct {
+	DtLastDigest pgtype.Timestamptz `json:"dt_last_digest"`
+	SearchID     int32              `json:"search_id"`
+}
+
+// sessions that exist at this point are not new to the next digest
+func (q *Queries) SetSavedSearchDigestSent(ctx context.Context, arg SetSavedSearchDigestSentParams) error {
+	_, err := q.db.Exec(ctx, setSavedSearchDigestSent, arg.DtLastDigest, arg.SearchID)
+	return err
+}
+
+const setWebhookDeliveryResult = `-- name: SetWebhookDeliveryResult :exec
+UPDATE london_jam_sessions.webhook_deliveries
+SET status = $1, response_status = $2, last_error = $3,
+    next_attempt_at = $4, dt_delivered = $5
+WHERE delivery_id = $6
+`
+
+type SetWebhookDeliveryResultParams struct {
+	Status         string             `json:"status"`
+	ResponseStatus *int16             `json:"response_status"`
+	LastError      *string            `json:"last_error"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	DtDelivered    pgtype.Timestamptz `json:"dt_delivered"`
+	DeliveryID     int64              `json:"delivery_id"`
+}
+
+func (q *Queries) SetWebhookDeliveryResult(ctx context.Context, arg SetWebhookDeliveryResultParams) error {
+	_, err := q.db.Exec(ctx, setWebhookDeliveryResult,
+		arg.Status,
+		arg.ResponseStatus,
+		arg.LastError,
+		arg.NextAttemptAt,
+		arg.DtDelivered,
+		arg.DeliveryID,
+	)
+	return err
+}
+
+const updateCommentById = `-- name: UpdateCommentById :execrows
+UPDATE london_jam_sessions.comments
+SET author = coalesce($1, author),
+    content = coalesce($2, content),
+    status = coalesce($3, status),
+    spam_score = coalesce($4, spam_score)
+WHERE comment_id = $5
+`
+
+type UpdateCommentByIdParams struct {
+	Author    *string  `json:"author"`
+	Content   *string  `json:"content"`
+	Status    *string  `json:"status"`
+	SpamScore *float32 `json:"spam_score"`
+	CommentID int32    `json:"comment_id"`
+}
+
+// edit of the author (the previous version is kept by the track_comment_edits trigger)
+func (q *Queries) UpdateCommentById(ctx context.Context, arg UpdateCommentByIdParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateCommentById,
+		arg.Author,
+		arg.Content,
+		arg.Status,
+		arg.SpamScore,
+		arg.CommentID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateCommentStatus = `-- name: UpdateCommentStatus :execrows
+UPDATE london_jam_sessions.comments
+SET status = $1, dt_moderated = NOW() AT TIME ZONE 'utc'
+WHERE comment_id = $2
+`
+
+type UpdateCommentStatusParams struct {
+	Status    string `json:"status"`
+	CommentID int32  `json:"comment_id"`
+}
+
+func (q *Queries) UpdateCommentStatus(ctx context.Context, arg UpdateCommentStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateCommentStatus, arg.Status, arg.CommentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateJamSessionById = `-- name: UpdateJamSessionById :exec
 UPDATE london_jam_sessions.jamsessions
 SET
@@ -867,7 +3514,8 @@ SET
     start_time_utc = coalesce($5, start_time_utc),
     interval = coalesce($6, interval),
     duration_minutes = coalesce($7, duration_minutes),
-    session_website = coalesce($8, session_website)
+    session_website = coalesce($8, session_website),
+    dt_updated_utc = NOW() AT TIME ZONE 'utc'
 WHERE session_id = $1
 `
 
@@ -896,6 +3544,24 @@ func (q *Queries) UpdateJamSessionById(ctx context.Context, arg UpdateJamSession
 	return err
 }
 
+const updateUserRole = `-- name: UpdateUserRole :execrows
+UPDATE london_jam_sessions.users SET role = $1
+WHERE email = lower($2::text)
+`
+
+type UpdateUserRoleParams struct {
+	Role  string `json:"role"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserRole, arg.Role, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateVenueById = `-- name: UpdateVenueById :exec
 UPDATE london_jam_sessions.venues
 SET -- see https://docs.sqlc.dev/en/latest/howto/named_parameters.html#nullable-parameters
@@ -907,7 +3573,8 @@ SET -- see https://docs.sqlc.dev/en/latest/howto/named_parameters.html#nullable-
     geom = coalesce($7, geom),
     venue_website = coalesce($8, venue_website),
     backline = coalesce($9, backline),
-    venue_comments = coalesce($10, venue_comments)
+    venue_comments = coalesce($10, venue_comments),
+    venue_dt_updated_utc = NOW() AT TIME ZONE 'utc'
 WHERE venue_id = $1
 `
 
@@ -0,0 +1,44 @@
+package dbutils
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RateLimiter spaces out requests to an external service across processes. Every call to Wait
+// reserves the next free slot in the rate_limits table (the row lock serialises concurrent callers)
+// and blocks until the slot starts, so parallel dbcli runs share a single limit.
+type RateLimiter struct {
+	queries *Queries
+	name    string
+	spacing time.Duration
+}
+
+// NewRateLimiter returns a RateLimiter that allows one request per 'spacing' for the service identified by 'name'
+func NewRateLimiter(db DBTX, name string, spacing time.Duration) *RateLimiter {
+	return &RateLimiter{queries: New(db), name: name, spacing: spacing}
+}
+
+// Wait blocks until the next request may be made or the context is done
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	waitSeconds, err := r.queries.ReserveRateLimitSlot(ctx, ReserveRateLimitSlotParams{
+		Name:    r.name,
+		Spacing: pgtype.Interval{Microseconds: r.spacing.Microseconds(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if waitSeconds <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(waitSeconds * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,9 +1,20 @@
 CREATE EXTENSION postgis;
+CREATE EXTENSION pg_trgm; -- trigram similarity, used to detect duplicate venues
 
 -- create schema
 
 CREATE SCHEMA london_jam_sessions AUTHORIZATION postgres;
 
+-- TABLE london_jam_sessions.users
+-- accounts of users that have logged in with a magic link, identified by their email address
+
+CREATE TABLE london_jam_sessions.users (
+    user_id SERIAL PRIMARY KEY,
+    email VARCHAR(320) NOT NULL UNIQUE CHECK (email = lower(email)),
+    role VARCHAR(30) NOT NULL DEFAULT 'contributor' CHECK (role IN ('contributor', 'trusted_contributor', 'moderator', 'admin')), -- set with 'dbcli user role'
+    dt_created TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
 -- create london_jam_sessions.venues table
 
 CREATE TABLE london_jam_sessions.venues (
@@ -22,7 +33,20 @@ CREATE TABLE london_jam_sessions.venues (
 );
 -- create indices
 CREATE INDEX venues_venue_name_idx ON london_jam_sessions.venues (venue_name);
+CREATE INDEX venues_venue_name_trgm_idx ON london_jam_sessions.venues USING GIN (venue_name gin_trgm_ops);
 CREATE INDEX venues_backline_idx ON london_jam_sessions.venues USING GIN (backline);
+CREATE INDEX venues_venue_dt_updated_utc_idx ON london_jam_sessions.venues (venue_dt_updated_utc);
+
+-- normalises an address for comparison, e.g. '12, Frith Street' + 'W1D 4HT' and '12 frith st' + 'w1d4ht' are considered equal
+CREATE FUNCTION london_jam_sessions.normalise_address(first_line VARCHAR, postcode VARCHAR) RETURNS TEXT AS $$
+    SELECT regexp_replace(
+        regexp_replace(
+            regexp_replace(lower(first_line), '\mstreet\M', 'st', 'g'),
+            '\mroad\M', 'rd', 'g'
+        ) || lower(postcode),
+        '[^a-z0-9]', '', 'g'
+    );
+$$ LANGUAGE sql IMMUTABLE;
 
 -- trigger to propagate dt_updated to london_jam_sessions.jamsessions table
 -- every time the london_jam_sessions.venues table is updated, the timestamp of the corresponding sessions is updated too
@@ -53,6 +77,92 @@ CREATE TABLE london_jam_sessions.jamsessions (
 );
 -- create indices
 CREATE INDEX jamsessions_venue_fkey_idx ON london_jam_sessions.jamsessions (venue);
+CREATE INDEX jamsessions_dt_updated_utc_idx ON london_jam_sessions.jamsessions (dt_updated_utc); -- used to compute ETags/Last-Modified
+
+-- TABLE london_jam_sessions.venue_owners
+-- users that run a venue (or its sessions), their edits of the venue and its sessions are applied without review
+
+CREATE TABLE london_jam_sessions.venue_owners (
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    venue INTEGER NOT NULL REFERENCES london_jam_sessions.venues(venue_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, venue)
+);
+
+-- TABLE london_jam_sessions.favourites
+-- sessions bookmarked by users (GET /v1/me/favourites and their calendar feed)
+
+CREATE TABLE london_jam_sessions.favourites (
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    session INTEGER NOT NULL REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE CASCADE,
+    dt_added TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    PRIMARY KEY (user_id, session)
+);
+CREATE INDEX favourites_session_fkey_idx ON london_jam_sessions.favourites (session);
+
+-- TABLE london_jam_sessions.follows
+-- sessions and venues followed by users, followers are notified when an approved change touches them
+
+CREATE TABLE london_jam_sessions.follows (
+    follow_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    session INTEGER REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE CASCADE,
+    venue INTEGER REFERENCES london_jam_sessions.venues(venue_id) ON DELETE CASCADE,
+    dt_added TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    CHECK ((session IS NULL) <> (venue IS NULL)), -- either a session or a venue
+    UNIQUE NULLS NOT DISTINCT (user_id, session, venue)
+);
+CREATE INDEX follows_session_fkey_idx ON london_jam_sessions.follows (session);
+CREATE INDEX follows_venue_fkey_idx ON london_jam_sessions.follows (venue);
+
+-- TABLE london_jam_sessions.notification_channels
+-- where the notifications of a user are delivered (users without channels are notified by email)
+
+CREATE TABLE london_jam_sessions.notification_channels (
+    channel_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('email', 'webpush', 'webhook')),
+    endpoint VARCHAR(2000), -- push service URL or webhook URL (emails go to the address of the account)
+    p256dh TEXT, -- public key of the push subscription
+    auth_secret TEXT, -- authentication secret of the push subscription
+    dt_created TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    CHECK ((kind = 'email') = (endpoint IS NULL)),
+    CHECK (kind <> 'webpush' OR (p256dh IS NOT NULL AND auth_secret IS NOT NULL)),
+    UNIQUE NULLS NOT DISTINCT (user_id, kind, endpoint)
+);
+
+-- TABLE london_jam_sessions.saved_searches
+-- session filters saved by users, 'dbcli digest send' emails them a weekly digest of the matching sessions
+
+CREATE TABLE london_jam_sessions.saved_searches (
+    search_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    search_name VARCHAR(100) NOT NULL,
+    genres VARCHAR(50)[], -- sessions have all of these genres (null: any genre)
+    backline VARCHAR(50)[], -- venues provide all of this backline (null: any backline)
+    weekdays SMALLINT[] CHECK (weekdays <@ ARRAY[1, 2, 3, 4, 5, 6, 7]::SMALLINT[]), -- ISO weekdays (1 = Monday, null: any day)
+    lon FLOAT8, -- centre of the area (null: anywhere)
+    lat FLOAT8,
+    radius_metres INTEGER CHECK (radius_metres > 0),
+    last_session_id INTEGER NOT NULL DEFAULT 0, -- sessions with a higher ID are new to the next digest
+    dt_created TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    dt_last_digest TIMESTAMPTZ,
+    CHECK ((lon IS NULL) = (lat IS NULL) AND (lon IS NULL) = (radius_metres IS NULL)),
+    UNIQUE (user_id, search_name)
+);
+
+-- TABLE london_jam_sessions.user_contributions
+-- sessions and venues submitted or edited by logged in users (kept out of the session/venue tables so that
+-- they don't end up in the GeoJSON responses)
+
+CREATE TABLE london_jam_sessions.user_contributions (
+    contribution_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES london_jam_sessions.users(user_id) ON DELETE CASCADE,
+    session INTEGER REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE SET NULL,
+    venue INTEGER REFERENCES london_jam_sessions.venues(venue_id) ON DELETE SET NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('insert', 'update')),
+    dt_contributed TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+CREATE INDEX user_contributions_user_id_fkey_idx ON london_jam_sessions.user_contributions (user_id);
 
 -- TABLE london_jam_sessions.comments
 
@@ -61,10 +171,82 @@ CREATE TABLE london_jam_sessions.comments (
     session INTEGER NOT NULL REFERENCES london_jam_sessions.jamsessions(session_id),
     author VARCHAR(200) NOT NULL,
     content TEXT NOT NULL,
-    dt_posted TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+    dt_posted TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    parent_comment INTEGER, -- set for replies, the parent must belong to the same session
+    verified BOOLEAN NOT NULL DEFAULT FALSE, -- posted by the verified organiser or venue of the session
+    status VARCHAR(10) NOT NULL DEFAULT 'visible' CHECK (status IN ('visible', 'hidden', 'pending')), -- only visible comments are public, pending ones are held for moderation
+    spam_score REAL, -- score assigned by the spam scorer of the API server on submission
+    dt_moderated TIMESTAMPTZ, -- last change of the status
+    edit_token_hash VARCHAR(64), -- SHA-256 hash of the secret token that lets the author edit or delete the comment
+    user_id INTEGER REFERENCES london_jam_sessions.users(user_id) ON DELETE SET NULL, -- set if the author was logged in
+    dt_edited TIMESTAMPTZ, -- last edit of the author
+    UNIQUE (comment_id, session),
+    -- replies to a deleted comment become top-level comments
+    FOREIGN KEY (parent_comment, session) REFERENCES london_jam_sessions.comments(comment_id, session) ON DELETE SET NULL (parent_comment)
 );
 -- create indices
 CREATE INDEX comments_session_fkey_idx ON london_jam_sessions.comments (session);
+CREATE INDEX comments_parent_comment_fkey_idx ON london_jam_sessions.comments (parent_comment);
+CREATE INDEX comments_dt_moderated_idx ON london_jam_sessions.comments (dt_moderated);
+CREATE INDEX comments_dt_posted_idx ON london_jam_sessions.comments (dt_posted);
+CREATE INDEX comments_dt_edited_idx ON london_jam_sessions.comments (dt_edited);
+CREATE INDEX comments_edit_token_hash_idx ON london_jam_sessions.comments (edit_token_hash); -- comments are edited by their token
+
+-- TABLE london_jam_sessions.comment_edits
+-- previous versions of edited comments, recorded by the track_comment_edits trigger
+
+CREATE TABLE london_jam_sessions.comment_edits (
+    edit_id SERIAL PRIMARY KEY,
+    comment INTEGER NOT NULL REFERENCES london_jam_sessions.comments(comment_id) ON DELETE CASCADE,
+    author VARCHAR(200) NOT NULL,
+    content TEXT NOT NULL,
+    dt_edited TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+CREATE INDEX comment_edits_comment_fkey_idx ON london_jam_sessions.comment_edits (comment);
+
+-- keeps the previous author and content when a comment is edited and sets dt_edited
+CREATE FUNCTION london_jam_sessions.track_comment_edits() RETURNS trigger AS $$
+    BEGIN
+        IF NEW.content IS DISTINCT FROM OLD.content OR NEW.author IS DISTINCT FROM OLD.author THEN
+            INSERT INTO london_jam_sessions.comment_edits (comment, author, content) VALUES (OLD.comment_id, OLD.author, OLD.content);
+            NEW.dt_edited = NOW() AT TIME ZONE 'utc';
+        END IF;
+        RETURN NEW;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER track_comment_edits BEFORE UPDATE OF author, content ON london_jam_sessions.comments
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.track_comment_edits();
+
+-- TABLE london_jam_sessions.comment_reports
+-- comments reported by users, reports are resolved when the status of the comment is set by an admin
+
+CREATE TABLE london_jam_sessions.comment_reports (
+    report_id SERIAL PRIMARY KEY,
+    comment INTEGER NOT NULL REFERENCES london_jam_sessions.comments(comment_id) ON DELETE CASCADE,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('spam', 'offensive', 'off_topic', 'other')),
+    details TEXT,
+    reporter_hash VARCHAR(64), -- salted hash of the IP address of the reporter
+    dt_reported TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    dt_resolved TIMESTAMPTZ
+);
+CREATE INDEX comment_reports_comment_fkey_idx ON london_jam_sessions.comment_reports (comment);
+
+-- visible comments with open reports from 3 different reporters are held until an admin reviews them
+CREATE FUNCTION london_jam_sessions.hold_reported_comment() RETURNS trigger AS $$
+    BEGIN
+        UPDATE london_jam_sessions.comments c
+        SET status = 'pending', dt_moderated = NOW() AT TIME ZONE 'utc'
+        WHERE c.comment_id = NEW.comment AND c.status = 'visible' AND (
+            SELECT count(DISTINCT coalesce(r.reporter_hash, r.report_id::text)) FROM london_jam_sessions.comment_reports r
+            WHERE r.comment = NEW.comment AND r.dt_resolved IS NULL
+        ) >= 3;
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER hold_reported_comment AFTER INSERT ON london_jam_sessions.comment_reports
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.hold_reported_comment();
 
 -- TABLE london_jam_sessions.ratings
 
@@ -73,11 +255,257 @@ CREATE TABLE london_jam_sessions.ratings (
     session INTEGER NOT NULL REFERENCES london_jam_sessions.jamsessions(session_id),
     comment INTEGER REFERENCES london_jam_sessions.comments(comment_id), --optionally link to comment
     rating SMALLINT CHECK(rating < 6 AND rating > 0), -- 1 to 5
-    dt_posted TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+    -- optional ratings (1 to 5) of individual aspects, the names must match the aspects in aspect_rating_aggregates
+    house_band SMALLINT CHECK(house_band < 6 AND house_band > 0),
+    backline SMALLINT CHECK(backline < 6 AND backline > 0),
+    beginner_friendliness SMALLINT CHECK(beginner_friendliness < 6 AND beginner_friendliness > 0),
+    sound SMALLINT CHECK(sound < 6 AND sound > 0),
+    crowd SMALLINT CHECK(crowd < 6 AND crowd > 0),
+    dt_posted TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    user_id INTEGER REFERENCES london_jam_sessions.users(user_id) ON DELETE SET NULL -- set if the author was logged in
 );
 -- create indices
 CREATE INDEX ratings_session_fkey_idx ON london_jam_sessions.ratings (session);
 CREATE INDEX ratings_comment_fkey_idx ON london_jam_sessions.ratings (comment);
+CREATE INDEX ratings_dt_posted_idx ON london_jam_sessions.ratings (dt_posted);
+
+-- TABLE london_jam_sessions.rating_aggregates
+-- number, sum and histogram of the ratings of a session, maintained by the update_rating_aggregates trigger
+-- (sessions without ratings have no row). Exposed through the session_rating_stats view.
+
+CREATE TABLE london_jam_sessions.rating_aggregates (
+    session INTEGER PRIMARY KEY REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE CASCADE,
+    rating_count INTEGER NOT NULL DEFAULT 0,
+    rating_sum INTEGER NOT NULL DEFAULT 0,
+    rating_histogram INTEGER[] NOT NULL DEFAULT '{0,0,0,0,0}' -- number of 1 to 5 star ratings
+);
+
+-- same for the ratings of individual aspects (one row per session and rated aspect)
+CREATE TABLE london_jam_sessions.aspect_rating_aggregates (
+    session INTEGER NOT NULL REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE CASCADE,
+    aspect VARCHAR(50) NOT NULL CHECK (aspect IN ('house_band', 'backline', 'beginner_friendliness', 'sound', 'crowd')),
+    rating_count INTEGER NOT NULL DEFAULT 0,
+    rating_sum INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (session, aspect)
+);
+
+CREATE FUNCTION london_jam_sessions.update_rating_aggregates() RETURNS trigger AS $$
+    BEGIN
+        IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.rating IS NOT NULL THEN
+            UPDATE london_jam_sessions.rating_aggregates a SET
+                rating_count = a.rating_count - 1,
+                rating_sum = a.rating_sum - OLD.rating,
+                rating_histogram[OLD.rating] = a.rating_histogram[OLD.rating] - 1
+            WHERE a.session = OLD.session;
+        END IF;
+        IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.rating IS NOT NULL THEN
+            INSERT INTO london_jam_sessions.rating_aggregates AS a (session, rating_count, rating_sum, rating_histogram)
+            VALUES (NEW.session, 1, NEW.rating, ARRAY(SELECT (i = NEW.rating)::int FROM generate_series(1, 5) i))
+            ON CONFLICT (session) DO UPDATE SET
+                rating_count = a.rating_count + 1,
+                rating_sum = a.rating_sum + NEW.rating,
+                rating_histogram[NEW.rating] = a.rating_histogram[NEW.rating] + 1;
+        END IF;
+        -- aspects are the rating columns named in the check constraint of aspect_rating_aggregates
+        IF TG_OP IN ('UPDATE', 'DELETE') THEN
+            UPDATE london_jam_sessions.aspect_rating_aggregates a SET
+                rating_count = a.rating_count - 1,
+                rating_sum = a.rating_sum - o.value::int
+            FROM jsonb_each_text(to_jsonb(OLD)) o
+            WHERE a.session = OLD.session AND a.aspect = o.key AND o.value IS NOT NULL;
+        END IF;
+        IF TG_OP IN ('INSERT', 'UPDATE') THEN
+            INSERT INTO london_jam_sessions.aspect_rating_aggregates AS a (session, aspect, rating_count, rating_sum)
+            SELECT NEW.session, n.key, 1, n.value::int FROM jsonb_each_text(to_jsonb(NEW)) n
+            WHERE n.key IN ('house_band', 'backline', 'beginner_friendliness', 'sound', 'crowd') AND n.value IS NOT NULL
+            ON CONFLICT (session, aspect) DO UPDATE SET
+                rating_count = a.rating_count + 1,
+                rating_sum = a.rating_sum + EXCLUDED.rating_sum;
+        END IF;
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_rating_aggregates AFTER INSERT OR UPDATE OR DELETE ON london_jam_sessions.ratings
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.update_rating_aggregates();
+
+-- VIEW london_jam_sessions.session_rating_stats
+-- rating statistics of all sessions. rating is the plain average (null without ratings), rating_score the
+-- bayesian average that pulls the average towards the mean of all ratings as if every session had received
+-- 5 additional ratings of that mean - a single 5 star rating doesn't outrank fifty 4.8s and unrated sessions
+-- are ranked in the middle. aspect_ratings holds the average and number of ratings of each rated aspect.
+
+CREATE VIEW london_jam_sessions.session_rating_stats AS
+WITH prior AS (
+    SELECT coalesce(sum(rating_sum)::numeric / nullif(sum(rating_count), 0), 3) AS mean, 5 AS weight
+    FROM london_jam_sessions.rating_aggregates
+)
+SELECT
+    s.session_id AS session,
+    round(a.rating_sum::numeric / nullif(a.rating_count, 0), 2)::real AS rating,
+    coalesce(a.rating_count, 0) AS rating_count,
+    coalesce(a.rating_histogram, '{0,0,0,0,0}') AS rating_histogram,
+    round((p.weight * p.mean + coalesce(a.rating_sum, 0)) / (p.weight + coalesce(a.rating_count, 0)), 2)::real AS rating_score,
+    (
+        SELECT jsonb_object_agg(aa.aspect, jsonb_build_object(
+            'rating', round(aa.rating_sum::numeric / aa.rating_count, 2),
+            'rating_count', aa.rating_count
+        ))
+        FROM london_jam_sessions.aspect_rating_aggregates aa
+        WHERE aa.session = s.session_id AND aa.rating_count > 0
+    ) AS aspect_ratings
+FROM london_jam_sessions.jamsessions s
+LEFT OUTER JOIN london_jam_sessions.rating_aggregates a ON a.session = s.session_id
+CROSS JOIN prior p;
+
+-- TABLE london_jam_sessions.api_keys
+-- keys of third-party consumers of the API (created with 'dbcli apikey create'), only the SHA-256 hash of a key is stored
+
+CREATE TABLE london_jam_sessions.api_keys (
+    key_id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL, -- beginning of the key, to tell keys apart
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    rate_limit INTEGER NOT NULL CHECK (rate_limit > 0), -- requests per minute
+    dt_created TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc'),
+    dt_revoked TIMESTAMPTZ
+);
+
+-- TABLE london_jam_sessions.api_key_usage
+-- number of requests made with a key per day, written by the API server (see add-api-usage-writer.sh)
+
+CREATE TABLE london_jam_sessions.api_key_usage (
+    key_id INTEGER NOT NULL REFERENCES london_jam_sessions.api_keys(key_id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    dt_last_used TIMESTAMPTZ,
+    PRIMARY KEY (key_id, day)
+);
+
+-- TABLE london_jam_sessions.webhook_subscriptions
+-- endpoints of third parties that are sent signed event payloads when sessions or venues change (managed via the admin API)
+
+CREATE TABLE london_jam_sessions.webhook_subscriptions (
+    subscription_id SERIAL PRIMARY KEY,
+    url VARCHAR(2000) NOT NULL,
+    secret TEXT NOT NULL, -- key of the HMAC signature of the payloads
+    event_types VARCHAR(50)[] NOT NULL CHECK (
+        cardinality(event_types) > 0 AND
+        event_types <@ ARRAY['session.created', 'session.updated', 'session.deleted', 'venue.created', 'venue.updated', 'venue.deleted']::VARCHAR(50)[]
+    ),
+    description VARCHAR(255),
+    dt_created TIMESTAMPTZ DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+-- TABLE london_jam_sessions.webhook_deliveries
+-- queue and log of the events sent to the subscriptions, 'dbcli webhook deliver' sends the pending deliveries
+-- and retries failed attempts with an exponential backoff
+
+CREATE TABLE london_jam_sessions.webhook_deliveries (
+    delivery_id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES london_jam_sessions.webhook_subscriptions(subscription_id) ON DELETE CASCADE,
+    event_id VARCHAR(50) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts SMALLINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'),
+    response_status SMALLINT, -- HTTP status of the last attempt
+    last_error TEXT,
+    dt_created TIMESTAMPTZ NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'),
+    dt_delivered TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON london_jam_sessions.webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_subscription_idx ON london_jam_sessions.webhook_deliveries (subscription_id, delivery_id DESC);
+
+-- TABLE london_jam_sessions.rate_limits
+-- used to share rate limits for external services (e.g. Nominatim) between processes,
+-- next_slot is the earliest time the next request may be made
+
+CREATE TABLE london_jam_sessions.rate_limits (
+    name VARCHAR(100) PRIMARY KEY,
+    next_slot TIMESTAMPTZ NOT NULL
+);
+
+-- notify listeners (e.g. the response cache of the API server) about changes to the tables exposed by the API,
+-- notifications are delivered on commit and identical payloads within a transaction are only sent once
+
+CREATE FUNCTION london_jam_sessions.notify_data_changed() RETURNS trigger AS $$
+    BEGIN
+        PERFORM pg_notify('data_changed', json_build_object('table', TG_TABLE_NAME, 'operation', TG_OP)::text);
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_data_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON london_jam_sessions.venues
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.notify_data_changed();
+CREATE TRIGGER notify_data_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON london_jam_sessions.jamsessions
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.notify_data_changed();
+CREATE TRIGGER notify_data_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON london_jam_sessions.comments
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.notify_data_changed();
+CREATE TRIGGER notify_data_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON london_jam_sessions.ratings
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.notify_data_changed();
+
+-- TABLE london_jam_sessions.events
+-- log of the applied changes streamed by the API server (GET /v1/events), clients resume after the last event
+-- they received. Written by the record_event triggers, 'dbcli events prune' removes old events
+
+CREATE TABLE london_jam_sessions.events (
+    event_id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL, -- session.created, session.updated, session.deleted, venue.* or comment.created
+    data JSONB NOT NULL,
+    dt_created TIMESTAMPTZ NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+CREATE INDEX events_dt_created_idx ON london_jam_sessions.events (dt_created);
+
+-- the notification carries the whole event so that the API server doesn't have to query it,
+-- updates that only touch the timestamps (see update_timestamp_venue) are not recorded
+
+CREATE FUNCTION london_jam_sessions.record_event() RETURNS trigger AS $$
+    DECLARE
+        ignored TEXT[] := ARRAY['dt_updated_utc', 'venue_dt_updated_utc'];
+        r JSONB;
+        evt london_jam_sessions.events;
+    BEGIN
+        IF TG_OP = 'DELETE' THEN
+            r := to_jsonb(OLD);
+        ELSE
+            r := to_jsonb(NEW);
+        END IF;
+        IF TG_TABLE_NAME = 'comments' THEN
+            -- comments are public once they are visible, either on submission or when a moderator publishes them
+            IF NEW.status <> 'visible' OR (TG_OP = 'UPDATE' AND OLD.status = 'visible') THEN
+                RETURN NULL;
+            END IF;
+            evt.event_type := 'comment.created';
+            evt.data := jsonb_build_object('comment_id', r->'comment_id', 'session_id', r->'session', 'parent_comment', r->'parent_comment');
+        ELSE
+            IF TG_OP = 'UPDATE' AND to_jsonb(OLD) - ignored = r - ignored THEN
+                RETURN NULL;
+            END IF;
+            IF TG_TABLE_NAME = 'jamsessions' THEN
+                evt.event_type := 'session';
+                evt.data := jsonb_build_object('session_id', r->'session_id', 'session_name', r->'session_name', 'venue_id', r->'venue');
+            ELSE
+                evt.event_type := 'venue';
+                evt.data := jsonb_build_object('venue_id', r->'venue_id', 'venue_name', r->'venue_name');
+            END IF;
+            evt.event_type := evt.event_type || CASE TG_OP WHEN 'INSERT' THEN '.created' WHEN 'UPDATE' THEN '.updated' ELSE '.deleted' END;
+        END IF;
+        INSERT INTO london_jam_sessions.events (event_type, data) VALUES (evt.event_type, evt.data)
+        RETURNING * INTO evt;
+        PERFORM pg_notify('events', json_build_object('id', evt.event_id, 'type', evt.event_type, 'time', evt.dt_created, 'data', evt.data)::text);
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_event AFTER INSERT OR UPDATE OR DELETE ON london_jam_sessions.jamsessions
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.record_event();
+CREATE TRIGGER record_event AFTER INSERT OR UPDATE OR DELETE ON london_jam_sessions.venues
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.record_event();
+CREATE TRIGGER record_event AFTER INSERT OR UPDATE OF status ON london_jam_sessions.comments
+    FOR EACH ROW EXECUTE FUNCTION london_jam_sessions.record_event();
 
 -- create funcs to get session matches by date (range), used in queries
 CREATE OR REPLACE FUNCTION london_jam_sessions.sessions_in_date_range(start date, stop date) 
@@ -175,4 +603,78 @@ AS $$
             )
         );
     END;
-$$ LANGUAGE plpgsql;
\ No newline at end of file
+$$ LANGUAGE plpgsql;
+
+-- TABLE london_jam_sessions.session_occurrences
+-- dates on which the sessions take place, materialised from their schedules (see sessions_in_date_range)
+-- for the horizon stored in session_occurrences_horizon (a single row). The occurrences of a session are
+-- refreshed whenever it is inserted or updated, 'dbcli occurrences refresh' moves the horizon forward and
+-- should be run daily.
+
+CREATE TABLE london_jam_sessions.session_occurrences (
+    session INTEGER NOT NULL REFERENCES london_jam_sessions.jamsessions(session_id) ON DELETE CASCADE,
+    d DATE NOT NULL,
+    PRIMARY KEY (d, session)
+);
+CREATE INDEX session_occurrences_session_idx ON london_jam_sessions.session_occurrences (session);
+
+CREATE TABLE london_jam_sessions.session_occurrences_horizon (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), -- only one row
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL CHECK (end_date >= start_date),
+    dt_refreshed_utc TIMESTAMPTZ NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+-- recomputes the occurrences of the given sessions (all sessions if null) within the horizon
+CREATE OR REPLACE FUNCTION london_jam_sessions.refresh_session_occurrences(sessions int[] DEFAULT NULL)
+RETURNS void
+AS $$
+    DECLARE
+        h london_jam_sessions.session_occurrences_horizon;
+    BEGIN
+        SELECT * INTO h FROM london_jam_sessions.session_occurrences_horizon;
+        IF NOT FOUND THEN
+            RETURN; -- nothing is materialised yet
+        END IF;
+        DELETE FROM london_jam_sessions.session_occurrences o WHERE sessions IS NULL OR o.session = ANY(sessions);
+        INSERT INTO london_jam_sessions.session_occurrences (session, d)
+        SELECT r.session_id, unnest(r.dates) FROM london_jam_sessions.sessions_in_date_range(h.start_date, h.end_date) r
+        WHERE sessions IS NULL OR r.session_id = ANY(sessions);
+        UPDATE london_jam_sessions.session_occurrences_horizon SET dt_refreshed_utc = NOW() AT TIME ZONE 'utc';
+    END;
+$$ LANGUAGE plpgsql;
+
+-- trigger to keep the occurrences of inserted/updated sessions up to date
+CREATE FUNCTION london_jam_sessions.refresh_changed_session_occurrences() RETURNS trigger AS $$
+    BEGIN
+        PERFORM london_jam_sessions.refresh_session_occurrences(ARRAY(SELECT session_id FROM changed_sessions));
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER refresh_session_occurrences_insert AFTER INSERT ON london_jam_sessions.jamsessions
+    REFERENCING NEW TABLE AS changed_sessions
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.refresh_changed_session_occurrences();
+CREATE TRIGGER refresh_session_occurrences_update AFTER UPDATE ON london_jam_sessions.jamsessions
+    REFERENCING NEW TABLE AS changed_sessions
+    FOR EACH STATEMENT EXECUTE FUNCTION london_jam_sessions.refresh_changed_session_occurrences();
+
+-- same result as sessions_in_date_range, served from session_occurrences if the horizon covers the date range
+CREATE OR REPLACE FUNCTION london_jam_sessions.session_dates(start date, stop date)
+RETURNS TABLE (session_id int, dates date[])
+AS $$
+    BEGIN
+        IF EXISTS (
+            SELECT 1 FROM london_jam_sessions.session_occurrences_horizon h
+            WHERE h.start_date <= start AND h.end_date >= stop
+        ) THEN
+            RETURN QUERY
+            SELECT o.session, array_agg(o.d ORDER BY o.d) FROM london_jam_sessions.session_occurrences o
+            WHERE o.d BETWEEN start AND stop
+            GROUP BY o.session;
+        ELSE
+            RETURN QUERY
+            SELECT r.session_id, r.dates FROM london_jam_sessions.sessions_in_date_range(start, stop) r;
+        END IF;
+    END;
+$$ LANGUAGE plpgsql STABLE;
@@ -0,0 +1,20 @@
+#!/bin/env bash
+
+# The API server (read_only) writes the usage of API keys as api_usage_writer. The membership doesn't inherit the
+# privileges of the role, the server switches to it for these writes only (SET LOCAL ROLE api_usage_writer).
+# Runs on startup of new databases and can be run again, existing databases are upgraded with
+# docker exec jamsessions_prod_db bash /docker-entrypoint-initdb.d/003_api_usage_writer.sh
+psql -v ON_ERROR_STOP=1 -U postgres -d $POSTGRES_DB <<-END
+DO \$\$
+BEGIN
+    CREATE ROLE api_usage_writer NOLOGIN;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END \$\$;
+
+GRANT USAGE ON SCHEMA ${POSTGRES_DB} TO api_usage_writer;
+GRANT SELECT, INSERT, UPDATE ON ${POSTGRES_DB}.api_key_usage TO api_usage_writer;
+GRANT api_usage_writer TO read_only, read_write WITH INHERIT FALSE;
+
+-- granted directly to read_only by previous versions
+REVOKE INSERT, UPDATE ON ${POSTGRES_DB}.api_key_usage FROM read_only;
+END
@@ -0,0 +1,115 @@
+// Package digest renders the weekly digest emails of saved searches
+package digest
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var funcs = map[string]any{
+	"date":  func(t time.Time) string { return t.Format("Mon 2 Jan") },
+	"clock": func(t time.Time) string { return t.UTC().Format("15:04") },
+}
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt.tmpl").Funcs(funcs).ParseFS(templates, "templates/digest.txt.tmpl"))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html.tmpl").Funcs(funcs).ParseFS(templates, "templates/digest.html.tmpl"))
+)
+
+// Session is a session matching the saved search
+type Session struct {
+	Name      string
+	VenueName string
+	URL       string
+	// start of the first occurrence (the time of day applies to all dates)
+	Start           time.Time
+	DurationMinutes int16
+	// dates within the period of the digest
+	Dates []time.Time
+}
+
+// Digest lists the sessions matching a saved search that take place in the coming days and the sessions that have
+// been added since the last digest
+type Digest struct {
+	SearchName string
+	// human readable description of the filters of the search
+	Filters  string
+	From     time.Time
+	To       time.Time
+	Upcoming []Session
+	New      []Session
+	// link to the website
+	URL string
+}
+
+// Empty reports whether there is nothing to tell (empty digests aren't sent)
+func (d Digest) Empty() bool {
+	return len(d.Upcoming) == 0 && len(d.New) == 0
+}
+
+// Subject of the email
+func (d Digest) Subject() string {
+	var parts []string
+	if n := len(d.Upcoming); n > 0 {
+		parts = append(parts, plural(n, "upcoming session"))
+	}
+	if n := len(d.New); n > 0 {
+		parts = append(parts, plural(n, "new session"))
+	}
+	return fmt.Sprintf("%v: %v", d.SearchName, strings.Join(parts, " and "))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %v", noun)
+	}
+	return fmt.Sprintf("%v %vs", n, noun)
+}
+
+// Message renders the digest as an email with a plain text and an HTML version
+func (d Digest) Message(to string) (mail.Message, error) {
+	var text, html bytes.Buffer
+	if err := textTemplate.Execute(&text, d); err != nil {
+		return mail.Message{}, err
+	}
+	if err := htmlTemplate.Execute(&html, d); err != nil {
+		return mail.Message{}, err
+	}
+	return mail.Message{To: to, Subject: d.Subject(), Text: text.String(), HTML: html.String()}, nil
+}
+
+// Filters describes the filters of a saved search, e.g. "Blues, Funk · backline: PA, Drums · Mondays · within 2 km"
+func Filters(genres []string, backline []string, weekdays []int16, radiusMetres *int32) string {
+	var parts []string
+	if len(genres) > 0 {
+		parts = append(parts, strings.ReplaceAll(strings.Join(genres, ", "), "_", " "))
+	}
+	if len(backline) > 0 {
+		parts = append(parts, "backline: "+strings.ReplaceAll(strings.Join(backline, ", "), "_", " "))
+	}
+	if len(weekdays) > 0 {
+		days := make([]string, len(weekdays))
+		for i, d := range weekdays {
+			days[i] = time.Weekday(d%7).String() + "s" // ISO weekday, 7 is Sunday
+		}
+		parts = append(parts, strings.Join(days, ", "))
+	}
+	if radiusMetres != nil {
+		if *radiusMetres%1000 == 0 {
+			parts = append(parts, fmt.Sprintf("within %v km", *radiusMetres/1000))
+		} else {
+			parts = append(parts, fmt.Sprintf("within %v m", *radiusMetres))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,77 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var digest = Digest{
+	SearchName: "Blues <nearby>",
+	Filters:    "Blues · within 2 km",
+	From:       time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+	To:         time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC),
+	Upcoming: []Session{{
+		Name:            "Monday Blues Jam",
+		VenueName:       "The Pub",
+		URL:             "https://example.org/the-pub-2/monday-blues-jam-5",
+		Start:           time.Date(2024, 1, 1, 19, 30, 0, 0, time.UTC),
+		DurationMinutes: 120,
+		Dates:           []time.Time{time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)},
+	}},
+	New: []Session{{
+		Name:      "Blues & Soul Night",
+		VenueName: "The Club",
+		URL:       "https://example.org/the-club-3/blues--soul-night-9",
+		Start:     time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC),
+	}},
+	URL: "https://example.org",
+}
+
+func TestMessage(t *testing.T) {
+	m, err := digest.Message("player@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if m.To != "player@example.org" || m.Subject != "Blues <nearby>: 1 upcoming session and 1 new session" {
+		t.Errorf("unexpected recipient or subject: %v, %v", m.To, m.Subject)
+	}
+	for _, expected := range []string{
+		`Your weekly digest for "Blues <nearby>" (Blues · within 2 km)`,
+		"Upcoming sessions (Mon 6 May to Sun 12 May):",
+		"* Monday Blues Jam at The Pub, 19:30 (UTC) for 120 minutes\n  Mon 6 May, Mon 13 May\n  https://example.org/the-pub-2/monday-blues-jam-5",
+		"New sessions:\n\n* Blues & Soul Night at The Club, starting Sat 1 Jun 20:00 (UTC)",
+	} {
+		if !strings.Contains(m.Text, expected) {
+			t.Errorf("expected the text to contain %q, got %q", expected, m.Text)
+		}
+	}
+	for _, expected := range []string{
+		"Your weekly digest for &ldquo;Blues &lt;nearby&gt;&rdquo;", // escaped
+		`<a href="https://example.org/the-pub-2/monday-blues-jam-5">Monday Blues Jam</a> at The Pub`,
+		"Blues &amp; Soul Night",
+	} {
+		if !strings.Contains(m.HTML, expected) {
+			t.Errorf("expected the HTML to contain %q, got %q", expected, m.HTML)
+		}
+	}
+
+	onlyNew := digest
+	onlyNew.Upcoming = nil
+	if m, err := onlyNew.Message("player@example.org"); err != nil || strings.Contains(m.Text, "Upcoming") || m.Subject != "Blues <nearby>: 1 new session" {
+		t.Errorf("expected only the new sessions, got %q (err: %v)", m.Text, err)
+	}
+	if (Digest{SearchName: "Nothing"}).Empty() != true || digest.Empty() {
+		t.Error("expected only digests without sessions to be empty")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	radius := int32(2000)
+	if f := Filters([]string{"Straight-Ahead_Jazz", "Blues"}, []string{"PA", "Guitar_Amp"}, []int16{1, 7}, &radius); f != "Straight-Ahead Jazz, Blues · backline: PA, Guitar Amp · Mondays, Sundays · within 2 km" {
+		t.Errorf("unexpected description %q", f)
+	}
+	if f := Filters(nil, nil, nil, nil); f != "" {
+		t.Errorf("expected an empty description, got %q", f)
+	}
+}
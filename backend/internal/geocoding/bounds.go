@@ -0,0 +1,64 @@
+package geocoding
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	geom "github.com/twpayne/go-geom"
+)
+
+// mean radius of the earth in metres
+const earthRadius = 6371008.8
+
+// Bounds is a bounding box in WGS84 coordinates
+type Bounds struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// LondonBounds roughly covers Greater London
+var LondonBounds = Bounds{MinLon: -0.5103, MinLat: 51.2868, MaxLon: 0.3340, MaxLat: 51.6919}
+
+// ParseBounds parses a bounding box provided as 'minLon,minLat,maxLon,maxLat'
+func ParseBounds(s string) (Bounds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Bounds{}, fmt.Errorf("failed to parse %v as a bounding box, please provide it as 'minLon,minLat,maxLon,maxLat'", s)
+	}
+	var values [4]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Bounds{}, fmt.Errorf("failed to parse %v as a bounding box, %v is not a number", s, p)
+		}
+		values[i] = v
+	}
+	b := Bounds{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}
+	if b.MinLon > b.MaxLon || b.MinLat > b.MaxLat {
+		return Bounds{}, fmt.Errorf("failed to parse %v as a bounding box, the minimum coordinates must be smaller than the maximum coordinates", s)
+	}
+	return b, nil
+}
+
+// Contains reports whether the point lies within the bounding box (edges included)
+func (b Bounds) Contains(p *geom.Point) bool {
+	return p.X() >= b.MinLon && p.X() <= b.MaxLon && p.Y() >= b.MinLat && p.Y() <= b.MaxLat
+}
+
+func (b Bounds) String() string {
+	return fmt.Sprintf("%v,%v,%v,%v", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+// Distance returns the great-circle distance between two WGS84 points in metres (haversine formula)
+func Distance(a *geom.Point, b *geom.Point) float64 {
+	lat1 := a.Y() * math.Pi / 180
+	lat2 := b.Y() * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.X() - a.X()) * math.Pi / 180
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,46 @@
+package geocoding
+
+import (
+	"math"
+	"testing"
+
+	geom "github.com/twpayne/go-geom"
+)
+
+func TestDistance(t *testing.T) {
+	frithStreet := geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.132, 51.513})
+	moorStreet := geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.12980699914457172, 51.51339645})
+
+	if d := Distance(frithStreet, frithStreet); d != 0 {
+		t.Errorf("expected the distance between identical points to be 0, got %v", d)
+	}
+	// roughly 157 metres according to https://www.movable-type.co.uk/scripts/latlong.html
+	if d := Distance(frithStreet, moorStreet); math.Abs(d-157) > 2 {
+		t.Errorf("expected the distance to be roughly 157 metres, got %v", d)
+	}
+	if Distance(frithStreet, moorStreet) != Distance(moorStreet, frithStreet) {
+		t.Error("expected the distance to be symmetric")
+	}
+}
+
+func TestBounds(t *testing.T) {
+	b, err := ParseBounds("-0.5103,51.2868,0.3340,51.6919")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if b != LondonBounds {
+		t.Errorf("expected the parsed bounds to equal LondonBounds, got %v", b)
+	}
+	if !b.Contains(geom.NewPoint(geom.XY).MustSetCoords([]float64{-0.132, 51.513})) {
+		t.Error("expected Soho to be within the bounds")
+	}
+	if b.Contains(geom.NewPoint(geom.XY).MustSetCoords([]float64{-2.244, 53.483})) { // Manchester
+		t.Error("expected Manchester to be outside the bounds")
+	}
+
+	for _, invalid := range []string{"", "1,2,3", "a,b,c,d", "1,1,0,0"} {
+		if _, err := ParseBounds(invalid); err == nil {
+			t.Errorf("expected an error when parsing %q", invalid)
+		}
+	}
+}
@@ -2,57 +2,118 @@ package geocoding
 
 import (
 	"context"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 // most code taken from https://gist.github.com/MelchiSalins/27c11566184116ec1629a0726e0f9af5
 
+// Limiter blocks until the next request may be made or the context is done.
+// *rate.Limiter implements this interface for limiting within a single process,
+// dbutils.RateLimiter can be used to share a limit between processes.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
 type httpClientWithRateLimit struct {
 	client      *http.Client
-	RateLimiter *rate.Limiter
-	TimeOut     float32
+	RateLimiter Limiter
 	UserAgent   string
+	MaxRetries  int           // number of times a request is retried after a transient error (429, 5xx or network error)
+	BaseBackoff time.Duration // delay before the first retry, doubled with every attempt (unless the server sends a Retry-After header)
 }
 
-// Do dispatches the HTTP request to the network
+// Do dispatches the HTTP request to the network, honouring the context of the request.
+// Transient errors are retried with exponential backoff, every attempt respects the rate limit.
 func (c *httpClientWithRateLimit) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
 	if c.UserAgent != "" {
 		req.Header.Set("User-Agent", c.UserAgent)
 	}
-	// rate limiting
-	if c.RateLimiter != nil {
-		ctx := context.Background()
-		err := c.RateLimiter.Wait(ctx) // This is a blocking call. Honors the rate limit
-		if err != nil {
-			return nil, err
+	for attempt := 0; ; attempt++ {
+		// rate limiting
+		if c.RateLimiter != nil {
+			if err := c.RateLimiter.Wait(ctx); err != nil { // This is a blocking call. Honors the rate limit
+				return nil, err
+			}
+		}
+		resp, err := c.client.Do(req.Clone(ctx))
+		if err == nil && !isTransient(resp.StatusCode) {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= c.MaxRetries {
+			return resp, err
+		}
+
+		delay := c.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				delay = retryAfter
+			}
+			io.Copy(io.Discard, resp.Body) // drain body so the connection can be reused
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
 		}
 	}
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, err
+}
+
+// exponential backoff with up to 10% jitter
+func (c *httpClientWithRateLimit) backoff(attempt int) time.Duration {
+	d := c.BaseBackoff << attempt
+	return d + time.Duration(rand.Int64N(int64(d)/10+1))
+}
+
+func isTransient(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// parses the value of a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
 	}
-	if c.TimeOut != 0 {
-		time.Sleep(time.Millisecond * time.Duration(c.TimeOut*1000))
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
 	}
-	return resp, nil
+	return 0, false
 }
 
 // Returns http client with a ratelimiter
-// optionally pass a rate.Limiter object OR a timeout value in seconds (after each request, the process will be blocked for the duration of the timeout)
-// pass a user agent string using the third param
-func NewHttpClient(rl *rate.Limiter, timeout float32, userAgent string) *httpClientWithRateLimit {
+// optionally pass a Limiter (e.g. a rate.Limiter) that every request (including retries) waits for
+// pass a user agent string using the second param
+func NewHttpClient(rl Limiter, userAgent string) *httpClientWithRateLimit {
 	var tr = &http.Transport{
 		IdleConnTimeout: 30 * time.Second,
 	}
-	var client = &http.Client{Transport: tr}
+	var client = &http.Client{Transport: tr, Timeout: 30 * time.Second}
 	c := &httpClientWithRateLimit{
 		client:      client,
 		RateLimiter: rl,
-		TimeOut:     timeout,
 		UserAgent:   userAgent,
+		MaxRetries:  3,
+		BaseBackoff: 2 * time.Second,
 	}
 	return c
 }
@@ -1,25 +1,61 @@
 package geocoding
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	geom "github.com/twpayne/go-geom"
+	"golang.org/x/time/rate"
 )
 
+const userAgent = "github.com/felix-schott/jamsessions"
+
+var nominatimUrl = "https://nominatim.openstreetmap.org"
+
 var client *httpClientWithRateLimit
+var clientMu sync.Mutex
+
+// UseRateLimiter replaces the rate limiter used for requests to Nominatim. By default, requests are limited to
+// one per second within the current process - use a limiter shared between processes (e.g. dbutils.RateLimiter)
+// if several processes geocode addresses at the same time.
+func UseRateLimiter(l Limiter) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	client = NewHttpClient(l, userAgent)
+}
+
+// instantiates the client that respects the nominatim rate limit (max 1 request per second) on first use
+func getClient() *httpClientWithRateLimit {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client == nil {
+		client = NewHttpClient(rate.NewLimiter(rate.Every(time.Second), 1), userAgent)
+	}
+	return client
+}
 
 type geometry struct {
 	Type        string
 	Coordinates []float64
 }
 
-type feature struct {
+type properties struct {
 	// we don't care about the rest of the fields
-	Geometry geometry `json:"geometry"`
+	PlaceRank   int    `json:"place_rank"`
+	Type        string `json:"type"`
+	AddressType string `json:"addresstype"`
+	DisplayName string `json:"display_name"`
+}
+
+type feature struct {
+	Properties properties `json:"properties"`
+	Geometry   geometry   `json:"geometry"`
 }
 
 type nominatimResponse struct {
@@ -39,22 +75,21 @@ func (r NominatimDownError) Error() string {
 }
 
 // Returns a NominatimDownError if the service is not healthy, otherwise nil
-func serviceIsHealthy() error {
-	req, err := http.NewRequest("GET", "https://nominatim.openstreetmap.org/status", nil)
+func serviceIsHealthy(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", nominatimUrl+"/status", nil)
 	if err != nil {
 		return err
 	}
-	resp, err := client.Do(req)
-	var b []byte
+	resp, err := getClient().Do(req)
 	if err != nil {
-		b, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return NominatimDownError{StatusCode: resp.StatusCode, Err: err}
+		if ctx.Err() != nil {
+			return err
 		}
-		return NominatimDownError{StatusCode: resp.StatusCode, Body: b}
+		return NominatimDownError{Err: err}
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		b, err = io.ReadAll(resp.Body)
+		b, err := io.ReadAll(resp.Body)
 		if err != nil {
 			return NominatimDownError{StatusCode: resp.StatusCode, Err: err}
 		}
@@ -63,31 +98,57 @@ func serviceIsHealthy() error {
 	return nil
 }
 
-func Geocode(street string, city string, postcode string) (*geom.Point, error) {
-	// instantiate client that respects nominatim rate limit (max 1 request per second)
-	if client == nil {
-		// rl := rate.NewLimiter(rate.Every(time.Second*1), 1)
-		client = NewHttpClient(nil, 1.5, "github.com/felix-schott/jamsessions") // we do a blocking call to time.Sleep for 1.2 seconds after each request
-		// not perfect but an easy way to avoid hitting the rate limit when running multiple processes
-		// as long as the execution is sequential and not parallel (this is what happens in production currently).
-		// rate.Limiter doesn't work in a multi-process environment and redis seems like an overkill since we're not running
-		// things in parallel
+// Result holds the coordinates returned for an address alongside some information about the quality of the match
+type Result struct {
+	Point *geom.Point
+	// Confidence is a value between 0 and 1 derived from the Nominatim place rank -
+	// a match on a building scores 1, a match on a street or postcode area scores lower
+	Confidence float64
+	// MatchType is the kind of object the address was matched to (e.g. 'building', 'road', 'postcode')
+	MatchType   string
+	DisplayName string
+}
+
+// the highest place rank assigned by Nominatim (houses and buildings), see https://nominatim.org/release-docs/latest/customize/Ranking/
+const maxPlaceRank = 30
+
+func confidence(placeRank int) float64 {
+	if placeRank <= 0 {
+		return 0
+	}
+	if placeRank >= maxPlaceRank {
+		return 1
 	}
+	return float64(placeRank) / maxPlaceRank
+}
 
-	err := serviceIsHealthy()
+// Geocode returns the coordinates for an address. Use Search if you need information about the quality of the match.
+func Geocode(ctx context.Context, street string, city string, postcode string) (*geom.Point, error) {
+	result, err := Search(ctx, street, city, postcode)
+	if err != nil {
+		return nil, err
+	}
+	return result.Point, nil
+}
+
+// Search geocodes an address and returns the best match alongside a confidence score and match type
+// The context is used for all requests made, including waiting for the rate limit and retries.
+func Search(ctx context.Context, street string, city string, postcode string) (*Result, error) {
+	err := serviceIsHealthy(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	reqUrl := fmt.Sprintf("https://nominatim.openstreetmap.org/search?street=%v&city=%v&country=UK&postcode=%v&format=geojson&limit=1", url.QueryEscape(street), url.QueryEscape(city), url.QueryEscape(postcode))
-	req, err := http.NewRequest("GET", reqUrl, nil)
+	reqUrl := fmt.Sprintf("%v/search?street=%v&city=%v&country=UK&postcode=%v&format=geojson&limit=1", nominatimUrl, url.QueryEscape(street), url.QueryEscape(city), url.QueryEscape(postcode))
+	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err2 := client.Do(req)
+	resp, err2 := getClient().Do(req)
 	if err2 != nil {
-		return nil, fmt.Errorf("an unkown error occured when making request to %v: %v", reqUrl, err2)
+		return nil, fmt.Errorf("an unkown error occured when making request to %v: %w", reqUrl, err2)
 	}
+	defer resp.Body.Close()
 	bodyBytes, err2 := io.ReadAll(resp.Body)
 	if err2 != nil {
 		return nil, fmt.Errorf("error reading the body: %v", err2)
@@ -104,5 +165,15 @@ func Geocode(street string, city string, postcode string) (*geom.Point, error) {
 		return nil, fmt.Errorf("no matches for %v, %v, %v (url %v)", street, city, postcode, reqUrl)
 	}
 
-	return geom.NewPoint(geom.XY).MustSetCoords(result.Features[0].Geometry.Coordinates).SetSRID(4326), nil
+	match := result.Features[0]
+	matchType := match.Properties.AddressType
+	if matchType == "" {
+		matchType = match.Properties.Type
+	}
+	return &Result{
+		Point:       geom.NewPoint(geom.XY).MustSetCoords(match.Geometry.Coordinates).SetSRID(4326),
+		Confidence:  confidence(match.Properties.PlaceRank),
+		MatchType:   matchType,
+		DisplayName: match.Properties.DisplayName,
+	}, nil
 }
@@ -1,6 +1,7 @@
 package geocoding
 
 import (
+	"context"
 	"log"
 	"math"
 	"testing"
@@ -21,7 +22,7 @@ func TestGeocoding(t *testing.T) {
 	}
 
 	for tc, exp := range cases {
-		result, err := Geocode(tc.Street, tc.City, tc.Postcode)
+		result, err := Geocode(context.Background(), tc.Street, tc.City, tc.Postcode)
 		if err != nil {
 			err, ok := err.(NominatimDownError)
 			if ok {
@@ -39,3 +40,19 @@ func TestGeocoding(t *testing.T) {
 		}
 	}
 }
+
+func TestConfidence(t *testing.T) {
+	cases := map[int]float64{
+		-1: 0,
+		0:  0,
+		15: 0.5,
+		26: 26.0 / 30,
+		30: 1,
+		31: 1,
+	}
+	for placeRank, exp := range cases {
+		if got := confidence(placeRank); got != exp {
+			t.Errorf("expected the confidence of place rank %v to be %v, got %v", placeRank, exp, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package ics writes iCalendar feeds (RFC 5545) that calendar apps can subscribe to
+package ics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const timestampFormat = "20060102T150405Z"
+
+// maximum length of a content line in octets (excluding the line break), longer lines are folded
+const maxLineLength = 75
+
+// Event is a single occurrence of a session
+type Event struct {
+	// globally unique ID, stays the same when the event is updated
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Location    string
+	Description string
+	URL         string
+	Modified    time.Time
+}
+
+// Calendar is a feed of events
+type Calendar struct {
+	// product identifier of the application that created the feed
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Write writes the calendar in the iCalendar format, times are written in UTC
+func (c Calendar) Write(w io.Writer, now time.Time) error {
+	lw := &lineWriter{w: w}
+	lw.line("BEGIN", "VCALENDAR")
+	lw.line("VERSION", "2.0")
+	lw.line("PRODID", c.ProdID)
+	lw.line("CALSCALE", "GREGORIAN")
+	lw.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, e := range c.Events {
+		lw.line("BEGIN", "VEVENT")
+		lw.line("UID", e.UID)
+		lw.line("DTSTAMP", now.UTC().Format(timestampFormat))
+		lw.line("DTSTART", e.Start.UTC().Format(timestampFormat))
+		lw.line("DTEND", e.End.UTC().Format(timestampFormat))
+		lw.line("SUMMARY", escape(e.Summary))
+		if e.Location != "" {
+			lw.line("LOCATION", escape(e.Location))
+		}
+		if e.Description != "" {
+			lw.line("DESCRIPTION", escape(e.Description))
+		}
+		if e.URL != "" {
+			lw.line("URL", e.URL)
+		}
+		if !e.Modified.IsZero() {
+			lw.line("LAST-MODIFIED", e.Modified.UTC().Format(timestampFormat))
+		}
+		lw.line("END", "VEVENT")
+	}
+	lw.line("END", "VCALENDAR")
+	return lw.err
+}
+
+// escape escapes text values (RFC 5545 section 3.3.11)
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// lineWriter writes content lines terminated by CRLF and folds lines longer than 75 octets, remembering the first error
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(name string, value string) {
+	if lw.err != nil {
+		return
+	}
+	_, lw.err = io.WriteString(lw.w, fold(name+":"+value)+"\r\n")
+}
+
+// fold splits the line into chunks of at most 75 octets (continuation lines start with a space),
+// without splitting multi-byte characters
+func fold(line string) string {
+	var b strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			b.WriteString("\r\n ")
+			length = 1
+		}
+		b.WriteRune(r)
+		length += size
+	}
+	return b.String()
+}
+
+// UID returns a unique ID for the occurrence of a session on a date
+func UID(session int32, date time.Time, domain string) string {
+	return fmt.Sprintf("session-%v-%v@%v", session, date.Format("20060102"), domain)
+}
@@ -0,0 +1,63 @@
+package ics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	start := time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC)
+	cal := Calendar{
+		ProdID: "-//Jam Sessions//EN",
+		Name:   "My jams",
+		Events: []Event{{
+			UID:         UID(3, start, "example.org"),
+			Start:       start,
+			End:         start.Add(3 * time.Hour),
+			Summary:     "Jazz Jam; Monday, weekly",
+			Location:    "The Pub, 1 Main Street, London",
+			Description: strings.Repeat("Bring your horn! ", 10) + "\nAll levels welcome.",
+			URL:         "https://example.org/jams/3",
+		}},
+	}
+	var buf bytes.Buffer
+	if err := cal.Write(&buf, start.Add(-time.Hour)); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	out := buf.String()
+
+	for _, exp := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Jam Sessions//EN\r\n",
+		"X-WR-CALNAME:My jams\r\n",
+		"UID:session-3-20240501@example.org\r\n",
+		"DTSTAMP:20240501T183000Z\r\n",
+		"DTSTART:20240501T193000Z\r\nDTEND:20240501T223000Z\r\n",
+		`SUMMARY:Jazz Jam\; Monday\, weekly` + "\r\n",
+		`LOCATION:The Pub\, 1 Main Street\, London` + "\r\n",
+		`\nAll levels welcome.`,
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected the feed to contain %q, got:\n%v", exp, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("expected lines to be folded, got a line of %v octets: %q", len(line), line)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("ü", 40) // 2 octets each
+	folded := fold(line)
+	parts := strings.Split(folded, "\r\n ")
+	if len(parts) != 2 {
+		t.Fatalf("expected the line to be folded once, got %q", folded)
+	}
+	if len(parts[0]) > maxLineLength || strings.Join(parts, "") != line {
+		t.Errorf("unexpected folding %q", folded)
+	}
+}
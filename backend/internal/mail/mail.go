@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email, optionally with an HTML alternative
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends emails. SMTPMailer delivers them, WriterMailer and FileMailer are meant for local development and
+// testing, they print the messages instead of delivering them.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// sender address if none is configured
+const DefaultFrom = "Jam Sessions <noreply@localhost>"
+
+// SMTPServer is the server the SMTP mailer delivers to. Username and Password are optional (e.g. for a local
+// MailHog-style stub).
+type SMTPServer struct {
+	Addr     string // host:port
+	Username string
+	Password string
+}
+
+// New returns the mailer of the given kind: 'stdout' (default), 'file' (writes the messages to dir) or 'smtp'
+// (delivers the messages to server)
+func New(kind string, dir string, from string, server SMTPServer) (Mailer, error) {
+	if from == "" {
+		from = DefaultFrom
+	}
+	switch kind {
+	case "", "stdout":
+		return &WriterMailer{W: os.Stdout, From: from}, nil
+	case "file":
+		if dir == "" {
+			return nil, fmt.Errorf("the file mailer needs a directory")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	case "smtp":
+		if _, _, err := net.SplitHostPort(server.Addr); err != nil {
+			return nil, fmt.Errorf("the smtp mailer needs the address (host:port) of the server: %w", err)
+		}
+		return &SMTPMailer{Server: server, From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q (available: 'stdout', 'file', 'smtp')", kind)
+	}
+}
+
+// format renders the message in the internet message format (RFC 5322), messages with HTML are sent as
+// multipart/alternative (RFC 2046)
+func format(from string, m Message, t time.Time) string {
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n",
+		from, m.To, mime.QEncoding.Encode("utf-8", m.Subject), t.Format(time.RFC1123Z))
+	if m.HTML == "" {
+		return header + fmt.Sprintf("Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", crlf(m.Text))
+	}
+	boundary := fmt.Sprintf("alternative_%x", t.UnixNano())
+	return header + fmt.Sprintf("MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary) +
+		fmt.Sprintf("--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, crlf(m.Text)) +
+		fmt.Sprintf("--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, crlf(m.HTML)) +
+		fmt.Sprintf("--%s--\r\n", boundary)
+}
+
+// crlf converts the line endings to CRLF
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// WriterMailer writes the messages to W
+type WriterMailer struct {
+	W    io.Writer
+	From string
+
+	mu sync.Mutex
+}
+
+func (w *WriterMailer) Send(ctx context.Context, m Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := io.WriteString(w.W, format(w.From, m, time.Now())+"\r\n")
+	return err
+}
+
+// FileMailer writes every message to a separate .eml file in Dir
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+	t := time.Now().UTC()
+	fp := filepath.Join(f.Dir, fmt.Sprintf("%v_%v_%v.eml", t.Format("20060102_150405"), t.Nanosecond(), unsafeChars.ReplaceAllString(m.To, "_")))
+	return os.WriteFile(fp, []byte(format(f.From, m, t)), 0644)
+}
+
+// SMTPMailer delivers the messages to an SMTP server (STARTTLS is used if the server supports it)
+type SMTPMailer struct {
+	Server SMTPServer
+	From   string
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m Message) error {
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", s.From, err)
+	}
+	to, err := netmail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", m.To, err)
+	}
+	var auth smtp.Auth
+	if s.Server.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Server.Addr)
+		auth = smtp.PlainAuth("", s.Server.Username, s.Server.Password, host)
+	}
+	return smtp.SendMail(s.Server.Addr, auth, from.Address, []string{to.Address}, []byte(format(s.From, m, time.Now())))
+}
@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var msg = Message{To: "player@example.org", Subject: "Your login link", Text: "Hello\nhttps://example.org/login"}
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &WriterMailer{W: &buf, From: "noreply@example.org"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for _, expected := range []string{"From: noreply@example.org\r\n", "To: player@example.org\r\n", "Subject: Your login link\r\n", "\r\n\r\nHello\r\nhttps://example.org/login"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the message to contain %q, got %q", expected, buf.String())
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := New("file", dir, "noreply@example.org", SMTPServer{})
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(entries), err)
+	}
+	if !strings.HasSuffix(entries[0].Name(), "_player@example.org.eml") {
+		t.Errorf("expected the file name to end with the recipient, got %v", entries[0].Name())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil || !strings.Contains(string(b), "Subject: Your login link") {
+		t.Errorf("expected the file to contain the message, got %s (err: %v)", b, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("pigeon", "", "", SMTPServer{}); err == nil {
+		t.Error("expected an error for an unknown mailer")
+	}
+	if _, err := New("file", "", "", SMTPServer{}); err == nil {
+		t.Error("expected an error for a file mailer without directory")
+	}
+	if _, err := New("smtp", "", "", SMTPServer{Addr: "localhost"}); err == nil {
+		t.Error("expected an error for an smtp mailer without port")
+	}
+}
+
+func TestFormatAlternative(t *testing.T) {
+	m := msg
+	m.Subject = "Your weekly digest – 3 sessions"
+	m.HTML = "<p>Hello</p>"
+	formatted := format("noreply@example.org", m, time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC))
+	for _, expected := range []string{
+		"Subject: =?utf-8?q?Your_weekly_digest_=E2=80=93_3_sessions?=\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Content-Type: text/plain; charset=utf-8\r\n\r\nHello\r\nhttps://example.org/login\r\n",
+		"Content-Type: text/html; charset=utf-8\r\n\r\n<p>Hello</p>\r\n",
+	} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("expected the message to contain %q, got %q", expected, formatted)
+		}
+	}
+	if !strings.HasSuffix(formatted, "--\r\n") {
+		t.Errorf("expected the message to end with the closing boundary, got %q", formatted)
+	}
+}
+
+// smtpStub is a minimal SMTP server (like MailHog) that records the messages it receives
+func smtpStub(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ESMTP stub")
+		var envelope []string
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				c.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 Send message")
+				data, err := c.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- strings.Join(envelope, "\n") + "\n" + string(data)
+				c.PrintfLine("250 OK")
+			case "QUIT":
+				c.PrintfLine("221 Bye")
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := smtpStub(t)
+	m, err := New("smtp", "", "Jam Sessions <noreply@example.org>", SMTPServer{Addr: addr})
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	data := <-received
+	for _, expected := range []string{"MAIL FROM:<noreply@example.org>", "RCPT TO:<player@example.org>", "From: Jam Sessions <noreply@example.org>\n", "Subject: Your login link\n", "Hello\nhttps://example.org/login"} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected the message to contain %q, got %q", expected, data)
+		}
+	}
+	if err := m.Send(context.Background(), Message{To: "not an address"}); err == nil {
+		t.Error("expected an error for an invalid recipient")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -20,23 +21,133 @@ func WriteMigration(cmd string, title string, migrationsDirectory string) (strin
 		return "", errors.New("an unknown error occured")
 	}
 
-	fp := filepath.Join(migrationsDirectory, fmt.Sprintf("%v_%v_%v.sh", time.Now().UTC().Format("20060102_150405"), time.Now().Nanosecond(), strings.ReplaceAll(strings.ReplaceAll(title, " ", "_"), "'", "")))
+	fp := filepath.Join(migrationsDirectory, fmt.Sprintf("%v_%v_%v.sh", time.Now().UTC().Format("20060102_150405"), time.Now().Nanosecond(), titleReplacer.Replace(title)))
 	slog.Info("writing migration", "filepath", fp)
 
 	// convention - cmd must look like this: dbcli do something "{"key":"value"}"; do something else "{"key":"value"}"
-	subCmds := strings.Split(cmd, ";") // split into subcommands
-	for idx, subCmd := range subCmds {
-		if len(subCmd) != 0 {
-			arr := strings.Split(subCmd, `"`)
-			newArr := make([]string, 3)
-			newArr[0] = arr[0]                                       // everything up to the start of the json body
-			newArr[1] = strings.Join(arr[1:len(arr)-1], `\"`)        // the json payload, here we escape all "
-			newArr[1] = strings.ReplaceAll(newArr[1], `\\"`, `\\\"`) // " in the field values (e.g. speech marks) needs to be triple escaped ///"
-			newArr[2] = arr[len(arr)-1]                              // end
-			subCmds[idx] = strings.Join(newArr, `"`)                 // overwrite subcmd with the substituted string
-		}
-	}
-	cleanCmd := strings.Join(subCmds, ";") // join back together
+	cleanCmd, err := quotePayloads(cmd)
+	if err != nil {
+		slog.Error("WriteMigration", "msg", err, "title", title)
+		return "", err
+	}
 	os.WriteFile(fp, []byte("#!/usr/bin/env bash\n\n"+cleanCmd), fs.FileMode(int(0755)))
 	return fp, nil
 }
+
+// the title is part of the file name, it must not point to another directory
+var titleReplacer = strings.NewReplacer(" ", "_", "'", "", "/", "_", `\`, "_")
+
+// variables assigned by subcommands (e.g. new_id=$(dbcli insert venue "...")), used as placeholders by later subcommands
+var assignedVariables = regexp.MustCompile(`(\w+)=\$\(`)
+
+// placeholders of assigned variables in the payloads (outside of strings, e.g. {"venue": $new_id})
+var placeholder = regexp.MustCompile(`^\$\w+`)
+
+// helper func - escapes the JSON payloads of cmd so that bash passes them on unchanged. The payloads are found by
+// matching their braces, so that nothing in the field values (", ;, $, ` or line breaks) can end the payload or the
+// command. The text outside of the payloads is written as it is.
+func quotePayloads(cmd string) (string, error) {
+	var outside, payloads []string
+	for rest := cmd; ; {
+		start := strings.IndexByte(rest, '"')
+		if start < 0 {
+			outside = append(outside, rest)
+			break
+		}
+		outside = append(outside, rest[:start])
+		n, err := payloadLength(rest[start+1:])
+		if err != nil {
+			return "", err
+		}
+		payloads = append(payloads, rest[start+1:start+1+n])
+		rest = rest[start+1+n:]
+		if !strings.HasPrefix(rest, `"`) {
+			return "", errors.New("payloads must be enclosed in double quotes")
+		}
+		rest = rest[1:]
+	}
+	vars := map[string]bool{}
+	for _, s := range outside {
+		for _, m := range assignedVariables.FindAllStringSubmatch(s, -1) {
+			vars[m[1]] = true
+		}
+	}
+	var b strings.Builder
+	for i, s := range outside {
+		b.WriteString(s)
+		if i < len(payloads) {
+			b.WriteString(`"` + escapePayload(payloads[i], vars) + `"`)
+		}
+	}
+	return b.String(), nil
+}
+
+// helper func - returns the length of the JSON object at the start of s
+func payloadLength(s string) (int, error) {
+	if !strings.HasPrefix(s, "{") {
+		return 0, errors.New("payloads must be JSON objects")
+	}
+	depth, inString, escaped := 0, false, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("unterminated payload")
+}
+
+// helper func - escapes the characters that bash interprets in double-quoted strings (\, ", $ and `), except for
+// the placeholders outside of the strings of the payload that refer to variables assigned by previous subcommands
+func escapePayload(s string, vars map[string]bool) string {
+	var b strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !inString && c == '$' {
+			if name := placeholder.FindString(s[i:]); vars[strings.TrimPrefix(name, "$")] {
+				b.WriteString(name)
+				i += len(name) - 1
+				continue
+			}
+		}
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		} else if c == '"' {
+			inString = true
+		}
+		if strings.IndexByte("\\\"$`", c) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Comment returns s as a single line that can be appended to a migration as a comment (e.g. "# notes: " + Comment(s)).
+// Line breaks would end the comment and run the rest of s as a command and " would be taken for the start of a
+// payload by WriteMigration, so they are removed (as well as ;)
+func Comment(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", `"`, "", ";", "").Replace(s)
+}
@@ -0,0 +1,126 @@
+package migrationutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteMigration(t *testing.T) {
+	dir := t.TempDir()
+	// fake dbcli that prints the payload (and an ID for inserts)
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "dbcli"), []byte("#!/usr/bin/env bash\nif [ \"$2\" = venue ]; then echo 42; else echo \"$3\" >&2; fi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := `new_id=$(dbcli insert venue "{"venue_name": "The $HOME Bar"}");` + "\n" + `dbcli insert session "{"venue": $new_id, "description": "Bring \"your\" axe ` + "`whoami`" + ` $(id) ${PATH}"}";`
+	fp, err := WriteMigration(cmd, "test_escaping", dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	run := exec.Command("bash", fp)
+	run.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	out, err := run.CombinedOutput()
+	if err != nil {
+		t.Fatalf("could not run the migration: %v: %s", err, out)
+	}
+	expected := `{"venue": 42, "description": "Bring \"your\" axe ` + "`whoami`" + ` $(id) ${PATH}"}`
+	if strings.TrimSpace(string(out)) != expected {
+		t.Errorf("expected the payload %v, got %s", expected, out)
+	}
+}
+
+func TestWriteMigrationPayloads(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "injected")
+	// fake dbcli that prints its last argument (the payload)
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "dbcli"), []byte("#!/usr/bin/env bash\necho \"${@: -1}\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, description := range []string{
+		"x; touch " + marker + "; echo ",
+		"$(touch " + marker + ")",
+		"`touch " + marker + "`",
+		"x\"}\"; touch " + marker + "; echo \"",
+		`C:\temp\ "quoted" \"`,
+		"line\nbreak {[",
+	} {
+		payload, err := json.Marshal(map[string]string{"description": description})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err := WriteMigration(fmt.Sprintf(`dbcli update session 1 "%s"`, payload), "test_payload", dir)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		run := exec.Command("bash", fp)
+		run.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+		out, err := run.CombinedOutput()
+		if err != nil {
+			t.Fatalf("could not run the migration: %v: %s", err, out)
+		}
+		if strings.TrimSuffix(string(out), "\n") != string(payload) {
+			t.Errorf("expected the payload %s, got %s", payload, out)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("expected the description %q not to be executed", description)
+		}
+	}
+
+	if _, err := WriteMigration(`dbcli update session 1 "{"description": "x"`, "test_unterminated", dir); err == nil {
+		t.Error("expected an error for an unterminated payload")
+	}
+	if fp, err := WriteMigration(`dbcli delete venue 1`, "insert_venue_../../x", dir); err != nil || filepath.Dir(fp) != dir {
+		t.Errorf("expected the migration to be written to %v, got %v (err: %v)", dir, fp, err)
+	}
+}
+
+func TestComment(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "injected")
+	cmd := `dbcli insert session "{"session_name": "Jazz Jam"}"` + "\n# submission notes: " + Comment("see you there\ntouch "+marker+"\r\n; \"bring\" a friend")
+	fp, err := WriteMigration(cmd, "test_comment", dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	migration, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(string(migration), "\n"); lines[len(lines)-1] != "# submission notes: see you there touch "+marker+"  bring a friend" {
+		t.Errorf("expected the notes on a single comment line, got %q", migration)
+	}
+	// dbcli is not needed, the migration fails after the first line at most
+	exec.Command("bash", fp).Run()
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the notes not to be executed")
+	}
+}
+
+func TestHasPendingUser(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := WriteMigration(`dbcli insert user "{"email":"player@example.org"}"`, "insert_user", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteMigration(`dbcli insert comment "{"author":"dbcli insert user","content":"player2@example.org"}"`, "insert_comment", dir); err != nil {
+		t.Fatal(err)
+	}
+	for email, expected := range map[string]bool{"player@example.org": true, "Player@Example.org": true, "player2@example.org": false} {
+		if pending, err := HasPendingUser(dir, email); err != nil || pending != expected {
+			t.Errorf("%v: expected %v, got %v (err: %v)", email, expected, pending, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package migrationutils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	types "github.com/felix-schott/jamsessions/backend/internal/types"
+)
+
+// PendingSession is a session submission that has been written to the migrations directory but not applied yet
+type PendingSession struct {
+	File     string
+	Session  types.SessionProperties
+	NewVenue *types.VenueProperties // set if the session was submitted together with a new venue
+}
+
+var insertCmdRegex = regexp.MustCompile(`dbcli insert (venue|session) "((?:[^"\\]|\\.)*)"`)
+
+// helper func - reverses the escaping of a bash double-quoted string
+func unescapeDoubleQuoted(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.ContainsRune("\\\"$`", rune(s[i+1])) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ReadPendingSessions parses all migrations in migrationsDirectory that insert a session.
+// Files that can't be parsed are skipped.
+func ReadPendingSessions(migrationsDirectory string) ([]PendingSession, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsDirectory, "*.sh"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []PendingSession
+	for _, fp := range files {
+		content, err := os.ReadFile(fp)
+		if err != nil {
+			return nil, err
+		}
+		var newVenue *types.VenueProperties
+		for _, match := range insertCmdRegex.FindAllStringSubmatch(string(content), -1) {
+			payload := unescapeDoubleQuoted(match[2])
+			switch match[1] {
+			case "venue":
+				var venue types.VenueProperties
+				if err := json.Unmarshal([]byte(payload), &venue); err == nil {
+					newVenue = &venue
+				}
+			case "session":
+				// the id of a venue that is inserted in the same migration is only known at runtime
+				payload = strings.ReplaceAll(payload, "$new_id", "null")
+				var session types.SessionProperties
+				if err := json.Unmarshal([]byte(payload), &session); err != nil {
+					continue
+				}
+				p := PendingSession{File: fp, Session: session}
+				if session.Venue == nil {
+					p.NewVenue = newVenue
+				}
+				pending = append(pending, p)
+			}
+		}
+	}
+	return pending, nil
+}
+
+var insertUserRegex = regexp.MustCompile(`dbcli insert user "((?:[^"\\]|\\.)*)"`)
+
+// HasPendingUser reports whether a migration in migrationsDirectory registers the user with the email address
+func HasPendingUser(migrationsDirectory string, email string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsDirectory, "*.sh"))
+	if err != nil {
+		return false, err
+	}
+	for _, fp := range files {
+		content, err := os.ReadFile(fp)
+		if err != nil {
+			return false, err
+		}
+		for _, match := range insertUserRegex.FindAllStringSubmatch(string(content), -1) {
+			var user struct {
+				Email string `json:"email"`
+			}
+			if err := json.Unmarshal([]byte(unescapeDoubleQuoted(match[1])), &user); err == nil && strings.EqualFold(user.Email, email) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
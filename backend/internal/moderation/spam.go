@@ -0,0 +1,224 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// comments with a score of at least HoldThreshold are held for moderation (status 'pending')
+const HoldThreshold = 1.0
+
+// Submission is a comment that is about to be written to the migrations directory
+type Submission struct {
+	Session int32
+	Author  string
+	Content string
+	Source  string // hashed IP address of the submitter (see HashIP), used to limit the submission velocity
+	Time    time.Time
+}
+
+// Verdict of a SpamScorer, the reasons explain how the score came about
+type Verdict struct {
+	Score   float64
+	Reasons []string
+}
+
+// Held reports whether the comment should be held for moderation
+func (v Verdict) Held() bool {
+	return v.Score >= HoldThreshold
+}
+
+// SpamScorer assigns a spam score to comment submissions. LocalScorer implements it without external services,
+// other implementations (e.g. backed by a spam filtering API) can be plugged into the API server.
+type SpamScorer interface {
+	Score(ctx context.Context, s Submission) (Verdict, error)
+}
+
+// Check contributes to the score of a LocalScorer, it returns a score of 0 if the submission looks fine
+type Check func(ctx context.Context, s Submission) (score float64, reason string, err error)
+
+// LocalScorer sums up the scores of its checks
+type LocalScorer struct {
+	Checks []Check
+}
+
+// NewLocalScorer returns a LocalScorer with the default checks: links, blocklisted words, repeated content
+// (countRepeats looks up previously stored comments with the same content, may be nil) and submission velocity.
+func NewLocalScorer(blocklist []string, countRepeats func(ctx context.Context, content string) (int64, error)) *LocalScorer {
+	return &LocalScorer{Checks: []Check{
+		LinkCheck(1),
+		BlocklistCheck(blocklist),
+		NewRepeatedContentCheck(countRepeats, 24*time.Hour).Check,
+		NewVelocityCheck(5, time.Hour).Check,
+	}}
+}
+
+func (l *LocalScorer) Score(ctx context.Context, s Submission) (Verdict, error) {
+	var v Verdict
+	for _, check := range l.Checks {
+		score, reason, err := check(ctx, s)
+		if err != nil {
+			return v, err
+		}
+		if score > 0 {
+			v.Score += score
+			v.Reasons = append(v.Reasons, reason)
+		}
+	}
+	return v, nil
+}
+
+var linkRegex = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// LinkCheck scores every link beyond maxLinks with 0.5
+func LinkCheck(maxLinks int) Check {
+	return func(ctx context.Context, s Submission) (float64, string, error) {
+		n := len(linkRegex.FindAllString(s.Content, -1)) + len(linkRegex.FindAllString(s.Author, -1))
+		if n <= maxLinks {
+			return 0, "", nil
+		}
+		return 0.5 * float64(n-maxLinks), fmt.Sprintf("%v links", n), nil
+	}
+}
+
+var wordRegex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// BlocklistCheck scores every distinct blocklisted word in the author or content with 0.6 (case-insensitive)
+func BlocklistCheck(blocklist []string) Check {
+	words := make(map[string]struct{}, len(blocklist))
+	for _, w := range blocklist {
+		words[strings.ToLower(w)] = struct{}{}
+	}
+	return func(ctx context.Context, s Submission) (float64, string, error) {
+		found := make(map[string]struct{})
+		var matches []string
+		for _, w := range wordRegex.FindAllString(strings.ToLower(s.Author+" "+s.Content), -1) {
+			if _, ok := words[w]; !ok {
+				continue
+			}
+			if _, ok := found[w]; !ok {
+				found[w] = struct{}{}
+				matches = append(matches, w)
+			}
+		}
+		if len(matches) == 0 {
+			return 0, "", nil
+		}
+		return 0.6 * float64(len(matches)), "blocklisted words: " + strings.Join(matches, ", "), nil
+	}
+}
+
+// RepeatedContentCheck scores content that has been submitted before with 1. Submissions are remembered for the
+// duration of the window because they only appear in the database once the migration has been applied.
+type RepeatedContentCheck struct {
+	countStored func(ctx context.Context, content string) (int64, error)
+	window      time.Duration
+
+	mu     sync.Mutex
+	recent map[string]time.Time // normalised content -> time of the last submission
+}
+
+func NewRepeatedContentCheck(countStored func(ctx context.Context, content string) (int64, error), window time.Duration) *RepeatedContentCheck {
+	return &RepeatedContentCheck{countStored: countStored, window: window, recent: make(map[string]time.Time)}
+}
+
+func (c *RepeatedContentCheck) Check(ctx context.Context, s Submission) (float64, string, error) {
+	key := strings.ToLower(strings.Join(strings.Fields(s.Content), " "))
+	if key == "" {
+		return 0, "", nil
+	}
+	c.mu.Lock()
+	for k, t := range c.recent {
+		if s.Time.Sub(t) > c.window {
+			delete(c.recent, k)
+		}
+	}
+	_, repeated := c.recent[key]
+	c.recent[key] = s.Time
+	c.mu.Unlock()
+
+	if !repeated && c.countStored != nil {
+		n, err := c.countStored(ctx, s.Content)
+		if err != nil {
+			return 0, "", err
+		}
+		repeated = n > 0
+	}
+	if !repeated {
+		return 0, "", nil
+	}
+	return 1, "repeated content", nil
+}
+
+// VelocityCheck scores every submission of a source beyond the limit within the window with 0.5
+type VelocityCheck struct {
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	submissions map[string][]time.Time
+}
+
+func NewVelocityCheck(limit int, window time.Duration) *VelocityCheck {
+	return &VelocityCheck{limit: limit, window: window, submissions: make(map[string][]time.Time)}
+}
+
+func (c *VelocityCheck) Check(ctx context.Context, s Submission) (float64, string, error) {
+	if s.Source == "" {
+		return 0, "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for source, times := range c.submissions {
+		i := 0
+		for i < len(times) && s.Time.Sub(times[i]) > c.window {
+			i++
+		}
+		if i == len(times) {
+			delete(c.submissions, source)
+		} else {
+			c.submissions[source] = times[i:]
+		}
+	}
+	c.submissions[s.Source] = append(c.submissions[s.Source], s.Time)
+	n := len(c.submissions[s.Source])
+	if n <= c.limit {
+		return 0, "", nil
+	}
+	return 0.5 * float64(n-c.limit), fmt.Sprintf("%v submissions within %v", n, c.window), nil
+}
+
+// HashIP returns the salted SHA-256 hash of an IP address, so that submissions of the same source can be
+// recognised without storing the address
+func HashIP(salt string, ip string) string {
+	h := sha256.Sum256([]byte(salt + ip))
+	return hex.EncodeToString(h[:])
+}
+
+// DefaultBlocklist is used if no blocklist file is configured
+var DefaultBlocklist = []string{"casino", "viagra", "cialis", "loan", "loans", "bitcoin", "crypto", "forex", "escort", "seo", "backlinks"}
+
+// ReadBlocklist reads a blocklist file (one word per line, lines starting with '#' are ignored)
+func ReadBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if w := strings.TrimSpace(scanner.Text()); w != "" && !strings.HasPrefix(w, "#") {
+			words = append(words, w)
+		}
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,106 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctx = context.Background()
+
+func TestLocalScorer(t *testing.T) {
+	now := time.Date(2024, 5, 1, 19, 0, 0, 0, time.UTC)
+	scorer := NewLocalScorer([]string{"casino"}, func(ctx context.Context, content string) (int64, error) {
+		if content == "already stored" {
+			return 1, nil
+		}
+		return 0, nil
+	})
+
+	cases := []struct {
+		content string
+		source  string
+		held    bool
+		reason  string
+	}{
+		{"Great session, the house band was on fire!", "a", false, ""},
+		{"Check out https://example.org", "b", false, ""},
+		{"Visit https://spam.example and www.spam.example and http://spam.example", "c", true, "3 links"},
+		{"Best CASINO in town, casino bonus", "d", false, "blocklisted words: casino"},
+		{"Casino bonus at https://casino.example and https://more.example", "e", true, "blocklisted words: casino"},
+		{"already stored", "f", true, "repeated content"},
+		{"great session, the house band was   ON FIRE!", "g", true, "repeated content"}, // same as the first one
+	}
+	for _, tc := range cases {
+		v, err := scorer.Score(ctx, Submission{Content: tc.content, Source: tc.source, Time: now})
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		if v.Held() != tc.held {
+			t.Errorf("expected held=%v for %q, got score %v (%v)", tc.held, tc.content, v.Score, v.Reasons)
+		}
+		if tc.reason != "" && !strings.Contains(strings.Join(v.Reasons, "; "), tc.reason) {
+			t.Errorf("expected the reasons for %q to contain %q, got %v", tc.content, tc.reason, v.Reasons)
+		}
+		if tc.reason == "" && len(v.Reasons) != 0 {
+			t.Errorf("expected no reasons for %q, got %v", tc.content, v.Reasons)
+		}
+	}
+}
+
+func TestLocalScorerError(t *testing.T) {
+	scorer := NewLocalScorer(nil, func(ctx context.Context, content string) (int64, error) {
+		return 0, errors.New("db down")
+	})
+	if _, err := scorer.Score(ctx, Submission{Content: "hello", Time: time.Now()}); err == nil {
+		t.Error("expected the error of the check to be returned")
+	}
+}
+
+func TestVelocityCheck(t *testing.T) {
+	check := NewVelocityCheck(2, time.Hour)
+	start := time.Date(2024, 5, 1, 19, 0, 0, 0, time.UTC)
+	var scores []float64
+	for i, source := range []string{"a", "a", "b", "a", "a"} {
+		score, _, err := check.Check(ctx, Submission{Source: source, Time: start.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		scores = append(scores, score)
+	}
+	if scores[0] != 0 || scores[1] != 0 || scores[2] != 0 || scores[3] != 0.5 || scores[4] != 1 {
+		t.Errorf("expected the 3rd and 4th submission of source a to be scored, got %v", scores)
+	}
+	// submissions outside of the window are forgotten
+	score, _, _ := check.Check(ctx, Submission{Source: "a", Time: start.Add(2 * time.Hour)})
+	if score != 0 {
+		t.Errorf("expected submissions outside of the window to be forgotten, got score %v", score)
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	if HashIP("salt", "127.0.0.1") != HashIP("salt", "127.0.0.1") {
+		t.Error("expected the hash to be deterministic")
+	}
+	if HashIP("salt", "127.0.0.1") == HashIP("other", "127.0.0.1") || HashIP("salt", "127.0.0.1") == HashIP("salt", "127.0.0.2") {
+		t.Error("expected the hash to depend on the salt and the address")
+	}
+}
+
+func TestReadBlocklist(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(fp, []byte("# spam words\ncasino\n\n  loans  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	words, err := ReadBlocklist(fp)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if strings.Join(words, ",") != "casino,loans" {
+		t.Errorf("expected the words casino,loans, got %v", words)
+	}
+}
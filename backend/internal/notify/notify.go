@@ -0,0 +1,251 @@
+// Package notify delivers notifications about changes of sessions and venues to the users that follow them
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	"github.com/felix-schott/jamsessions/backend/internal/publicnet"
+)
+
+// Kind is the kind of a notification channel
+type Kind string
+
+const (
+	Email   Kind = "email"
+	WebPush Kind = "webpush"
+	Webhook Kind = "webhook"
+)
+
+// Kinds are all kinds of channels users can register
+var Kinds = []Kind{Email, WebPush, Webhook}
+
+// Channel is where the notifications of a user are delivered
+type Channel struct {
+	ID   int32 `json:"channel_id,omitempty"`
+	Kind Kind  `json:"kind"`
+	// push service URL (WebPush) or URL the notifications are posted to (Webhook), empty for emails
+	Endpoint string `json:"endpoint,omitempty"`
+	// public key (P-256, uncompressed) and authentication secret of the push subscription, base64url encoded
+	P256dh string `json:"p256dh,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+}
+
+// Validate checks that the channel can be used for deliveries
+func (c Channel) Validate() error {
+	switch c.Kind {
+	case Email:
+		if c.Endpoint != "" {
+			return errors.New("email channels don't have an endpoint, notifications are sent to the address of the account")
+		}
+		return nil
+	case WebPush, Webhook:
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("the endpoint has to be an https URL, got %q", c.Endpoint)
+		}
+		if err := publicnet.CheckURL(u); err != nil {
+			return fmt.Errorf("the endpoint has to be a public URL, got %q", c.Endpoint)
+		}
+		if strings.ContainsAny(c.Endpoint, " \t\n\"'`$\\;") {
+			return fmt.Errorf("the endpoint contains characters that have to be percent-encoded, got %q", c.Endpoint)
+		}
+	default:
+		return fmt.Errorf("unknown channel %q (available: 'email', 'webpush', 'webhook')", c.Kind)
+	}
+	if c.Kind == WebPush {
+		if key, err := decodeKey(c.P256dh); err != nil || len(key) != 65 {
+			return errors.New("p256dh has to be an uncompressed P-256 public key (base64url)")
+		}
+		if secret, err := decodeKey(c.Auth); err != nil || len(secret) != 16 {
+			return errors.New("auth has to be a 16 byte secret (base64url)")
+		}
+	}
+	return nil
+}
+
+// decodeKey decodes base64url with or without padding (browsers differ)
+func decodeKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Recipient is a follower of a session or venue
+type Recipient struct {
+	Email string
+	// followers without channels are notified by email
+	Channels []Channel
+}
+
+// Change describes an approved change of a session or venue
+type Change struct {
+	// 'session_updated', 'session_cancelled', 'venue_updated' or 'venue_deleted'
+	Type      string    `json:"type"`
+	SessionID *int32    `json:"session_id,omitempty"`
+	VenueID   *int32    `json:"venue_id,omitempty"`
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	URL       string    `json:"url,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Notification is the delivery of a change to a recipient through one of their channels
+type Notification struct {
+	Recipient string  `json:"recipient"`
+	Channel   Channel `json:"-"`
+	Change    Change  `json:"change"`
+}
+
+// Transport delivers notifications of one (or every) kind of channel
+type Transport interface {
+	Deliver(ctx context.Context, n Notification) error
+}
+
+// ErrExpired is returned by transports if the channel doesn't exist anymore (e.g. the user unsubscribed from push
+// notifications in the browser), the channel should be removed
+var ErrExpired = errors.New("the channel has expired")
+
+// DeliveryError is a failed delivery
+type DeliveryError struct {
+	Recipient string
+	Channel   Channel
+	Err       error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("delivery of %v notification to %v failed: %v", e.Channel.Kind, e.Recipient, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Dispatcher delivers notifications through the transport of their channel
+type Dispatcher struct {
+	Transports map[Kind]Transport
+}
+
+// Dispatch notifies all recipients of the change and returns the number of delivered notifications. Failed
+// deliveries don't stop the dispatch, they are returned as DeliveryErrors (joined).
+func (d *Dispatcher) Dispatch(ctx context.Context, c Change, recipients []Recipient) (int, error) {
+	var errs []error
+	sent := 0
+	for _, r := range recipients {
+		channels := r.Channels
+		if len(channels) == 0 {
+			channels = []Channel{{Kind: Email}}
+		}
+		for _, ch := range channels {
+			t, ok := d.Transports[ch.Kind]
+			if !ok {
+				errs = append(errs, &DeliveryError{r.Email, ch, errors.New("no transport configured")})
+				continue
+			}
+			if err := t.Deliver(ctx, Notification{Recipient: r.Email, Channel: ch, Change: c}); err != nil {
+				errs = append(errs, &DeliveryError{r.Email, ch, err})
+				continue
+			}
+			sent++
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+// New returns a dispatcher with the transports of the given kind: 'deliver' (default, email through the mailer,
+// Web Push if vapid is set and webhooks), 'file' (writes all notifications to dir) or 'memory'
+func New(kind string, dir string, mailer mail.Mailer, vapid *VAPID) (*Dispatcher, error) {
+	var t Transport
+	switch kind {
+	case "", "deliver":
+		d := &Dispatcher{Transports: map[Kind]Transport{
+			Email:   &EmailTransport{Mailer: mailer},
+			Webhook: &WebhookTransport{},
+		}}
+		if vapid != nil {
+			d.Transports[WebPush] = &WebPushTransport{VAPID: vapid}
+		}
+		return d, nil
+	case "file":
+		if dir == "" {
+			return nil, fmt.Errorf("the file transport needs a directory")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		t = &FileTransport{Dir: dir}
+	case "memory":
+		t = &MemoryTransport{}
+	default:
+		return nil, fmt.Errorf("unknown notifier %q (available: 'deliver', 'file', 'memory')", kind)
+	}
+	d := &Dispatcher{Transports: make(map[Kind]Transport)}
+	for _, k := range Kinds {
+		d.Transports[k] = t
+	}
+	return d, nil
+}
+
+// EmailTransport sends notifications to the address of the account
+type EmailTransport struct {
+	Mailer mail.Mailer
+}
+
+func (e *EmailTransport) Deliver(ctx context.Context, n Notification) error {
+	text := n.Change.Text
+	if n.Change.URL != "" {
+		text += "\n\n" + n.Change.URL
+	}
+	text += "\n\nYou receive this email because you follow this session or venue."
+	return e.Mailer.Send(ctx, mail.Message{To: n.Recipient, Subject: n.Change.Title, Text: text})
+}
+
+// MemoryTransport keeps the notifications in memory (for tests)
+type MemoryTransport struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (m *MemoryTransport) Deliver(ctx context.Context, n Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications = append(m.notifications, n)
+	return nil
+}
+
+// Notifications returns the delivered notifications
+func (m *MemoryTransport) Notifications() []Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Notification(nil), m.notifications...)
+}
+
+// FileTransport writes every notification to a separate JSON file in Dir (for local development and tests)
+type FileTransport struct {
+	Dir string
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+func (f *FileTransport) Deliver(ctx context.Context, n Notification) error {
+	b, err := json.MarshalIndent(struct {
+		Notification
+		Kind     Kind   `json:"kind"`
+		Endpoint string `json:"endpoint,omitempty"`
+	}{n, n.Channel.Kind, n.Channel.Endpoint}, "", "  ")
+	if err != nil {
+		return err
+	}
+	t := time.Now().UTC()
+	fp := filepath.Join(f.Dir, fmt.Sprintf("%v_%v_%v_%v.json", t.Format("20060102_150405"), t.Nanosecond(), n.Channel.Kind, unsafeChars.ReplaceAllString(n.Recipient, "_")))
+	return os.WriteFile(fp, b, 0644)
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/mail"
+	"github.com/felix-schott/jamsessions/backend/internal/publicnet"
+)
+
+var session int32 = 3
+
+var change = Change{Type: "session_updated", SessionID: &session, Title: "Blues Jam has changed", Text: "The session now starts at 20:00.", URL: "https://example.org/3", Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+type failingTransport struct{ err error }
+
+func (f failingTransport) Deliver(ctx context.Context, n Notification) error {
+	return f.err
+}
+
+func TestDispatch(t *testing.T) {
+	memory := &MemoryTransport{}
+	d := &Dispatcher{Transports: map[Kind]Transport{Email: memory, Webhook: failingTransport{ErrExpired}}}
+	sent, err := d.Dispatch(context.Background(), change, []Recipient{
+		{Email: "default@example.org"}, // no channels: email
+		{Email: "hooked@example.org", Channels: []Channel{{ID: 1, Kind: Webhook, Endpoint: "https://example.org/hook"}, {ID: 2, Kind: Email}}},
+		{Email: "pushed@example.org", Channels: []Channel{{ID: 3, Kind: WebPush, Endpoint: "https://push.example.org/abc"}}},
+	})
+	if sent != 2 {
+		t.Errorf("expected 2 notifications to be sent, got %v", sent)
+	}
+	if n := memory.Notifications(); len(n) != 2 || n[0].Recipient != "default@example.org" || n[1].Recipient != "hooked@example.org" || n[1].Change.Title != change.Title {
+		t.Errorf("expected the email notifications of both users, got %+v", n)
+	}
+	var delivery *DeliveryError
+	if !errors.As(err, &delivery) || delivery.Channel.ID != 1 || !errors.Is(err, ErrExpired) {
+		t.Errorf("expected the failed webhook delivery to be reported, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "webpush notification to pushed@example.org failed: no transport configured") {
+		t.Errorf("expected an error for the channel without transport, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	var outbox bytes.Buffer
+	d, err := New("", "", &mail.WriterMailer{W: &outbox}, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, ok := d.Transports[WebPush]; ok {
+		t.Error("expected no Web Push transport without VAPID keys")
+	}
+	if _, err := d.Dispatch(context.Background(), change, []Recipient{{Email: "player@example.org"}}); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for _, expected := range []string{"To: player@example.org\r\n", "Subject: Blues Jam has changed\r\n", "The session now starts at 20:00.\r\n\r\nhttps://example.org/3"} {
+		if !strings.Contains(outbox.String(), expected) {
+			t.Errorf("expected the email to contain %q, got %q", expected, outbox.String())
+		}
+	}
+
+	if _, err := New("pigeon", "", nil, nil); err == nil {
+		t.Error("expected an error for an unknown notifier")
+	}
+	if _, err := New("file", "", nil, nil); err == nil {
+		t.Error("expected an error for a file notifier without directory")
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "notifications")
+	d, err := New("file", dir, nil, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, err := d.Dispatch(context.Background(), change, []Recipient{{Email: "player@example.org", Channels: []Channel{{Kind: Webhook, Endpoint: "https://example.org/hook"}}}}); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected exactly 1 file in the directory, got %v (err: %v)", len(entries), err)
+	}
+	if !strings.HasSuffix(entries[0].Name(), "_webhook_player@example.org.json") {
+		t.Errorf("expected the file name to end with the channel and the recipient, got %v", entries[0].Name())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil || !strings.Contains(string(b), `"endpoint": "https://example.org/hook"`) || !strings.Contains(string(b), `"title": "Blues Jam has changed"`) {
+		t.Errorf("expected the file to contain the notification, got %s (err: %v)", b, err)
+	}
+}
+
+func TestWebhookTransport(t *testing.T) {
+	var received Change
+	status := http.StatusNoContent
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON body, got %v", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("could not decode the body: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	hook := &WebhookTransport{Client: srv.Client()}
+	n := Notification{Recipient: "player@example.org", Channel: Channel{Kind: Webhook, Endpoint: srv.URL}, Change: change}
+
+	if err := hook.Deliver(context.Background(), n); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if received.Type != "session_updated" || *received.SessionID != session || !received.Time.Equal(change.Time) {
+		t.Errorf("expected the change to be posted, got %+v", received)
+	}
+	status = http.StatusGone
+	if err := hook.Deliver(context.Background(), n); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+	status = http.StatusInternalServerError
+	if err := hook.Deliver(context.Background(), n); err == nil || errors.Is(err, ErrExpired) {
+		t.Errorf("expected an error, got %v", err)
+	}
+
+	// the default client doesn't connect to internal addresses like the loopback address of the test server
+	if err := (&WebhookTransport{}).Deliver(context.Background(), n); !errors.Is(err, publicnet.ErrNotPublic) {
+		t.Errorf("expected publicnet.ErrNotPublic, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	key, auth := subscriptionKeys(t)
+	for i, tc := range []struct {
+		channel Channel
+		valid   bool
+	}{
+		{Channel{Kind: Email}, true},
+		{Channel{Kind: Email, Endpoint: "https://example.org"}, false},
+		{Channel{Kind: Webhook, Endpoint: "https://example.org/hook"}, true},
+		{Channel{Kind: Webhook, Endpoint: "http://example.org/hook"}, false},
+		{Channel{Kind: Webhook}, false},
+		{Channel{Kind: Webhook, Endpoint: "https://example.org/hook?a=$(id)"}, false},
+		{Channel{Kind: Webhook, Endpoint: "https://localhost/hook"}, false},
+		{Channel{Kind: Webhook, Endpoint: "https://169.254.169.254/latest/meta-data"}, false},
+		{Channel{Kind: Webhook, Endpoint: "https://10.0.0.5:8080/hook"}, false},
+		{Channel{Kind: WebPush, Endpoint: "https://[::1]/abc", P256dh: key.pub, Auth: auth}, false},
+		{Channel{Kind: WebPush, Endpoint: "https://push.example.org/abc", P256dh: key.pub, Auth: auth}, true},
+		{Channel{Kind: WebPush, Endpoint: "https://push.example.org/abc", P256dh: key.pub + "==", Auth: auth + "=="}, true},
+		{Channel{Kind: WebPush, Endpoint: "https://push.example.org/abc", P256dh: key.pub}, false},
+		{Channel{Kind: WebPush, Endpoint: "https://push.example.org/abc", P256dh: "abc", Auth: auth}, false},
+		{Channel{Kind: "pigeon"}, false},
+	} {
+		if err := tc.channel.Validate(); (err == nil) != tc.valid {
+			t.Errorf("case %v: expected valid=%v, got %v", i, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/publicnet"
+)
+
+const deliveryTimeout = 10 * time.Second
+
+// WebhookTransport posts the change as JSON to the endpoint of the channel
+type WebhookTransport struct {
+	Client *http.Client // publicnet.Client if nil
+}
+
+func (h *WebhookTransport) Deliver(ctx context.Context, n Notification) error {
+	b, err := json.Marshal(n.Change)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Channel.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return send(h.Client, req)
+}
+
+// send sends the request, responses other than 2xx are errors (404 and 410 mean that the channel has expired)
+func send(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = publicnet.Client
+	}
+	req.Header.Set("User-Agent", "jamsessions-notify")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrExpired
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return fmt.Errorf("%v responded with status %v: %s", req.URL.Host, res.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
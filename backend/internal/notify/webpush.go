@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// WEB PUSH - payloads are encrypted as described in RFC 8291 (aes128gcm), the application server identifies itself
+// with VAPID (RFC 8292)
+
+// how long the push service keeps notifications of offline browsers
+const pushTTL = 24 * time.Hour
+
+// record size of the encrypted payload (a single record)
+const recordSize = 4096
+
+// VAPID is the key pair that identifies the application server to the push services. The public key is
+// passed to the browser when subscribing (GET /v1/notifications/webpush-key).
+type VAPID struct {
+	// base64url encoded public key (P-256, uncompressed)
+	PublicKey string
+	// contact address of the operator (mailto: or https: URL)
+	Subject string
+
+	key *ecdsa.PrivateKey
+}
+
+// ParseVAPID parses the base64url encoded private key (P-256 scalar) of the key pair, e.g. generated with
+// 'npx web-push generate-vapid-keys'
+func ParseVAPID(privateKey string, subject string) (*VAPID, error) {
+	d, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("the VAPID private key has to be base64url encoded: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	if subject == "" {
+		return nil, errors.New("VAPID needs a subject (mailto: or https: URL of the operator)")
+	}
+	pub := key.PublicKey().Bytes() // 0x04 || X || Y
+	return &VAPID{
+		PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+		Subject:   subject,
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(pub[1:33]), Y: new(big.Int).SetBytes(pub[33:])},
+			D:         new(big.Int).SetBytes(d),
+		},
+	}, nil
+}
+
+// authorization returns the Authorization header for requests to the push service of the endpoint
+func (v *VAPID) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": v.Subject,
+	}).SignedString(v.key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%v, k=%v", token, v.PublicKey), nil
+}
+
+// WebPushTransport sends push messages to the browsers of the users, the message is the change as JSON
+type WebPushTransport struct {
+	VAPID  *VAPID
+	Client *http.Client // publicnet.Client if nil
+}
+
+func (p *WebPushTransport) Deliver(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(n.Change)
+	if err != nil {
+		return err
+	}
+	body, err := encrypt(payload, n.Channel.P256dh, n.Channel.Auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := p.VAPID.authorization(n.Channel.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Channel.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	req.Header.Set("Authorization", authorization)
+	return send(p.Client, req)
+}
+
+// encrypt encrypts the payload for the push subscription (RFC 8291)
+func encrypt(payload []byte, p256dh string, auth string) ([]byte, error) {
+	uaPublicBytes, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription key: %w", err)
+	}
+	authSecret, err := decodeKey(auth)
+	if err != nil {
+		return nil, err
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublicBytes...), asPublic...)
+	ikm := hkdfExpand(hkdfExtract(authSecret, secret), keyInfo, 32)
+	prk := hkdfExtract(salt, ikm)
+	cek := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// header: salt, record size, key ID (the public key of the application server)
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	if len(payload)+1+gcm.Overhead() > recordSize {
+		return nil, fmt.Errorf("the payload is too large for a push message (%v bytes)", len(payload))
+	}
+	plaintext := append(payload, 0x02) // padding delimiter of the last record
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+func hkdfExtract(salt []byte, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand only supports lengths up to the hash size, which is all we need
+func hkdfExpand(prk []byte, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{0x01})
+	return mac.Sum(nil)[:length]
+}
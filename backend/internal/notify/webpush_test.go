@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type subscriptionKey struct {
+	priv *ecdh.PrivateKey
+	pub  string
+}
+
+// subscriptionKeys generates the keys of a push subscription, like a browser does
+func subscriptionKeys(t *testing.T) (subscriptionKey, string) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return subscriptionKey{priv, base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes())}, base64.RawURLEncoding.EncodeToString(auth)
+}
+
+// readAll reads the body of the request
+func readAll(t *testing.T, r io.Reader) []byte {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decrypt decrypts a push message with the keys of the subscription (the user agent side of RFC 8291)
+func decrypt(t *testing.T, body []byte, key subscriptionKey, auth string) []byte {
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if rs != recordSize || idLen != 65 {
+		t.Fatalf("unexpected header: record size %v, key ID length %v", rs, idLen)
+	}
+	asPublicBytes := body[21 : 21+idLen]
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := key.priv.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret, _ := decodeKey(auth)
+	keyInfo := append(append([]byte("WebPush: info\x00"), key.priv.PublicKey().Bytes()...), asPublicBytes...)
+	prk := hkdfExtract(salt, hkdfExpand(hkdfExtract(authSecret, secret), keyInfo, 32))
+	block, err := aes.NewCipher(hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12), body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("could not decrypt the message: %v", err)
+	}
+	if plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatalf("expected the plaintext to end with the padding delimiter, got %v", plaintext)
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestWebPushTransport(t *testing.T) {
+	vapid, err := ParseVAPID(base64.RawURLEncoding.EncodeToString(vapidKey(t).Bytes()), "mailto:admin@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	key, auth := subscriptionKeys(t)
+
+	var received Change
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		// the request is signed with the VAPID key
+		token, k, ok := strings.Cut(strings.TrimPrefix(r.Header.Get("Authorization"), "vapid t="), ", k=")
+		if !ok || k != vapid.PublicKey {
+			t.Errorf("expected the VAPID public key in the Authorization header, got %v", r.Header.Get("Authorization"))
+		}
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) { return &vapid.key.PublicKey, nil }, jwt.WithValidMethods([]string{"ES256"})); err != nil {
+			t.Errorf("invalid VAPID token: %v", err)
+		}
+		if claims["aud"] != "https://"+r.Host || claims["sub"] != "mailto:admin@example.org" {
+			t.Errorf("unexpected claims %v", claims)
+		}
+		if err := json.Unmarshal(decrypt(t, readAll(t, r.Body), key, auth), &received); err != nil {
+			t.Errorf("could not decode the message: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	push := &WebPushTransport{VAPID: vapid, Client: srv.Client()}
+	channel := Channel{Kind: WebPush, Endpoint: srv.URL + "/push/abc", P256dh: key.pub, Auth: auth}
+	if err := push.Deliver(context.Background(), Notification{Recipient: "player@example.org", Channel: channel, Change: change}); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if received.Title != change.Title || received.Text != change.Text {
+		t.Errorf("expected the change to be pushed, got %+v", received)
+	}
+}
+
+// vapidKey generates a VAPID private key
+func vapidKey(t *testing.T) *ecdh.PrivateKey {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestParseVAPID(t *testing.T) {
+	priv := vapidKey(t)
+	vapid, err := ParseVAPID(base64.RawURLEncoding.EncodeToString(priv.Bytes()), "mailto:admin@example.org")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if expected := base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()); vapid.PublicKey != expected {
+		t.Errorf("expected the public key %v, got %v", expected, vapid.PublicKey)
+	}
+	if ecdhKey, err := vapid.key.ECDH(); err != nil || !ecdhKey.Equal(priv) {
+		t.Errorf("expected the signing key to match the private key (err: %v)", err)
+	}
+	for _, tc := range []struct{ key, subject string }{
+		{"not base64!", "mailto:admin@example.org"},
+		{"AAAA", "mailto:admin@example.org"},
+		{base64.RawURLEncoding.EncodeToString(priv.Bytes()), ""},
+	} {
+		if _, err := ParseVAPID(tc.key, tc.subject); err == nil {
+			t.Errorf("expected an error for key %q and subject %q", tc.key, tc.subject)
+		}
+	}
+}
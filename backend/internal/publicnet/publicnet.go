@@ -0,0 +1,82 @@
+// Package publicnet sends requests to URLs registered by users (webhooks and Web Push endpoints) without giving them
+// access to the internal network of the server: connections to loopback, private, link-local and other non-public
+// addresses are refused when they are dialled (so that DNS names resolving to them are caught as well) and redirects
+// aren't followed.
+package publicnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotPublic is returned when a request is sent to an address that isn't publicly routable
+var ErrNotPublic = errors.New("the address is not publicly routable")
+
+// reserved ranges that IsGlobalUnicast doesn't exclude: "this network" and the shared address space of
+// carrier-grade NAT (used for internal services by some cloud providers)
+var reserved = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/8"), netip.MustParsePrefix("100.64.0.0/10")}
+
+// IsPublic reports whether the address is publicly routable: loopback, private (RFC 1918 and unique local), link-local
+// (e.g. the metadata service at 169.254.169.254), multicast and unspecified addresses are not
+func IsPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range reserved {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Control refuses connections to non-public addresses, to be used as the Control function of a net.Dialer
+func Control(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotPublic, err)
+	}
+	if !IsPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %v", ErrNotPublic, addrPort.Addr())
+	}
+	return nil
+}
+
+// CheckURL rejects URLs whose host is a non-public IP address or localhost, the addresses of other hosts are only
+// known when they are dialled
+func CheckURL(u *url.URL) error {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %v", ErrNotPublic, host)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !IsPublic(ip) {
+		return fmt.Errorf("%w: %v", ErrNotPublic, ip)
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that only connects to public addresses, doesn't use a proxy and returns
+// redirects as responses instead of following them
+func NewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: Control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Client is the client shared by the deliveries
+var Client = NewClient()
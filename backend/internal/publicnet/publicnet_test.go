@@ -0,0 +1,90 @@
+package publicnet
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		expected bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	} {
+		if got := IsPublic(netip.MustParseAddr(tc.ip)); got != tc.expected {
+			t.Errorf("IsPublic(%v): expected %v, got %v", tc.ip, tc.expected, got)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.org/hook", true},
+		{"https://prod_db/hook", true}, // resolved when dialled
+		{"https://93.184.215.14/hook", true},
+		{"https://localhost/hook", false},
+		{"https://LOCALHOST./hook", false},
+		{"https://api.localhost/hook", false},
+		{"https://127.0.0.1:8080/hook", false},
+		{"https://[::1]/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://192.168.0.1/", false},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckURL(u); (err == nil) != tc.ok {
+			t.Errorf("CheckURL(%v): expected ok=%v, got %v", tc.url, tc.ok, err)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// the test server listens on a loopback address
+	if _, err := NewClient().Get(srv.URL); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("expected ErrNotPublic, got %v", err)
+	}
+
+	// redirects are returned instead of being followed
+	client := NewClient()
+	client.Transport = srv.Client().Transport
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Errorf("expected the redirect to be returned, got %v", res.StatusCode)
+	}
+}
@@ -8,7 +8,8 @@ import (
 )
 
 type ValidationError struct {
-	Msg string
+	Msg   string
+	Field string // name of the JSON field that failed validation, if known
 }
 
 func (e ValidationError) Error() string {
@@ -68,7 +69,7 @@ func (g *Genre) UnmarshalJSON(b []byte) error {
 			validGenres[i] = k.String()
 			i += 1
 		}
-		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Genre. Valid values: %v", b, strings.Join(validGenres, ", "))}
+		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Genre. Valid values: %v", b, strings.Join(validGenres, ", ")), Field: "genres"}
 	}
 	*g = s
 	return nil
@@ -115,7 +116,7 @@ func (backline *Backline) UnmarshalJSON(b []byte) error {
 			validBacklineOptions[i] = k.String()
 			i += 1
 		}
-		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Backline option. Valid values: %v", b, strings.Join(validBacklineOptions, ", "))}
+		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Backline option. Valid values: %v", b, strings.Join(validBacklineOptions, ", ")), Field: "backline"}
 	}
 	*backline = s
 	return nil
@@ -167,7 +168,7 @@ func (i *Interval) UnmarshalJSON(b []byte) error {
 			validIntervalOptions[i] = k.String()
 			i += 1
 		}
-		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Interval option. Valid values: %v", b, strings.Join(validIntervalOptions, ", "))}
+		return ValidationError{Msg: fmt.Sprintf("%s is not a valid Interval option. Valid values: %v", b, strings.Join(validIntervalOptions, ", ")), Field: "interval"}
 	}
 	*i = s
 	return nil
@@ -226,21 +227,35 @@ type VenueFeature struct {
 	Type       string          `json:"type"`
 	Properties VenueProperties `json:"properties"`
 	Geometry   Geometry        `json:"geometry"`
+	raw        []byte
+}
+
+// RawVenueFeature returns a VenueFeature that holds the JSON document b (see RawJSON)
+func RawVenueFeature(b []byte) VenueFeature {
+	return VenueFeature{raw: b}
+}
+
+func (f VenueFeature) RawJSON() []byte {
+	return f.raw
 }
 
 type SessionProperties struct {
-	SessionID       *int32     `json:"session_id,omitempty"`
-	SessionName     *string    `json:"session_name,omitempty"`
-	Venue           *int32     `json:"venue,omitempty"`
-	Description     *string    `json:"description,omitempty"`
-	Genres          *[]Genre   `json:"genres,omitempty"`
-	StartTimeUtc    *time.Time `json:"start_time_utc,omitempty"`
-	Interval        *Interval  `json:"interval,omitempty"`
-	DurationMinutes *int16     `json:"duration_minutes,omitempty"`
-	SessionWebsite  *string    `json:"session_website,omitempty"`
-	DtUpdatedUtc    *time.Time `json:"dt_updated_utc,omitempty"`
-	Rating          *float32   `json:"rating,omitempty"`
-	Dates           *[]Date    `json:"dates,omitempty"`
+	SessionID       *int32             `json:"session_id,omitempty"`
+	SessionName     *string            `json:"session_name,omitempty"`
+	Venue           *int32             `json:"venue,omitempty"`
+	Description     *string            `json:"description,omitempty"`
+	Genres          *[]Genre           `json:"genres,omitempty"`
+	StartTimeUtc    *time.Time         `json:"start_time_utc,omitempty"`
+	Interval        *Interval          `json:"interval,omitempty"`
+	DurationMinutes *int16             `json:"duration_minutes,omitempty"`
+	SessionWebsite  *string            `json:"session_website,omitempty"`
+	DtUpdatedUtc    *time.Time         `json:"dt_updated_utc,omitempty"`
+	Rating          *float32           `json:"rating,omitempty"`
+	RatingCount     *int32             `json:"rating_count,omitempty"`
+	RatingHistogram *[]int32           `json:"rating_histogram,omitempty"`
+	RatingScore     *float32           `json:"rating_score,omitempty"`
+	AspectRatings   *AspectRatingStats `json:"aspect_ratings,omitempty"`
+	Dates           *[]Date            `json:"dates,omitempty"`
 }
 
 type SessionPropertiesWithVenue struct {
@@ -259,14 +274,206 @@ type SessionFeature[T SessionProperties | SessionPropertiesWithVenue] struct {
 	Type       string   `json:"type"`
 	Properties T        `json:"properties"`
 	Geometry   Geometry `json:"geometry"`
+	raw        []byte
+}
+
+// RawSessionFeature returns a SessionFeature that holds the JSON document b (see RawJSON)
+func RawSessionFeature[T SessionProperties | SessionPropertiesWithVenue](b []byte) SessionFeature[T] {
+	return SessionFeature[T]{raw: b}
 }
 
-type FeatureCollection[T SessionFeature[SessionProperties] | SessionFeature[SessionPropertiesWithVenue] | VenueFeature] struct {
+func (f SessionFeature[T]) RawJSON() []byte {
+	return f.raw
+}
+
+type Feature interface {
+	SessionFeature[SessionProperties] | SessionFeature[SessionPropertiesWithVenue] | VenueFeature
+}
+
+type FeatureCollection[T Feature] struct {
 	Type     string `json:"type"`
 	Features []T    `json:"features"`
+	Links    []Link `json:"links,omitempty"`
+	raw      []byte
+}
+
+// RawFeatureCollection returns a FeatureCollection that holds the JSON document b (see RawJSON)
+func RawFeatureCollection[T Feature](b []byte) FeatureCollection[T] {
+	return FeatureCollection[T]{raw: b}
+}
+
+func (fc FeatureCollection[T]) RawJSON() []byte {
+	return fc.raw
+}
+
+// RawJSON is implemented by responses that can hold a JSON document built by the database (e.g. with ST_AsGeoJSON).
+// The document is sent to the client as is instead of being decoded into the struct and encoded again,
+// RawJSON returns nil if the struct was populated as usual.
+type RawJSON interface {
+	RawJSON() []byte
+}
+
+// Link to a related resource, e.g. the next page of a collection (rel 'next')
+type Link struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
 }
 
 // declare alias
 type SessionFeatureCollection = FeatureCollection[SessionFeature[SessionProperties]]
 type SessionWithVenueFeatureCollection = FeatureCollection[SessionFeature[SessionPropertiesWithVenue]]
 type VenueFeatureCollection = FeatureCollection[VenueFeature]
+
+// COMMENTS
+
+type Comment struct {
+	CommentID int32      `json:"comment_id"`
+	Session   int32      `json:"session"`
+	Author    string     `json:"author"`
+	Content   string     `json:"content"`
+	DtPosted  *time.Time `json:"dt_posted"`
+	// set if the author has edited the comment
+	DtEdited *time.Time `json:"dt_edited,omitempty"`
+	Rating   *int16     `json:"rating,omitempty"`
+	RatingID *int32     `json:"rating_id,omitempty"`
+	// replies reference the comment they respond to
+	ParentComment *int32 `json:"parent_comment,omitempty"`
+	// set for replies of the verified organiser or venue of the session
+	Verified   bool  `json:"verified"`
+	ReplyCount int32 `json:"reply_count"`
+	// nested replies (view=tree only), long threads are truncated - use reply_count and the
+	// parent_comment query parameter to page through all replies
+	Replies []Comment `json:"replies,omitempty"`
+}
+
+// USERS
+
+type User struct {
+	// not set until the account has been registered (the registration is applied like any other change)
+	UserID *int32 `json:"user_id,omitempty"`
+	Email  string `json:"email"`
+	// 'contributor', 'trusted_contributor', 'moderator' or 'admin'
+	Role string `json:"role"`
+	// IDs of the venues the user owns
+	OwnedVenues []int32    `json:"owned_venues,omitempty"`
+	DtCreated   *time.Time `json:"dt_created,omitempty"`
+}
+
+// Follow is a session or venue followed by a user (exactly one of Session and Venue is set)
+type Follow struct {
+	FollowID    int32     `json:"follow_id"`
+	Session     *int32    `json:"session,omitempty"`
+	SessionName *string   `json:"session_name,omitempty"`
+	Venue       *int32    `json:"venue,omitempty"`
+	VenueName   *string   `json:"venue_name,omitempty"`
+	DtAdded     time.Time `json:"dt_added"`
+}
+
+// NotificationChannel is where the notifications of a user are delivered
+type NotificationChannel struct {
+	ChannelID int32 `json:"channel_id"`
+	// 'email', 'webpush' or 'webhook'
+	Kind string `json:"kind"`
+	// push service URL or webhook URL
+	Endpoint  *string   `json:"endpoint,omitempty"`
+	DtCreated time.Time `json:"dt_created"`
+}
+
+// SavedSearch is a session filter saved by a user, the user receives a weekly digest of the matching sessions
+type SavedSearch struct {
+	SearchID   int32    `json:"search_id"`
+	SearchName string   `json:"search_name"`
+	Genres     []string `json:"genres,omitempty"`
+	Backline   []string `json:"backline,omitempty"`
+	// ISO weekdays (1 = Monday, 7 = Sunday)
+	Weekdays []int16 `json:"weekdays,omitempty"`
+	// centre and radius of the area
+	Lon          *float64   `json:"lon,omitempty"`
+	Lat          *float64   `json:"lat,omitempty"`
+	RadiusMetres *int32     `json:"radius_metres,omitempty"`
+	DtCreated    time.Time  `json:"dt_created"`
+	DtLastDigest *time.Time `json:"dt_last_digest,omitempty"`
+}
+
+// Submission is a change that waits for review: a migration script in the migrations directory or a suggestion
+type Submission struct {
+	File string `json:"file"`
+	// 'migration' or 'suggestion'
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	DtCreated time.Time `json:"dt_created"`
+}
+
+// WebhookSubscription is an endpoint of a third party that receives signed events when sessions or venues change
+type WebhookSubscription struct {
+	SubscriptionID int32    `json:"subscription_id"`
+	URL            string   `json:"url"`
+	EventTypes     []string `json:"event_types"`
+	Description    *string  `json:"description,omitempty"`
+	// number of deliveries that are waiting to be (re)tried and that have been given up
+	PendingDeliveries int64     `json:"pending_deliveries"`
+	FailedDeliveries  int64     `json:"failed_deliveries"`
+	DtCreated         time.Time `json:"dt_created"`
+}
+
+// WebhookDelivery is an entry of the delivery log of a subscription
+type WebhookDelivery struct {
+	DeliveryID int64  `json:"delivery_id"`
+	EventID    string `json:"event_id"`
+	EventType  string `json:"event_type"`
+	// 'pending', 'delivered' or 'failed' (given up)
+	Status   string `json:"status"`
+	Attempts int16  `json:"attempts"`
+	// time of the next attempt of pending deliveries
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// HTTP status and error of the last attempt
+	ResponseStatus *int16     `json:"response_status,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	DtCreated      time.Time  `json:"dt_created"`
+	DtDelivered    *time.Time `json:"dt_delivered,omitempty"`
+}
+
+// RATINGS
+
+// AspectRatings are optional ratings (1 to 5) of individual aspects of a session, submitted alongside the overall rating.
+// The JSON keys must match the rating columns in the database schema.
+type AspectRatings struct {
+	HouseBand            *int16 `json:"house_band,omitempty"`
+	Backline             *int16 `json:"backline,omitempty"`
+	BeginnerFriendliness *int16 `json:"beginner_friendliness,omitempty"`
+	Sound                *int16 `json:"sound,omitempty"`
+	Crowd                *int16 `json:"crowd,omitempty"`
+}
+
+// Validate checks that all aspect ratings are between 1 and 5
+func (a AspectRatings) Validate() error {
+	for _, aspect := range []struct {
+		field  string
+		rating *int16
+	}{
+		{"house_band", a.HouseBand},
+		{"backline", a.Backline},
+		{"beginner_friendliness", a.BeginnerFriendliness},
+		{"sound", a.Sound},
+		{"crowd", a.Crowd},
+	} {
+		if aspect.rating != nil && (*aspect.rating < 1 || *aspect.rating > 5) {
+			return ValidationError{Msg: fmt.Sprintf("%v must be between 1 and 5, got %v", aspect.field, *aspect.rating), Field: "aspect_ratings/" + aspect.field}
+		}
+	}
+	return nil
+}
+
+// AspectRatingStats holds the average rating of every aspect of a session that has been rated
+type AspectRatingStats struct {
+	HouseBand            *RatingStats `json:"house_band,omitempty"`
+	Backline             *RatingStats `json:"backline,omitempty"`
+	BeginnerFriendliness *RatingStats `json:"beginner_friendliness,omitempty"`
+	Sound                *RatingStats `json:"sound,omitempty"`
+	Crowd                *RatingStats `json:"crowd,omitempty"`
+}
+
+type RatingStats struct {
+	Rating      float32 `json:"rating"`
+	RatingCount int32   `json:"rating_count"`
+}
@@ -0,0 +1,222 @@
+// Package webhooks signs and delivers the events sent to the webhook subscriptions of third parties when sessions or
+// venues change
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/publicnet"
+)
+
+const (
+	SessionCreated = "session.created"
+	SessionUpdated = "session.updated"
+	SessionDeleted = "session.deleted"
+	VenueCreated   = "venue.created"
+	VenueUpdated   = "venue.updated"
+	VenueDeleted   = "venue.deleted"
+)
+
+// EventTypes are all types of events subscriptions can receive (same as the check of webhook_subscriptions.event_types)
+var EventTypes = []string{SessionCreated, SessionUpdated, SessionDeleted, VenueCreated, VenueUpdated, VenueDeleted}
+
+// ValidateEventTypes checks that the subscription has at least one event type and only known ones
+func ValidateEventTypes(types []string) error {
+	if len(types) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, t := range types {
+		if !slices.Contains(EventTypes, t) {
+			return fmt.Errorf("unknown event type %q (available: %v)", t, EventTypes)
+		}
+	}
+	return nil
+}
+
+// Event is the JSON payload posted to the subscriptions
+type Event struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// SessionData is the data of session events
+type SessionData struct {
+	SessionID   int32  `json:"session_id"`
+	SessionName string `json:"session_name"`
+	VenueID     int32  `json:"venue_id"`
+	VenueName   string `json:"venue_name"`
+	URL         string `json:"url,omitempty"` // empty for deleted sessions
+}
+
+// VenueData is the data of venue events
+type VenueData struct {
+	VenueID   int32  `json:"venue_id"`
+	VenueName string `json:"venue_name"`
+	// venue the deleted venue was merged into
+	MergedInto *int32 `json:"merged_into,omitempty"`
+}
+
+// NewEvent returns an event of the given type with a random ID
+func NewEvent(eventType string, data any, now time.Time) (Event, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	id, err := random(12)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: "evt_" + id, Type: eventType, Time: now.UTC().Truncate(time.Second), Data: b}, nil
+}
+
+// NewSecret returns a new random signing secret, it is shown once when the subscription is created
+func NewSecret() (string, error) {
+	s, err := random(24)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + s, nil
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Headers of the deliveries
+const (
+	SignatureHeader = "X-Jamsessions-Signature"
+	TimestampHeader = "X-Jamsessions-Timestamp"
+	EventHeader     = "X-Jamsessions-Event"
+	DeliveryHeader  = "X-Jamsessions-Delivery"
+)
+
+// Sign returns the signature of a payload: the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the secret of
+// the subscription, prefixed with "sha256=". The timestamp (unix seconds) lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery, for receivers (and tests)
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// MaxAttempts is the number of attempts after which a delivery is given up
+const MaxAttempts = 10
+
+const (
+	initialBackoff = time.Minute
+	maxBackoff     = 12 * time.Hour
+)
+
+// Backoff returns the time to wait after the given (failed) attempt: one minute, doubled with every attempt
+// and capped at 12 hours
+func Backoff(attempt int) time.Duration {
+	d := initialBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Statuses of a delivery
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Failed    = "failed"
+)
+
+// ErrGone is returned if the receiver responded with 410 Gone, the delivery isn't retried
+var ErrGone = errors.New("the receiver doesn't accept deliveries anymore (410 Gone)")
+
+// Next returns the status of a delivery after an attempt and the time of its next attempt (if it is still pending)
+func Next(attempt int, err error, now time.Time) (string, time.Time) {
+	switch {
+	case err == nil:
+		return Delivered, now
+	case errors.Is(err, ErrGone) || attempt >= MaxAttempts:
+		return Failed, now
+	default:
+		return Pending, now.Add(Backoff(attempt))
+	}
+}
+
+// Delivery is the attempt to send an event to a subscription
+type Delivery struct {
+	ID        int64
+	URL       string
+	Secret    string
+	EventID   string
+	EventType string
+	Payload   []byte
+}
+
+const deliveryTimeout = 10 * time.Second
+
+// Client posts deliveries to the subscriptions
+type Client struct {
+	HTTP *http.Client // publicnet.Client if nil, it refuses to connect to internal addresses and doesn't follow redirects
+	Now  func() time.Time
+}
+
+// Deliver posts the signed payload and returns the status of the response (0 if there was none). Responses other
+// than 2xx are errors.
+func (c *Client) Deliver(ctx context.Context, d Delivery) (int, error) {
+	client := c.HTTP
+	if client == nil {
+		client = publicnet.Client
+	}
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jamsessions-webhooks")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, ts, d.Payload))
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	switch {
+	case res.StatusCode == http.StatusGone:
+		return res.StatusCode, ErrGone
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return res.StatusCode, fmt.Errorf("%v responded with status %v: %s", req.URL.Host, res.StatusCode, bytes.TrimSpace(body))
+	}
+	return res.StatusCode, nil
+}
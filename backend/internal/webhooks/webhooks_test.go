@@ -0,0 +1,139 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felix-schott/jamsessions/backend/internal/publicnet"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestValidateEventTypes(t *testing.T) {
+	if err := ValidateEventTypes([]string{SessionCreated, VenueDeleted}); err != nil {
+		t.Errorf("expected error to be nil, got %v", err)
+	}
+	for _, types := range [][]string{nil, {"session.created", "session.rated"}} {
+		if err := ValidateEventTypes(types); err == nil {
+			t.Errorf("expected an error for %v", types)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	e, err := NewEvent(SessionUpdated, SessionData{SessionID: 3, SessionName: "Blues Jam", VenueID: 1, VenueName: "The Pub"}, now)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !strings.HasPrefix(e.ID, "evt_") || len(e.ID) != 28 {
+		t.Errorf("expected a random event ID, got %v", e.ID)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	expected := `{"id":"` + e.ID + `","type":"session.updated","time":"2024-05-01T12:00:00Z","data":{"session_id":3,"session_name":"Blues Jam","venue_id":1,"venue_name":"The Pub"}}`
+	if string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+	if other, _ := NewEvent(SessionUpdated, nil, now); other.ID == e.ID {
+		t.Error("expected events to have different IDs")
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	// echo -n '1714564800.{"id":"evt_1"}' | openssl dgst -sha256 -hmac whsec_test
+	expected := "sha256=b262f80aff315baea7ebf306998167cd09d65e133849f90188a2f33fa082c767"
+	sig := Sign("whsec_test", now.Unix(), body)
+	if sig != expected {
+		t.Errorf("expected %v, got %v", expected, sig)
+	}
+	if !Verify("whsec_test", now.Unix(), body, sig) {
+		t.Error("expected the signature to be valid")
+	}
+	if Verify("whsec_other", now.Unix(), body, sig) || Verify("whsec_test", now.Unix()+1, body, sig) || Verify("whsec_test", now.Unix(), []byte(`{"id":"evt_2"}`), sig) {
+		t.Error("expected the signature to depend on the secret, timestamp and body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, expected := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 5: 16 * time.Minute, 10: 8*time.Hour + 32*time.Minute, 20: 12 * time.Hour} {
+		if d := Backoff(attempt); d != expected {
+			t.Errorf("expected a backoff of %v after attempt %v, got %v", expected, attempt, d)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	failed := errors.New("connection refused")
+	for _, c := range []struct {
+		attempt int
+		err     error
+		status  string
+		next    time.Time
+	}{
+		{1, nil, Delivered, now},
+		{1, failed, Pending, now.Add(time.Minute)},
+		{3, failed, Pending, now.Add(4 * time.Minute)},
+		{MaxAttempts, failed, Failed, now},
+		{1, ErrGone, Failed, now},
+	} {
+		status, next := Next(c.attempt, c.err, now)
+		if status != c.status || !next.Equal(c.next) {
+			t.Errorf("attempt %v (%v): expected %v at %v, got %v at %v", c.attempt, c.err, c.status, c.next, status, next)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var status int
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	client := &Client{HTTP: srv.Client(), Now: func() time.Time { return now }}
+	d := Delivery{ID: 42, URL: srv.URL, Secret: "whsec_test", EventID: "evt_1", EventType: VenueCreated, Payload: []byte(`{"id":"evt_1"}`)}
+
+	status = http.StatusNoContent
+	if code, err := client.Deliver(context.Background(), d); err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected the delivery to succeed, got %v (%v)", code, err)
+	}
+	if received.Method != http.MethodPost || string(body) != `{"id":"evt_1"}` || received.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the payload to be posted as JSON, got %v %s", received.Method, body)
+	}
+	if received.Header.Get(EventHeader) != VenueCreated || received.Header.Get(DeliveryHeader) != "42" {
+		t.Errorf("expected the event and delivery headers to be set, got %v", received.Header)
+	}
+	ts, _ := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+	if ts != now.Unix() || !Verify("whsec_test", ts, body, received.Header.Get(SignatureHeader)) {
+		t.Errorf("expected a valid signature, got %v", received.Header)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err := client.Deliver(context.Background(), d); err == nil || errors.Is(err, ErrGone) || code != http.StatusInternalServerError {
+		t.Errorf("expected a retryable error, got %v (%v)", code, err)
+	}
+	status = http.StatusGone
+	if code, err := client.Deliver(context.Background(), d); !errors.Is(err, ErrGone) || code != http.StatusGone {
+		t.Errorf("expected ErrGone, got %v (%v)", code, err)
+	}
+
+	// the default client doesn't connect to internal addresses like the loopback address of the test server
+	if code, err := (&Client{}).Deliver(context.Background(), d); !errors.Is(err, publicnet.ErrNotPublic) || code != 0 {
+		t.Errorf("expected publicnet.ErrNotPublic, got %v (%v)", code, err)
+	}
+}
@@ -2,7 +2,10 @@
 
 # applies all changes in $MIGRATIONS_DIRECTORY and moves the scripts to 
 # the archive ($MIGRATIONS_ARCHIVE) afterwards.
-# use -y flag for non-interactive mode
+# scripts that fail are moved to $MIGRATIONS_DIRECTORY/failed and the admins are alerted
+# (dbcli alert), the remaining scripts are still applied. the exit status is 1 if any script failed.
+# use -y flag for non-interactive mode, pass a directory to apply the changes in another directory
+# (e.g. run-migrations.sh -y migrations/auto applies the edits of trusted contributors)
 
 # if you're not running this script as part of the production setup (see deploy/install.sh)
 # make sure the dbcli binary is on your PATH
@@ -15,13 +18,19 @@ set -eo pipefail
 # if there is a local bin directory (normal production setup), add to PATH
 [[ -d "$PWD/bin" ]] && export PATH=$PATH:$PWD/bin
 
+non_interactive=""
+for arg in "$@"
+do
+  [[ $arg == "-y" ]] && non_interactive="y" || MIGRATIONS_DIRECTORY=$arg
+done
+
 [[ $MIGRATIONS_DIRECTORY == "" ]] && echo "Please provide the environment variable 'MIGRATIONS_DIRECTORY'" 1>&2 && exit 1;
 
 if [ -z "$( ls -Ap $MIGRATIONS_DIRECTORY | grep -v / )" ] 
 then # list all files in the directory (make ls append / to directories, then filter)
    echo "The directory $MIGRATIONS_DIRECTORY is empty, no migrations to run" 1>&2;
 else
-  if [[ "$1" == "-y" ]]
+  if [[ $non_interactive == "y" ]]
   then
     echo "Running in non-interactive mode." 1>&2;
     choice="y"
@@ -35,6 +44,7 @@ else
   mkdir -p -m 755 $MIGRATIONS_ARCHIVE
 
   out=""
+  failed=0
 
   # if yes, run all files in migrations directory
   case "$choice" in 
@@ -44,8 +54,20 @@ else
         if [[ -f $file ]]
         then
           echo "Running $file" 1>&2;
-          out="$out $(bash $file)";
-          echo "Moving file to archive $MIGRATIONS_ARCHIVE/" 1>&2 && mv $file $MIGRATIONS_ARCHIVE/;
+          log=$(mktemp)
+          if result=$(bash $file 2>$log)
+          then
+            cat $log 1>&2;
+            out="$out $result";
+            echo "Moving file to archive $MIGRATIONS_ARCHIVE/" 1>&2 && mv $file $MIGRATIONS_ARCHIVE/;
+          else
+            cat $log 1>&2;
+            failed=1
+            mkdir -p -m 755 $MIGRATIONS_DIRECTORY/failed
+            echo "Failed to apply $file, moving file to $MIGRATIONS_DIRECTORY/failed/" 1>&2 && mv $file $MIGRATIONS_DIRECTORY/failed/;
+            dbcli alert "failed migration" "$(basename $file)" --notes "$(tail -n 5 $log)" || echo "Could not alert the admins" 1>&2;
+          fi
+          rm -f $log
           echo -e "\n" 1>&2;
         fi
       done
@@ -59,4 +81,5 @@ else
   esac
 fi
 
-echo $out # return stdout of all commands
\ No newline at end of file
+echo $out # return stdout of all commands
+exit $failed
\ No newline at end of file
@@ -27,6 +27,10 @@ wget -q -O $directory/init_db/001_schema.sql "https://raw.githubusercontent.com/
 
 wget -q -O $directory/init_db/002_roles.sh "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/backend/internal/db/scripts/add-roles.sh"
 
+wget -q -O $directory/init_db/003_api_usage_writer.sh "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/backend/internal/db/scripts/add-api-usage-writer.sh"
+
+wget -q -O $directory/init_db/004_upgrade_schema.sh "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/backend/internal/db/scripts/upgrade-schema.sh"
+
 echo "Downloading production docker compose file"
 wget -q -O $directory/docker-compose.yml "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/deploy/prod.docker-compose.yml"
 
@@ -36,9 +40,6 @@ wget -q -O $directory/Caddyfile "https://raw.githubusercontent.com/felix-schott/
 echo "Downloading prometheus.yml"
 wget -q -O $directory/prometheus.yml "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/deploy/prometheus.yml"
 
-echo "Downloading migrations-alert.sh"
-wget -q -O $directory/migrations-alert.sh "https://raw.githubusercontent.com/felix-schott/jamsessions/refs/tags/$tag/deploy/migrations-alert.sh"
-
 [[ ! -f $directory/.env ]] && {
     echo ".env doesn't exist yet - creating file and default directories"
     touch $directory/.env
@@ -56,6 +57,7 @@ wget -q -O $directory/migrations-alert.sh "https://raw.githubusercontent.com/fel
     echo "MIGRATIONS_ARCHIVE=$directory/migrations/archive" >> $directory/.env
     mkdir -p $directory/postgres-data
     mkdir -p $directory/migrations/suggestions
+    mkdir -p $directory/migrations/auto
     mkdir -p $directory/migrations/archive
 } || {
     echo ".env already exists - overwriting RELEASE_TAG"
@@ -82,15 +84,90 @@ if you're not the project owner, and you will have to build your own production
 
 Then, you can start the application by running \`docker compose up -d\` in the directory $directory
 
+# Upgrading
+The scripts in $directory/init_db only run when the database is created. Databases created by previous versions are
+upgraded to the current schema (new tables, columns, triggers and views, the rating aggregates and the materialised
+session occurrences are backfilled) with
+\`docker exec jamsessions_prod_db bash /docker-entrypoint-initdb.d/004_upgrade_schema.sh\` followed by
+\`docker exec jamsessions_prod_db bash /docker-entrypoint-initdb.d/003_api_usage_writer.sh\`. Both scripts can be run
+again, e.g. after every upgrade.
+
 # Managing the database
 Whenever a user requests modification of the database (e.g. the addition of a new session), the application 
 will write little bash scripts to $directory/migrations that make use the dbcli binary.
 
 Review the script contents and execute run-migrations.sh to apply all changes.
+
+The API server alerts the admins about every new submission that waits for review, with a summary and a link to
+\`GET /v1/admin/submissions\` (lists the pending scripts and suggestions). Set ALERT_NOTIFIERS in the .env file to a
+comma separated list of notifiers (the alerts are only logged by default):
+- telegram: TELEGRAM_TOKEN (bot token) and TELEGRAM_CHAT_ID
+- slack: SLACK_WEBHOOK_URL (incoming webhook of Slack or a compatible service like Mattermost)
+- email: ALERT_EMAIL (comma separated addresses, sent through the mailer configured below)
+- log: writes the alerts to the log of the api container
+
+Changes made by trusted contributors, venue owners (concerning their venue), moderators and admins are written to
+$directory/migrations/auto instead and applied every 5 minutes by a cron job (\`run-migrations.sh -y migrations/auto\`).
+Scripts that fail are moved to $directory/migrations/auto/failed and the admins are alerted (\`dbcli alert\`, same
+settings as above), fix them and move them back to apply them again.
+Roles are assigned with \`dbcli user role <email> <role>\` (contributor, trusted_contributor, moderator or admin),
+venue owners with \`dbcli user owner <email> <venue id>\`.
+
+Third-party consumers of the API get an API key with \`dbcli apikey create <name>\` (600 requests per minute by default,
+use --rate-limit to change), \`dbcli apikey list\` shows the usage of all keys and \`dbcli apikey revoke <id>\` revokes a key.
+Requests without a key are limited to ANONYMOUS_RATE_LIMIT requests per minute and client IP (default 120).
+
+The dates on which sessions take place are precomputed for the next 12 months. A cron job runs
+\`dbcli occurrences refresh\` every night to move this horizon forward (run it manually after restoring a backup).
+
+Users can follow sessions and venues. When an approved change renames, reschedules, moves or removes them, dbcli
+notifies the followers (by email unless they added push notification or webhook channels). Add the following variables
+to the .env file (dbcli reads them when the migrations are applied):
+- PUBLIC_URL (URL of the website, used for the links in notifications)
+- MAILER, MAIL_FROM (see the api service in docker-compose.yml)
+- VAPID_PRIVATE_KEY, VAPID_PUBLIC_KEY and VAPID_SUBJECT (e.g. mailto:admin@example.org) to enable push notifications,
+  generate the key pair with \`npx web-push generate-vapid-keys\` (leave them out to disable push notifications)
+- NOTIFIER=file and NOTIFICATION_DIRECTORY to write the notifications to files instead of sending them
+
+Emails are printed to stdout by default (MAILER=stdout). Set MAILER=smtp and SMTP_ADDRESS (host:port), SMTP_USERNAME and
+SMTP_PASSWORD to deliver them, MAILER=file writes them to MAIL_DIRECTORY instead.
+
+Users can save searches (genres, backline, weekdays and area). A cron job runs \`dbcli digest send\` every Monday morning
+to email them a digest of the matching sessions of the coming week and of the sessions added since the last digest
+(use --force to resend digests that have been sent in the last 6 days).
+
+Admins subscribe third parties to changes with \`POST /v1/admin/webhooks\` (https URL and event types, e.g.
+session.created or venue.deleted). dbcli queues an event for every applied change and a cron job runs
+\`dbcli webhook deliver\` every minute to post them, signed with the secret returned when the subscription was created.
+Failed deliveries are retried with an exponential backoff (10 attempts), \`GET /v1/admin/webhooks/{id}/deliveries\`
+shows the delivery log.
+
+Clients follow the applied changes with \`GET /v1/events\` (Server-Sent Events, e.g. \`new EventSource(".../v1/events")\`).
+Events are kept for 30 days, a cron job runs \`dbcli events prune\` every night.
+
+\`POST /v1/graphql\` answers GraphQL queries for sessions, venues, occurrences, comments and ratings in one round trip
+(schema at \`GET /v1/graphql/schema\`). Queries nested deeper than 8 levels or with a cost above 1000 are rejected.
 EOF
 
-echo "Installing alerting cron job"
 set +eo pipefail
-(crontab -l; echo "0 */2 * * * cd $directory && bash $directory/migrations-alert.sh $directory") | sort - | uniq - | crontab -;
+echo "Removing the alerting cron job of previous versions (alerts are sent by the API server)"
+crontab -l | grep -v "migrations-alert.sh" | crontab -;
+rm -f $directory/migrations-alert.sh
+
+echo "Installing cron job to apply the changes of trusted contributors (runs don't overlap)"
+crontab -l | grep -v "run-migrations.sh -y $directory/migrations/auto" | crontab -;
+(crontab -l; echo "*/5 * * * * cd $directory && flock -n $directory/run-migrations.lock bash $directory/run-migrations.sh -y $directory/migrations/auto") | sort - | uniq - | crontab -;
+
+echo "Installing cron job to refresh the materialised session occurrences"
+(crontab -l; echo "15 0 * * * cd $directory && set -a && source .env && set +a && $directory/bin/dbcli occurrences refresh") | sort - | uniq - | crontab -;
+
+echo "Installing cron job to send the weekly digests of saved searches"
+(crontab -l; echo "0 7 * * 1 cd $directory && set -a && source .env && set +a && $directory/bin/dbcli digest send") | sort - | uniq - | crontab -;
+
+echo "Installing cron job to deliver webhook events"
+(crontab -l; echo "* * * * * cd $directory && set -a && source .env && set +a && $directory/bin/dbcli webhook deliver") | sort - | uniq - | crontab -;
+
+echo "Installing cron job to prune the event stream"
+(crontab -l; echo "30 3 * * * cd $directory && set -a && source .env && set +a && $directory/bin/dbcli events prune") | sort - | uniq - | crontab -;
 
 echo "Finished installation process - please consult the generated README file for further instructions."
\ No newline at end of file
@@ -68,6 +68,10 @@ export interface SessionProperties {
 	duration_minutes: number
 	session_website: string
 	rating?: number
+	rating_count?: number
+	rating_histogram?: number[] // number of 1 to 5 star ratings
+	rating_score?: number
+	aspect_ratings?: AspectRatingStats
 	dt_updated_utc?: Date
 	submission_notes?: string
 	submission_email?: string
@@ -79,14 +83,31 @@ export interface SessionComment {
 	author: string
 	content: string
 	dt_posted: string
+	dt_edited?: string // set if the author has edited the comment
 	rating: number // between 1 and 5
+	parent_comment?: number
+	verified: boolean // reply of the organiser or venue
+	reply_count: number
+	replies?: SessionComment[] // view=tree only
 }
 
+export interface AspectRatings {
+	house_band?: number
+	backline?: number
+	beginner_friendliness?: number
+	sound?: number
+	crowd?: number
+}
+
+export type AspectRatingStats = { [K in keyof AspectRatings]: { rating: number, rating_count: number } };
+
 export interface CommentBody {
 	session?: number
 	author?: string
 	content: string
 	rating?: number
+	aspect_ratings?: AspectRatings // between 1 and 5
+	parent_comment?: number
 }
 
 export interface SessionPropertiesWithVenue extends SessionProperties, VenueProperties { };